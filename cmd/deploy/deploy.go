@@ -13,11 +13,15 @@ import (
 type flags struct {
 	force      *bool
 	abortEarly *bool
+	reconcile  *bool
+	parallel   *int
 }
 
 func (f *flags) Init(cmd *cobra.Command) {
 	f.force = cmd.Flags().BoolP("force", "f", false, "Force deploy even if no changes detected")
 	f.abortEarly = cmd.Flags().BoolP("abort-early", "a", false, "Abort on first error")
+	f.reconcile = cmd.Flags().BoolP("reconcile", "r", false, "Repair volumes, ips and scale also for apps that are otherwise up to date")
+	f.parallel = cmd.Flags().IntP("parallel", "p", 1, "Deploy up to N apps in parallel")
 }
 
 func Cmd(
@@ -38,7 +42,10 @@ func Cmd(
 					NewDefaultDeployConfig().
 					WithRetries(1).
 					WithForce(*flags.force).
-					WithAbortOnFirstError(*flags.abortEarly)
+					WithAbortOnFirstError(*flags.abortEarly).
+					WithReconcileInfra(*flags.reconcile).
+					WithParallelism(*flags.parallel).
+					WithTrigger(model.TriggerCli)
 
 				result, err := deployService.DeployAll(ctx, path, deployCfg)
 				if err != nil {
@@ -0,0 +1,83 @@
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gigurra/flycd/pkg/domain"
+	"github.com/gigurra/flycd/pkg/domain/model"
+	"github.com/gigurra/flycd/pkg/util/util_cobra"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+type flags struct {
+	json  *bool
+	limit *int
+}
+
+func (f *flags) Init(cmd *cobra.Command) {
+	f.json = cmd.Flags().BoolP("json", "j", false, "Print the records as json instead of a table")
+	f.limit = cmd.Flags().IntP("limit", "n", 20, "Max number of records to show, newest first (0 for all)")
+}
+
+func Cmd(deployHistory model.DeployHistory) *cobra.Command {
+	flags := flags{}
+	return util_cobra.CreateCmd(&flags, func() *cobra.Command {
+		return &cobra.Command{
+			Use:   "history [app]",
+			Short: "Show recent attempts to deploy apps, or a single app",
+			Args:  cobra.RangeArgs(0, 1),
+			// Keep stdout clean for the records themselves, e.g. to pipe the json into jq
+			Annotations: map[string]string{util_cobra.AnnotationStdoutIsOutput: "true"},
+			Run: func(cmd *cobra.Command, args []string) {
+				app := ""
+				if len(args) > 0 {
+					app = args[0]
+				}
+
+				records, err := deployHistory.List(app, *flags.limit)
+				if err != nil {
+					_, _ = fmt.Fprintf(os.Stderr, "Error reading deploy history: %v\n", err)
+					os.Exit(1)
+				}
+
+				if *flags.json {
+					encoder := json.NewEncoder(os.Stdout)
+					encoder.SetIndent("", "  ")
+					err = encoder.Encode(records)
+					if err != nil {
+						_, _ = fmt.Fprintf(os.Stderr, "Error encoding deploy history: %v\n", err)
+						os.Exit(1)
+					}
+				} else {
+					printTable(os.Stdout, records)
+				}
+			},
+		}
+	})
+}
+
+func printTable(out io.Writer, records []model.DeployRecord) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintf(w, "TIME\tAPP\tTRIGGER\tRESULT\tDURATION\tCOMMIT\tCONFIG VERSION\tERROR\n")
+	for _, record := range records {
+		result := string(record.Result)
+		if !record.Success() {
+			result = "failed"
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			record.Time.Local().Format(time.DateTime),
+			record.App,
+			record.Trigger,
+			result,
+			time.Duration(record.DurationSeconds*float64(time.Second)).Round(time.Second),
+			domain.ShortHash(record.Commit),
+			domain.ShortHash(record.ConfigHash),
+			record.Error,
+		)
+	}
+	_ = w.Flush()
+}
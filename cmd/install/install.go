@@ -23,6 +23,7 @@ type Flags struct {
 	projectPath       *string
 	scaleToZero       *bool
 	shutdownGraceTime *int
+	persistQueue      *bool
 }
 
 func (f *Flags) Init(cmd *cobra.Command) {
@@ -32,6 +33,7 @@ func (f *Flags) Init(cmd *cobra.Command) {
 	f.projectPath = cmd.Flags().StringP("project-path", "p", "projects", "Path to the projects folder to use. This can contain both projects (project.yaml) and apps (app.yaml)")
 	f.scaleToZero = cmd.Flags().BoolP("scale-to-zero", "", false, "scale instances to zero when not in use")
 	f.shutdownGraceTime = cmd.Flags().IntP("shutdown-grace-time", "", 300, "how long to wait for graceful shutdown of instances before killing them")
+	f.persistQueue = cmd.Flags().BoolP("persist-queue", "", true, "keep flycd's job queue, deploy history and git poll state on a volume, so they survive restarts")
 }
 
 func Cmd(
@@ -173,7 +175,7 @@ func Cmd(
 					os.Exit(1)
 				}
 				defer tempDir.RemoveAll()
-				err = packagedFs.WriteOut(tempDir.Cwd())
+				err = packagedFs.WriteOut(ctx, tempDir.Cwd())
 				if err != nil {
 					fmt.Printf("Error writing embedded files: %v\n", err)
 					os.Exit(1)
@@ -209,7 +211,7 @@ func Cmd(
 					minScale = 0
 				}
 
-				_, err = deployService.DeployAppFromInlineConfig(ctx, deployCfg, model.AppConfig{
+				appConfig := model.AppConfig{
 					App:           appName,
 					Org:           orgSlug,
 					PrimaryRegion: region,
@@ -217,7 +219,20 @@ func Cmd(
 					LaunchParams:  model.NewDefaultLaunchParams(appName, orgSlug),
 					DeployParams:  model.NewDefaultDeployParams(),
 					Services:      []model.Service{model.NewDefaultServiceConfig().WithMinScale(minScale)},
-				}.WithKillTimeout(*flags.shutdownGraceTime))
+				}.WithKillTimeout(*flags.shutdownGraceTime)
+
+				if *flags.persistQueue {
+					// The machine's root fs is reset on restart, so the queue, history and poll state must live on a volume
+					appConfig.Env = map[string]string{
+						"QUEUE_DIR":          "/flycd/data/queue",
+						"FLYCD_HISTORY_FILE": "/flycd/data/history.jsonl",
+						"POLL_STATE_FILE":    "/flycd/data/poll-state.json",
+					}
+					appConfig.Mounts = []model.Mount{{Source: "flycd_data", Destination: "/flycd/data"}}
+					appConfig.Volumes = []model.VolumeConfig{{Name: "flycd_data", SizeGb: 1, Count: 1}}
+				}
+
+				_, err = deployService.DeployAppFromInlineConfig(ctx, deployCfg, appConfig)
 				if err != nil {
 					fmt.Printf("Error deploying flycd in monitoring mode: %v\n", err)
 					os.Exit(1)
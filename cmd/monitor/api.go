@@ -0,0 +1,217 @@
+package monitor
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"github.com/gigurra/flycd/pkg/domain"
+	"github.com/gigurra/flycd/pkg/domain/model"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/samber/lo"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// statusTimeout How long /api/status may query fly.io before giving up. The web ui calls it from a browser,
+// which must not be left hanging, nor keep flycd busy after it has given up
+const statusTimeout = 2 * time.Minute
+
+type apiApp struct {
+	model.ListedApp
+	LastDeploy *model.DeployRecord `json:"last_deploy,omitempty"`
+}
+
+type apiApps struct {
+	Apps     []apiApp              `json:"apps"`
+	Projects []model.ListedProject `json:"projects"`
+}
+
+type apiJobs struct {
+	Running []model.Job `json:"running"`
+	Pending []model.Job `json:"pending"` // queued, not including the running ones
+	Dead    []model.Job `json:"dead"`
+}
+
+type apiJobLog struct {
+	JobId string   `json:"job_id"`
+	Lines []string `json:"lines"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// registerApi Adds the management api under /api, only reachable with one of the tokens as a bearer token
+func registerApi(
+	ctx context.Context,
+	e *echo.Echo,
+	path string,
+	tokens []string,
+	deployService domain.DeployService,
+	webhookService domain.WebHookService,
+	deployHistory model.DeployHistory,
+	listings *domain.TreeListingCache,
+) {
+	api := e.Group("/api", middleware.KeyAuth(func(key string, c echo.Context) (bool, error) {
+		return lo.SomeBy(tokens, func(token string) bool {
+			return subtle.ConstantTimeCompare([]byte(key), []byte(token)) == 1
+		}), nil
+	}))
+
+	api.GET("/apps", func(c echo.Context) error {
+		return processListApps(ctx, c, listings, deployHistory)
+	})
+	api.GET("/status", func(c echo.Context) error {
+		return processStatus(c, path, deployService)
+	})
+	api.GET("/jobs", func(c echo.Context) error {
+		return processListJobs(c, webhookService)
+	})
+	api.GET("/jobs/:id/log", func(c echo.Context) error {
+		return processJobLog(c, webhookService)
+	})
+	api.POST("/sync", func(c echo.Context) error {
+		return processSync(ctx, c, path, listings, webhookService)
+	})
+	api.GET("/history", func(c echo.Context) error {
+		return processHistory(c, deployHistory)
+	})
+}
+
+// processListApps Lists the apps and projects in the monitored tree, with the last attempt to deploy each app
+func processListApps(ctx context.Context, c echo.Context, listings *domain.TreeListingCache, deployHistory model.DeployHistory) error {
+	listing, err := listings.Get(ctx)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, apiError{Error: fmt.Sprintf("error listing apps: %v", err)})
+	}
+
+	// Read the history once, rather than once per app. It is newest first, so keep the first record of each app
+	lastDeploys := map[string]model.DeployRecord{}
+	records, err := deployHistory.List("", 0)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error reading deploy history", "error", err)
+	}
+	for _, record := range records {
+		if _, found := lastDeploys[record.App]; !found {
+			lastDeploys[record.App] = record
+		}
+	}
+
+	result := apiApps{
+		Apps:     make([]apiApp, len(listing.Apps)),
+		Projects: listing.Projects,
+	}
+	for i, app := range listing.Apps {
+		result.Apps[i] = apiApp{ListedApp: app}
+		if record, found := lastDeploys[app.App]; found {
+			result.Apps[i].LastDeploy = &record
+		}
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
+
+// processStatus Compares the monitored tree with what is deployed on fly.io, like flycd status does.
+// Stops when the client goes away, or after statusTimeout.
+func processStatus(c echo.Context, path string, deployService domain.DeployService) error {
+	ctx, cancel := context.WithTimeout(c.Request().Context(), statusTimeout)
+	defer cancel()
+	report, err := deployService.StatusAll(ctx, path)
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return c.JSON(http.StatusGatewayTimeout, apiError{Error: fmt.Sprintf("getting the status took more than %v", statusTimeout)})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, apiError{Error: fmt.Sprintf("error getting status: %v", err)})
+	}
+	return c.JSON(http.StatusOK, report)
+}
+
+func processListJobs(c echo.Context, webhookService domain.WebHookService) error {
+	pending, err := webhookService.PendingJobs()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, apiError{Error: fmt.Sprintf("error reading job queue: %v", err)})
+	}
+	dead, err := webhookService.DeadJobs()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, apiError{Error: fmt.Sprintf("error reading dead jobs: %v", err)})
+	}
+
+	running := webhookService.RunningJobs()
+	runningIds := lo.Map(running, func(job model.Job, _ int) string { return job.Id })
+
+	return c.JSON(http.StatusOK, apiJobs{
+		Running: running,
+		Pending: lo.Reject(pending, func(job model.Job, _ int) bool { return lo.Contains(runningIds, job.Id) }),
+		Dead:    dead,
+	})
+}
+
+func processJobLog(c echo.Context, webhookService domain.WebHookService) error {
+	jobId := c.Param("id")
+	lines, found := webhookService.JobLog(jobId)
+	if !found {
+		return c.JSON(http.StatusNotFound, apiError{Error: fmt.Sprintf("no log for job '%s', it may not have run since the last restart", jobId)})
+	}
+	return c.JSON(http.StatusOK, apiJobLog{JobId: jobId, Lines: lines})
+}
+
+// processSync Queues a sync of one app (?app=<name>), one project (?project=<name>), or everything
+func processSync(ctx context.Context, c echo.Context, path string, listings *domain.TreeListingCache, webhookService domain.WebHookService) error {
+	filter := model.AppFilter{
+		App:     c.QueryParam("app"),
+		Project: c.QueryParam("project"),
+	}
+
+	if !filter.IsEmpty() {
+		missingApp := func(listing model.TreeListing) bool { return filter.App != "" && !listing.HasApp(filter.App) }
+		missingProject := func(listing model.TreeListing) bool {
+			return filter.Project != "" && !listing.HasProject(filter.Project)
+		}
+
+		listing, err := listings.Get(ctx)
+		if err == nil && (missingApp(listing) || missingProject(listing)) {
+			// it may have been added since the tree was last listed
+			listings.Invalidate()
+			listing, err = listings.Get(ctx)
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, apiError{Error: fmt.Sprintf("error listing apps: %v", err)})
+		}
+		if missingApp(listing) {
+			return c.JSON(http.StatusNotFound, apiError{Error: fmt.Sprintf("no app '%s' in %s", filter.App, path)})
+		}
+		if missingProject(listing) {
+			return c.JSON(http.StatusNotFound, apiError{Error: fmt.Sprintf("no project '%s' in %s", filter.Project, path)})
+		}
+	}
+
+	job, err := webhookService.SubmitJob(model.NewSyncJob(path).WithOnly(filter).WithTrigger(model.TriggerApi))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, apiError{Error: err.Error()})
+	}
+
+	return c.JSON(http.StatusAccepted, job)
+}
+
+// processHistory Lists recent deploy attempts, newest first. Query params: app (optional), limit (default 100)
+func processHistory(c echo.Context, deployHistory model.DeployHistory) error {
+	limit := 100
+	if limitStr := c.QueryParam("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit < 0 {
+			return c.JSON(http.StatusBadRequest, apiError{Error: fmt.Sprintf("invalid limit '%s'", limitStr)})
+		}
+	}
+
+	records, err := deployHistory.List(c.QueryParam("app"), limit)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, apiError{Error: fmt.Sprintf("error reading deploy history: %v", err)})
+	}
+
+	return c.JSON(http.StatusOK, records)
+}
@@ -2,17 +2,22 @@ package monitor
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"github.com/gigurra/flycd/pkg/domain"
 	"github.com/gigurra/flycd/pkg/domain/model"
 	"github.com/gigurra/flycd/pkg/ext/fly_client"
-	"github.com/gigurra/flycd/pkg/ext/github"
+	"github.com/gigurra/flycd/pkg/ext/notify"
+	"github.com/gigurra/flycd/pkg/ext/webhooks"
 	"github.com/gigurra/flycd/pkg/util/util_cobra"
+	"github.com/gigurra/flycd/pkg/util/util_log"
+	"github.com/gigurra/flycd/pkg/util/util_metrics"
+	"github.com/gigurra/flycd/pkg/util/util_trace"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
+	"github.com/samber/lo"
 	"github.com/spf13/cobra"
 	"io"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
@@ -22,11 +27,28 @@ import (
 	"time"
 )
 
+// notifyFlushTimeout How long to wait for deploy notifications on shutdown
+const notifyFlushTimeout = 10 * time.Second
+
 type flags struct {
 	whIfc       *string
 	whPath      *string
 	whPort      *int
 	startupSync *bool
+	reconcile   *time.Duration
+	sync        *time.Duration
+	poll        *time.Duration
+	pollState   *string
+	whSecrets   *[]string
+	whUnsigned  *bool
+	queueDir    *string
+	maxAttempts *int
+	workers     *int
+	orgLimits   *[]string
+	apiTokens   *[]string
+	metrics     *bool
+	metricsAddr *string
+	status      *time.Duration
 }
 
 func (f *flags) Init(cmd *cobra.Command) {
@@ -34,6 +56,91 @@ func (f *flags) Init(cmd *cobra.Command) {
 	f.whPath = cmd.Flags().StringP("webhook-path", "w", os.Getenv("WEBHOOK_PATH"), "Webhook path")
 	f.whPort = cmd.Flags().IntP("webhook-port", "p", defaultWhPort(), "Webhook port")
 	f.startupSync = cmd.Flags().BoolP("sync-on-startup", "s", false, "Sync all apps on startup")
+	f.reconcile = cmd.Flags().DurationP("reconcile-interval", "r", defaultInterval("RECONCILE_INTERVAL", 0), "How often to repair drifted volumes, ips and scale of all apps, e.g. 10m (0 to disable)")
+	f.sync = cmd.Flags().DurationP("sync-interval", "y", defaultInterval("SYNC_INTERVAL", 0), "How often to sync/deploy all apps from fresh git state (0 to disable)")
+	f.whSecrets = cmd.Flags().StringSliceP("webhook-secret", "k", defaultWhSecrets(), "Secret(s) to verify webhook signatures with. Several can be given while rotating secrets")
+	f.whUnsigned = cmd.Flags().BoolP("allow-unsigned-webhooks", "", os.Getenv("ALLOW_UNSIGNED_WEBHOOKS") == "true", "Accept webhooks without a webhook secret configured. Anyone who can reach the webhook path can then trigger deploys")
+	f.poll = cmd.Flags().DurationP("poll-interval", "g", defaultInterval("POLL_INTERVAL", 0), "How often to poll all referenced git repos for new commits, as an alternative to webhooks (0 to disable)")
+	f.pollState = cmd.Flags().StringP("poll-state-file", "", os.Getenv("POLL_STATE_FILE"), "File to keep the last polled commits in, so pushes while flycd is down are deployed after a restart")
+	f.queueDir = cmd.Flags().StringP("queue-dir", "q", os.Getenv("QUEUE_DIR"), "Dir to persist the job queue in, so queued deploys survive restarts (empty to keep it in memory)")
+	f.maxAttempts = cmd.Flags().IntP("max-attempts", "m", defaultInt("MAX_JOB_ATTEMPTS", 3), "How many times to try a failing job before moving it to the dead letters")
+	f.workers = cmd.Flags().IntP("workers", "n", defaultInt("WORKERS", 1), "How many jobs to run in parallel. The same app is never deployed by two jobs at once")
+	f.orgLimits = cmd.Flags().StringSliceP("org-limit", "l", defaultOrgLimits(), "Max parallel app deploys per org, as org=N. Use *=N for orgs not listed")
+	f.apiTokens = cmd.Flags().StringSliceP("api-token", "t", defaultApiTokens(), "Bearer token(s) for the management api under /api. The api is disabled without any")
+	f.metrics = cmd.Flags().BoolP("metrics", "x", os.Getenv("METRICS") == "true", "Serve prometheus metrics on /metrics. They are not authenticated, see --metrics-address")
+	f.metricsAddr = cmd.Flags().StringP("metrics-address", "", os.Getenv("METRICS_ADDRESS"), "Serve the metrics on this address, e.g. fly-local-6pn:9091, instead of on the public webhook port")
+	f.status = cmd.Flags().DurationP("status-interval", "c", defaultInterval("STATUS_INTERVAL", 0), "How often to check which apps are out of sync, for the metrics (0 to disable)")
+}
+
+func defaultApiTokens() []string {
+	tokens := os.Getenv("API_TOKEN")
+	if tokens == "" {
+		return []string{}
+	}
+	return strings.Split(tokens, ",")
+}
+
+func defaultOrgLimits() []string {
+	limits := os.Getenv("ORG_LIMITS")
+	if limits == "" {
+		return []string{}
+	}
+	return strings.Split(limits, ",")
+}
+
+func parseOrgLimits(entries []string) (map[string]int, error) {
+	result := map[string]int{}
+	for _, entry := range entries {
+		org, limitStr, found := strings.Cut(entry, "=")
+		org = strings.TrimSpace(org)
+		if !found || org == "" {
+			return nil, fmt.Errorf("invalid org limit '%s', expected org=N", entry)
+		}
+		limit, err := strconv.Atoi(strings.TrimSpace(limitStr))
+		if err != nil || limit < 0 {
+			return nil, fmt.Errorf("invalid org limit '%s', expected a non-negative integer", entry)
+		}
+		result[org] = limit
+	}
+	return result, nil
+}
+
+func defaultWhSecrets() []string {
+	secrets := os.Getenv("WEBHOOK_SECRET")
+	if secrets == "" {
+		return []string{}
+	}
+	return strings.Split(secrets, ",")
+}
+
+func defaultInterval(envVar string, fallback time.Duration) time.Duration {
+
+	intervalStr := os.Getenv(envVar)
+	if intervalStr == "" {
+		return fallback
+	}
+
+	interval, err := time.ParseDuration(intervalStr)
+	if err != nil {
+		panic(fmt.Errorf("invalid %s (not a valid duration): '%s', %w", envVar, intervalStr, err))
+	}
+
+	return interval
+}
+
+func defaultInt(envVar string, fallback int) int {
+
+	valueStr := os.Getenv(envVar)
+	if valueStr == "" {
+		return fallback
+	}
+
+	value, err := strconv.Atoi(valueStr)
+	if err != nil {
+		panic(fmt.Errorf("invalid %s (not a valid integer): '%s', %w", envVar, valueStr, err))
+	}
+
+	return value
 }
 
 func defaultWhPort() int {
@@ -67,7 +174,7 @@ func handleShutdown(shutdownHandler func()) {
 	go func() {
 		select {
 		case s := <-sig:
-			fmt.Printf("Received signal: %s\n", s.String())
+			slog.Info("Received signal", "signal", s.String())
 			shutdownHandler()
 		}
 	}()
@@ -78,6 +185,9 @@ func Cmd(
 	flyClient fly_client.FlyClient,
 	deployService domain.DeployService,
 	webhookService domain.WebHookService,
+	gitPollService domain.GitPollService,
+	deployHistory model.DeployHistory,
+	notifier *notify.HttpNotifier,
 ) *cobra.Command {
 	flags := flags{}
 	return util_cobra.CreateCmd(&flags, func() *cobra.Command {
@@ -89,7 +199,7 @@ func Cmd(
 
 				path, err := os.Getwd()
 				if err != nil {
-					fmt.Printf("Error getting current working directory: %v\n", err)
+					slog.Error("Error getting current working directory", "error", err)
 					os.Exit(1)
 				}
 
@@ -97,36 +207,36 @@ func Cmd(
 					path = args[0]
 				}
 
-				fmt.Printf("Monitoring: %s\n", path)
+				slog.Info("Monitoring", "path", path)
 
 				// Get access token from env var
 				accessToken := os.Getenv("FLY_ACCESS_TOKEN")
 				if accessToken == "" {
-					fmt.Printf("WARNING: FLY_ACCESS_TOKEN env var not set. Proceeding and assuming you are running locally logged in...\n")
+					slog.Warn("FLY_ACCESS_TOKEN env var not set. Proceeding and assuming you are running locally logged in...")
 				} else {
 					ctx = context.WithValue(ctx, "FLY_ACCESS_TOKEN", accessToken)
 				}
 
 				// Get flycd ssh key from env var
-				fmt.Printf("Checking if to store ssh... \n")
+				slog.Debug("Checking if to store ssh...")
 				sshKey := os.Getenv("FLY_SSH_PRIVATE_KEY")
 				sshKeyName := os.Getenv("FLY_SSH_PRIVATE_KEY_NAME")
 				if sshKey == "" {
-					fmt.Printf("WARNING: FLY_SSH_PRIVATE_KEY env var not set. Proceeding and assuming you only want to access public repos, or you have magically solved git auth in some other way...\n")
+					slog.Warn("FLY_SSH_PRIVATE_KEY env var not set. Proceeding and assuming you only want to access public repos, or you have magically solved git auth in some other way...")
 				} else {
 
-					fmt.Printf("FLY_SSH_PRIVATE_KEY env var is set, so we probably want o do something... \n")
+					slog.Debug("FLY_SSH_PRIVATE_KEY env var is set, so we probably want o do something...")
 
 					if sshKeyName == "" {
-						fmt.Printf("FLY_SSH_PRIVATE_KEY_NAME env var not set, so just guessing we want 'id_rsa'\n")
+						slog.Info("FLY_SSH_PRIVATE_KEY_NAME env var not set, so just guessing we want 'id_rsa'")
 						sshKeyName = "id_rsa"
 					}
 
-					fmt.Printf("Checking if to store ssh key: %s\n", sshKeyName)
+					slog.Debug("Checking if to store ssh key", "key", sshKeyName)
 
 					homeDir, err := os.UserHomeDir()
 					if err != nil {
-						fmt.Printf("Error getting user home directory: %v\n", err)
+						slog.Error("Error getting user home directory", "error", err)
 						os.Exit(1)
 					}
 
@@ -135,75 +245,128 @@ func Cmd(
 
 					// Ensure ssh dir exists
 					if _, err := os.Stat(sshDir); os.IsNotExist(err) {
-						fmt.Printf("ssh dir does not exist: %s\n", sshDir)
+						slog.Error("ssh dir does not exist", "dir", sshDir)
 						os.Exit(1)
 					}
 
 					// Don't overwrite existing key
 					if _, err := os.Stat(sshKeyPath); !os.IsNotExist(err) {
-						fmt.Printf("ssh key already exists. Skipping copy from env var -> %s\n", sshKeyPath)
+						slog.Info("ssh key already exists. Skipping copy from env var", "path", sshKeyPath)
 					} else {
 
 						// Write key to file
 						err = os.WriteFile(sshKeyPath, []byte(sshKey), 0600)
 						if err != nil {
-							fmt.Printf("Error writing ssh key to file: %v\n", err)
+							slog.Error("Error writing ssh key to file", "error", err)
 							os.Exit(1)
 						}
 
-						fmt.Printf("Stored ssh key: %s\n", sshKeyPath)
+						slog.Info("Stored ssh key", "path", sshKeyPath)
 					}
 				}
 
 				// ensure we have a token loaded for the org we are monitoring, by listing apps
 				appstList, err := flyClient.ListApps(ctx)
 				if err != nil {
-					fmt.Printf("Error listing apps (do you have a valid fly.io token loaded?): %v\n", err)
+					slog.Error("Error listing apps (do you have a valid fly.io token loaded?)", "error", err)
 					os.Exit(1)
 				}
 
-				fmt.Printf("Currently deployed apps: \n")
 				for _, app := range appstList {
-					fmt.Printf(" - name=%s, org=%s\n", app.Name, app.Org)
+					slog.Info("Currently deployed app", util_log.KeyApp, app.Name, "org", app.Org)
 				}
 
-				err = webhookService.Start(ctx)
+				orgLimits, err := parseOrgLimits(*flags.orgLimits)
 				if err != nil {
-					fmt.Printf("Error starting webhook service: %v\n", err)
+					slog.Error("Error parsing org limits", "error", err)
+					os.Exit(1)
+				}
+
+				// The api lists the tree often, but it only changes with the syncs and pushes that deploy it
+				listings := domain.NewTreeListingCache(path)
+
+				workerCfg := model.
+					NewDefaultWorkerConfig().
+					WithMaxAttempts(*flags.maxAttempts).
+					WithWorkers(*flags.workers).
+					WithOrgLimits(orgLimits).
+					WithOnJobDone(func(job model.Job) {
+						if job.Type != model.JobTypeReconcile {
+							listings.Invalidate()
+							gitPollService.Invalidate()
+						}
+					})
+				if *flags.queueDir != "" {
+					slog.Info("Persisting job queue", "dir", *flags.queueDir)
+					queue, err := domain.NewFileJobQueue(*flags.queueDir)
+					if err != nil {
+						slog.Error("Error opening job queue", "error", err)
+						os.Exit(1)
+					}
+					workerCfg = workerCfg.WithQueue(queue)
+				} else {
+					slog.Warn("No job queue dir configured (--queue-dir or QUEUE_DIR). Queued deploys are lost on restart!")
+				}
+
+				err = webhookService.Start(ctx, workerCfg)
+				if err != nil {
+					slog.Error("Error starting webhook service", "error", err)
 					os.Exit(1)
 				}
 
 				if *flags.startupSync {
-					fmt.Printf("Syncing/Deploying all apps in %s\n", path)
+					webhookService.EnqueueJob(model.NewSyncJob(path).WithTrigger(model.TriggerStartupSync))
+				}
 
-					deployCfg := model.
-						NewDefaultDeployConfig().
-						WithAbortOnFirstError(false)
+				if *flags.sync > 0 {
+					slog.Info("Syncing all apps periodically", "path", path, "interval", *flags.sync)
+					runPeriodically(ctx, webhookService, *flags.sync, model.NewSyncJob(path))
+				}
 
-					_, err := deployService.DeployAll(ctx, path, deployCfg)
+				if *flags.reconcile > 0 {
+					slog.Info("Reconciling infra of all apps periodically", "path", path, "interval", *flags.reconcile)
+					runPeriodically(ctx, webhookService, *flags.reconcile, model.NewReconcileJob(path))
+				}
+
+				if *flags.poll > 0 {
+					err = gitPollService.SetStateFile(*flags.pollState)
 					if err != nil {
-						fmt.Printf("Error deploying: %v\n", err)
+						slog.Error("Error loading git poll state", "error", err)
+						os.Exit(1)
 					}
+					slog.Info("Polling git repos periodically", "path", path, "interval", *flags.poll)
+					pollPeriodically(ctx, gitPollService, path, *flags.poll)
+				}
 
+				if *flags.status > 0 {
+					slog.Info("Checking the sync status of all apps periodically", "path", path, "interval", *flags.status)
+					checkStatusPeriodically(ctx, deployService, path, *flags.status)
 				}
 
 				// Install shutdown signal handler
-				fmt.Printf("Installing shutdown signal handler\n")
+				slog.Debug("Installing shutdown signal handler")
 				handleShutdown(func() {
-					fmt.Printf("Placing 'shutdown-application' job at the end of the current local job queue\n")
-					webhookService.EnqueueJob(func() {
-						fmt.Printf("Reached end of job queue, shutting down!\n")
-						os.Exit(0)
-					})
+					slog.Info("Waiting for the current job to finish before shutting down")
 					webhookService.CloseJobQueue()
+					slog.Info("Job queue closed, shutting down!")
+					if !notifier.Flush(notifyFlushTimeout) {
+						slog.Warn("Gave up waiting for deploy notifications to be sent", "timeout", notifyFlushTimeout)
+					}
+					err := util_trace.Shutdown(context.Background())
+					if err != nil {
+						slog.Error("Error flushing traces", "error", err)
+					}
+					os.Exit(0)
 				})
 
 				// Echo instance
-				fmt.Printf("Starting echo http server\n")
+				slog.Info("Starting echo http server")
 				e := echo.New()
+				e.HideBanner = true
+				e.HidePort = true
 
 				// Middleware
-				e.Use(middleware.Logger())
+				e.Use(requestLogger())
 				e.Use(middleware.Recover())
 
 				whPath := *flags.whPath
@@ -214,67 +377,232 @@ func Cmd(
 					whPath = "/" + whPath
 				}
 
-				fmt.Printf("Listening on webhook path: %s\n", whPath)
-				fmt.Printf("Listening on webhook port: %d\n", *flags.whPort)
+				whSecrets := lo.Compact(lo.Map(*flags.whSecrets, func(secret string, _ int) string { return strings.TrimSpace(secret) }))
+				allowUnsigned := len(whSecrets) == 0 && *flags.whUnsigned
+				if allowUnsigned {
+					slog.Warn("No webhook secret configured (--webhook-secret or WEBHOOK_SECRET). Anyone who can reach the webhook path can trigger deploys!", "path", whPath)
+				} else if len(whSecrets) == 0 {
+					slog.Warn("No webhook secret configured (--webhook-secret or WEBHOOK_SECRET). All webhooks are rejected, unless --allow-unsigned-webhooks is given", "path", whPath)
+				}
+
+				slog.Info("Listening for webhooks", "path", whPath, "interface", *flags.whIfc, "port", *flags.whPort)
 
 				// Routes
 				e.GET("/", processHealth)
 				e.POST(whPath, func(c echo.Context) error {
-					return processWebhook(c, path, webhookService)
+					return processWebhook(c, path, webhookService, whSecrets, allowUnsigned)
 				})
 
+				if *flags.metrics {
+					observeJobQueue(webhookService)
+					if *flags.metricsAddr != "" {
+						slog.Info("Serving prometheus metrics on /metrics", "address", *flags.metricsAddr)
+						serveMetrics(*flags.metricsAddr)
+					} else {
+						slog.Warn("Serving prometheus metrics on /metrics of the webhook port. Anyone who can reach it can read them. Use --metrics-address to serve them elsewhere")
+						e.GET("/metrics", echo.WrapHandler(util_metrics.Handler()))
+					}
+				}
+
+				apiTokens := lo.Compact(lo.Map(*flags.apiTokens, func(token string, _ int) string { return strings.TrimSpace(token) }))
+				if len(apiTokens) > 0 {
+					slog.Info("Serving the management api on /api, and the web ui on /ui")
+					registerApi(ctx, e, path, apiTokens, deployService, webhookService, deployHistory, listings)
+					registerUi(e)
+				} else {
+					slog.Info("No api token configured (--api-token or API_TOKEN), so the management api and web ui are disabled")
+				}
+
 				// Start server
-				e.Logger.Fatal(e.Start(fmt.Sprintf("%s:%d", *flags.whIfc, *flags.whPort)))
+				err = e.Start(fmt.Sprintf("%s:%d", *flags.whIfc, *flags.whPort))
+				slog.Error("Error running echo http server", "error", err)
+				os.Exit(1)
 			},
 		}
 	})
 }
 
-// Handler
-func processWebhook(c echo.Context, path string, webhookService domain.WebHookService) error {
+// runPeriodically Puts the job on the webhook service's job queue every interval, so that it never
+// runs in parallel with deploys. Runs that are still waiting in the queue are merged by the queue.
+func runPeriodically(ctx context.Context, webhookService domain.WebHookService, interval time.Duration, job model.Job) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				webhookService.EnqueueJob(job)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// pollPeriodically Polls outside the job queue, since polling itself doesn't deploy anything.
+// Deploys for changed repos are put on the job queue by the poll service.
+func pollPeriodically(ctx context.Context, gitPollService domain.GitPollService, path string, interval time.Duration) {
+	poll := func() {
+		changedRepos, err := gitPollService.Poll(ctx, path)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error polling git repos", "error", err)
+			return
+		}
+		for _, repo := range changedRepos {
+			slog.InfoContext(ctx, "Queued deploys for new commits", "repo", repo)
+		}
+	}
+	go func() {
+		poll() // record the current state of all repos, and deploy what changed since the state file was saved
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				poll()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// checkStatusPeriodically Runs status checks outside the job queue, since they don't change anything.
+// Every status check updates the sync status metrics.
+func checkStatusPeriodically(ctx context.Context, deployService domain.DeployService, path string, interval time.Duration) {
+	check := func() {
+		_, err := deployService.StatusAll(ctx, path)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error checking the sync status of apps", "error", err)
+		}
+	}
+	go func() {
+		check()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				check()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// serveMetrics Serves the metrics on their own address, e.g. one only reachable inside fly.io's private network
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", util_metrics.Handler())
+	go func() {
+		err := http.ListenAndServe(addr, mux)
+		slog.Error("Error serving metrics", "address", addr, "error", err)
+		os.Exit(1)
+	}()
+}
+
+// observeJobQueue Exposes the size of the job queue as metrics
+func observeJobQueue(webhookService domain.WebHookService) {
+	util_metrics.ObserveJobQueue(
+		func() int {
+			pending, err := webhookService.PendingJobs()
+			if err != nil {
+				slog.Error("Error reading job queue for metrics", "error", err)
+				return 0
+			}
+			running := lo.Map(webhookService.RunningJobs(), func(job model.Job, _ int) string { return job.Id })
+			return len(lo.Reject(pending, func(job model.Job, _ int) bool { return lo.Contains(running, job.Id) }))
+		},
+		func() int {
+			return len(webhookService.RunningJobs())
+		},
+		func() int {
+			dead, err := webhookService.DeadJobs()
+			if err != nil {
+				slog.Error("Error reading dead jobs for metrics", "error", err)
+				return 0
+			}
+			return len(dead)
+		},
+	)
+}
+
+// Handler. Webhooks are only accepted without a secret to verify them with if allowUnsigned
+func processWebhook(c echo.Context, path string, webhookService domain.WebHookService, whSecrets []string, allowUnsigned bool) error {
+
+	provider := webhooks.Detect(c.Request().Header)
+	util_metrics.WebhookReceived(provider.Name())
+
+	// the jobs queued by the webhook share its correlation id
+	correlationId := util_log.NewCorrelationId()
+	ctx := util_log.With(c.Request().Context(), util_log.KeyCorrelationId, correlationId, "provider", provider.Name())
 
 	body := c.Request().Body
 	bodyBytes, err := io.ReadAll(body)
 	if err != nil {
+		slog.ErrorContext(ctx, "Rejecting webhook: error reading request body", "error", err)
+		util_metrics.WebhookRejected(provider.Name(), "unreadable_body")
 		return c.String(http.StatusUnsupportedMediaType, "Error reading request body")
 	}
 	defer func(body io.Closer) {
 		err := body.Close()
 		if err != nil {
-			fmt.Printf("Error closing request body: %v\n", err)
+			slog.ErrorContext(ctx, "Error closing request body", "error", err)
 		}
 	}(body)
 
+	if len(whSecrets) == 0 && !allowUnsigned {
+		slog.ErrorContext(ctx, "Rejecting webhook: no webhook secret configured to verify it with")
+		util_metrics.WebhookRejected(provider.Name(), "no_secret")
+		return c.String(http.StatusUnauthorized, "No webhook secret configured")
+	}
+
+	if len(whSecrets) > 0 {
+		err = provider.Verify(c.Request().Header, bodyBytes, whSecrets)
+		if err != nil {
+			slog.ErrorContext(ctx, "Rejecting webhook: invalid signature", "error", err)
+			util_metrics.WebhookRejected(provider.Name(), "invalid_signature")
+			return c.String(http.StatusUnauthorized, "Invalid webhook signature")
+		}
+	}
+
+	// Only now that we know who sent it, so that anyone can't write what they want into the logs
 	truncatedBodyStr := string(bodyBytes)
 	// Truncate to max 512 bytes
 	if len(truncatedBodyStr) > 512 {
 		truncatedBodyStr = truncatedBodyStr[:512] + "..."
 	}
 
-	fmt.Printf("Received webhook: %s\n", truncatedBodyStr)
+	slog.DebugContext(ctx, "Received webhook", "body", truncatedBodyStr)
 
-	// Try to deserialize as GitHub webhook payload
-	var githubWebhookPayload github.PushWebhookPayload
-	err = json.Unmarshal(bodyBytes, &githubWebhookPayload)
+	events, err := provider.Parse(c.Request().Header, bodyBytes)
 	if err != nil {
-		fmt.Printf("ERROR: deserializing github webhook payload: %v\n", err)
+		slog.ErrorContext(ctx, "Rejecting webhook: invalid payload", "error", err)
+		util_metrics.WebhookRejected(provider.Name(), "invalid_payload")
 		return c.String(http.StatusBadRequest, "Error deserializing webhook payload")
 	}
 
-	ch := webhookService.HandleGithubWebhook(githubWebhookPayload, path)
-	// TODO: Probably busy processing... Fix later and hand over to persistent queue
-	select {
-	case result := <-ch:
-		if result != nil {
-			fmt.Printf("ERROR: handling github webhook: %v\n", result)
-			return c.String(http.StatusInternalServerError, "something went wrong - check flycd server logs!")
-		} else {
-			return c.String(http.StatusAccepted, "Too fast... something could be wrong")
+	if len(events) == 0 {
+		slog.InfoContext(ctx, "Ignoring webhook without any pushes to deploy")
+		return c.String(http.StatusOK, "Ignoring event, only pushes to branches and tags are deployed")
+	}
+
+	util_metrics.WebhookAccepted(provider.Name())
+
+	// The jobs are in the (possibly persistent) job queue now, so the push is as good as handled
+	jobIds := make([]string, 0, len(events))
+	for _, event := range events {
+		job, err := webhookService.SubmitJob(model.NewPushJob(event, path).WithCorrelationId(correlationId))
+		if err != nil {
+			slog.ErrorContext(ctx, "Error queueing deploy", "push", event.Description(), "error", err)
+			return c.String(http.StatusInternalServerError, "Error queueing deploy - check flycd server logs!")
 		}
-	case <-time.After(1 * time.Second):
-		return c.String(http.StatusAccepted, "This is probably ok ;). ")
+		slog.InfoContext(ctx, "Queued deploy", "push", event.Description(), util_log.KeyJobId, job.Id)
+		jobIds = append(jobIds, job.Id)
 	}
 
+	return c.String(http.StatusAccepted, fmt.Sprintf("Queued deploy job(s) %s", strings.Join(jobIds, ", ")))
 }
 
 // Handler
@@ -282,3 +610,30 @@ func processHealth(c echo.Context) error {
 
 	return c.String(http.StatusOK, "Hello, World!")
 }
+
+// requestLogger Logs every http request, like echo's logger middleware but with slog
+func requestLogger() echo.MiddlewareFunc {
+	return middleware.RequestLoggerWithConfig(middleware.RequestLoggerConfig{
+		Skipper: func(c echo.Context) bool {
+			// health checks and metric scrapes would drown out everything else
+			return c.Path() == "/" || c.Path() == "/metrics"
+		},
+		LogMethod:  true,
+		LogURI:     true,
+		LogStatus:  true,
+		LogLatency: true,
+		LogError:   true,
+		LogValuesFunc: func(c echo.Context, v middleware.RequestLoggerValues) error {
+			level := slog.LevelInfo
+			if v.Error != nil || v.Status >= http.StatusInternalServerError {
+				level = slog.LevelError
+			}
+			attrs := []any{"method", v.Method, "uri", v.URI, "status", v.Status, "latency", v.Latency}
+			if v.Error != nil {
+				attrs = append(attrs, "error", v.Error)
+			}
+			slog.Log(c.Request().Context(), level, "Handled request", attrs...)
+			return nil
+		},
+	})
+}
@@ -0,0 +1,24 @@
+package monitor
+
+import (
+	"embed"
+	"github.com/labstack/echo/v4"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed ui
+var uiAssets embed.FS
+
+// registerUi Serves the web ui under /ui. The assets themselves are public, the ui asks
+// for an api token and uses it for all its calls to /api.
+func registerUi(e *echo.Echo) {
+	assets, err := fs.Sub(uiAssets, "ui")
+	if err != nil {
+		panic(err) // can only happen if the embed directive above is broken
+	}
+	e.GET("/ui", func(c echo.Context) error {
+		return c.Redirect(http.StatusMovedPermanently, "/ui/")
+	})
+	e.GET("/ui/*", echo.WrapHandler(http.StripPrefix("/ui/", http.FileServer(http.FS(assets)))))
+}
@@ -0,0 +1,274 @@
+// FlyCD web ui. Talks to the monitor's management api (/api), with a token kept in localStorage.
+"use strict";
+
+const tokenKey = "flycd-api-token";
+const jobPollMillis = 2000;
+
+const state = {
+    listing: {apps: [], projects: []},
+    status: null, // from /api/status, only loaded on demand since it queries fly.io
+    runningIds: "",
+    pollTimer: null,
+};
+
+function $(id) {
+    return document.getElementById(id);
+}
+
+// el Creates an element. Children can be elements or strings, which are always added as text.
+function el(tag, attrs, ...children) {
+    const element = document.createElement(tag);
+    for (const [key, value] of Object.entries(attrs || {})) {
+        if (key.startsWith("on")) {
+            element.addEventListener(key.substring(2), value);
+        } else {
+            element.setAttribute(key, value);
+        }
+    }
+    for (const child of children.flat()) {
+        if (child !== null && child !== undefined) {
+            element.append(child);
+        }
+    }
+    return element;
+}
+
+function badge(text, cls) {
+    return el("span", {class: "badge " + (cls || text)}, text);
+}
+
+function showError(message) {
+    $("error").textContent = message;
+    $("error").hidden = !message;
+}
+
+async function api(method, path) {
+    const response = await fetch("/api" + path, {
+        method: method,
+        headers: {"Authorization": "Bearer " + localStorage.getItem(tokenKey)},
+    });
+    if (response.status === 401) {
+        logout();
+        throw new Error("the api token was not accepted");
+    }
+    const body = await response.json();
+    if (!response.ok) {
+        throw new Error(body.error || response.statusText);
+    }
+    return body;
+}
+
+function ago(time) {
+    const seconds = Math.round((Date.now() - new Date(time).getTime()) / 1000);
+    if (seconds < 60) return seconds + "s ago";
+    if (seconds < 3600) return Math.round(seconds / 60) + "m ago";
+    if (seconds < 86400) return Math.round(seconds / 3600) + "h ago";
+    return Math.round(seconds / 86400) + "d ago";
+}
+
+// ---- apps tree ----
+
+function buildTree() {
+    const root = {projects: new Map(), apps: []};
+    const nodeOf = (names) => {
+        let node = root;
+        for (const name of names) {
+            if (!node.projects.has(name)) {
+                node.projects.set(name, {project: null, projects: new Map(), apps: []});
+            }
+            node = node.projects.get(name);
+        }
+        return node;
+    };
+    for (const project of state.listing.projects) {
+        nodeOf([...(project.projects || []), project.project]).project = project;
+    }
+    for (const app of state.listing.apps) {
+        nodeOf(app.projects || []).apps.push(app);
+    }
+    return root;
+}
+
+function syncButton(label, query) {
+    return el("button", {
+        onclick: async (event) => {
+            event.target.disabled = true;
+            try {
+                await api("POST", "/sync" + query);
+                showError("");
+                await refreshJobs();
+            } catch (err) {
+                showError("Error queueing sync: " + err.message);
+            } finally {
+                event.target.disabled = false;
+            }
+        },
+    }, label);
+}
+
+function renderApp(app) {
+    const status = state.status && state.status.apps.find((s) => s.path === app.path);
+    const last = app.last_deploy;
+
+    let syncState = badge("unknown", "");
+    if (app.error) {
+        syncState = badge("failing");
+    } else if (status) {
+        syncState = badge(status.status);
+    }
+
+    let lastDeploy = el("span", {class: "detail"}, "never deployed");
+    if (last) {
+        lastDeploy = el("span", {class: "detail", title: last.error || last.trigger || ""},
+            last.error ? badge("failed") : badge(last.result),
+            " " + ago(last.time) + (last.trigger ? " by " + last.trigger : ""));
+    }
+
+    const detail = app.error || (status && status.error) || "";
+    return el("li", {},
+        el("div", {class: "node app"},
+            el("span", {class: "name"}, app.app, " ", el("span", {class: "detail"}, app.org || "")),
+            syncState,
+            lastDeploy,
+            syncButton("Sync", "?app=" + encodeURIComponent(app.app))),
+        detail ? el("div", {class: "detail"}, detail) : null);
+}
+
+function renderNode(node) {
+    const items = node.apps.map(renderApp);
+    for (const [name, child] of node.projects) {
+        items.push(el("li", {},
+            el("div", {class: "node project"},
+                el("span", {class: "name"}, name),
+                child.project && child.project.error ? badge("failing") : null,
+                syncButton("Sync project", "?project=" + encodeURIComponent(name))),
+            child.project && child.project.error ? el("div", {class: "detail"}, child.project.error) : null,
+            renderNode(child)));
+    }
+    return el("ul", {class: "tree"}, items);
+}
+
+function renderTree() {
+    $("tree").replaceChildren(renderNode(buildTree()));
+    if (state.status) {
+        const counts = {};
+        for (const app of state.status.apps) {
+            counts[app.status] = (counts[app.status] || 0) + 1;
+        }
+        $("status-summary").textContent = state.status.apps.length + " apps: " +
+            Object.entries(counts).map(([status, count]) => count + " " + status).join(", ");
+    } else {
+        $("status-summary").textContent = "Sync state not checked yet.";
+    }
+}
+
+async function refreshApps() {
+    state.listing = await api("GET", "/apps");
+    renderTree();
+}
+
+async function checkStatus() {
+    $("check-status").disabled = true;
+    $("status-summary").textContent = "Checking sync state against fly.io...";
+    try {
+        state.status = await api("GET", "/status");
+        showError("");
+    } catch (err) {
+        showError("Error checking sync state: " + err.message);
+    } finally {
+        $("check-status").disabled = false;
+        renderTree();
+    }
+}
+
+// ---- jobs ----
+
+function describeJob(job) {
+    if (job.type === "push" && job.push_event) {
+        const event = job.push_event;
+        return "push to " + (event.repo || event.repo_urls[0]) + " " + (event.ref || "") +
+            (event.commit ? " @ " + event.commit.substring(0, 8) : "");
+    }
+    if (job.only && job.only.app) return job.type + " of app " + job.only.app;
+    if (job.only && job.only.project) return job.type + " of project " + job.only.project;
+    return job.type + " of " + job.path;
+}
+
+function renderJob(job, kind, lines) {
+    return el("div", {class: "job"},
+        el("div", {}, badge(kind), " ", describeJob(job)),
+        el("div", {class: "detail"},
+            (job.trigger ? job.trigger + ", " : "") + "queued " + ago(job.enqueued_at) +
+            (job.attempts ? ", attempt " + job.attempts : "") +
+            (job.last_error ? ", last error: " + job.last_error : "")),
+        lines && lines.length ? el("pre", {}, lines.join("\n")) : null);
+}
+
+async function refreshJobs() {
+    const jobs = await api("GET", "/jobs");
+    const logs = await Promise.all(jobs.running.map((job) =>
+        api("GET", "/jobs/" + encodeURIComponent(job.id) + "/log").then((log) => log.lines, () => [])));
+
+    const items = [
+        ...jobs.running.map((job, i) => renderJob(job, "running", logs[i])),
+        ...jobs.pending.map((job) => renderJob(job, "pending")),
+        ...jobs.dead.map((job) => renderJob(job, "dead")),
+    ];
+    $("jobs").replaceChildren(...(items.length ? items : [el("p", {class: "muted"}, "No jobs running or queued.")]));
+
+    // a job finished (or started), so the last deploys may have changed
+    const runningIds = jobs.running.map((job) => job.id).join(",");
+    if (runningIds !== state.runningIds) {
+        state.runningIds = runningIds;
+        await refreshApps();
+    }
+}
+
+function pollJobs() {
+    refreshJobs()
+        .then(() => showError(""), (err) => showError("Error refreshing jobs: " + err.message))
+        .finally(() => {
+            if (localStorage.getItem(tokenKey)) {
+                state.pollTimer = setTimeout(pollJobs, jobPollMillis);
+            }
+        });
+}
+
+// ---- login ----
+
+function logout() {
+    localStorage.removeItem(tokenKey);
+    clearTimeout(state.pollTimer);
+    $("main").hidden = true;
+    $("actions").hidden = true;
+    $("login").hidden = false;
+}
+
+async function start() {
+    $("login").hidden = true;
+    $("main").hidden = false;
+    $("actions").hidden = false;
+    try {
+        await refreshApps();
+    } catch (err) {
+        showError("Error listing apps: " + err.message);
+        return;
+    }
+    pollJobs();
+}
+
+$("login").addEventListener("submit", (event) => {
+    event.preventDefault();
+    localStorage.setItem(tokenKey, $("token").value.trim());
+    $("token").value = "";
+    start();
+});
+$("logout").addEventListener("click", logout);
+$("check-status").addEventListener("click", checkStatus);
+$("sync-all").replaceWith(syncButton("Sync all", ""));
+
+if (localStorage.getItem(tokenKey)) {
+    start();
+} else {
+    logout();
+}
@@ -0,0 +1,99 @@
+package plan
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gigurra/flycd/pkg/domain"
+	"github.com/gigurra/flycd/pkg/domain/model"
+	"github.com/gigurra/flycd/pkg/util/util_cobra"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"text/tabwriter"
+)
+
+type flags struct {
+	force *bool
+	json  *bool
+}
+
+func (f *flags) Init(cmd *cobra.Command) {
+	f.force = cmd.Flags().BoolP("force", "f", false, "Plan as if deploying with --force")
+	f.json = cmd.Flags().BoolP("json", "j", false, "Print the plan as json instead of a table")
+}
+
+func Cmd(
+	ctx context.Context,
+	deployService domain.DeployService,
+) *cobra.Command {
+	flags := flags{}
+	return util_cobra.CreateCmd(&flags, func() *cobra.Command {
+		return &cobra.Command{
+			Use:   "plan <path>",
+			Short: "Show every action a deploy would take, without changing anything",
+			Args:  cobra.ExactArgs(1),
+			// The deploy logic is chatty. Keep stdout clean for the plan itself.
+			Annotations: map[string]string{util_cobra.AnnotationStdoutIsOutput: "true"},
+			Run: func(cmd *cobra.Command, args []string) {
+				path := args[0]
+
+				deployCfg := model.
+					NewDefaultDeployConfig().
+					WithForce(*flags.force)
+
+				plan, err := deployService.PlanAll(ctx, path, deployCfg)
+				if err != nil {
+					_, _ = fmt.Fprintf(os.Stderr, "Error planning: %v\n", err)
+					os.Exit(1)
+				}
+
+				if *flags.json {
+					encoder := json.NewEncoder(os.Stdout)
+					encoder.SetIndent("", "  ")
+					err = encoder.Encode(plan)
+					if err != nil {
+						_, _ = fmt.Fprintf(os.Stderr, "Error encoding plan: %v\n", err)
+						os.Exit(1)
+					}
+				} else {
+					printTable(os.Stdout, plan)
+				}
+
+				if plan.HasErrors() {
+					os.Exit(1)
+				}
+			},
+		}
+	})
+}
+
+func printTable(out io.Writer, plan model.DeployPlan) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintf(w, "APP\tCHANGE\tACTION\tDESCRIPTION\n")
+	numChanged := 0
+	for _, app := range plan.Apps {
+		switch {
+		case app.Error != "":
+			_, _ = fmt.Fprintf(w, "%s\t%s\t\t%s\n", app.App, "error", app.Error)
+		case !app.HasChanges():
+			_, _ = fmt.Fprintf(w, "%s\t%s\t\t\n", app.App, model.SingleAppDeployNoChange)
+		default:
+			numChanged++
+			for i, action := range app.Actions {
+				if i == 0 {
+					_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", app.App, app.Change, action.Type, action.Description)
+				} else {
+					_, _ = fmt.Fprintf(w, "\t\t%s\t%s\n", action.Type, action.Description)
+				}
+			}
+		}
+	}
+	for _, project := range plan.Projects {
+		if project.Error != "" {
+			_, _ = fmt.Fprintf(w, "project %s\t%s\t\t%s\n", project.Project, "error", project.Error)
+		}
+	}
+	_ = w.Flush()
+	_, _ = fmt.Fprintf(out, "\n%d of %d apps would change\n", numChanged, len(plan.Apps))
+}
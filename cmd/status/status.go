@@ -0,0 +1,116 @@
+package status
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gigurra/flycd/pkg/domain"
+	"github.com/gigurra/flycd/pkg/domain/model"
+	"github.com/gigurra/flycd/pkg/util/util_cobra"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+type flags struct {
+	json        *bool
+	exitOnDrift *bool
+}
+
+func (f *flags) Init(cmd *cobra.Command) {
+	f.json = cmd.Flags().BoolP("json", "j", false, "Print the report as json instead of a table")
+	f.exitOnDrift = cmd.Flags().BoolP("exit-code", "e", false, "Exit with code 2 if any app is not in sync")
+}
+
+func Cmd(
+	ctx context.Context,
+	deployService domain.DeployService,
+) *cobra.Command {
+	flags := flags{}
+	return util_cobra.CreateCmd(&flags, func() *cobra.Command {
+		return &cobra.Command{
+			Use:   "status <path>",
+			Short: "Report which apps differ from what is deployed on fly.io, without changing anything",
+			Args:  cobra.ExactArgs(1),
+			// The deploy logic is chatty. Keep stdout clean for the report itself.
+			Annotations: map[string]string{util_cobra.AnnotationStdoutIsOutput: "true"},
+			Run: func(cmd *cobra.Command, args []string) {
+				path := args[0]
+
+				report, err := deployService.StatusAll(ctx, path)
+				if err != nil {
+					_, _ = fmt.Fprintf(os.Stderr, "Error getting status: %v\n", err)
+					os.Exit(1)
+				}
+
+				if *flags.json {
+					encoder := json.NewEncoder(os.Stdout)
+					encoder.SetIndent("", "  ")
+					err = encoder.Encode(report)
+					if err != nil {
+						_, _ = fmt.Fprintf(os.Stderr, "Error encoding status report: %v\n", err)
+						os.Exit(1)
+					}
+				} else {
+					printTable(os.Stdout, report)
+				}
+
+				if *flags.exitOnDrift && !report.InSync() {
+					os.Exit(2)
+				}
+			},
+		}
+	})
+}
+
+func printTable(out io.Writer, report model.StatusReport) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintf(w, "APP\tSTATUS\tAPP VERSION\tCONFIG VERSION\tDETAILS\n")
+	for _, app := range report.Apps {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			app.App,
+			app.Status,
+			versionDiff(app.DeployedAppVersion, app.WantedAppVersion),
+			versionDiff(app.DeployedConfigVersion, app.WantedConfigVersion),
+			details(app),
+		)
+	}
+	for _, project := range report.Projects {
+		if project.Error != "" {
+			_, _ = fmt.Fprintf(w, "project %s\t%s\t\t\t%s\n", project.Project, model.AppStatusFailing, project.Error)
+		}
+	}
+	_ = w.Flush()
+
+	counts := report.CountByStatus()
+	_, _ = fmt.Fprintf(out, "\n%d apps: %d %s, %d %s, %d %s, %d %s\n",
+		len(report.Apps),
+		counts[model.AppStatusInSync], model.AppStatusInSync,
+		counts[model.AppStatusOutOfDate], model.AppStatusOutOfDate,
+		counts[model.AppStatusMissing], model.AppStatusMissing,
+		counts[model.AppStatusFailing], model.AppStatusFailing,
+	)
+}
+
+func versionDiff(deployed string, wanted string) string {
+	if deployed == wanted {
+		return domain.ShortHash(wanted)
+	}
+	if deployed == "" {
+		deployed = "-"
+	}
+	return fmt.Sprintf("%s -> %s", domain.ShortHash(deployed), domain.ShortHash(wanted))
+}
+
+func details(app model.AppStatus) string {
+	if app.Error != "" {
+		return app.Error
+	}
+	drift := make([]string, len(app.Drift))
+	for i, action := range app.Drift {
+		drift[i] = action.Description
+	}
+	return strings.Join(drift, "; ")
+}
@@ -6,18 +6,31 @@ import (
 	"fmt"
 	"github.com/gigurra/flycd/cmd/convert"
 	"github.com/gigurra/flycd/cmd/deploy"
+	"github.com/gigurra/flycd/cmd/history"
 	"github.com/gigurra/flycd/cmd/install"
 	"github.com/gigurra/flycd/cmd/monitor"
+	"github.com/gigurra/flycd/cmd/plan"
 	"github.com/gigurra/flycd/cmd/repos"
+	"github.com/gigurra/flycd/cmd/status"
 	"github.com/gigurra/flycd/pkg/domain"
 	"github.com/gigurra/flycd/pkg/ext/fly_client"
+	"github.com/gigurra/flycd/pkg/ext/notify"
+	"github.com/gigurra/flycd/pkg/util/util_cobra"
+	"github.com/gigurra/flycd/pkg/util/util_log"
+	"github.com/gigurra/flycd/pkg/util/util_trace"
 	"github.com/spf13/cobra"
+	"log/slog"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"time"
 )
 
 const Version = "v0.0.47"
 
+// notifyFlushTimeout How long to wait for deploy notifications before exiting
+const notifyFlushTimeout = 10 * time.Second
+
 var rootCmd = &cobra.Command{
 	Use:   "flycd",
 	Short: "flycd deployment of fly apps entirely from code, without manual fly.io cli commands... I hope :D",
@@ -33,42 +46,130 @@ var rootCmd = &cobra.Command{
 	},
 }
 
-func main() {
-	fmt.Printf("Starting FlyCD %s...\n", Version)
-
-	// Check that required applications are installed
-	// At some point we should just use a go library instead,
-	// and maybe even embed fly.io cli into our app :P
-	// (Alternatively we could integrate directly with fly.io API)
-	requiredApps := []string{"fly", "git", "ssh"}
-	for _, app := range requiredApps {
-		_, err := exec.LookPath(app)
-		if err != nil {
-			fmt.Printf("Error: required app '%s' not found in PATH\n", app)
-			os.Exit(1)
-		}
+func defaultBackend() string {
+	backend := os.Getenv("FLYCD_BACKEND")
+	if backend == "" {
+		return string(fly_client.BackendCli)
+	}
+	return backend
+}
+
+func envOr(name string, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func defaultHistoryFile() string {
+	if path, isSet := os.LookupEnv("FLYCD_HISTORY_FILE"); isSet {
+		return path
 	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(homeDir, ".flycd", "history.jsonl")
+}
+
+func main() {
+	// banners go to stderr, to keep stdout clean for machine-readable output
+	_, _ = fmt.Fprintf(os.Stderr, "Starting FlyCD %s...\n", Version)
 
 	// Create di-ish separable components
 	appCtx := context.Background() // TODO: make cancellable later on signals
-	flyClient := fly_client.NewFlyClient()
-	deployService := domain.NewDeployService(flyClient)
+	flyClient := fly_client.NewSelectableFlyClient()
+	tracedFlyClient := fly_client.NewTracedFlyClient(flyClient)
+	deployHistory := domain.NewJsonlDeployHistory("")
+	notifier := notify.NewHttpNotifier()
+	deployService := domain.NewDeployServiceWithHistoryAndNotifier(tracedFlyClient, deployHistory, notifier)
 	webhookService := domain.NewWebHookService(deployService)
+	gitPollService := domain.NewGitPollService(webhookService)
 
 	// prepare cli
+	backend := rootCmd.PersistentFlags().StringP("backend", "", defaultBackend(), fmt.Sprintf("Fly.io backend to use, one of %v", fly_client.AllBackends))
+	simState := rootCmd.PersistentFlags().StringP("sim-state", "", os.Getenv("FLYCD_SIM_STATE"), "File to load/store the simulated org state in, when using the sim backend")
+	historyFile := rootCmd.PersistentFlags().StringP("history-file", "", defaultHistoryFile(), "File to record deploy attempts in (empty to disable)")
+	historyNoChange := rootCmd.PersistentFlags().BoolP("history-no-change", "", os.Getenv("FLYCD_HISTORY_NO_CHANGE") == "true", "Also record deploys that found the app up to date in the history")
+	notifications := rootCmd.PersistentFlags().BoolP("notifications", "", os.Getenv("FLYCD_NOTIFICATIONS") != "false", "Send the deploy notifications configured in project.yaml files")
+	logFormat := rootCmd.PersistentFlags().StringP("log-format", "", envOr("FLYCD_LOG_FORMAT", util_log.FormatText), fmt.Sprintf("Log format, one of %s or %s", util_log.FormatText, util_log.FormatJson))
+	logLevel := rootCmd.PersistentFlags().StringP("log-level", "", envOr("FLYCD_LOG_LEVEL", "info"), "Minimum level to log, one of debug, info, warn or error")
+	traceExporter := rootCmd.PersistentFlags().StringP("trace-exporter", "", envOr("FLYCD_TRACE_EXPORTER", util_trace.ExporterNone), fmt.Sprintf("Where to export opentelemetry traces, one of %v", util_trace.AllExporters))
+	rootCmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+
+		logOutput := util_cobra.LogOutput(cmd)
+		err := util_log.Setup(logOutput, *logFormat, *logLevel)
+		if err != nil {
+			return err
+		}
+		// so that everything logged while running a job can be looked up per job on the management api
+		slog.SetDefault(slog.New(webhookService.LogHandler(slog.Default().Handler())))
+
+		err = util_trace.Setup(appCtx, logOutput, *traceExporter, Version)
+		if err != nil {
+			return err
+		}
+
+		err = flyClient.Select(fly_client.Backend(*backend))
+		if err != nil {
+			return err
+		}
+
+		if sim, isSim := flyClient.FlyClient.(*fly_client.FlyClientSim); isSim && *simState != "" {
+			err = sim.WithPersistence(*simState)
+			if err != nil {
+				return err
+			}
+		}
+
+		// Simulated deploys don't belong in the history of the real org, unless asked for
+		_, isSim := flyClient.FlyClient.(*fly_client.FlyClientSim)
+		if !isSim || cmd.Flags().Changed("history-file") {
+			deployHistory.SetPath(*historyFile)
+		}
+		deployHistory.SetRecordNoChange(*historyNoChange)
+		// Nor do they belong in the team's chat
+		notifier.SetEnabled(*notifications && (!isSim || cmd.Flags().Changed("notifications")))
+
+		// Check that required applications are installed
+		requiredApps := []string{"git", "ssh"}
+		if fly_client.Backend(*backend).RequiresFlyCli() {
+			requiredApps = append(requiredApps, "fly")
+		}
+		for _, app := range requiredApps {
+			_, err := exec.LookPath(app)
+			if err != nil {
+				return fmt.Errorf("required app '%s' not found in PATH", app)
+			}
+		}
+
+		return nil
+	}
+
 	rootCmd.AddCommand(
 		deploy.Cmd(appCtx, deployService),
-		monitor.Cmd(appCtx, flyClient, deployService, webhookService),
-		install.Cmd(appCtx, PackagedFileSystem, flyClient, deployService),
+		plan.Cmd(appCtx, deployService),
+		status.Cmd(appCtx, deployService),
+		monitor.Cmd(appCtx, tracedFlyClient, deployService, webhookService, gitPollService, deployHistory, notifier),
+		install.Cmd(appCtx, PackagedFileSystem, tracedFlyClient, deployService),
 		convert.Cmd(appCtx),
 		repos.Cmd(appCtx),
+		history.Cmd(deployHistory),
 	)
 
 	// run cli
-	if err := rootCmd.Execute(); err != nil {
+	err := rootCmd.Execute()
+	if !notifier.Flush(notifyFlushTimeout) {
+		_, _ = fmt.Fprintln(os.Stderr, "Gave up waiting for deploy notifications to be sent")
+	}
+	shutdownErr := util_trace.Shutdown(appCtx)
+	if shutdownErr != nil {
+		_, _ = fmt.Fprintln(os.Stderr, shutdownErr)
+	}
+	if err != nil {
 		_, _ = fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 
-	fmt.Printf("FlyCD %s exiting normally, bye!\n", Version)
+	_, _ = fmt.Fprintf(os.Stderr, "FlyCD %s exiting normally, bye!\n", Version)
 }
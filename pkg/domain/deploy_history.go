@@ -0,0 +1,165 @@
+package domain
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/gigurra/flycd/pkg/domain/model"
+	"github.com/gigurra/flycd/pkg/util/util_log"
+	"github.com/samber/lo"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// historyMaxBytes How large the history file may grow before it is rotated
+const historyMaxBytes = 10 * 1024 * 1024
+
+// JsonlDeployHistory A deploy history with one json record per line, only ever appended to.
+// The file can be chosen after construction with SetPath, e.g. once cli flags are parsed.
+// Once the file is larger than maxBytes, it is moved to <path>.1, replacing the previous one,
+// so that at most about twice that is kept.
+type JsonlDeployHistory struct {
+	mutex          sync.Mutex
+	path           string
+	maxBytes       int64
+	recordNoChange bool
+}
+
+// prove that JsonlDeployHistory implements DeployHistory
+var _ model.DeployHistory = &JsonlDeployHistory{}
+
+func NewJsonlDeployHistory(path string) *JsonlDeployHistory {
+	return &JsonlDeployHistory{path: path, maxBytes: historyMaxBytes}
+}
+
+// SetPath Changes the file to use. An empty path disables recording.
+func (h *JsonlDeployHistory) SetPath(path string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.path = path
+}
+
+// SetRecordNoChange Also records successful deploys that found the app up to date. They are
+// skipped by default, since every sync would otherwise add a record for every app.
+func (h *JsonlDeployHistory) SetRecordNoChange(recordNoChange bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.recordNoChange = recordNoChange
+}
+
+func (h *JsonlDeployHistory) Path() string {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.path
+}
+
+func (h *JsonlDeployHistory) Record(record model.DeployRecord) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.path == "" {
+		return nil
+	}
+	if !h.recordNoChange && record.Success() && record.Result == model.SingleAppDeployNoChange {
+		return nil
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("error serializing deploy record: %w", err)
+	}
+
+	err = os.MkdirAll(filepath.Dir(h.path), 0755)
+	if err != nil {
+		return fmt.Errorf("error creating deploy history dir: %w", err)
+	}
+
+	if info, err := os.Stat(h.path); err == nil && info.Size() >= h.maxBytes {
+		err = os.Rename(h.path, rotatedHistoryPath(h.path))
+		if err != nil {
+			return fmt.Errorf("error rotating deploy history %s: %w", h.path, err)
+		}
+	}
+
+	file, err := os.OpenFile(h.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening deploy history %s: %w", h.path, err)
+	}
+	defer func() { _ = file.Close() }()
+
+	_, err = file.Write(append(data, '\n'))
+	if err != nil {
+		return fmt.Errorf("error writing to deploy history %s: %w", h.path, err)
+	}
+	return nil
+}
+
+func (h *JsonlDeployHistory) List(app string, limit int) ([]model.DeployRecord, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.path == "" {
+		return nil, fmt.Errorf("no deploy history file configured")
+	}
+
+	result := make([]model.DeployRecord, 0)
+	for _, path := range []string{rotatedHistoryPath(h.path), h.path} {
+		records, err := readDeployRecords(path, app)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, records...)
+	}
+
+	result = lo.Reverse(result)
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+	return result, nil
+}
+
+func rotatedHistoryPath(path string) string {
+	return path + ".1"
+}
+
+// readDeployRecords Reads the records of the app (or all apps if app is empty) in the file, oldest first
+func readDeployRecords(path string, app string) ([]model.DeployRecord, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return []model.DeployRecord{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error opening deploy history %s: %w", path, err)
+	}
+	defer func() { _ = file.Close() }()
+
+	result := make([]model.DeployRecord, 0)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024) // error messages can be long
+	for scanner.Scan() {
+		var record model.DeployRecord
+		err := json.Unmarshal(scanner.Bytes(), &record)
+		if err != nil {
+			continue // e.g. a line cut short by a crash. Don't let it hide the rest of the history
+		}
+		if app == "" || record.App == app {
+			result = append(result, record)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading deploy history %s: %w", path, err)
+	}
+	return result, nil
+}
+
+func recordDeploy(history model.DeployHistory, record model.DeployRecord) {
+	if history == nil {
+		return
+	}
+	err := history.Record(record)
+	if err != nil {
+		slog.Error("Error recording deploy in the deploy history", util_log.KeyApp, record.App, "error", err)
+	}
+}
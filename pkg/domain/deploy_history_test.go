@@ -0,0 +1,164 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"github.com/gigurra/flycd/pkg/domain/model"
+	"github.com/gigurra/flycd/pkg/ext/fly_client"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestJsonlDeployHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "some", "dir", "history.jsonl")
+	history := NewJsonlDeployHistory(path)
+	history.SetRecordNoChange(true)
+
+	for _, record := range []model.DeployRecord{
+		{App: "app1", Result: model.SingleAppDeployCreated},
+		{App: "app2", Error: "boom"},
+		{App: "app1", Result: model.SingleAppDeployNoChange},
+	} {
+		err := history.Record(record)
+		if err != nil {
+			t.Fatalf("Record failed: %v", err)
+		}
+	}
+
+	// A line cut short, e.g. by a crash, should not hide the rest of the history
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("failed to open history file: %v", err)
+	}
+	_, _ = file.WriteString(`{"app": "app1", "res` + "\n")
+	_ = file.Close()
+	err = history.Record(model.DeployRecord{App: "app3", Result: model.SingleAppDeployUpdated})
+	if err != nil {
+		t.Fatalf("Record failed: %v", err)
+	}
+
+	all, err := history.List("", 0)
+	if err != nil || len(all) != 4 || all[0].App != "app3" || all[3].App != "app1" {
+		t.Fatalf("expected all 4 records newest first, got %+v, err=%v", all, err)
+	}
+
+	app1, err := history.List("app1", 0)
+	if err != nil || len(app1) != 2 || app1[0].Result != model.SingleAppDeployNoChange {
+		t.Fatalf("expected the 2 records of app1 newest first, got %+v, err=%v", app1, err)
+	}
+
+	limited, err := history.List("", 2)
+	if err != nil || len(limited) != 2 || limited[1].App != "app1" {
+		t.Fatalf("expected the 2 newest records, got %+v, err=%v", limited, err)
+	}
+
+	if all[2].Success() || !all[1].Success() {
+		t.Fatalf("expected only the record with an error to be unsuccessful, got %+v", all)
+	}
+}
+
+func TestJsonlDeployHistory_disabled(t *testing.T) {
+	history := NewJsonlDeployHistory("")
+	err := history.Record(model.DeployRecord{App: "app1"})
+	if err != nil {
+		t.Fatalf("expected recording without a file to be a no-op, got %v", err)
+	}
+	_, err = history.List("", 0)
+	if err == nil {
+		t.Fatalf("expected listing without a file to fail")
+	}
+}
+
+func TestDeployAll_recordsHistory(t *testing.T) {
+	ctx := context.Background()
+	history := NewJsonlDeployHistory(filepath.Join(t.TempDir(), "history.jsonl"))
+	history.SetRecordNoChange(true)
+	deployService := NewDeployServiceWithHistory(fly_client.NewFlyClientSim(), history)
+	deployCfg := model.
+		NewDefaultDeployConfig().
+		WithRetries(0).
+		WithTrigger(model.TriggerCli)
+
+	for i := 0; i < 2; i++ {
+		_, err := deployService.DeployAll(ctx, "../../test/test-projects/deploy-tests/apps", deployCfg)
+		if err != nil {
+			t.Fatalf("DeployAll failed: %v", err)
+		}
+	}
+
+	records, err := history.List("app1", 0)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected 2 deploy attempts of app1, got %+v", records)
+	}
+	if records[0].Result != model.SingleAppDeployNoChange || records[1].Result != model.SingleAppDeployCreated {
+		t.Fatalf("expected app1 to be created and then unchanged, got %+v", records)
+	}
+	for _, record := range records {
+		if record.Trigger != model.TriggerCli || record.AppHash == "" || record.ConfigHash == "" || record.Error != "" {
+			t.Fatalf("expected a successful cli deploy with hashes, got %+v", record)
+		}
+		if record.Commit != "" {
+			t.Fatalf("expected no commit for an app from a local folder, got %+v", record)
+		}
+	}
+}
+
+func TestJsonlDeployHistory_skipsNoChange(t *testing.T) {
+	history := NewJsonlDeployHistory(filepath.Join(t.TempDir(), "history.jsonl"))
+
+	for _, record := range []model.DeployRecord{
+		{App: "app1", Result: model.SingleAppDeployCreated},
+		{App: "app1", Result: model.SingleAppDeployNoChange},
+		{App: "app1", Result: model.SingleAppDeployNoChange, Error: "boom"},
+	} {
+		err := history.Record(record)
+		if err != nil {
+			t.Fatalf("Record failed: %v", err)
+		}
+	}
+
+	records, err := history.List("app1", 0)
+	if err != nil || len(records) != 2 || records[0].Error != "boom" || records[1].Result != model.SingleAppDeployCreated {
+		t.Fatalf("expected only the creation and the failure to be recorded, got %+v, err=%v", records, err)
+	}
+}
+
+func TestJsonlDeployHistory_rotates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	history := NewJsonlDeployHistory(path)
+	history.maxBytes = 100
+
+	for i := 0; i < 10; i++ {
+		err := history.Record(model.DeployRecord{App: fmt.Sprintf("app%d", i), Result: model.SingleAppDeployUpdated})
+		if err != nil {
+			t.Fatalf("Record failed: %v", err)
+		}
+	}
+
+	for _, file := range []string{path, path + ".1"} {
+		info, err := os.Stat(file)
+		if err != nil {
+			t.Fatalf("expected %s to exist: %v", file, err)
+		}
+		if info.Size() > 2*history.maxBytes {
+			t.Fatalf("expected %s to be rotated before growing much past %d bytes, got %d", file, history.maxBytes, info.Size())
+		}
+	}
+
+	records, err := history.List("", 0)
+	if err != nil || len(records) == 0 || len(records) == 10 {
+		t.Fatalf("expected only the newest records to be kept, got %+v, err=%v", records, err)
+	}
+	if records[0].App != "app9" {
+		t.Fatalf("expected the newest record first, got %+v", records[0])
+	}
+	for i := 1; i < len(records); i++ {
+		if records[i-1].App < records[i].App {
+			t.Fatalf("expected the records newest first across both files, got %+v", records)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package domain
+
+import (
+	"sync"
+)
+
+// deployLocks Keeps parallel jobs from deploying the same app at once,
+// and limits how many apps of the same org are deployed at once.
+type deployLocks struct {
+	mutex     sync.Mutex
+	apps      map[string]*sync.Mutex
+	orgs      map[string]chan struct{}
+	orgLimits map[string]int
+}
+
+func newDeployLocks(orgLimits map[string]int) *deployLocks {
+	return &deployLocks{
+		apps:      map[string]*sync.Mutex{},
+		orgs:      map[string]chan struct{}{},
+		orgLimits: orgLimits,
+	}
+}
+
+// lock Blocks until the app may be deployed, and returns a func releasing it again
+func (l *deployLocks) lock(app string, org string) func() {
+	appLock, orgSlots := l.get(app, org)
+
+	appLock.Lock()
+	if orgSlots != nil {
+		orgSlots <- struct{}{}
+	}
+
+	return func() {
+		if orgSlots != nil {
+			<-orgSlots
+		}
+		appLock.Unlock()
+	}
+}
+
+func (l *deployLocks) get(app string, org string) (*sync.Mutex, chan struct{}) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	appLock, found := l.apps[app]
+	if !found {
+		appLock = &sync.Mutex{}
+		l.apps[app] = appLock
+	}
+
+	orgSlots, found := l.orgs[org]
+	if !found {
+		if limit := orgLimit(l.orgLimits, org); limit > 0 {
+			orgSlots = make(chan struct{}, limit)
+		}
+		l.orgs[org] = orgSlots // nil when unlimited
+	}
+
+	return appLock, orgSlots
+}
+
+// orgLimit The limit for the org, falling back to the limit for "*". 0 means unlimited.
+func orgLimit(orgLimits map[string]int, org string) int {
+	if limit, found := orgLimits[org]; found {
+		return limit
+	}
+	return orgLimits["*"]
+}
@@ -0,0 +1,78 @@
+package domain
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDeployLocks_sameApp(t *testing.T) {
+	locks := newDeployLocks(map[string]int{})
+
+	unlock := locks.lock("app", "org")
+	acquired := make(chan struct{})
+	go func() {
+		locks.lock("app", "org")()
+		close(acquired)
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatalf("expected the second lock of the same app to wait for the first")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	unlock()
+	select {
+	case <-acquired:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the second lock to be acquired once the first was released")
+	}
+
+	// Other apps are not affected
+	locks.lock("app", "org")()
+	locks.lock("other-app", "org")()
+}
+
+func TestDeployLocks_orgLimits(t *testing.T) {
+	for _, test := range []struct {
+		name        string
+		orgLimits   map[string]int
+		org         string
+		expectedMax int32
+	}{
+		{name: "org limit", orgLimits: map[string]int{"org": 2, "*": 1}, org: "org", expectedMax: 2},
+		{name: "default limit", orgLimits: map[string]int{"org": 2, "*": 1}, org: "other-org", expectedMax: 1},
+		{name: "unlimited", orgLimits: map[string]int{"org": 2}, org: "other-org", expectedMax: 4},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			locks := newDeployLocks(test.orgLimits)
+
+			var current, highest atomic.Int32
+			wg := sync.WaitGroup{}
+			for _, app := range []string{"a", "b", "c", "d"} {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					unlock := locks.lock(app, test.org)
+					defer unlock()
+					n := current.Add(1)
+					for {
+						prev := highest.Load()
+						if n <= prev || highest.CompareAndSwap(prev, n) {
+							break
+						}
+					}
+					time.Sleep(50 * time.Millisecond)
+					current.Add(-1)
+				}()
+			}
+			wg.Wait()
+
+			if highest.Load() != test.expectedMax {
+				t.Fatalf("expected at most %d parallel deploys, got %d", test.expectedMax, highest.Load())
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package domain
+
+import (
+	"context"
+	"github.com/gigurra/flycd/pkg/domain/model"
+)
+
+// notifyDeploy Tells the notifiers of the app that want to hear about the event, if any
+func notifyDeploy(
+	notifier model.DeployNotifier,
+	ctx context.Context,
+	notifications model.NotificationsConfig,
+	deployCfg model.DeployConfig,
+	record model.DeployRecord,
+	event string,
+) {
+	if notifier == nil {
+		return
+	}
+	notifiers := notifications.For(event)
+	if len(notifiers) == 0 {
+		return
+	}
+	notification := model.DeployNotification{
+		Event:           event,
+		App:             record.App,
+		Org:             record.Org,
+		Path:            record.Path,
+		Trigger:         record.Trigger,
+		Commit:          record.Commit,
+		Result:          record.Result,
+		DurationSeconds: record.DurationSeconds,
+		Error:           record.Error,
+	}
+	if notification.Commit == "" {
+		notification.Commit = deployCfg.Commit
+	}
+	if notification.Commit == deployCfg.Commit {
+		notification.Author = deployCfg.Author // otherwise the app is from another repo than the one pushed to
+	}
+	notifier.Notify(ctx, notifiers, notification)
+}
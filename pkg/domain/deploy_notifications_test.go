@@ -0,0 +1,124 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"github.com/gigurra/flycd/pkg/domain/model"
+	"github.com/gigurra/flycd/pkg/ext/fly_client"
+	"github.com/gigurra/flycd/pkg/util/util_work_dir"
+	"github.com/samber/lo"
+	"sync"
+	"testing"
+)
+
+type sentNotification struct {
+	url          string
+	notification model.DeployNotification
+}
+
+type recordingNotifier struct {
+	mutex sync.Mutex
+	sent  []sentNotification
+}
+
+func (n *recordingNotifier) Notify(_ context.Context, notifiers []model.NotifierConfig, notification model.DeployNotification) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	for _, notifier := range notifiers {
+		n.sent = append(n.sent, sentNotification{url: notifier.Url, notification: notification})
+	}
+}
+
+func (n *recordingNotifier) take() []sentNotification {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	result := n.sent
+	n.sent = nil
+	return result
+}
+
+// failingDeployClient Creates apps, but fails to deploy them
+type failingDeployClient struct {
+	fly_client.FlyClient
+}
+
+func (c failingDeployClient) DeployExistingApp(context.Context, model.AppConfig, util_work_dir.WorkDir, model.DeployConfig, string) error {
+	return fmt.Errorf("boom")
+}
+
+func TestDeployAll_notifies(t *testing.T) {
+	ctx := context.Background()
+	flyClient := fly_client.NewFlyClientSim()
+	notifier := &recordingNotifier{}
+	deployService := NewDeployServiceWithHistoryAndNotifier(flyClient, nil, notifier)
+	deployCfg := model.
+		NewDefaultDeployConfig().
+		WithRetries(0).
+		WithAbortOnFirstError(false).
+		WithTrigger("github webhook 123").
+		WithPush(model.PushEvent{Commit: "1a2b3c4", Author: "Jane Doe"})
+	path := "../../test/test-projects/notifications"
+
+	_, err := deployService.DeployAll(ctx, path, deployCfg)
+	if err != nil {
+		t.Fatalf("DeployAll failed: %v", err)
+	}
+	sent := notifier.take()
+	// notify-app2 is in the ops project, which only cares about failures and doesn't inherit the team's webhook
+	if len(sent) != 2 ||
+		sent[0].notification.Event != model.NotifyStarted ||
+		sent[1].notification.Event != model.NotifySucceeded {
+		t.Fatalf("expected the team to be told that notify-app1 was being deployed and then deployed, got %+v", sent)
+	}
+	for _, s := range sent {
+		if s.url != "http://team.example.com" ||
+			s.notification.App != "notify-app1" ||
+			s.notification.Commit != "1a2b3c4" ||
+			s.notification.Author != "Jane Doe" ||
+			s.notification.Trigger != "github webhook 123" {
+			t.Fatalf("unexpected notification: %+v", s)
+		}
+	}
+	if sent[1].notification.Result != model.SingleAppDeployCreated {
+		t.Fatalf("expected notify-app1 to be created, got %+v", sent[1])
+	}
+
+	_, err = deployService.DeployAll(ctx, path, deployCfg)
+	if err != nil {
+		t.Fatalf("DeployAll failed: %v", err)
+	}
+	if sent := notifier.take(); len(sent) != 0 {
+		t.Fatalf("expected no notifications for apps that are up to date, got %+v", sent)
+	}
+
+	failingService := NewDeployServiceWithHistoryAndNotifier(failingDeployClient{FlyClient: flyClient}, nil, notifier)
+	_, err = failingService.DeployAll(ctx, path, deployCfg.WithForce())
+	if err != nil {
+		t.Fatalf("DeployAll failed: %v", err)
+	}
+	byApp := lo.GroupBy(notifier.take(), func(s sentNotification) string { return s.notification.App })
+	app1, app2 := byApp["notify-app1"], byApp["notify-app2"]
+	if len(app1) != 2 ||
+		app1[0].notification.Event != model.NotifyStarted ||
+		app1[1].notification.Event != model.NotifyFailed || app1[1].url != "http://team.example.com" {
+		t.Fatalf("expected the team to hear about notify-app1 failing, got %+v", app1)
+	}
+	if len(app2) != 1 || app2[0].notification.Event != model.NotifyFailed || app2[0].url != "http://ops.example.com" {
+		t.Fatalf("expected ops to hear about notify-app2 failing, got %+v", app2)
+	}
+	if app2[0].notification.Error != "boom" || app2[0].notification.DurationSeconds == 0 {
+		t.Fatalf("expected the failure to tell why and how long it took, got %+v", app2[0])
+	}
+}
+
+func TestPlanAll_doesNotNotify(t *testing.T) {
+	notifier := &recordingNotifier{}
+	deployService := NewDeployServiceWithHistoryAndNotifier(fly_client.NewFlyClientSim(), nil, notifier)
+	_, err := deployService.PlanAll(context.Background(), "../../test/test-projects/notifications", model.NewDefaultDeployConfig())
+	if err != nil {
+		t.Fatalf("PlanAll failed: %v", err)
+	}
+	if sent := notifier.take(); len(sent) != 0 {
+		t.Fatalf("expected planning not to notify, got %+v", sent)
+	}
+}
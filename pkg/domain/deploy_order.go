@@ -0,0 +1,200 @@
+package domain
+
+import (
+	"fmt"
+	"github.com/gigurra/flycd/pkg/domain/model"
+	"github.com/samber/lo"
+	"slices"
+	"strings"
+)
+
+func SkippedDependencyFailed(dependency string) error {
+	return fmt.Errorf("skipped: dependency %s was not deployed", dependency)
+}
+
+// deployOrder The apps of a tree and their depends_on relations, by index into apps
+type deployOrder struct {
+	apps         []model.AppAtFsNode
+	dependencies [][]int
+	dependents   [][]int
+	invalid      map[int]error // unknown dependencies and dependency cycles
+}
+
+// newDeployOrder Dependencies on otherApps, which are in the tree but not being deployed, are taken as already deployed
+func newDeployOrder(apps []model.AppAtFsNode, otherApps []string) deployOrder {
+
+	byName := map[string][]int{}
+	for i, app := range apps {
+		byName[app.AppConfig.App] = append(byName[app.AppConfig.App], i)
+	}
+
+	order := deployOrder{
+		apps:         apps,
+		dependencies: make([][]int, len(apps)),
+		dependents:   make([][]int, len(apps)),
+		invalid:      map[int]error{},
+	}
+
+	for i, app := range apps {
+		for _, dependency := range lo.Uniq(app.AppConfig.DependsOn) {
+			indices, found := byName[dependency]
+			if !found {
+				if !lo.Contains(otherApps, dependency) {
+					order.invalid[i] = fmt.Errorf("depends on app '%s', which is not in the tree", dependency)
+				}
+				continue
+			}
+			for _, dep := range indices {
+				order.dependencies[i] = append(order.dependencies[i], dep)
+				order.dependents[dep] = append(order.dependents[dep], i)
+			}
+		}
+	}
+
+	order.findCycles()
+
+	return order
+}
+
+// findCycles Marks all apps that are part of a dependency cycle as invalid.
+// Apps that merely depend on a cycle are skipped later, like dependents of any other failed app.
+func (o *deployOrder) findCycles() {
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	state := make([]int, len(o.apps))
+	stack := make([]int, 0)
+
+	var visit func(i int)
+	visit = func(i int) {
+		state[i] = visiting
+		stack = append(stack, i)
+		for _, dep := range o.dependencies[i] {
+			switch state[dep] {
+			case unvisited:
+				visit(dep)
+			case visiting:
+				cycle := stack[lo.IndexOf(stack, dep):]
+				names := lo.Map(append(append([]int{}, cycle...), dep), func(j int, _ int) string { return o.apps[j].AppConfig.App })
+				for _, j := range cycle {
+					if _, alreadyInvalid := o.invalid[j]; !alreadyInvalid {
+						o.invalid[j] = fmt.Errorf("dependency cycle: %s", strings.Join(names, " -> "))
+					}
+				}
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[i] = visited
+	}
+
+	for i := range o.apps {
+		if state[i] == unvisited {
+			visit(i)
+		}
+	}
+}
+
+type deployOutcome struct {
+	index int
+	err   error
+}
+
+// deployInOrder Deploys the apps in topological order, at most parallelism at a time, and at most the org's limit
+// of each org (see orgLimit). Apps that don't depend on each other are started in tree order. When an app fails, is invalid or is skipped, all apps depending on it
+// are skipped. deployApp returns nil if the app was deployed, and fail records apps that are never deployed.
+// otherApps are apps in the tree that are not being deployed this time, and are not waited for.
+// With abortOnFailure, no more apps are started after the first failure, and the apps not started are
+// skipped with SkippedAbortedEarlier. Apps already being deployed are waited for.
+func deployInOrder(
+	apps []model.AppAtFsNode,
+	otherApps []string,
+	parallelism int,
+	orgLimits map[string]int,
+	abortOnFailure bool,
+	deployApp func(model.AppAtFsNode) error,
+	fail func(model.AppAtFsNode, error),
+) {
+	order := newDeployOrder(apps, otherApps)
+	parallelism = max(1, parallelism)
+
+	done := make([]bool, len(apps))
+	remaining := lo.Map(order.dependencies, func(deps []int, _ int) int { return len(deps) })
+
+	var skipDependents func(i int)
+	skipDependents = func(i int) {
+		for _, dependent := range order.dependents[i] {
+			if !done[dependent] {
+				done[dependent] = true
+				fail(apps[dependent], SkippedDependencyFailed(apps[i].AppConfig.App))
+				skipDependents(dependent)
+			}
+		}
+	}
+
+	// All invalid apps are reported as such first, so that apps in a cycle aren't reported as dependents of each other
+	for i, app := range apps {
+		if err, isInvalid := order.invalid[i]; isInvalid {
+			done[i] = true
+			fail(app, SkippedNotValid(err))
+		}
+	}
+	for i := range apps {
+		if _, isInvalid := order.invalid[i]; isInvalid {
+			skipDependents(i)
+		}
+	}
+
+	ready := lo.Filter(lo.Range(len(apps)), func(i int, _ int) bool { return !done[i] && remaining[i] == 0 })
+	outcomes := make(chan deployOutcome)
+	inFlight := 0
+	inFlightPerOrg := map[string]int{}
+	hasOrgSlot := func(i int) bool {
+		limit := orgLimit(orgLimits, apps[i].AppConfig.Org)
+		return limit <= 0 || inFlightPerOrg[apps[i].AppConfig.Org] < limit
+	}
+
+	aborted := abortOnFailure && len(order.invalid) > 0
+	for (len(ready) > 0 && !aborted) || inFlight > 0 {
+		for inFlight < parallelism && !aborted {
+			pos := slices.IndexFunc(ready, hasOrgSlot)
+			if pos < 0 {
+				break
+			}
+			next := ready[pos]
+			ready = slices.Delete(ready, pos, pos+1)
+			inFlight++
+			inFlightPerOrg[apps[next].AppConfig.Org]++
+			go func() {
+				outcomes <- deployOutcome{index: next, err: deployApp(apps[next])}
+			}()
+		}
+
+		outcome := <-outcomes
+		inFlight--
+		inFlightPerOrg[apps[outcome.index].AppConfig.Org]--
+		done[outcome.index] = true
+
+		if outcome.err != nil {
+			aborted = aborted || abortOnFailure
+			skipDependents(outcome.index)
+			continue
+		}
+		for _, dependent := range order.dependents[outcome.index] {
+			remaining[dependent]--
+			if remaining[dependent] == 0 && !done[dependent] {
+				ready = append(ready, dependent)
+			}
+		}
+	}
+
+	for i, app := range apps {
+		if !done[i] {
+			done[i] = true
+			fail(app, SkippedAbortedEarlier)
+		}
+	}
+}
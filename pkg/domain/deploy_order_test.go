@@ -0,0 +1,219 @@
+package domain
+
+import (
+	"fmt"
+	"github.com/gigurra/flycd/pkg/domain/model"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testApp(name string, dependsOn ...string) model.AppAtFsNode {
+	return model.AppAtFsNode{
+		Path:      "/apps/" + name,
+		AppConfig: model.AppConfig{App: name, DependsOn: dependsOn},
+	}
+}
+
+// testDeployInOrder Deploys the apps one by one, failing the ones in failing, and returns
+// the names of the deployed apps in order, plus the causes of the apps that were never deployed
+func testDeployInOrder(apps []model.AppAtFsNode, failing ...string) ([]string, map[string]error) {
+	deployed := make([]string, 0)
+	failed := map[string]error{}
+	deployInOrder(apps, nil, 1, nil, false,
+		func(app model.AppAtFsNode) error {
+			for _, name := range failing {
+				if name == app.AppConfig.App {
+					failed[name] = fmt.Errorf("boom")
+					return failed[name]
+				}
+			}
+			deployed = append(deployed, app.AppConfig.App)
+			return nil
+		},
+		func(app model.AppAtFsNode, cause error) {
+			failed[app.AppConfig.App] = cause
+		},
+	)
+	return deployed, failed
+}
+
+func TestDeployInOrder_dependenciesFirst(t *testing.T) {
+	deployed, failed := testDeployInOrder([]model.AppAtFsNode{
+		testApp("api-gateway", "auth", "users"),
+		testApp("auth"),
+		testApp("users", "auth"),
+		testApp("web"),
+	})
+
+	if len(failed) != 0 {
+		t.Fatalf("expected no failures, got %v", failed)
+	}
+	if !reflect.DeepEqual(deployed, []string{"auth", "web", "users", "api-gateway"}) {
+		t.Fatalf("unexpected deploy order %v", deployed)
+	}
+}
+
+func TestDeployInOrder_skipsDependentsOfFailedApps(t *testing.T) {
+	deployed, failed := testDeployInOrder([]model.AppAtFsNode{
+		testApp("api-gateway", "users"),
+		testApp("auth"),
+		testApp("users", "auth"),
+		testApp("web"),
+	}, "auth")
+
+	if !reflect.DeepEqual(deployed, []string{"web"}) {
+		t.Fatalf("expected only web to be deployed, got %v", deployed)
+	}
+	if failed["users"].Error() != SkippedDependencyFailed("auth").Error() ||
+		failed["api-gateway"].Error() != SkippedDependencyFailed("users").Error() {
+		t.Fatalf("expected dependents of auth to be skipped, got %v", failed)
+	}
+}
+
+func TestDeployInOrder_invalidDependencies(t *testing.T) {
+	deployed, failed := testDeployInOrder([]model.AppAtFsNode{
+		testApp("a", "b"),
+		testApp("b", "c"),
+		testApp("c", "a"),
+		testApp("d", "c"),
+		testApp("e", "missing"),
+		testApp("f"),
+	})
+
+	if !reflect.DeepEqual(deployed, []string{"f"}) {
+		t.Fatalf("expected only f to be deployed, got %v", deployed)
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		if !strings.Contains(failed[name].Error(), "dependency cycle") {
+			t.Fatalf("expected %s to be reported as part of a cycle, got %v", name, failed[name])
+		}
+	}
+	if !strings.Contains(failed["a"].Error(), "a -> b -> c -> a") {
+		t.Fatalf("expected the cycle to be described, got %v", failed["a"])
+	}
+	if failed["d"].Error() != SkippedDependencyFailed("c").Error() {
+		t.Fatalf("expected d to be skipped because of c, got %v", failed["d"])
+	}
+	if !strings.Contains(failed["e"].Error(), "'missing', which is not in the tree") {
+		t.Fatalf("expected e to be invalid because of its unknown dependency, got %v", failed["e"])
+	}
+}
+
+func TestDeployInOrder_parallel(t *testing.T) {
+	mutex := sync.Mutex{}
+	running := map[string]bool{}
+	deployed := map[string]bool{}
+	maxRunning := 0
+	deployInOrder([]model.AppAtFsNode{
+		testApp("api-gateway", "auth", "users"),
+		testApp("auth"),
+		testApp("users"),
+		testApp("web"),
+	}, nil, 3, nil, false,
+		func(app model.AppAtFsNode) error {
+			mutex.Lock()
+			for _, dependency := range app.AppConfig.DependsOn {
+				if !deployed[dependency] {
+					t.Errorf("%s started before its dependency %s was deployed", app.AppConfig.App, dependency)
+				}
+			}
+			running[app.AppConfig.App] = true
+			maxRunning = max(maxRunning, len(running))
+			mutex.Unlock()
+
+			time.Sleep(20 * time.Millisecond)
+
+			mutex.Lock()
+			delete(running, app.AppConfig.App)
+			deployed[app.AppConfig.App] = true
+			mutex.Unlock()
+			return nil
+		},
+		func(app model.AppAtFsNode, cause error) {
+			t.Errorf("unexpected failure of %s: %v", app.AppConfig.App, cause)
+		},
+	)
+
+	if len(deployed) != 4 || maxRunning != 3 {
+		t.Fatalf("expected all 4 apps deployed with 3 at a time, got deployed=%v, max running=%d", deployed, maxRunning)
+	}
+}
+
+func TestDeployInOrder_orgLimits(t *testing.T) {
+	mutex := sync.Mutex{}
+	runningPerOrg := map[string]int{}
+	maxRunningPerOrg := map[string]int{}
+	deployed := make([]string, 0)
+	inOrg := func(app model.AppAtFsNode, org string) model.AppAtFsNode {
+		app.AppConfig.Org = org
+		return app
+	}
+	deployInOrder([]model.AppAtFsNode{
+		inOrg(testApp("a1"), "a"),
+		inOrg(testApp("a2"), "a"),
+		inOrg(testApp("a3"), "a"),
+		inOrg(testApp("b1"), "b"),
+		inOrg(testApp("b2"), "b"),
+	}, nil, 4, map[string]int{"a": 1}, false,
+		func(app model.AppAtFsNode) error {
+			org := app.AppConfig.Org
+			mutex.Lock()
+			runningPerOrg[org]++
+			maxRunningPerOrg[org] = max(maxRunningPerOrg[org], runningPerOrg[org])
+			mutex.Unlock()
+
+			time.Sleep(20 * time.Millisecond)
+
+			mutex.Lock()
+			runningPerOrg[org]--
+			deployed = append(deployed, app.AppConfig.App)
+			mutex.Unlock()
+			return nil
+		},
+		func(app model.AppAtFsNode, cause error) {
+			t.Errorf("unexpected failure of %s: %v", app.AppConfig.App, cause)
+		},
+	)
+
+	if len(deployed) != 5 {
+		t.Fatalf("expected all 5 apps deployed, got %v", deployed)
+	}
+	if maxRunningPerOrg["a"] != 1 || maxRunningPerOrg["b"] != 2 {
+		t.Fatalf("expected org a to be limited to 1 app at a time while b is not, got %v", maxRunningPerOrg)
+	}
+}
+
+func TestDeployInOrder_abortOnFailure(t *testing.T) {
+	deployed := make([]string, 0)
+	failed := map[string]error{}
+	deployInOrder([]model.AppAtFsNode{
+		testApp("auth"),
+		testApp("users", "auth"),
+		testApp("web"),
+		testApp("api"),
+	}, nil, 1, nil, true,
+		func(app model.AppAtFsNode) error {
+			deployed = append(deployed, app.AppConfig.App)
+			if app.AppConfig.App == "auth" {
+				return fmt.Errorf("boom")
+			}
+			return nil
+		},
+		func(app model.AppAtFsNode, cause error) {
+			failed[app.AppConfig.App] = cause
+		},
+	)
+
+	if !reflect.DeepEqual(deployed, []string{"auth"}) {
+		t.Fatalf("expected nothing to be started after auth failed, got %v", deployed)
+	}
+	if failed["users"].Error() != SkippedDependencyFailed("auth").Error() {
+		t.Fatalf("expected users to be skipped because of auth, got %v", failed["users"])
+	}
+	if failed["web"] != SkippedAbortedEarlier || failed["api"] != SkippedAbortedEarlier {
+		t.Fatalf("expected the other apps to be skipped as aborted, got %v", failed)
+	}
+}
@@ -0,0 +1,68 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"github.com/gigurra/flycd/pkg/domain/model"
+	"github.com/gigurra/flycd/pkg/ext/fly_client"
+	"github.com/gigurra/flycd/pkg/util/util_log"
+	"log/slog"
+)
+
+// planAll runs the regular deploy logic for every app in the tree, but against a
+// planningFlyClient, so that nothing is changed on fly.io. Each app gets its own
+// recorder, so the actions can be reported per app.
+func planAll(
+	flyClient fly_client.FlyClient,
+	ctx context.Context,
+	path string,
+	deployCfg model.DeployConfig,
+) (model.DeployPlan, error) {
+
+	plan := model.NewEmptyDeployPlan()
+
+	err := TraverseDeepAppTree(path, model.TraverseAppTreeContext{
+		Context: ctx,
+		ValidAppCb: func(ctx model.TraverseAppTreeContext, appNode model.AppAtFsNode) error {
+			appCtx := util_log.With(ctx, util_log.KeyApp, appNode.AppConfig.App)
+			slog.InfoContext(appCtx, "Planning app", "path", appNode.Path)
+			recorder := newPlanningFlyClient(flyClient)
+			change, err := deployAppFromFolder(recorder, nil, nil, appCtx, appNode.Path, deployCfg, appNode.ToPreCalculatedApoConf())
+			appPlan := model.AppPlan{
+				App:     appNode.AppConfig.App,
+				Path:    appNode.Path,
+				Change:  change,
+				Actions: recorder.Actions(),
+			}
+			if err != nil {
+				appPlan.Error = err.Error()
+			}
+			plan.Apps = append(plan.Apps, appPlan)
+			return nil
+		},
+		InvalidAppCb: func(ctx model.TraverseAppTreeContext, appNode model.AppAtFsNode) error {
+			plan.Apps = append(plan.Apps, model.AppPlan{
+				App:     appNode.AppConfig.App,
+				Path:    appNode.Path,
+				Actions: []model.PlannedAction{},
+				Error:   SkippedNotValid(appNode.ErrCause()).Error(),
+			})
+			return nil
+		},
+		BeginProjectCb: func(ctx model.TraverseAppTreeContext, projNode model.ProjectAtFsNode) error {
+			projPlan := model.ProjectPlan{
+				Project: projNode.ProjectConfig.Project,
+				Path:    projNode.Path,
+			}
+			if !projNode.IsValidProject() {
+				projPlan.Error = SkippedNotValid(projNode.ErrCause()).Error()
+			}
+			plan.Projects = append(plan.Projects, projPlan)
+			return nil
+		},
+	})
+	if err != nil {
+		return plan, fmt.Errorf("error traversing app tree: %w", err)
+	}
+	return plan, nil
+}
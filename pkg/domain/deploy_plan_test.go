@@ -0,0 +1,103 @@
+package domain
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gigurra/flycd/pkg/domain/model"
+	"github.com/gigurra/flycd/pkg/ext/fly_client"
+	"github.com/gigurra/flycd/pkg/util/util_metrics"
+	"github.com/samber/lo"
+	"strings"
+	"testing"
+)
+
+func TestPlanAll_simulatedBackend(t *testing.T) {
+	ctx := context.Background()
+	flyClient := fly_client.NewFlyClientSim()
+	deployService := NewDeployService(flyClient)
+	deployCfg := model.NewDefaultDeployConfig().WithRetries(0)
+	path := "../../test/test-projects/nginx-with-volumes"
+
+	plan, err := deployService.PlanAll(ctx, path, deployCfg)
+	if err != nil {
+		t.Fatalf("PlanAll failed: %v", err)
+	}
+	if plan.HasErrors() || len(plan.Apps) != 1 {
+		t.Fatalf("expected a plan for exactly 1 app without errors, got %+v", plan)
+	}
+
+	appPlan := plan.Apps[0]
+	actionTypes := lo.Map(appPlan.Actions, func(a model.PlannedAction, _ int) model.PlannedActionType { return a.Type })
+	expected := []model.PlannedActionType{model.PlannedActionCreateApp, model.PlannedActionDeploy, model.PlannedActionScaleCount}
+	if appPlan.Change != model.SingleAppDeployCreated || !lo.Every(actionTypes, expected) || len(actionTypes) != len(expected) {
+		t.Fatalf("expected a created app with actions %v, got %s with %v", expected, appPlan.Change, actionTypes)
+	}
+
+	if len(flyClient.State().Apps) != 0 {
+		t.Fatalf("expected planning not to change anything, got %+v", flyClient.State().Apps)
+	}
+
+	// Once deployed, there should be nothing left to do
+	_, err = deployService.DeployAll(ctx, path, deployCfg)
+	if err != nil {
+		t.Fatalf("DeployAll failed: %v", err)
+	}
+	plan, err = deployService.PlanAll(ctx, path, deployCfg)
+	if err != nil {
+		t.Fatalf("PlanAll failed: %v", err)
+	}
+	if plan.Apps[0].HasChanges() || plan.Apps[0].Change != model.SingleAppDeployNoChange {
+		t.Fatalf("expected no changes after deploy, got %+v", plan.Apps[0])
+	}
+}
+
+// countDeploys The number of deploys in the metrics, successful or not
+func countDeploys(t *testing.T) float64 {
+	families, err := util_metrics.Registry.Gather()
+	if err != nil {
+		t.Fatalf("error gathering metrics: %v", err)
+	}
+	count := 0.0
+	for _, family := range families {
+		if family.GetName() != "flycd_deploys_total" && family.GetName() != "flycd_deploy_failures_total" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			count += metric.GetCounter().GetValue()
+		}
+	}
+	return count
+}
+
+func TestPlanAll_isNotCountedAsDeploys(t *testing.T) {
+	before := countDeploys(t)
+	_, err := NewDeployService(fly_client.NewFlyClientSim()).
+		PlanAll(context.Background(), "../../test/test-projects/nginx-with-volumes", model.NewDefaultDeployConfig())
+	if err != nil {
+		t.Fatalf("PlanAll failed: %v", err)
+	}
+	if after := countDeploys(t); after != before {
+		t.Fatalf("expected planning not to show up in the deploy metrics, went from %v to %v", before, after)
+	}
+}
+
+func TestPlanningFlyClient_neverRecordsSecretValues(t *testing.T) {
+	recorder := newPlanningFlyClient(fly_client.NewFlyClientSim())
+	err := recorder.SaveSecrets(context.Background(), "my-app", []fly_client.Secret{{Name: "DB_PASSWORD", Value: "hunter2"}}, true)
+	if err != nil {
+		t.Fatalf("SaveSecrets failed: %v", err)
+	}
+
+	actions := recorder.Actions()
+	if len(actions) != 1 || actions[0].Type != model.PlannedActionSetSecrets {
+		t.Fatalf("expected a single set-secrets action, got %+v", actions)
+	}
+	if !strings.Contains(actions[0].Description, "DB_PASSWORD") {
+		t.Fatalf("expected the secret name in the plan, got %s", actions[0].Description)
+	}
+	for _, action := range actions {
+		if strings.Contains(action.Description, "hunter2") || strings.Contains(string(lo.Must(json.Marshal(action))), "hunter2") {
+			t.Fatalf("secret value leaked into plan: %+v", action)
+		}
+	}
+}
@@ -1,21 +1,36 @@
 package domain
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/gigurra/flycd/pkg/domain/model"
 	"github.com/gigurra/flycd/pkg/ext/fly_client"
 	"github.com/gigurra/flycd/pkg/util/util_cvt"
 	"github.com/gigurra/flycd/pkg/util/util_git"
+	"github.com/gigurra/flycd/pkg/util/util_glob"
+	"github.com/gigurra/flycd/pkg/util/util_log"
 	"github.com/gigurra/flycd/pkg/util/util_math"
+	"github.com/gigurra/flycd/pkg/util/util_metrics"
 	"github.com/gigurra/flycd/pkg/util/util_toml"
+	"github.com/gigurra/flycd/pkg/util/util_trace"
 	"github.com/gigurra/flycd/pkg/util/util_work_dir"
 	"github.com/samber/lo"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/mod/sumdb/dirhash"
 	"gopkg.in/yaml.v3"
+	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 )
 
 func SkippedNotValid(cause error) error { return fmt.Errorf("skipped: not a valid app: %w", cause) }
@@ -42,18 +57,42 @@ type DeployService interface {
 		deployCfg model.DeployConfig,
 		preCalculatedAppConfig *model.PreCalculatedAppConfig,
 	) (model.SingleAppDeploySuccessType, error)
+
+	// PlanAll computes everything DeployAll would do, without changing anything on fly.io
+	PlanAll(
+		ctx context.Context,
+		path string,
+		deployCfg model.DeployConfig,
+	) (model.DeployPlan, error)
+
+	// StatusAll compares the config tree with what is deployed on fly.io, without changing anything
+	StatusAll(
+		ctx context.Context,
+		path string,
+	) (model.StatusReport, error)
+
+	// ReconcileAll repairs volumes, ips and scale of all deployed apps, without redeploying them
+	ReconcileAll(
+		ctx context.Context,
+		path string,
+		deployCfg model.DeployConfig,
+	) (model.DeployResult, error)
 }
 
 type DeployServiceImpl struct {
 	flyClient fly_client.FlyClient
+	history   model.DeployHistory  // nil if deploys are not recorded
+	notifier  model.DeployNotifier // nil if nobody is told about deploys
+
+	gitAppVersions *gitAppVersionCache // versions of git apps found by earlier status checks
 }
 
 func (d DeployServiceImpl) DeployAll(ctx context.Context, path string, deployCfg model.DeployConfig) (model.DeployResult, error) {
-	return deployAll(d.flyClient, ctx, path, deployCfg)
+	return deployAll(d.flyClient, d.history, d.notifier, ctx, path, deployCfg)
 }
 
 func (d DeployServiceImpl) DeployAppFromInlineConfig(ctx context.Context, deployCfg model.DeployConfig, cfg model.AppConfig) (model.SingleAppDeploySuccessType, error) {
-	return deployAppFromInlineConfig(d.flyClient, ctx, deployCfg, cfg)
+	return deployAppFromInlineConfig(d.flyClient, d.history, d.notifier, ctx, deployCfg, cfg)
 }
 
 func (d DeployServiceImpl) DeployAppFromFolder(
@@ -62,55 +101,138 @@ func (d DeployServiceImpl) DeployAppFromFolder(
 	deployCfg model.DeployConfig,
 	preCalculatedAppConfig *model.PreCalculatedAppConfig,
 ) (model.SingleAppDeploySuccessType, error) {
-	return deployAppFromFolder(d.flyClient, ctx, path, deployCfg, preCalculatedAppConfig)
+	return deployAppFromFolder(d.flyClient, d.history, d.notifier, ctx, path, deployCfg, preCalculatedAppConfig)
+}
+
+func (d DeployServiceImpl) PlanAll(ctx context.Context, path string, deployCfg model.DeployConfig) (model.DeployPlan, error) {
+	return planAll(d.flyClient, ctx, path, deployCfg)
+}
+
+func (d DeployServiceImpl) StatusAll(ctx context.Context, path string) (model.StatusReport, error) {
+	return statusAll(d.flyClient, d.gitAppVersions, ctx, path)
+}
+
+func (d DeployServiceImpl) ReconcileAll(ctx context.Context, path string, deployCfg model.DeployConfig) (model.DeployResult, error) {
+	return reconcileAll(d.flyClient, d.history, d.notifier, ctx, path, deployCfg)
 }
 
 // prove that DeployServiceImpl implements DeployService
 var _ DeployService = DeployServiceImpl{}
 
 func NewDeployService(flyClient fly_client.FlyClient) DeployService {
+	return NewDeployServiceWithHistory(flyClient, nil)
+}
+
+// NewDeployServiceWithHistory Creates a deploy service that records every attempt to deploy an app in the history
+func NewDeployServiceWithHistory(flyClient fly_client.FlyClient, history model.DeployHistory) DeployService {
+	return NewDeployServiceWithHistoryAndNotifier(flyClient, history, nil)
+}
+
+// NewDeployServiceWithHistoryAndNotifier Creates a deploy service that also tells the notifiers
+// configured in project.yaml files about deploys of their apps
+func NewDeployServiceWithHistoryAndNotifier(
+	flyClient fly_client.FlyClient,
+	history model.DeployHistory,
+	notifier model.DeployNotifier,
+) DeployService {
 	return DeployServiceImpl{
 		flyClient: flyClient,
+		history:   history,
+		notifier:  notifier,
+
+		gitAppVersions: newGitAppVersionCache(),
 	}
 }
 
 func deployAll(
 	flyClient fly_client.FlyClient,
+	history model.DeployHistory,
+	notifier model.DeployNotifier,
 	ctx context.Context,
 	path string,
 	deployCfg model.DeployConfig,
 ) (model.DeployResult, error) {
 
+	ctx, span := util_trace.Start(ctx, "deploy all",
+		util_trace.KeyPath.String(path),
+		attribute.String("flycd.trigger", deployCfg.Trigger),
+		attribute.Int("flycd.parallelism", deployCfg.Parallelism),
+	)
+
 	result := model.NewEmptyDeployResult()
+	mutex := sync.Mutex{} // guards result, since apps may be deployed in parallel
+
+	hasErrors := func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return result.HasErrors()
+	}
+
+	failApp := func(appNode model.AppAtFsNode, cause error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		result.FailedApps = append(result.FailedApps, model.AppDeployFailure{
+			Spec:  appNode,
+			Cause: cause,
+		})
+	}
+
+	appContexts := map[string]context.Context{} // by app path, with the project of each app for its log lines
+
+	deployApp := func(appNode model.AppAtFsNode) error {
+		if deployCfg.AbortOnFirstError && hasErrors() {
+			slog.WarnContext(appContexts[appNode.Path], "Aborted earlier, skipping app")
+			failApp(appNode, SkippedAbortedEarlier)
+			return SkippedAbortedEarlier
+		}
+		if deployCfg.LockApp != nil {
+			unlock := deployCfg.LockApp(appNode.AppConfig.App, appNode.AppConfig.Org)
+			defer unlock()
+		}
+		res, err := deployAppFromFolder(flyClient, history, notifier, appContexts[appNode.Path], appNode.Path, deployCfg, appNode.ToPreCalculatedApoConf())
+		if err != nil {
+			failApp(appNode, err)
+			return err
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		result.SucceededApps = append(result.SucceededApps, model.AppDeploySuccess{
+			Spec:        appNode,
+			SuccessType: res,
+		})
+		return nil
+	}
+
+	// The whole tree is collected first, so that apps can be deployed in depends_on order.
+	// Projects cloned from git are kept around until all apps are deployed.
+	collectedApps := make([]model.AppAtFsNode, 0)
+	otherApps := make([]string, 0) // not selected by deployCfg.Only
+	cleanups := make([]func(), 0)
+	defer func() {
+		for _, cleanup := range cleanups {
+			cleanup()
+		}
+	}()
 
 	err := TraverseDeepAppTree(path, model.TraverseAppTreeContext{
-		Context: ctx,
+		Context:      ctx,
+		DeferCleanup: func(cleanup func()) { cleanups = append(cleanups, cleanup) },
 		ValidAppCb: func(ctx model.TraverseAppTreeContext, appNode model.AppAtFsNode) error {
-			fmt.Printf("Considering app %s @ %s\n", appNode.AppConfig.App, appNode.Path)
-			if deployCfg.AbortOnFirstError && result.HasErrors() {
-				fmt.Printf("Aborted earlier, skipping!\n")
-				result.FailedApps = append(result.FailedApps, model.AppDeployFailure{
-					Spec:  appNode,
-					Cause: SkippedAbortedEarlier,
-				})
-				return nil
-			} else {
-				res, err := deployAppFromFolder(flyClient, ctx, appNode.Path, deployCfg, appNode.ToPreCalculatedApoConf())
-				if err != nil {
-					result.FailedApps = append(result.FailedApps, model.AppDeployFailure{
-						Spec:  appNode,
-						Cause: err,
-					})
-				} else {
-					result.SucceededApps = append(result.SucceededApps, model.AppDeploySuccess{
-						Spec:        appNode,
-						SuccessType: res,
-					})
-				}
+			if !deployCfg.Only.Matches(appNode.AppConfig, ctx.Parents) {
+				otherApps = append(otherApps, appNode.AppConfig.App)
 				return nil
 			}
+			// deployed after the traversal, so the deploy spans belong under deploy all, not the project spans
+			appCtx := trace.ContextWithSpan(ctx, span)
+			appContexts[appNode.Path] = util_log.With(appCtx, util_log.KeyApp, appNode.AppConfig.App)
+			slog.InfoContext(appContexts[appNode.Path], "Considering app", "path", appNode.Path)
+			collectedApps = append(collectedApps, appNode)
+			return nil
 		},
 		InvalidAppCb: func(ctx model.TraverseAppTreeContext, appNode model.AppAtFsNode) error {
+			if !deployCfg.Only.Matches(appNode.AppConfig, ctx.Parents) {
+				return nil
+			}
 			result.FailedApps = append(result.FailedApps, model.AppDeployFailure{
 				Spec:  appNode,
 				Cause: SkippedNotValid(appNode.ErrCause()),
@@ -118,13 +240,8 @@ func deployAll(
 			return nil
 		},
 		BeginProjectCb: func(ctx model.TraverseAppTreeContext, projNode model.ProjectAtFsNode) error {
-			if deployCfg.AbortOnFirstError && result.HasErrors() {
-				result.FailedProjects = append(result.FailedProjects, model.ProjectProcessingFailure{
-					Spec:  projNode,
-					Cause: SkippedAbortedEarlier,
-				})
-				return nil
-			} else if !projNode.IsValidProject() {
+			// Nothing is deployed until the whole tree is collected, so there is nothing to abort yet
+			if !projNode.IsValidProject() {
 				result.FailedProjects = append(result.FailedProjects, model.ProjectProcessingFailure{
 					Spec:  projNode,
 					Cause: SkippedNotValid(projNode.ErrCause()),
@@ -137,13 +254,28 @@ func deployAll(
 		},
 	})
 	if err != nil {
-		return result, fmt.Errorf("error traversing app tree: %w", err)
+		err = fmt.Errorf("error traversing app tree: %w", err)
+		util_trace.End(span, err)
+		return result, err
+	}
+
+	if deployCfg.Parallelism > 1 {
+		slog.InfoContext(ctx, "Deploying apps in parallel", "apps", len(collectedApps), "parallelism", deployCfg.Parallelism)
 	}
+	deployInOrder(collectedApps, otherApps, deployCfg.Parallelism, deployCfg.OrgLimits, deployCfg.AbortOnFirstError, deployApp, failApp)
+	span.SetAttributes(
+		attribute.Int("flycd.apps.succeeded", len(result.SucceededApps)),
+		attribute.Int("flycd.apps.failed", len(result.FailedApps)),
+	)
+	span.End()
+
 	return result, nil
 }
 
 func deployAppFromInlineConfig(
 	flyClient fly_client.FlyClient,
+	history model.DeployHistory,
+	notifier model.DeployNotifier,
 	ctx context.Context,
 	deployCfg model.DeployConfig,
 	cfg model.AppConfig,
@@ -171,24 +303,144 @@ func deployAppFromInlineConfig(
 		return "", fmt.Errorf("error writing app.yaml: %w", err)
 	}
 
-	return deployAppFromFolder(flyClient, ctx, cfgDir.Cwd(), deployCfg, &model.PreCalculatedAppConfig{
+	return deployAppFromFolder(flyClient, history, notifier, ctx, cfgDir.Cwd(), deployCfg, &model.PreCalculatedAppConfig{
 		Typed:   cfg,
 		UnTyped: untypedCfg,
 	})
 }
 
+// deployAppFromFolder Deploys the app, recording the attempt in the history and notifying
+// about it, unless they are nil
 func deployAppFromFolder(
 	flyClient fly_client.FlyClient,
+	history model.DeployHistory,
+	notifier model.DeployNotifier,
 	ctx context.Context,
 	path string,
 	deployCfg model.DeployConfig,
 	preCalculatedAppCfg *model.PreCalculatedAppConfig,
 ) (model.SingleAppDeploySuccessType, error) {
 
+	record := model.DeployRecord{
+		Time:    time.Now(),
+		Path:    path,
+		Trigger: deployCfg.Trigger,
+	}
+	ctx, span := util_trace.Start(ctx, "deploy app",
+		util_trace.KeyPath.String(path),
+		attribute.String("flycd.trigger", deployCfg.Trigger),
+		attribute.Bool("flycd.force", deployCfg.Force),
+	)
+	notifications := model.NotificationsConfig{}
+	if preCalculatedAppCfg != nil {
+		record.App = preCalculatedAppCfg.Typed.App
+		record.Org = preCalculatedAppCfg.Typed.Org
+		ctx = util_log.With(ctx, util_log.KeyApp, record.App)
+		notifications = preCalculatedAppCfg.Notifications
+	}
+	notify := func(event string) {
+		notifyDeploy(notifier, ctx, notifications, deployCfg, record, event)
+	}
+
+	result, err := func() (model.SingleAppDeploySuccessType, error) {
+		prepareCtx, prepareSpan := util_trace.Start(ctx, "step."+util_metrics.StepPrepare, util_trace.KeyStep.String(util_metrics.StepPrepare))
+		input, err := prepareDeployInput(flyClient, util_log.With(prepareCtx, util_log.KeyStep, util_metrics.StepPrepare), path, deployCfg, preCalculatedAppCfg)
+		util_trace.End(prepareSpan, err)
+		if err != nil {
+			return "", inStep(util_metrics.StepPrepare, err)
+		}
+		defer input.tempDir.RemoveAll()
+
+		input.ctx = ctx
+		if record.App == "" {
+			input.ctx = util_log.With(input.ctx, util_log.KeyApp, input.cfgTyped.App)
+		}
+		record.App = input.cfgTyped.App
+		record.Org = input.cfgTyped.Org
+		record.AppHash = input.appHash
+		record.ConfigHash = input.cfgHash
+		record.Commit = input.commit
+		input.started = func() { notify(model.NotifyStarted) }
+
+		return deployAppToFly(input)
+	}()
+
+	record.Result = result
+	record.DurationSeconds = time.Since(record.Time).Seconds()
+	if err != nil {
+		record.Error = err.Error()
+	}
+	recordDeploy(history, record)
+	if _, isPlan := flyClient.(*planningFlyClient); !isPlan {
+		observeDeploy(record, err)
+	}
+	if err != nil {
+		notify(model.NotifyFailed)
+	} else if result != model.SingleAppDeployNoChange {
+		notify(model.NotifySucceeded)
+	}
+	span.SetAttributes(
+		util_trace.KeyApp.String(record.App),
+		util_trace.KeyOrg.String(record.Org),
+		util_trace.KeyResult.String(string(result)),
+	)
+	util_trace.End(span, err)
+
+	return result, err
+}
+
+// observeDeploy Updates the deploy metrics with the outcome of a deploy
+func observeDeploy(record model.DeployRecord, err error) {
+	duration := time.Duration(record.DurationSeconds * float64(time.Second))
+	if err != nil {
+		util_metrics.DeployFailed(record.App, failedStep(err), duration)
+	} else {
+		util_metrics.DeploySucceeded(record.App, string(record.Result), duration)
+	}
+}
+
+// deployStepError Tells which step of a deploy failed, for the failure metrics
+type deployStepError struct {
+	step string
+	err  error
+}
+
+func (e deployStepError) Error() string {
+	return e.err.Error()
+}
+
+func (e deployStepError) Unwrap() error {
+	return e.err
+}
+
+func inStep(step string, err error) error {
+	return deployStepError{step: step, err: err}
+}
+
+// failedStep The step a deploy failed in. Errors not tagged with a step come from the fly deploy itself.
+func failedStep(err error) string {
+	var stepErr deployStepError
+	if errors.As(err, &stepErr) {
+		return stepErr.step
+	}
+	return util_metrics.StepDeploy
+}
+
+// prepareDeployInput Fetches the app, merges it with its config and calculates the
+// hashes we compare with what is deployed. The caller is responsible for removing
+// input.tempDir when done.
+func prepareDeployInput(
+	flyClient fly_client.FlyClient,
+	ctx context.Context,
+	path string,
+	deployCfg model.DeployConfig,
+	preCalculatedAppCfg *model.PreCalculatedAppConfig,
+) (deployInput, error) {
+
 	if preCalculatedAppCfg != nil {
 		err := preCalculatedAppCfg.Typed.Validate()
 		if err != nil {
-			return "", fmt.Errorf("error validating app config: %w", err)
+			return deployInput{}, fmt.Errorf("error validating app config: %w", err)
 		}
 	}
 
@@ -198,47 +450,55 @@ func deployAppFromFolder(
 		if preCalculatedAppCfg != nil {
 			return preCalculatedAppCfg.Typed, preCalculatedAppCfg.UnTyped, nil
 		} else {
-			return readAppConfigs(path)
+			return readAppConfigs(ctx, path)
 		}
 	}()
 	if err != nil {
-		return "", err
+		return deployInput{}, err
 	}
 
-	cfgHash, err := dirhash.HashDir(cfgDir.Cwd(), "", dirhash.DefaultHash)
+	cfgHash, err := effectiveCfgHash(cfgDir, cfgUntyped)
 	if err != nil {
-		return "", fmt.Errorf("error getting local dir hash for '%s': %w", path, err)
+		return deployInput{}, fmt.Errorf("error getting config hash for '%s': %w", path, err)
 	}
 
 	tempDir, err := util_work_dir.NewTempDir(cfgTyped.App, "")
 	if err != nil {
-		return "", fmt.Errorf("error creating temp dir: %w", err)
+		return deployInput{}, fmt.Errorf("error creating temp dir: %w", err)
 	}
-	defer tempDir.RemoveAll()
 
-	appHash, err := fetchAppFs(ctx, cfgTyped, cfgDir, &tempDir)
-	if err != nil {
-		return "", fmt.Errorf("error preparing fs to deploy: %w", err)
-	}
+	commit := ""
+	appHash, err := func() (string, error) {
+		appHash, fetchedCommit, err := fetchAppFs(ctx, cfgTyped, cfgDir, &tempDir)
+		if err != nil {
+			return "", fmt.Errorf("error preparing fs to deploy: %w", err)
+		}
+		commit = fetchedCommit
 
-	err = mergeCfgAndAppFs(cfgTyped, cfgDir, tempDir)
-	if err != nil {
-		return "", fmt.Errorf("error merging config and app fs: %w", err)
-	}
+		err = mergeCfgAndAppFs(ctx, cfgTyped, cfgDir, tempDir)
+		if err != nil {
+			return "", fmt.Errorf("error merging config and app fs: %w", err)
+		}
 
-	updateCfgHashes(&cfgTyped, &cfgUntyped, appHash, cfgHash)
+		updateCfgHashes(&cfgTyped, &cfgUntyped, appHash, cfgHash, commit)
 
-	err = writeOutUpdatedConfigFiles(cfgUntyped, tempDir)
-	if err != nil {
-		return "", fmt.Errorf("error writing out updated config files: %w", err)
-	}
+		err = writeOutUpdatedConfigFiles(cfgUntyped, tempDir)
+		if err != nil {
+			return "", fmt.Errorf("error writing out updated config files: %w", err)
+		}
 
-	err = ensureDockerIgnoreExists(tempDir, err)
+		err = ensureDockerIgnoreExists(tempDir, err)
+		if err != nil {
+			return "", fmt.Errorf("error ensuring docker ignore exists: %w", err)
+		}
+		return appHash, nil
+	}()
 	if err != nil {
-		return "", fmt.Errorf("error ensuring docker ignore exists: %w", err)
+		tempDir.RemoveAll()
+		return deployInput{}, err
 	}
 
-	input := deployInput{
+	return deployInput{
 		ctx:       ctx,
 		flyClient: flyClient,
 		deployCfg: deployCfg,
@@ -246,9 +506,8 @@ func deployAppFromFolder(
 		tempDir:   tempDir,
 		appHash:   appHash,
 		cfgHash:   cfgHash,
-	}
-
-	return deployAppToFly(input)
+		commit:    commit,
+	}, nil
 }
 
 type deployInput struct {
@@ -259,23 +518,40 @@ type deployInput struct {
 	tempDir   util_work_dir.WorkDir
 	appHash   string
 	cfgHash   string
+	commit    string // only for apps from git
+	started   func() // called when we know the app will be created, redeployed or have its infra repaired. May be nil
+}
+
+func (i deployInput) notifyStarted() {
+	if i.started != nil {
+		i.started()
+	}
+}
+
+// runStep Runs a step of the deploy in its own span, with its log lines tagged with the step
+func runStep(input deployInput, step string, fn func(input deployInput) error) error {
+	ctx, span := util_trace.Start(input.ctx, "step."+step, util_trace.KeyStep.String(step))
+	input.ctx = util_log.With(ctx, util_log.KeyStep, step)
+	err := fn(input)
+	util_trace.End(span, err)
+	return err
 }
 
 func runIntermediateSteps(input deployInput) error {
 
-	err := runIntermediateVolumeSteps(input)
+	err := runStep(input, util_metrics.StepVolumes, runIntermediateVolumeSteps)
 	if err != nil {
-		return fmt.Errorf("error running intermediate volume steps: %w", err)
+		return inStep(util_metrics.StepVolumes, fmt.Errorf("error running intermediate volume steps: %w", err))
 	}
 
-	err = runIntermediateSecretsSteps(input)
+	err = runStep(input, util_metrics.StepSecrets, runIntermediateSecretsSteps)
 	if err != nil {
-		return fmt.Errorf("error running intermediate secrets steps: %w", err)
+		return inStep(util_metrics.StepSecrets, fmt.Errorf("error running intermediate secrets steps: %w", err))
 	}
 
-	err = runIntermediateNetworkingSteps(input)
+	err = runStep(input, util_metrics.StepNetworking, runIntermediateNetworkingSteps)
 	if err != nil {
-		return fmt.Errorf("error running intermediate networking steps: %w", err)
+		return inStep(util_metrics.StepNetworking, fmt.Errorf("error running intermediate networking steps: %w", err))
 	}
 
 	// add intermediate steps here
@@ -290,17 +566,25 @@ func runPostDeploySteps(input deployInput) error {
 		return fmt.Errorf("error getting app scale for app %s: %w", input.cfgTyped.App, err)
 	}
 
-	err = runScaleCountAllRegionsPostDeployStep(input, deployedScales)
+	postDeployStep := func(name string, fn func(input deployInput, deployedScales []model.ScaleState) error) error {
+		return util_trace.Run(input.ctx, "step."+name, func(ctx context.Context) error {
+			stepInput := input
+			stepInput.ctx = ctx
+			return fn(stepInput, deployedScales)
+		})
+	}
+
+	err = postDeployStep("scale_count", runScaleCountAllRegionsPostDeployStep)
 	if err != nil {
 		return fmt.Errorf("error during runScaleCountAllRegionsPostDeployStep step: %w", err)
 	}
 
-	err = runScaleRamPostDeployStep(input, deployedScales)
+	err = postDeployStep("scale_ram", runScaleRamPostDeployStep)
 	if err != nil {
 		return fmt.Errorf("error during runScaleRamPostDeployStep step: %w", err)
 	}
 
-	err = runScaleVmPostDeployStep(input, deployedScales)
+	err = postDeployStep("scale_vm", runScaleVmPostDeployStep)
 	if err != nil {
 		return fmt.Errorf("error during runScaleVmPostDeployStep step: %w", err)
 	}
@@ -312,13 +596,13 @@ func runPostDeploySteps(input deployInput) error {
 
 func runScaleCountAllRegionsPostDeployStep(input deployInput, deployedScales []model.ScaleState) error {
 
-	fmt.Printf("Checking if we need to scale up instance count in any region\n")
+	slog.DebugContext(input.ctx, "Checking if we need to scale up instance count in any region")
 	minSvcReq := input.cfgTyped.MinMachinesFromServices()
 	if len(input.cfgTyped.ExtraRegions) == 0 &&
 		minSvcReq <= 1 &&
 		len(input.cfgTyped.Machines.CountPerRegion) == 0 &&
 		input.cfgTyped.Machines.Count <= 1 {
-		fmt.Printf("No need to scale up instance count in any region, beacuse we only have one region and don't require more than 1 instance\n")
+		slog.DebugContext(input.ctx, "No need to scale up instance count in any region, because we only have one region and don't require more than 1 instance")
 		return nil // nothing to do
 	}
 
@@ -333,14 +617,14 @@ func runScaleCountAllRegionsPostDeployStep(input deployInput, deployedScales []m
 		}
 
 		if wantedCountForRegion > currentCountPerRegion[wantedRegion] {
-			fmt.Printf("Need to region %s has %d instances, but we want %d (region_min)... scaling up!\n", wantedRegion, currentCountPerRegion[wantedRegion], wantedCountForRegion)
+			slog.InfoContext(input.ctx, "Scaling up instance count in region", "region", wantedRegion, "instances", currentCountPerRegion[wantedRegion], "wanted", wantedCountForRegion)
 			err = input.flyClient.ScaleApp(input.ctx, input.cfgTyped.App, wantedRegion, wantedCountForRegion)
 			if err != nil {
 				// Don't return immediately, try to scale all regions
-				fmt.Printf("error scaling app %s to %d in region %s: %v\n", input.cfgTyped.App, wantedCountForRegion, wantedRegion, err)
+				slog.ErrorContext(input.ctx, "Error scaling app in region", "region", wantedRegion, "wanted", wantedCountForRegion, "error", err)
 			}
 		} else {
-			fmt.Printf("region %s has %d instances, which is >= %d (region_min)... no need to scale up\n", wantedRegion, currentCountPerRegion[wantedRegion], wantedCountForRegion)
+			slog.DebugContext(input.ctx, "No need to scale up instance count in region", "region", wantedRegion, "instances", currentCountPerRegion[wantedRegion], "wanted", wantedCountForRegion)
 		}
 	}
 
@@ -349,15 +633,15 @@ func runScaleCountAllRegionsPostDeployStep(input deployInput, deployedScales []m
 
 func runScaleVmPostDeployStep(input deployInput, deployedScales []model.ScaleState) error {
 
-	fmt.Printf("Checking if we need to change vm type\n")
+	slog.DebugContext(input.ctx, "Checking if we need to change vm type")
 	if input.cfgTyped.Machines.CpuCores <= 0 {
-		fmt.Printf("No need to change vm type, no vm type specified\n")
+		slog.DebugContext(input.ctx, "No need to change vm type, no vm type specified")
 		return nil
 	}
 
 	cpuType := input.cfgTyped.Machines.CpuType
 	if cpuType == "" {
-		fmt.Printf("Cpu type unspecified, defaulting to whatever is already deployed\n")
+		slog.DebugContext(input.ctx, "Cpu type unspecified, defaulting to whatever is already deployed")
 	}
 
 	currentScalesByName := lo.GroupBy(deployedScales, func(scale model.ScaleState) string {
@@ -376,16 +660,16 @@ func runScaleVmPostDeployStep(input deployInput, deployedScales []model.ScaleSta
 	}
 
 	if needToScale {
-		fmt.Printf("Scaling app %s to %s with %d cores\n", input.cfgTyped.App, cpuType, input.cfgTyped.Machines.CpuCores)
+		slog.InfoContext(input.ctx, "Scaling app vm", "cpu_type", cpuType, "cpu_cores", input.cfgTyped.Machines.CpuCores)
 		vmString := fmt.Sprintf("%s-cpu-%dx", cpuType, input.cfgTyped.Machines.CpuCores)
 		err := input.flyClient.ScaleAppVm(input.ctx, input.cfgTyped.App, vmString)
 		if err != nil {
 			return fmt.Errorf("error scaling app %s to %s with %d cores: %w", input.cfgTyped.App, cpuType, input.cfgTyped.Machines.CpuCores, err)
 		} else {
-			fmt.Printf("scaled app %s to %s with %d cores\n", input.cfgTyped.App, cpuType, input.cfgTyped.Machines.CpuCores)
+			slog.InfoContext(input.ctx, "Scaled app vm", "cpu_type", cpuType, "cpu_cores", input.cfgTyped.Machines.CpuCores)
 		}
 	} else {
-		fmt.Printf("No need to scale app %s to %s with %d cores, either already at that level, or 'app' process not found\n", input.cfgTyped.App, cpuType, input.cfgTyped.Machines.CpuCores)
+		slog.DebugContext(input.ctx, "No need to scale app vm, either already at that level, or 'app' process not found", "cpu_type", cpuType, "cpu_cores", input.cfgTyped.Machines.CpuCores)
 	}
 
 	return nil
@@ -393,9 +677,9 @@ func runScaleVmPostDeployStep(input deployInput, deployedScales []model.ScaleSta
 
 func runScaleRamPostDeployStep(input deployInput, deployedScales []model.ScaleState) error {
 
-	fmt.Printf("Checking if we need to change amount of ram per instance\n")
+	slog.DebugContext(input.ctx, "Checking if we need to change amount of ram per instance")
 	if input.cfgTyped.Machines.RamMB <= 0 {
-		fmt.Printf("No need to change ram per instance, no ram specified\n")
+		slog.DebugContext(input.ctx, "No need to change ram per instance, no ram specified")
 		return nil
 	}
 
@@ -412,15 +696,15 @@ func runScaleRamPostDeployStep(input deployInput, deployedScales []model.ScaleSt
 	}
 
 	if needToScale {
-		fmt.Printf("Scaling app %s to %d ram\n", input.cfgTyped.App, input.cfgTyped.Machines.RamMB)
+		slog.InfoContext(input.ctx, "Scaling app ram", "ram_mb", input.cfgTyped.Machines.RamMB)
 		err := input.flyClient.ScaleAppRam(input.ctx, input.cfgTyped.App, input.cfgTyped.Machines.RamMB)
 		if err != nil {
 			return fmt.Errorf("error scaling app %s to %d ram: %w", input.cfgTyped.App, input.cfgTyped.Machines.RamMB, err)
 		} else {
-			fmt.Printf("scaled app %s to %d ram\n", input.cfgTyped.App, input.cfgTyped.Machines.RamMB)
+			slog.InfoContext(input.ctx, "Scaled app ram", "ram_mb", input.cfgTyped.Machines.RamMB)
 		}
 	} else {
-		fmt.Printf("No need to scale app %s to %d ram, either already at that level, or 'app' process not found\n", input.cfgTyped.App, input.cfgTyped.Machines.RamMB)
+		slog.DebugContext(input.ctx, "No need to scale app ram, either already at that level, or 'app' process not found", "ram_mb", input.cfgTyped.Machines.RamMB)
 	}
 
 	return nil
@@ -504,7 +788,7 @@ func runIntermediateNetworkingSteps(input deployInput) error {
 		}
 
 		if needCreate {
-			fmt.Printf("Creating ip %+v for app %s\n", cfgIp, input.cfgTyped.App)
+			slog.InfoContext(input.ctx, "Creating ip", "ip", fmt.Sprintf("%+v", cfgIp))
 			err := input.flyClient.CreateIp(input.ctx, input.cfgTyped.App, cfgIp)
 			if err != nil {
 				return fmt.Errorf("error creating ip %+v for app %s: %w", cfgIp, input.cfgTyped.App, err)
@@ -514,10 +798,10 @@ func runIntermediateNetworkingSteps(input deployInput) error {
 
 	// Release/prune unspecified IPs
 	if networkCfg.AutoPruneIps {
-		fmt.Printf("Pruning ips for app %s\n", input.cfgTyped.App)
+		slog.DebugContext(input.ctx, "Pruning ips")
 		for _, ip := range currentIps {
 			if !toBeKept[ip.Id] {
-				fmt.Printf("Removing ip %s for app %s\n", ip.Id, input.cfgTyped.App)
+				slog.InfoContext(input.ctx, "Removing ip", "ip_id", ip.Id, "address", ip.Address)
 				err = input.flyClient.DeleteIp(input.ctx, input.cfgTyped.App, ip.Id, ip.Address)
 				if err != nil {
 					return fmt.Errorf("error pruning ip %s for app %s: %w", ip.Address, input.cfgTyped.App, err)
@@ -525,7 +809,7 @@ func runIntermediateNetworkingSteps(input deployInput) error {
 			}
 		}
 	} else {
-		fmt.Printf("Not pruning ips for app %s\n", input.cfgTyped.App)
+		slog.DebugContext(input.ctx, "Not pruning ips")
 	}
 
 	return nil
@@ -563,19 +847,20 @@ func runIntermediateVolumeSteps(input deployInput) error {
 
 			wantedCount := util_math.Max(wantedVolume.Count, minVolumeCountByServicesPerRegion[region])
 
-			fmt.Printf("Volumes '%s': We need %d x %d GB in region %s \n", wantedVolume.Name, wantedCount, wantedVolume.SizeGb, region)
-
 			deployedVolumesThisRegion := deployedVolumesByNameAndRegion[wantedVolume.Name+region]
 
-			fmt.Printf("Currently deployed volumes: %d\n", len(deployedVolumesThisRegion))
-			for _, deployedVolume := range deployedVolumesThisRegion {
-				fmt.Printf(" - %s (%d GB)\n", deployedVolume.Name, deployedVolume.SizeGb)
-			}
+			slog.DebugContext(input.ctx, "Checking volumes in region",
+				"volume", wantedVolume.Name,
+				"region", region,
+				"wanted_count", wantedCount,
+				"wanted_size_gb", wantedVolume.SizeGb,
+				"deployed_sizes_gb", lo.Map(deployedVolumesThisRegion, func(v model.VolumeState, _ int) int { return v.SizeGb }),
+			)
 
 			// First bring all deployed volumes up to our required size
 			for _, currentVolume := range deployedVolumesThisRegion {
 				if currentVolume.SizeGb < wantedVolume.SizeGb {
-					fmt.Printf("Resizing app %s's volume %s from %d to %d in region %s\n", input.cfgTyped.App, currentVolume.Name, currentVolume.SizeGb, wantedVolume.SizeGb, region)
+					slog.InfoContext(input.ctx, "Resizing volume", "volume", currentVolume.Name, "volume_id", currentVolume.ID, "region", region, "from_gb", currentVolume.SizeGb, "to_gb", wantedVolume.SizeGb)
 					err := input.flyClient.ExtendVolume(input.ctx, input.cfgTyped.App, currentVolume.ID, wantedVolume.SizeGb)
 					if err != nil {
 						return fmt.Errorf("error resizing volume %s for app %s in region %s: %w", currentVolume.ID, input.cfgTyped.App, region, err)
@@ -587,7 +872,7 @@ func runIntermediateVolumeSteps(input deployInput) error {
 			// Create new needed volumes
 			newVolumesNeeded := util_math.Max(0, wantedCount-len(deployedVolumesThisRegion))
 			for i := 0; i < newVolumesNeeded; i++ {
-				fmt.Printf("Creating new %s volume for app %s in region %s \n", wantedVolume.Name, input.cfgTyped.App, region)
+				slog.InfoContext(input.ctx, "Creating volume", "volume", wantedVolume.Name, "region", region, "size_gb", wantedVolume.SizeGb)
 				_, err := input.flyClient.CreateVolume(input.ctx, input.cfgTyped.App, wantedVolume, region)
 				if err != nil {
 					return fmt.Errorf("error creating volume %s for app %s in region %s: %w", wantedVolume.Name, input.cfgTyped.App, region, err)
@@ -598,9 +883,9 @@ func runIntermediateVolumeSteps(input deployInput) error {
 	}
 
 	if numExtendedVolumes > 0 || numCreatedVolumes > 0 {
-		fmt.Printf("Extended %d volumes and created %d new volumes for app %s \n", numExtendedVolumes, numCreatedVolumes, input.cfgTyped.App)
+		slog.InfoContext(input.ctx, "Updated volumes", "extended", numExtendedVolumes, "created", numCreatedVolumes)
 	} else {
-		fmt.Printf("No change of volumes needed for app %s \n", input.cfgTyped.App)
+		slog.DebugContext(input.ctx, "No change of volumes needed")
 	}
 
 	return nil
@@ -637,24 +922,31 @@ func deployAppToFly(
 	input deployInput,
 ) (model.SingleAppDeploySuccessType, error) {
 
-	fmt.Printf("Checking if the app %s exists\n", input.cfgTyped.App)
+	slog.DebugContext(input.ctx, "Checking if the app exists")
 	appExists, err := input.flyClient.ExistsApp(input.ctx, input.cfgTyped.App)
 	if err != nil {
 		return "", fmt.Errorf("error checking if app %s exists: %w", input.cfgTyped.App, err)
 	}
 
 	if appExists {
-		fmt.Printf("App %s exists, grabbing its currently deployed config from fly.io\n", input.cfgTyped.App)
+		slog.DebugContext(input.ctx, "App exists, grabbing its currently deployed config from fly.io")
 		deployedCfg, err := input.flyClient.GetDeployedAppConfig(input.ctx, input.cfgTyped.App)
 		if err != nil {
 			return "", fmt.Errorf("error getting deployed app config: %w", err)
 		}
 
-		fmt.Printf("Comparing deployed config with current config\n")
+		slog.DebugContext(input.ctx, "Comparing deployed config with current config")
 		if input.deployCfg.Force ||
 			deployedCfg.Env["FLYCD_APP_VERSION"] != input.appHash ||
 			deployedCfg.Env["FLYCD_CONFIG_VERSION"] != input.cfgHash {
-			fmt.Printf("App %s needs to be re-deployed, doing it now!\n", input.cfgTyped.App)
+			slog.InfoContext(input.ctx, "App needs to be re-deployed, doing it now",
+				"deployed_app_version", deployedCfg.Env["FLYCD_APP_VERSION"],
+				"app_version", input.appHash,
+				"deployed_config_version", deployedCfg.Env["FLYCD_CONFIG_VERSION"],
+				"config_version", input.cfgHash,
+				"force", input.deployCfg.Force,
+			)
+			input.notifyStarted()
 			err = runIntermediateSteps(input) // set up volumes etc
 			if err != nil {
 				return "", err
@@ -662,65 +954,87 @@ func deployAppToFly(
 			// At first, it was believed that we could deploy to each region here to create machines there.
 			// However, it turns out that fly.io doesn't create machines at all with the 'deploy' command at all.
 			// So there is no point in looping over regions here and deploying to each.
-			fmt.Printf("Deploying app %s\n", input.cfgTyped.App)
-			err = input.flyClient.DeployExistingApp(input.ctx, input.cfgTyped, input.tempDir, input.deployCfg, input.cfgTyped.PrimaryRegion)
+			err = runStep(input, util_metrics.StepDeploy, deployExistingApp)
 			if err != nil {
 				return "", err
 			}
-			err = runPostDeploySteps(input)
+			err = runStep(input, util_metrics.StepScale, runPostDeploySteps)
 			if err != nil {
-				return "", err
+				return "", inStep(util_metrics.StepScale, err)
 			}
 			return model.SingleAppDeployUpdated, nil
+		} else if input.deployCfg.ReconcileInfra {
+			slog.InfoContext(input.ctx, "App is already up to date, skipping deploy but reconciling its infra")
+			return reconcileInfra(input)
 		} else {
-			fmt.Printf("App is already up to date, skipping deploy\n")
+			slog.InfoContext(input.ctx, "App is already up to date, skipping deploy")
 			return model.SingleAppDeployNoChange, nil
 		}
 	} else {
-		fmt.Printf("App not found, creating it\n")
-		err = input.flyClient.CreateNewApp(input.ctx, input.cfgTyped, input.tempDir, true)
+		input.notifyStarted()
+		err = runStep(input, util_metrics.StepDeploy, func(input deployInput) error {
+			slog.InfoContext(input.ctx, "App not found, creating it")
+			err := input.flyClient.CreateNewApp(input.ctx, input.cfgTyped, input.tempDir, true)
+			if err != nil {
+				return fmt.Errorf("error creating new app: %w", err)
+			}
+			return nil
+		})
 		if err != nil {
-			return "", fmt.Errorf("error creating new app: %w", err)
+			return "", err
 		}
 		err = runIntermediateSteps(input) // set up volumes etc
 		if err != nil {
 			return "", err
 		}
-		fmt.Printf("Issuing an explicit deploy command\n")
 		// At first, it was believed that we could deploy to each region here to create machines there.
 		// However, it turns out that fly.io doesn't create machines at all with the 'deploy' command at all.
 		// So there is no point in looping over regions here and deploying to each.
-		fmt.Printf("Deploying app %s\n", input.cfgTyped.App)
-		err = input.flyClient.DeployExistingApp(input.ctx, input.cfgTyped, input.tempDir, input.deployCfg, input.cfgTyped.PrimaryRegion)
+		err = runStep(input, util_metrics.StepDeploy, deployExistingApp)
 		if err != nil {
 			return "", err
 		}
-		err = runPostDeploySteps(input)
+		err = runStep(input, util_metrics.StepScale, runPostDeploySteps)
 		if err != nil {
-			return "", err
+			return "", inStep(util_metrics.StepScale, err)
 		}
 		return model.SingleAppDeployCreated, nil
 	}
 }
 
+func deployExistingApp(input deployInput) error {
+	slog.InfoContext(input.ctx, "Deploying app")
+	return input.flyClient.DeployExistingApp(input.ctx, input.cfgTyped, input.tempDir, input.deployCfg, input.cfgTyped.PrimaryRegion)
+}
+
 func fetchAppFs(
 	ctx context.Context,
 	cfgTyped model.AppConfig,
 	cfgDir util_work_dir.WorkDir,
 	tempDir *util_work_dir.WorkDir,
-) ( /* appHash */ string, error) {
+) ( /* appHash */ string /* commit */, string, error) {
 	appHash := ""
+	commit := ""
 
 	switch cfgTyped.Source.Type {
 	case model.SourceTypeGit:
 
+		cloneStart := time.Now()
 		cloneResult, err := util_git.CloneShallow(ctx, cfgTyped.Source.AsGitCloneSource(), *tempDir)
 		if err != nil {
-			return "", fmt.Errorf("cloning git repo: %w", err)
+			return "", "", fmt.Errorf("cloning git repo: %w", err)
 		}
+		util_metrics.CloneFinished(util_metrics.CloneApp, time.Since(cloneStart))
 
 		*tempDir = tempDir.WithRootFsCwd(cloneResult.Dir.Cwd())
-		appHash = cloneResult.Hash
+		commit = cloneResult.Hash
+
+		// Not the commit, since in a monorepo that changes with every push to any of its apps
+		files, err := util_git.ListFiles(ctx, cloneResult.Dir)
+		if err != nil {
+			return "", "", err
+		}
+		appHash = watchedFilesHash(files, cfgTyped.WatchedPaths())
 
 	case model.SourceTypeLocal:
 		srcDir := func() util_work_dir.WorkDir {
@@ -734,51 +1048,89 @@ func fetchAppFs(
 		// check if srcDir exists
 		if !srcDir.Exists() {
 			// Try with it as an absolute path
-			fmt.Printf("Local path '%s' does not exist, trying as absolute path\n", cfgTyped.Source.Path)
+			slog.DebugContext(ctx, "Local path does not exist, trying as absolute path", "path", cfgTyped.Source.Path)
 			srcDir = util_work_dir.NewWorkDir(cfgTyped.Source.Path)
 			if !srcDir.Exists() {
-				return "", fmt.Errorf("local path '%s' does not exist", cfgTyped.Source.Path)
+				return "", "", fmt.Errorf("local path '%s' does not exist", cfgTyped.Source.Path)
 			}
 		}
 
 		err := srcDir.CopyContentsTo(*tempDir)
 		if err != nil {
-			return "", fmt.Errorf("error copying local folder %s: %w", srcDir.Cwd(), err)
+			return "", "", fmt.Errorf("error copying local folder %s: %w", srcDir.Cwd(), err)
 		}
 
 		appHash, err = dirhash.HashDir(tempDir.Cwd(), "", dirhash.DefaultHash)
 		if err != nil {
-			return "", fmt.Errorf("error getting local dir hash for '%s': %w", tempDir.Cwd(), err)
+			return "", "", fmt.Errorf("error getting local dir hash for '%s': %w", tempDir.Cwd(), err)
 		}
 
 		if err != nil {
-			return "", fmt.Errorf("error copying local folder %s: %w", cfgTyped.Source.Path, err)
+			return "", "", fmt.Errorf("error copying local folder %s: %w", cfgTyped.Source.Path, err)
 		}
 	case model.SourceTypeInlineDockerFile:
 		// Copy the local folder to the temp tempDir
 		err := tempDir.WriteFile("Dockerfile", cfgTyped.Source.Inline)
 		if err != nil {
-			return "", fmt.Errorf("error writing Dockerfile: %w", err)
+			return "", "", fmt.Errorf("error writing Dockerfile: %w", err)
 		}
 		err = tempDir.WriteFile(".dockerignore", "")
 		if err != nil {
-			return "", fmt.Errorf("error writing .dockerignore: %w", err)
+			return "", "", fmt.Errorf("error writing .dockerignore: %w", err)
 		}
 
 		appHash, err = dirhash.HashDir(tempDir.Cwd(), "", dirhash.DefaultHash)
 		if err != nil {
-			return "", fmt.Errorf("error getting local dir hash for '%s': %w", tempDir.Cwd(), err)
+			return "", "", fmt.Errorf("error getting local dir hash for '%s': %w", tempDir.Cwd(), err)
 		}
 
 	default:
-		return "", fmt.Errorf("unknown source type %s", cfgTyped.Source.Type)
+		return "", "", fmt.Errorf("unknown source type %s", cfgTyped.Source.Type)
 	}
 	appHash = strings.TrimSpace(appHash) // Not sure if we need this anymore
 
-	return appHash, nil
+	return appHash, commit, nil
+}
+
+// watchedFilesHash A version of the app that only changes when the files it is built from do, i.e. the files
+// matching its watched paths. Given the blob ids of all files in the repo, from util_git.ListFiles.
+func watchedFilesHash(files map[string]string, watchedPaths []string) string {
+	watched := lo.Filter(lo.Keys(files), func(file string, _ int) bool {
+		return lo.ContainsBy(watchedPaths, func(glob string) bool { return util_glob.MatchSelfOrParent(glob, file) })
+	})
+	sort.Strings(watched)
+	hash := sha256.New()
+	for _, file := range watched {
+		_, _ = fmt.Fprintf(hash, "%s %s\n", files[file], file)
+	}
+	return "git:" + hex.EncodeToString(hash.Sum(nil))
+}
+
+// ShortHash The start of an app/config version or commit, without its "h1:" or "git:" prefix, for display
+func ShortHash(hash string) string {
+	hash = strings.TrimPrefix(strings.TrimPrefix(hash, "h1:"), "git:")
+	if len(hash) > 8 {
+		return hash[:8]
+	}
+	return hash
 }
 
 func mergeCfgAndAppFs(
+	ctx context.Context,
+	cfg model.AppConfig,
+	cfgDir util_work_dir.WorkDir,
+	tempDir util_work_dir.WorkDir,
+) error {
+	_, span := util_trace.Start(ctx, "merge config files",
+		attribute.Bool("flycd.merge_cfg.all", cfg.MergeCfg.All),
+		attribute.StringSlice("flycd.merge_cfg.include", cfg.MergeCfg.Include),
+	)
+	err := copyCfgToAppFs(cfg, cfgDir, tempDir)
+	util_trace.End(span, err)
+	return err
+}
+
+func copyCfgToAppFs(
 	cfg model.AppConfig,
 	cfgDir util_work_dir.WorkDir,
 	tempDir util_work_dir.WorkDir,
@@ -800,17 +1152,19 @@ func mergeCfgAndAppFs(
 	return nil
 }
 
+// updateCfgHashes Sets the flycd env vars on copies of the configs' env maps, since the
+// configs may be shared with the tree listing they came from (and other deploys of it)
 func updateCfgHashes(
 	cfg *model.AppConfig,
 	cfgUntyped *map[string]any,
 	appHash string,
 	cfgHash string,
+	commit string,
 ) {
-	if cfg.Env == nil {
-		cfg.Env = map[string]string{}
-	}
+	cfg.Env = lo.Assign(cfg.Env)
 	cfg.Env["FLYCD_CONFIG_VERSION"] = cfgHash
 	cfg.Env["FLYCD_APP_VERSION"] = appHash
+	cfg.Env["FLYCD_APP_COMMIT"] = commit // lets status skip fetching git apps that haven't moved on since
 	cfg.Env["FLYCD_APP_SOURCE_TYPE"] = string(cfg.Source.Type)
 	cfg.Env["FLYCD_APP_SOURCE_PATH"] = cfg.Source.Path
 	cfg.Env["FLYCD_APP_SOURCE_REPO"] = cfg.Source.Repo
@@ -821,9 +1175,37 @@ func updateCfgHashes(
 	if err != nil {
 		panic(err)
 	}
+	*cfgUntyped = lo.Assign(*cfgUntyped)
 	(*cfgUntyped)["env"] = envUntyped
 }
 
+// effectiveCfgHash Hashes the fully merged app config (incl. app_defaults, app_overrides and
+// substitutions from all parent projects) instead of the app.yaml on disk, together with all
+// other files in the config dir. This way, changing a project.yaml also changes the config
+// version of every app it applies to.
+func effectiveCfgHash(cfgDir util_work_dir.WorkDir, cfgUntyped map[string]any) (string, error) {
+
+	effectiveCfg, err := yaml.Marshal(cfgUntyped)
+	if err != nil {
+		return "", fmt.Errorf("error marshalling effective app config: %w", err)
+	}
+
+	files, err := dirhash.DirFiles(cfgDir.Cwd(), "")
+	if err != nil {
+		return "", fmt.Errorf("error listing files in '%s': %w", cfgDir.Cwd(), err)
+	}
+
+	files = lo.Filter(files, func(file string, _ int) bool { return file != "app.yaml" })
+	files = append(files, "app.yaml")
+
+	return dirhash.Hash1(files, func(file string) (io.ReadCloser, error) {
+		if file == "app.yaml" {
+			return io.NopCloser(bytes.NewReader(effectiveCfg)), nil
+		}
+		return os.Open(filepath.Join(cfgDir.Cwd(), file))
+	})
+}
+
 func writeOutUpdatedConfigFiles(cfgUntyped map[string]any, tempDir util_work_dir.WorkDir) error {
 	cfgBytesYaml, err := yaml.Marshal(cfgUntyped)
 	if err != nil {
@@ -870,6 +1252,7 @@ func ensureDockerIgnoreExists(tempDir util_work_dir.WorkDir, err error) error {
 }
 
 func readAppConfigs(
+	ctx context.Context,
 	path string,
 ) (model.AppConfig, map[string]any, error) {
 
@@ -878,7 +1261,7 @@ func readAppConfigs(
 		return model.AppConfig{}, map[string]any{}, fmt.Errorf("error reading app.yaml from folder %s: %w", path, err)
 	}
 
-	typed, untyped, err := model.CommonAppConfig{}.MakeAppConfig(appYaml)
+	typed, untyped, err := model.CommonAppConfig{}.MakeAppConfig(ctx, appYaml)
 	if err != nil {
 		return model.AppConfig{}, untyped, fmt.Errorf("error making app config from folder %s: %w", path, err)
 	}
@@ -5,7 +5,18 @@ import (
 	"fmt"
 	mocks "github.com/gigurra/flycd/mocks/ext/fly_client"
 	"github.com/gigurra/flycd/pkg/domain/model"
+	"github.com/gigurra/flycd/pkg/ext/fly_client"
+	"github.com/gigurra/flycd/pkg/util/util_metrics"
+	"github.com/gigurra/flycd/pkg/util/util_work_dir"
 	"github.com/stretchr/testify/mock"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -100,7 +111,7 @@ func TestDeployFromFolder_appMergingConfig(t *testing.T) {
 		EXPECT().
 		GetAppScale(mock.Anything, mock.Anything).
 		Return([]model.ScaleState{}, nil)
-	
+
 	flyClient.
 		EXPECT().
 		ExistsApp(mock.Anything, mock.Anything).
@@ -266,3 +277,376 @@ func TestDeployFromFolder_withVolumes(t *testing.T) {
 	}
 
 }
+
+func TestDeployAll_simulatedBackend(t *testing.T) {
+	ctx := context.Background()
+	flyClient := fly_client.NewFlyClientSim()
+	deployService := NewDeployService(flyClient)
+	deployCfg := model.
+		NewDefaultDeployConfig().
+		WithAbortOnFirstError(true).
+		WithRetries(0)
+
+	result, err := deployService.DeployAll(ctx, "../../test/test-projects/deploy-tests/apps", deployCfg)
+	if err != nil {
+		t.Fatalf("DeployAll failed: %v", err)
+	}
+	if !result.Success() || len(result.SucceededApps) != 2 {
+		t.Fatalf("expected 2 successfully deployed apps, got %+v", result)
+	}
+
+	state := flyClient.State()
+	for _, appName := range []string{"app1", "app2"} {
+		app, ok := state.Apps[appName]
+		if !ok {
+			t.Fatalf("expected app %s to exist in simulated org", appName)
+		}
+		if app.Deploys != 1 || len(app.Machines) != 1 || app.Machines[0].Region != "arn" {
+			t.Fatalf("unexpected state for app %s: %+v", appName, app)
+		}
+		if app.Config.Env["FLYCD_APP_VERSION"] == "" || app.Config.Env["FLYCD_CONFIG_VERSION"] == "" {
+			t.Fatalf("expected app %s to be deployed with version env vars, got %+v", appName, app.Config.Env)
+		}
+	}
+
+	// Deploying again without changes should not touch anything
+	result, err = deployService.DeployAll(ctx, "../../test/test-projects/deploy-tests/apps", deployCfg)
+	if err != nil {
+		t.Fatalf("DeployAll failed: %v", err)
+	}
+	for _, success := range result.SucceededApps {
+		if success.SuccessType != model.SingleAppDeployNoChange {
+			t.Fatalf("expected app %s to be unchanged, got %s", success.Spec.AppConfig.App, success.SuccessType)
+		}
+	}
+	if flyClient.State().Apps["app1"].Deploys != 1 {
+		t.Fatalf("expected app1 not to be redeployed")
+	}
+}
+
+func TestDeployAll_simulatedBackend_parallel(t *testing.T) {
+	ctx := context.Background()
+	flyClient := fly_client.NewFlyClientSim()
+	deployService := NewDeployService(flyClient)
+	deployCfg := model.
+		NewDefaultDeployConfig().
+		WithAbortOnFirstError(true).
+		WithRetries(0).
+		WithParallelism(4)
+
+	result, err := deployService.DeployAll(ctx, "../../test/test-projects/deploy-tests/apps", deployCfg)
+	if err != nil {
+		t.Fatalf("DeployAll failed: %v", err)
+	}
+	if !result.Success() || len(result.SucceededApps) != 2 {
+		t.Fatalf("expected 2 successfully deployed apps, got %+v", result)
+	}
+
+	state := flyClient.State()
+	for _, appName := range []string{"app1", "app2"} {
+		app, ok := state.Apps[appName]
+		if !ok || app.Deploys != 1 {
+			t.Fatalf("expected app %s to be deployed once, got %+v", appName, app)
+		}
+	}
+}
+
+func TestDeployAll_simulatedBackend_withVolumes(t *testing.T) {
+	ctx := context.Background()
+	flyClient := fly_client.NewFlyClientSim()
+	deployService := NewDeployService(flyClient)
+	deployCfg := model.
+		NewDefaultDeployConfig().
+		WithAbortOnFirstError(true).
+		WithRetries(0)
+
+	_, err := deployService.DeployAll(ctx, "../../test/test-projects/nginx-with-volumes", deployCfg)
+	if err != nil {
+		t.Fatalf("DeployAll failed: %v", err)
+	}
+
+	app := flyClient.State().Apps["nginx-with-volumes-test"]
+	if len(app.Machines) != 3 || len(app.Volumes) != 3 {
+		t.Fatalf("expected 3 machines with 3 volumes, got %+v", app)
+	}
+
+	// A forced redeploy brings the volumes the fly cli created up to the configured size
+	_, err = deployService.DeployAll(ctx, "../../test/test-projects/nginx-with-volumes", deployCfg.WithForce())
+	if err != nil {
+		t.Fatalf("DeployAll failed: %v", err)
+	}
+
+	app = flyClient.State().Apps["nginx-with-volumes-test"]
+	for _, volume := range app.Volumes {
+		if volume.SizeGb != 10 {
+			t.Fatalf("expected all volumes to be 10 GB, got %+v", app.Volumes)
+		}
+	}
+}
+
+func TestDeployAll_projectDefaultsChangeConfigVersion(t *testing.T) {
+	ctx := context.Background()
+	flyClient := fly_client.NewFlyClientSim()
+	deployService := NewDeployService(flyClient)
+	deployCfg := model.NewDefaultDeployConfig().WithRetries(0)
+
+	root := util_work_dir.NewWorkDir(t.TempDir())
+	writeProject := func(env string) {
+		err := root.WriteFile("project.yaml", "project: my-project\nsource:\n  type: local\ncommon:\n  app_defaults:\n    env:\n      FOO: "+env+"\n")
+		if err != nil {
+			t.Fatalf("error writing project.yaml: %v", err)
+		}
+	}
+	writeProject("first")
+	if err := os.MkdirAll(filepath.Join(root.Cwd(), "app1"), 0755); err != nil {
+		t.Fatalf("error creating app dir: %v", err)
+	}
+	err := root.WithChildCwd("app1").WriteFile("app.yaml", "app: app1\norg: personal\nprimary_region: arn\nsource:\n  type: local\n")
+	if err != nil {
+		t.Fatalf("error writing app.yaml: %v", err)
+	}
+
+	deploy := func() model.SingleAppDeploySuccessType {
+		result, err := deployService.DeployAll(ctx, root.Cwd(), deployCfg)
+		if err != nil || !result.Success() || len(result.SucceededApps) != 1 {
+			t.Fatalf("expected app1 to deploy, got %+v, err=%v", result, err)
+		}
+		return result.SucceededApps[0].SuccessType
+	}
+
+	if res := deploy(); res != model.SingleAppDeployCreated {
+		t.Fatalf("expected app1 to be created, got %s", res)
+	}
+	if res := deploy(); res != model.SingleAppDeployNoChange {
+		t.Fatalf("expected no change on second deploy, got %s", res)
+	}
+
+	// Only project.yaml changes, the app folder stays the same
+	writeProject("second")
+	if res := deploy(); res != model.SingleAppDeployUpdated {
+		t.Fatalf("expected changed app_defaults to redeploy app1, got %s", res)
+	}
+	if env := flyClient.State().Apps["app1"].Config.Env; env["FOO"] != "second" {
+		t.Fatalf("expected new defaults to be deployed, got %+v", env)
+	}
+}
+
+func TestDeployFromFolder_failedStep(t *testing.T) {
+	ctx := context.Background()
+	flyClient := mocks.NewMockFlyClient(t)
+	deployService := NewDeployService(flyClient)
+	deployCfg := model.
+		NewDefaultDeployConfig().
+		WithRetries(0)
+
+	flyClient.
+		EXPECT().
+		ExistsApp(mock.Anything, mock.Anything).
+		Return(false, nil)
+
+	flyClient.
+		EXPECT().
+		CreateNewApp(mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(nil)
+
+	flyClient.
+		EXPECT().
+		DeployExistingApp(mock.Anything, mock.Anything, mock.Anything, mock.Anything, "arn").
+		Return(nil)
+
+	flyClient.
+		EXPECT().
+		GetAppScale(mock.Anything, mock.Anything).
+		Return(nil, fmt.Errorf("fly.io is down"))
+
+	_, err := deployService.DeployAppFromFolder(ctx, "../../test/test-projects/deploy-tests/apps/app1", deployCfg, nil)
+	if err == nil {
+		t.Fatalf("expected DeployAppFromFolder to fail")
+	}
+	if step := failedStep(err); step != util_metrics.StepScale {
+		t.Fatalf("expected the failure to be in the scale step, got %s", step)
+	}
+	if step := failedStep(fmt.Errorf("error deploying app: %w", fmt.Errorf("fly deploy failed"))); step != util_metrics.StepDeploy {
+		t.Fatalf("expected untagged failures to be in the deploy step, got %s", step)
+	}
+}
+
+func TestDeployAll_traced(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(previous)
+
+	ctx := context.Background()
+	deployService := NewDeployService(fly_client.NewTracedFlyClient(fly_client.NewFlyClientSim()))
+	deployCfg := model.
+		NewDefaultDeployConfig().
+		WithRetries(0)
+
+	result, err := deployService.DeployAll(ctx, "../../test/test-projects/deploy-tests/apps", deployCfg)
+	if err != nil || !result.Success() {
+		t.Fatalf("DeployAll failed: %v, %+v", err, result)
+	}
+
+	spans := recorder.Ended()
+	byId := map[trace.SpanID]sdktrace.ReadOnlySpan{}
+	for _, span := range spans {
+		byId[span.SpanContext().SpanID()] = span
+	}
+	parentName := func(span sdktrace.ReadOnlySpan) string {
+		if parent, ok := byId[span.Parent().SpanID()]; ok {
+			return parent.Name()
+		}
+		return ""
+	}
+
+	counts := map[string]int{}
+	for _, span := range spans {
+		counts[parentName(span)+" > "+span.Name()]++
+		if span.SpanContext().TraceID() != spans[0].SpanContext().TraceID() {
+			t.Fatalf("expected all spans to be in the same trace, got %s", span.Name())
+		}
+	}
+	expected := map[string]int{
+		" > deploy all":                       1,
+		"deploy all > traverse app tree":      1,
+		"deploy all > deploy app":             2,
+		"deploy app > step.prepare":           2,
+		"step.prepare > merge config files":   2,
+		"deploy app > fly.ExistsApp":          2,
+		"deploy app > step.deploy":            4, // creating the app, and deploying it
+		"step.deploy > fly.CreateNewApp":      2,
+		"step.deploy > fly.DeployExistingApp": 2,
+		"step.scale > fly.GetAppScale":        2,
+		"step.scale > step.scale_count":       2,
+	}
+	for name, count := range expected {
+		if counts[name] != count {
+			t.Fatalf("expected %d spans '%s', got %d, all spans: %v", count, name, counts[name], counts)
+		}
+	}
+}
+
+// newGitRepo Creates a local git repo with the files, returning its path and a func committing more changes to it
+func newGitRepo(t *testing.T, files map[string]string) (string, func(files map[string]string) string) {
+	repo := t.TempDir()
+	git := func(args ...string) string {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = repo
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v failed: %v, %s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	commit := func(files map[string]string) string {
+		for name, content := range files {
+			err := os.MkdirAll(filepath.Dir(filepath.Join(repo, name)), 0755)
+			if err != nil {
+				t.Fatalf("failed to create dir for %s: %v", name, err)
+			}
+			err = os.WriteFile(filepath.Join(repo, name), []byte(content), 0644)
+			if err != nil {
+				t.Fatalf("failed to write %s: %v", name, err)
+			}
+		}
+		git("add", "-A")
+		git("commit", "-m", "change")
+		return git("rev-parse", "HEAD")
+	}
+	git("init", "-b", "main")
+	commit(files)
+	return repo, commit
+}
+
+func TestDeployAppFromFolder_monorepoAppVersion(t *testing.T) {
+	ctx := context.Background()
+	repo, commit := newGitRepo(t, map[string]string{
+		"apps/a/Dockerfile": "FROM scratch",
+		"apps/b/Dockerfile": "FROM scratch",
+		"libs/common.txt":   "v1",
+	})
+	cfgDir := t.TempDir()
+	appYaml := "app: mono-a\norg: personal\nprimary_region: arn\n" +
+		"source:\n  type: git\n  repo: " + repo + "\n  path: apps/a\n" +
+		"watch_paths:\n  - libs\n"
+	err := os.WriteFile(filepath.Join(cfgDir, "app.yaml"), []byte(appYaml), 0644)
+	if err != nil {
+		t.Fatalf("failed to write app.yaml: %v", err)
+	}
+
+	history := NewJsonlDeployHistory(filepath.Join(t.TempDir(), "history.jsonl"))
+	history.SetRecordNoChange(true)
+	deployService := NewDeployServiceWithHistory(fly_client.NewFlyClientSim(), history)
+	deployCfg := model.NewDefaultDeployConfig().WithRetries(0)
+	deploy := func() model.DeployRecord {
+		_, err := deployService.DeployAppFromFolder(ctx, cfgDir, deployCfg, nil)
+		if err != nil {
+			t.Fatalf("DeployAppFromFolder failed: %v", err)
+		}
+		records, err := history.List("mono-a", 1)
+		if err != nil || len(records) != 1 {
+			t.Fatalf("expected a deploy record, got %+v, err=%v", records, err)
+		}
+		return records[0]
+	}
+
+	first := deploy()
+	if first.Result != model.SingleAppDeployCreated || !strings.HasPrefix(first.AppHash, "git:") {
+		t.Fatalf("expected the app to be created with a version from its files, got %+v", first)
+	}
+
+	otherAppCommit := commit(map[string]string{"apps/b/Dockerfile": "FROM alpine"})
+	second := deploy()
+	if second.Result != model.SingleAppDeployNoChange || second.AppHash != first.AppHash || second.Commit != otherAppCommit {
+		t.Fatalf("expected a change to another app in the repo to leave the app alone, got %+v", second)
+	}
+
+	commit(map[string]string{"libs/common.txt": "v2"})
+	third := deploy()
+	if third.Result != model.SingleAppDeployUpdated || third.AppHash == first.AppHash {
+		t.Fatalf("expected a change to a watched path to redeploy the app, got %+v", third)
+	}
+}
+
+func TestPrepareDeployInput_doesNotModifySharedConfig(t *testing.T) {
+	path := "../../test/test-projects/deploy-tests/apps/app1"
+	cfgTyped, cfgUntyped, err := readAppConfigs(context.Background(), path)
+	if err != nil {
+		t.Fatalf("readAppConfigs failed: %v", err)
+	}
+	if cfgTyped.Env == nil {
+		cfgTyped.Env = map[string]string{}
+	}
+	shared := &model.PreCalculatedAppConfig{Typed: cfgTyped, UnTyped: cfgUntyped}
+
+	input, err := prepareDeployInput(nil, context.Background(), path, model.NewDefaultDeployConfig(), shared)
+	if err != nil {
+		t.Fatalf("prepareDeployInput failed: %v", err)
+	}
+	defer input.tempDir.RemoveAll()
+
+	if input.cfgTyped.Env["FLYCD_APP_VERSION"] == "" {
+		t.Fatalf("expected the deployed config to have the app version set")
+	}
+	if _, found := shared.Typed.Env["FLYCD_APP_VERSION"]; found {
+		t.Fatalf("expected the shared typed config to be left alone, got %v", shared.Typed.Env)
+	}
+	if env, found := shared.UnTyped["env"]; found {
+		t.Fatalf("expected the shared untyped config to be left alone, got %v", env)
+	}
+}
+
+func TestShortHash(t *testing.T) {
+	for hash, expected := range map[string]string{
+		"h1:79JxEp9o1tBPsjWnVCqxRF41bq5dJDUctFBkHAYG2Oo=":                      "79JxEp9o",
+		"git:1a2b3c4d5e6f7a8b9c0d1e2f3a4b5c6d7e8f9a0b1c2d3e4f5a6b7c8d9e0f1a2b": "1a2b3c4d",
+		"709d658dc5b6d6afcd46049c2f332ee3f515a67d":                             "709d658d",
+		"abc": "abc",
+		"":    "",
+	} {
+		if actual := ShortHash(hash); actual != expected {
+			t.Fatalf("expected %s to be shortened to %s, got %s", hash, expected, actual)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package domain
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gigurra/flycd/pkg/domain/model"
+	"github.com/gigurra/flycd/pkg/util/util_git"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// GitPollService An alternative to webhooks, for repos on hosts where we can't configure them.
+// It checks all git repos referenced in the tree for new commits, and deploys what uses them.
+type GitPollService interface {
+	// Poll Checks all repos once, and returns the ones that changed since the previous poll.
+	// The first poll of a repo only records its current state, unless it is in the state file.
+	Poll(ctx context.Context, path string) ([]string, error)
+
+	// Invalidate Forgets which repos the tree references, since finding them clones every git
+	// project in it. Call it whenever the tree may have changed, e.g. when a sync or push job is done.
+	Invalidate()
+
+	// SetStateFile Keeps the last seen commits in the file, so that commits pushed while flycd was
+	// down are deployed by the first poll after a restart. Empty to only keep them in memory.
+	SetStateFile(path string) error
+}
+
+type GitPollServiceImpl struct {
+	webhookService WebHookService
+	lsRemote       func(ctx context.Context, source util_git.CloneSource) (string, error)
+	polling        sync.Mutex // one poll at a time, without holding mutex while cloning and polling
+	mutex          sync.Mutex // guards the fields below
+	lastSeen       map[util_git.CloneSource]string
+	sources        []util_git.CloneSource // nil until found, or after Invalidate
+	invalidations  int                    // so that sources found before an Invalidate aren't kept
+	stateFile      string
+}
+
+// polledHead What the state file remembers about a repo
+type polledHead struct {
+	Source util_git.CloneSource `json:"source"`
+	Commit string               `json:"commit"`
+}
+
+// prove that GitPollServiceImpl implements GitPollService
+var _ GitPollService = &GitPollServiceImpl{}
+
+func NewGitPollService(webhookService WebHookService) GitPollService {
+	return &GitPollServiceImpl{
+		webhookService: webhookService,
+		lsRemote:       util_git.LsRemote,
+		lastSeen:       map[util_git.CloneSource]string{},
+	}
+}
+
+func (g *GitPollServiceImpl) Poll(ctx context.Context, path string) ([]string, error) {
+
+	g.polling.Lock()
+	defer g.polling.Unlock()
+
+	g.mutex.Lock()
+	sources := g.sources
+	invalidations := g.invalidations
+	g.mutex.Unlock()
+
+	// Finding the sources clones every git project, so it must not block Invalidate and SetStateFile
+	if sources == nil {
+		found, err := findGitSources(ctx, path)
+		if err != nil {
+			return nil, err
+		}
+		sources = found
+	}
+
+	hashes := map[util_git.CloneSource]string{}
+	for _, source := range sources {
+		hash, err := g.lsRemote(ctx, source)
+		if err != nil {
+			// Don't stop polling other repos just because one is unreachable
+			slog.ErrorContext(ctx, "Error polling git repo", "repo", source.Repo, "error", err)
+			continue
+		}
+		hashes[source] = hash
+	}
+
+	g.mutex.Lock()
+	if g.invalidations == invalidations {
+		g.sources = sources
+	}
+	changedRepos := map[string]bool{}
+	events := make([]model.PushEvent, 0)
+	for _, source := range sources {
+		hash, polled := hashes[source]
+		if !polled {
+			continue
+		}
+		previous, seenBefore := g.lastSeen[source]
+		g.lastSeen[source] = hash
+		if seenBefore && previous != hash {
+			slog.InfoContext(ctx, "Git repo changed", "repo", source.Repo, "branch", source.Branch, "tag", source.Tag, "previous", previous, "current", hash)
+			changedRepos[source.Repo] = true
+			events = append(events, pollEvent(source, hash))
+		}
+	}
+	err := g.saveState()
+	if err != nil {
+		// Only means that changes might be missed (or deployed twice) across a restart
+		slog.ErrorContext(ctx, "Error saving git poll state", "file", g.stateFile, "error", err)
+	}
+	g.mutex.Unlock()
+
+	result := make([]string, 0, len(changedRepos))
+	for repo := range changedRepos {
+		result = append(result, repo)
+	}
+	sort.Strings(result)
+
+	for _, event := range events {
+		g.webhookService.HandlePushEvent(event, path)
+	}
+
+	return result, nil
+}
+
+func (g *GitPollServiceImpl) Invalidate() {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.sources = nil
+	g.invalidations++
+}
+
+func (g *GitPollServiceImpl) SetStateFile(path string) error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.stateFile = path
+	if path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading git poll state %s: %w", path, err)
+	}
+
+	var heads []polledHead
+	err = json.Unmarshal(data, &heads)
+	if err != nil {
+		return fmt.Errorf("error parsing git poll state %s: %w", path, err)
+	}
+	for _, head := range heads {
+		if _, polledAlready := g.lastSeen[head.Source]; !polledAlready {
+			g.lastSeen[head.Source] = head.Commit
+		}
+	}
+	return nil
+}
+
+// saveState Replaces the state file, if any, with the last seen commits. Callers must hold g.mutex.
+func (g *GitPollServiceImpl) saveState() error {
+	if g.stateFile == "" {
+		return nil
+	}
+
+	heads := make([]polledHead, 0, len(g.lastSeen))
+	for source, commit := range g.lastSeen {
+		heads = append(heads, polledHead{Source: source, Commit: commit})
+	}
+	sort.Slice(heads, func(i, j int) bool {
+		return fmt.Sprintf("%+v", heads[i].Source) < fmt.Sprintf("%+v", heads[j].Source)
+	})
+	data, err := json.MarshalIndent(heads, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error serializing git poll state: %w", err)
+	}
+
+	dir := filepath.Dir(g.stateFile)
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return fmt.Errorf("error creating git poll state dir: %w", err)
+	}
+	tmp, err := os.CreateTemp(dir, ".tmp-"+filepath.Base(g.stateFile)+"-*")
+	if err != nil {
+		return fmt.Errorf("error creating temp file for git poll state: %w", err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }() // no-op once renamed
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("error writing git poll state: %w", err)
+	}
+	err = os.Rename(tmp.Name(), g.stateFile)
+	if err != nil {
+		return fmt.Errorf("error storing git poll state %s: %w", g.stateFile, err)
+	}
+	return nil
+}
+
+// pollEvent What a push webhook for the changed ref would have looked like
+func pollEvent(source util_git.CloneSource, hash string) model.PushEvent {
+	ref := model.HeadRef
+	switch {
+	case source.Branch != "":
+		ref = "refs/heads/" + source.Branch
+	case source.Tag != "":
+		ref = "refs/tags/" + source.Tag
+	}
+	return model.PushEvent{
+		Provider: "git-poll",
+		RepoUrls: []string{source.Repo},
+		Ref:      ref,
+		Commit:   hash,
+	}
+}
+
+// findGitSources Finds the same repos as the 'repos' command, i.e. all git sources of apps and projects
+func findGitSources(ctx context.Context, path string) ([]util_git.CloneSource, error) {
+
+	found := map[util_git.CloneSource]bool{}
+	result := make([]util_git.CloneSource, 0)
+	add := func(source model.Source) {
+		if source.Type != model.SourceTypeGit || source.Repo == "" {
+			return
+		}
+		cloneSource := source.AsGitCloneSource()
+		if !found[cloneSource] {
+			found[cloneSource] = true
+			result = append(result, cloneSource)
+		}
+	}
+
+	err := TraverseDeepAppTree(path, model.TraverseAppTreeContext{
+		Context: ctx,
+		ValidAppCb: func(ctx model.TraverseAppTreeContext, node model.AppAtFsNode) error {
+			add(node.AppConfig.Source)
+			return nil
+		},
+		BeginProjectCb: func(ctx model.TraverseAppTreeContext, node model.ProjectAtFsNode) error {
+			if node.IsValidProject() {
+				add(node.ProjectConfig.Source)
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		return result, fmt.Errorf("error traversing app tree: %w", err)
+	}
+
+	return result, nil
+}
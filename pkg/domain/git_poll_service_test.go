@@ -0,0 +1,155 @@
+package domain
+
+import (
+	"context"
+	"github.com/gigurra/flycd/mocks/domain"
+	"github.com/gigurra/flycd/pkg/domain/model"
+	"github.com/gigurra/flycd/pkg/util/util_git"
+	"reflect"
+	"testing"
+)
+
+func TestGitPollService(t *testing.T) {
+	ctx := context.Background()
+	path := "../../test/test-projects/webhooks/regular"
+	fakeWebhookService := domain.NewMockWebHookService(t)
+
+	heads := map[string]string{
+		"git@github.com:TestUser/TestRepo.git":      "aaa",
+		"git@github.com:TestUser/OtherTestRepo.git": "bbb",
+	}
+	pollService := NewGitPollService(fakeWebhookService).(*GitPollServiceImpl)
+	pollService.lsRemote = func(ctx context.Context, source util_git.CloneSource) (string, error) {
+		return heads[source.Repo], nil
+	}
+
+	// The first poll only records the current state
+	changed, err := pollService.Poll(ctx, path)
+	if err != nil || len(changed) != 0 {
+		t.Fatalf("expected no changes on first poll, got %v, err=%v", changed, err)
+	}
+
+	heads["git@github.com:TestUser/TestRepo.git"] = "ccc"
+	fakeWebhookService.
+		EXPECT().
+		HandlePushEvent(model.PushEvent{
+			Provider: "git-poll",
+			RepoUrls: []string{"git@github.com:TestUser/TestRepo.git"},
+			Ref:      model.HeadRef,
+			Commit:   "ccc",
+		}, path).
+		Return(make(chan error))
+
+	changed, err = pollService.Poll(ctx, path)
+	if err != nil {
+		t.Fatalf("Poll failed: %v", err)
+	}
+	if !reflect.DeepEqual(changed, []string{"git@github.com:TestUser/TestRepo.git"}) {
+		t.Fatalf("expected only TestRepo to have changed, got %v", changed)
+	}
+
+	// Nothing new since last poll
+	changed, err = pollService.Poll(ctx, path)
+	if err != nil || len(changed) != 0 {
+		t.Fatalf("expected no changes on third poll, got %v, err=%v", changed, err)
+	}
+}
+
+func TestGitPollService_stateFile(t *testing.T) {
+	ctx := context.Background()
+	path := "../../test/test-projects/webhooks/regular"
+	stateFile := t.TempDir() + "/poll-state.json"
+	fakeWebhookService := domain.NewMockWebHookService(t)
+
+	heads := map[string]string{
+		"git@github.com:TestUser/TestRepo.git":      "aaa",
+		"git@github.com:TestUser/OtherTestRepo.git": "bbb",
+	}
+	lsRemote := func(ctx context.Context, source util_git.CloneSource) (string, error) {
+		return heads[source.Repo], nil
+	}
+
+	pollService := NewGitPollService(fakeWebhookService).(*GitPollServiceImpl)
+	pollService.lsRemote = lsRemote
+	if err := pollService.SetStateFile(stateFile); err != nil {
+		t.Fatalf("SetStateFile failed: %v", err)
+	}
+	changed, err := pollService.Poll(ctx, path)
+	if err != nil || len(changed) != 0 {
+		t.Fatalf("expected no changes on first poll, got %v, err=%v", changed, err)
+	}
+
+	// Pushed while flycd was down
+	heads["git@github.com:TestUser/TestRepo.git"] = "ccc"
+	fakeWebhookService.
+		EXPECT().
+		HandlePushEvent(model.PushEvent{
+			Provider: "git-poll",
+			RepoUrls: []string{"git@github.com:TestUser/TestRepo.git"},
+			Ref:      model.HeadRef,
+			Commit:   "ccc",
+		}, path).
+		Return(make(chan error))
+
+	restarted := NewGitPollService(fakeWebhookService).(*GitPollServiceImpl)
+	restarted.lsRemote = lsRemote
+	if err := restarted.SetStateFile(stateFile); err != nil {
+		t.Fatalf("SetStateFile failed: %v", err)
+	}
+	changed, err = restarted.Poll(ctx, path)
+	if err != nil {
+		t.Fatalf("Poll failed: %v", err)
+	}
+	if !reflect.DeepEqual(changed, []string{"git@github.com:TestUser/TestRepo.git"}) {
+		t.Fatalf("expected the push while down to be found, got %v", changed)
+	}
+}
+
+func TestGitPollService_cachesSources(t *testing.T) {
+	ctx := context.Background()
+	path := "../../test/test-projects/webhooks/regular"
+	pollService := NewGitPollService(domain.NewMockWebHookService(t)).(*GitPollServiceImpl)
+	pollService.lsRemote = func(ctx context.Context, source util_git.CloneSource) (string, error) {
+		return "aaa", nil
+	}
+
+	if _, err := pollService.Poll(ctx, path); err != nil {
+		t.Fatalf("Poll failed: %v", err)
+	}
+	if len(pollService.sources) == 0 {
+		t.Fatalf("expected the sources to be remembered")
+	}
+
+	// A cached listing is used as is, even if the tree is gone
+	if _, err := pollService.Poll(ctx, "/does/not/exist"); err != nil {
+		t.Fatalf("expected the cached sources to be used, got %v", err)
+	}
+
+	pollService.Invalidate()
+	if _, err := pollService.Poll(ctx, "/does/not/exist"); err == nil {
+		t.Fatalf("expected the tree to be searched again after Invalidate")
+	}
+}
+
+func TestGitPollService_invalidateWhilePolling(t *testing.T) {
+	ctx := context.Background()
+	path := "../../test/test-projects/webhooks/regular"
+	pollService := NewGitPollService(domain.NewMockWebHookService(t)).(*GitPollServiceImpl)
+
+	// The tree changes while the repos are polled, which must neither wait for the poll
+	// nor be overwritten by the sources the poll found before the change
+	pollService.lsRemote = func(ctx context.Context, source util_git.CloneSource) (string, error) {
+		pollService.Invalidate()
+		return "aaa", nil
+	}
+
+	if _, err := pollService.Poll(ctx, path); err != nil {
+		t.Fatalf("Poll failed: %v", err)
+	}
+	if pollService.sources != nil {
+		t.Fatalf("expected the sources to be searched again by the next poll, got %v", pollService.sources)
+	}
+	if len(pollService.lastSeen) != 2 {
+		t.Fatalf("expected both repos to have been polled, got %v", pollService.lastSeen)
+	}
+}
@@ -0,0 +1,121 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"github.com/gigurra/flycd/pkg/util/util_log"
+	"github.com/samber/lo"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jobLogs Keeps the log lines of the most recent jobs, so they can be looked up per job.
+// Only kept in memory, so the logs of jobs run before a restart are lost.
+type jobLogs struct {
+	mutex    sync.Mutex
+	maxJobs  int
+	maxLines int // per job. The first lines are dropped beyond it
+	order    []string
+	lines    map[string][]string
+}
+
+func newJobLogs(maxJobs int, maxLines int) *jobLogs {
+	return &jobLogs{
+		maxJobs:  maxJobs,
+		maxLines: maxLines,
+		order:    []string{},
+		lines:    map[string][]string{},
+	}
+}
+
+func (l *jobLogs) add(jobId string, line string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if _, found := l.lines[jobId]; !found {
+		l.order = append(l.order, jobId)
+		if len(l.order) > l.maxJobs {
+			delete(l.lines, l.order[0])
+			l.order = l.order[1:]
+		}
+	}
+	lines := append(l.lines[jobId], fmt.Sprintf("%s %s", time.Now().UTC().Format(time.RFC3339), line))
+	if len(lines) > l.maxLines {
+		lines = lines[len(lines)-l.maxLines:]
+	}
+	l.lines[jobId] = lines
+}
+
+func (l *jobLogs) get(jobId string) ([]string, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	lines, found := l.lines[jobId]
+	return append([]string{}, lines...), found
+}
+
+// jobLogHandler Keeps the log records of jobs, i.e. those with a job id, in their job logs,
+// and passes all records on to the inner handler
+type jobLogHandler struct {
+	inner slog.Handler
+	logs  *jobLogs
+	attrs []slog.Attr // added with WithAttrs
+}
+
+// prove that jobLogHandler implements slog.Handler
+var _ slog.Handler = jobLogHandler{}
+
+// jobLogOmittedKeys Attributes that are the same for all lines of a job, or only make sense in the log backend
+var jobLogOmittedKeys = []string{util_log.KeyJobId, util_log.KeyCorrelationId, util_log.KeyTraceId, util_log.KeySpanId}
+
+func (h jobLogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.inner.Enabled(ctx, level)
+}
+
+func (h jobLogHandler) Handle(ctx context.Context, record slog.Record) error {
+	attrs := append(append([]slog.Attr{}, util_log.Attrs(ctx)...), h.attrs...)
+	record.Attrs(func(attr slog.Attr) bool {
+		attrs = append(attrs, attr)
+		return true
+	})
+
+	jobId := ""
+	for _, attr := range attrs {
+		if attr.Key == util_log.KeyJobId {
+			jobId = attr.Value.String()
+		}
+	}
+	if jobId != "" {
+		h.logs.add(jobId, formatJobLogLine(record, attrs))
+	}
+
+	return h.inner.Handle(ctx, record)
+}
+
+func (h jobLogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return jobLogHandler{
+		inner: h.inner.WithAttrs(attrs),
+		logs:  h.logs,
+		attrs: append(append([]slog.Attr{}, h.attrs...), attrs...),
+	}
+}
+
+func (h jobLogHandler) WithGroup(name string) slog.Handler {
+	return jobLogHandler{inner: h.inner.WithGroup(name), logs: h.logs, attrs: h.attrs}
+}
+
+// formatJobLogLine The message, prefixed with the level unless it is info, followed by the attributes as key=value
+func formatJobLogLine(record slog.Record, attrs []slog.Attr) string {
+	parts := make([]string, 0, len(attrs)+2)
+	if record.Level != slog.LevelInfo {
+		parts = append(parts, record.Level.String())
+	}
+	parts = append(parts, record.Message)
+	for _, attr := range attrs {
+		if !lo.Contains(jobLogOmittedKeys, attr.Key) {
+			parts = append(parts, attr.String())
+		}
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,235 @@
+package domain
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gigurra/flycd/pkg/domain/model"
+	"github.com/samber/lo"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jobIds Ids are zero-padded timestamps, so that sorting them by name sorts them by age, also across restarts
+type jobIds struct {
+	mutex sync.Mutex
+	last  int64
+}
+
+func (g *jobIds) next() string {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.last = max(time.Now().UnixNano(), g.last+1)
+	return fmt.Sprintf("%019d", g.last)
+}
+
+func newJob(ids *jobIds, job model.Job) model.Job {
+	job.Id = ids.next()
+	job.EnqueuedAt = time.Now()
+	return job
+}
+
+// MemJobQueue An in-memory job queue, for when there is nowhere to persist jobs
+type MemJobQueue struct {
+	ids     jobIds
+	mutex   sync.Mutex
+	pending []model.Job
+	dead    []model.Job
+}
+
+// prove that MemJobQueue implements JobQueue
+var _ model.JobQueue = &MemJobQueue{}
+
+func NewMemJobQueue() model.JobQueue {
+	return &MemJobQueue{
+		pending: []model.Job{},
+		dead:    []model.Job{},
+	}
+}
+
+func (q *MemJobQueue) Push(job model.Job) (model.Job, error) {
+	job = newJob(&q.ids, job)
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.pending = append(q.pending, job)
+	return job, nil
+}
+
+func (q *MemJobQueue) Pending() ([]model.Job, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return append([]model.Job{}, q.pending...), nil
+}
+
+func (q *MemJobQueue) Update(job model.Job) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	_, index, found := lo.FindIndexOf(q.pending, func(j model.Job) bool { return j.Id == job.Id })
+	if !found {
+		return fmt.Errorf("job %s is not in the queue", job.Id)
+	}
+	q.pending[index] = job
+	return nil
+}
+
+func (q *MemJobQueue) Remove(id string) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.pending = lo.Reject(q.pending, func(j model.Job, _ int) bool { return j.Id == id })
+	return nil
+}
+
+func (q *MemJobQueue) DeadLetter(job model.Job) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.pending = lo.Reject(q.pending, func(j model.Job, _ int) bool { return j.Id == job.Id })
+	q.dead = append(q.dead, job)
+	return nil
+}
+
+func (q *MemJobQueue) DeadLetters() ([]model.Job, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return append([]model.Job{}, q.dead...), nil
+}
+
+// FileJobQueue A job queue with one json file per job. Files are written to a temp
+// file first and then renamed, so a crash never leaves a half written job.
+type FileJobQueue struct {
+	ids     jobIds
+	mutex   sync.Mutex
+	pending string
+	dead    string
+}
+
+// prove that FileJobQueue implements JobQueue
+var _ model.JobQueue = &FileJobQueue{}
+
+func NewFileJobQueue(dir string) (model.JobQueue, error) {
+	q := &FileJobQueue{
+		pending: filepath.Join(dir, "pending"),
+		dead:    filepath.Join(dir, "dead"),
+	}
+	for _, subDir := range []string{q.pending, q.dead} {
+		err := os.MkdirAll(subDir, 0755)
+		if err != nil {
+			return nil, fmt.Errorf("error creating job queue dir %s: %w", subDir, err)
+		}
+	}
+	return q, nil
+}
+
+func (q *FileJobQueue) Push(job model.Job) (model.Job, error) {
+	job = newJob(&q.ids, job)
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return job, writeJobFile(q.pending, job)
+}
+
+func (q *FileJobQueue) Pending() ([]model.Job, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return readJobFiles(q.pending)
+}
+
+func (q *FileJobQueue) Update(job model.Job) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if _, err := os.Stat(jobFile(q.pending, job.Id)); err != nil {
+		return fmt.Errorf("job %s is not in the queue: %w", job.Id, err)
+	}
+	return writeJobFile(q.pending, job)
+}
+
+func (q *FileJobQueue) Remove(id string) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	err := os.Remove(jobFile(q.pending, id))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error removing job %s: %w", id, err)
+	}
+	return nil
+}
+
+func (q *FileJobQueue) DeadLetter(job model.Job) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	err := writeJobFile(q.dead, job)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(jobFile(q.pending, job.Id))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error removing dead job %s from the queue: %w", job.Id, err)
+	}
+	return nil
+}
+
+func (q *FileJobQueue) DeadLetters() ([]model.Job, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return readJobFiles(q.dead)
+}
+
+func jobFile(dir string, id string) string {
+	return filepath.Join(dir, id+".json")
+}
+
+func writeJobFile(dir string, job model.Job) error {
+	data, err := json.MarshalIndent(job, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error serializing job %s: %w", job.Id, err)
+	}
+	tmp, err := os.CreateTemp(dir, ".tmp-"+job.Id+"-*")
+	if err != nil {
+		return fmt.Errorf("error creating temp file for job %s: %w", job.Id, err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }() // no-op once renamed
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("error writing job %s: %w", job.Id, err)
+	}
+	err = os.Rename(tmp.Name(), jobFile(dir, job.Id))
+	if err != nil {
+		return fmt.Errorf("error storing job %s: %w", job.Id, err)
+	}
+	return nil
+}
+
+func readJobFiles(dir string) ([]model.Job, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error listing jobs in %s: %w", dir, err)
+	}
+	names := lo.FilterMap(entries, func(entry os.DirEntry, _ int) (string, bool) {
+		return entry.Name(), !entry.IsDir() && strings.HasSuffix(entry.Name(), ".json")
+	})
+	sort.Strings(names)
+
+	result := make([]model.Job, 0, len(names))
+	for _, name := range names {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, fmt.Errorf("error reading job file %s: %w", name, err)
+		}
+		var job model.Job
+		err = json.Unmarshal(data, &job)
+		if err != nil {
+			// Don't let one bad file block the whole queue
+			slog.Warn("Ignoring unreadable job file", "file", name, "error", err)
+			continue
+		}
+		result = append(result, job)
+	}
+	return result, nil
+}
@@ -0,0 +1,67 @@
+package domain
+
+import (
+	"github.com/gigurra/flycd/pkg/domain/model"
+	"testing"
+)
+
+func TestFileJobQueue(t *testing.T) {
+	dir := t.TempDir()
+	queue, err := NewFileJobQueue(dir)
+	if err != nil {
+		t.Fatalf("NewFileJobQueue failed: %v", err)
+	}
+
+	first, err := queue.Push(model.NewSyncJob("some/path"))
+	if err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	second, err := queue.Push(model.NewPushJob(model.PushEvent{Provider: "github", RepoUrls: []string{"git@github.com:a/b.git"}}, "some/path"))
+	if err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	if first.Id == "" || first.Id >= second.Id {
+		t.Fatalf("expected increasing job ids, got '%s' and '%s'", first.Id, second.Id)
+	}
+
+	first.Attempts = 1
+	first.LastError = "boom"
+	err = queue.Update(first)
+	if err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+
+	// Jobs survive a restart, in order
+	queue, err = NewFileJobQueue(dir)
+	if err != nil {
+		t.Fatalf("NewFileJobQueue failed: %v", err)
+	}
+	pending, err := queue.Pending()
+	if err != nil {
+		t.Fatalf("Pending failed: %v", err)
+	}
+	if len(pending) != 2 || pending[0].Id != first.Id || pending[1].Id != second.Id {
+		t.Fatalf("expected both jobs in order, got %+v", pending)
+	}
+	if pending[0].Attempts != 1 || pending[0].LastError != "boom" {
+		t.Fatalf("expected the update to be persisted, got %+v", pending[0])
+	}
+	if pending[1].PushEvent == nil || pending[1].PushEvent.RepoUrls[0] != "git@github.com:a/b.git" {
+		t.Fatalf("expected the push event to be persisted, got %+v", pending[1])
+	}
+
+	err = queue.DeadLetter(pending[0])
+	if err != nil {
+		t.Fatalf("DeadLetter failed: %v", err)
+	}
+	err = queue.Remove(second.Id)
+	if err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+
+	pending, _ = queue.Pending()
+	dead, _ := queue.DeadLetters()
+	if len(pending) != 0 || len(dead) != 1 || dead[0].Id != first.Id {
+		t.Fatalf("expected only the dead letter to remain, got pending=%+v, dead=%+v", pending, dead)
+	}
+}
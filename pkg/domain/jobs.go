@@ -0,0 +1,82 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gigurra/flycd/pkg/domain/model"
+)
+
+// runSyncJob Deploys everything in the tree from fresh git state. Apps whose app and config
+// versions are unchanged are skipped by the deploy service, so this is cheap when nothing changed.
+// Failing apps don't fail the job, since retrying the whole tree for them would be wasteful.
+// Like reconciliations, syncs deploy as many apps at once as there are workers, within the org limits.
+// lockApp, if not nil, is held while deploying each app.
+func runSyncJob(
+	ctx context.Context,
+	deployService DeployService,
+	job model.Job,
+	workerCfg model.WorkerConfig,
+	lockApp model.AppLocker,
+	logf func(format string, args ...any),
+) error {
+	logf("Syncing/Deploying %s in %s", job.Only.Description(), job.Path)
+
+	deployCfg := model.
+		NewDefaultDeployConfig().
+		WithAbortOnFirstError(false).
+		WithParallelism(workerCfg.Workers).
+		WithOrgLimits(workerCfg.OrgLimits).
+		WithTrigger(job.Trigger).
+		WithOnly(job.Only).
+		WithLockApp(lockApp)
+
+	result, err := deployService.DeployAll(ctx, job.Path, deployCfg)
+	if err != nil {
+		return fmt.Errorf("error deploying: %w", err)
+	}
+
+	for _, success := range result.SucceededApps {
+		if success.SuccessType != model.SingleAppDeployNoChange {
+			logf("Synced app %s (%s)", success.Spec.AppConfig.App, success.SuccessType)
+		}
+	}
+	for _, failure := range result.FailedApps {
+		logf("Error syncing app %s: %v", failure.Spec.AppConfig.App, failure.Cause)
+	}
+
+	return nil
+}
+
+// runReconcileJob Repairs drifted volumes, ips and scale of everything in the tree, without redeploying.
+// Since reconciliations wait for all other jobs, they reconcile as many apps at once as there are workers,
+// so that they hold up the other jobs for as short as possible.
+func runReconcileJob(ctx context.Context, deployService DeployService, job model.Job, workerCfg model.WorkerConfig, logf func(format string, args ...any)) error {
+	logf("Reconciling infra of %s in %s", job.Only.Description(), job.Path)
+
+	deployCfg := model.
+		NewDefaultDeployConfig().
+		WithAbortOnFirstError(false).
+		WithParallelism(workerCfg.Workers).
+		WithOrgLimits(workerCfg.OrgLimits).
+		WithTrigger(job.Trigger).
+		WithOnly(job.Only)
+
+	result, err := deployService.ReconcileAll(ctx, job.Path, deployCfg)
+	if err != nil {
+		return fmt.Errorf("error reconciling: %w", err)
+	}
+
+	for _, success := range result.SucceededApps {
+		if success.SuccessType == model.SingleAppDeployReconciled {
+			logf("Reconciled drifted infra of app %s", success.Spec.AppConfig.App)
+		}
+	}
+	for _, failure := range result.FailedApps {
+		if !errors.Is(failure.Cause, SkippedNotDeployed) {
+			logf("Error reconciling app %s: %v", failure.Spec.AppConfig.App, failure.Cause)
+		}
+	}
+
+	return nil
+}
@@ -21,6 +21,11 @@ type TraverseAppTreeContext struct {
 	}
 	Parents      []ProjectConfig
 	CommonAppCfg CommonAppConfig
+	// Notifications Of the projects we are in, merged like CommonAppCfg
+	Notifications NotificationsConfig
+	// DeferCleanup If set, projects cloned from git are not removed when done traversing them, but their cleanup
+	// is handed to this func instead. For callers that use the app paths after the traversal has finished.
+	DeferCleanup func(cleanup func())
 }
 
 // prove that TraverseAppTreeContext implements the context interface
@@ -40,12 +45,14 @@ type AppAtFsNode struct {
 	AppConfigUntyped map[string]any
 	AppConfig        AppConfig
 	AppConfigErr     error
+	Notifications    NotificationsConfig // of the projects the app is in
 }
 
 func (s AppAtFsNode) ToPreCalculatedApoConf() *PreCalculatedAppConfig {
 	return &PreCalculatedAppConfig{
-		Typed:   s.AppConfig,
-		UnTyped: s.AppConfigUntyped,
+		Typed:         s.AppConfig,
+		UnTyped:       s.AppConfigUntyped,
+		Notifications: s.Notifications,
 	}
 }
 
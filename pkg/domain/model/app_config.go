@@ -5,7 +5,9 @@ import (
 	"github.com/gigurra/flycd/pkg/util/util_math"
 	"github.com/samber/lo"
 	"os"
+	"path"
 	"regexp"
+	"strings"
 )
 
 type Concurrency struct {
@@ -63,8 +65,9 @@ type Mount struct {
 }
 
 type PreCalculatedAppConfig struct {
-	Typed   AppConfig
-	UnTyped map[string]any
+	Typed         AppConfig
+	UnTyped       map[string]any
+	Notifications NotificationsConfig // of the projects the app is in
 }
 
 type MachineConfig struct {
@@ -90,6 +93,8 @@ type AppConfig struct {
 	ExtraRegions  []string          `yaml:"extra_regions,omitempty" toml:"extra_regions,omitempty"`
 	Source        Source            `yaml:"source,omitempty" toml:"source"`
 	MergeCfg      MergeCfg          `yaml:"merge_cfg,omitempty" toml:"merge_cfg" json:"merge_cfg,omitempty"`
+	WatchPaths    []string          `yaml:"watch_paths,omitempty" toml:"watch_paths,omitempty" json:"watch_paths,omitempty"`
+	DependsOn     []string          `yaml:"depends_on,omitempty" toml:"depends_on,omitempty" json:"depends_on,omitempty"`
 	Services      []Service         `yaml:"services,omitempty" toml:"services,omitempty"`
 	HttpService   *HttpService      `yaml:"http_service,omitempty" toml:"http_service,omitempty"`
 	LaunchParams  []string          `yaml:"launch_params,omitempty" toml:"launch_params,omitempty"`
@@ -109,6 +114,31 @@ func (a AppConfig) WithKillTimeout(seconds int) AppConfig {
 	return a
 }
 
+// WatchedPaths Globs of the files in the source repo that the app is built from: its source path,
+// and any extra dependencies listed in watch_paths (e.g. shared libraries in a monorepo)
+func (a *AppConfig) WatchedPaths() []string {
+	sourcePath := strings.Trim(path.Clean("/"+a.Source.Path), "/")
+	if sourcePath == "" {
+		sourcePath = "**"
+	}
+	return append([]string{sourcePath}, a.WatchPaths...)
+}
+
+// WatchedConfigPaths Globs of the files in the config repo that the app is configured from, given the dir of its
+// app.yaml in that repo: the dir itself (app.yaml and the files merged into the app), its source if local, and
+// all project.yaml files, since their common config may apply to the app
+func (a *AppConfig) WatchedConfigPaths(configDir string) []string {
+	configDir = strings.Trim(path.Clean("/"+configDir), "/")
+	if configDir == "" {
+		return []string{"**"}
+	}
+	result := []string{configDir, "**/project.yaml"}
+	if a.Source.Type == SourceTypeLocal && !path.IsAbs(a.Source.Path) {
+		result = append(result, strings.Trim(path.Clean("/"+path.Join(configDir, a.Source.Path)), "/"))
+	}
+	return lo.Uniq(result)
+}
+
 func (a *AppConfig) RegionsWPrimaryLast() []string {
 	result := []string{}
 	result = append(result, a.ExtraRegions...)
@@ -336,6 +366,12 @@ func (a *AppConfig) Validate(options ...ValidateAppConfigOptions) error {
 		return fmt.Errorf("primary_region is required when org is specified")
 	}
 
+	for _, dependency := range a.DependsOn {
+		if dependency == a.App {
+			return fmt.Errorf("app '%s' can't depend on itself", a.App)
+		}
+	}
+
 	return nil
 }
 
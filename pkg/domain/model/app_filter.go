@@ -0,0 +1,40 @@
+package model
+
+import (
+	"fmt"
+	"github.com/samber/lo"
+)
+
+// AppFilter Limits a deploy to a single app, or to the apps of a single project. The zero value matches all apps.
+type AppFilter struct {
+	App     string `json:"app,omitempty"`
+	Project string `json:"project,omitempty"`
+}
+
+func (f AppFilter) IsEmpty() bool {
+	return f.App == "" && f.Project == ""
+}
+
+// Matches tells if the app, found inside the given parent projects, is selected by the filter
+func (f AppFilter) Matches(app AppConfig, parents []ProjectConfig) bool {
+	if f.App != "" && app.App != f.App {
+		return false
+	}
+	if f.Project != "" && !lo.SomeBy(parents, func(p ProjectConfig) bool { return p.Project == f.Project }) {
+		return false
+	}
+	return true
+}
+
+func (f AppFilter) Description() string {
+	switch {
+	case f.App != "" && f.Project != "":
+		return fmt.Sprintf("app %s in project %s", f.App, f.Project)
+	case f.App != "":
+		return fmt.Sprintf("app %s", f.App)
+	case f.Project != "":
+		return fmt.Sprintf("project %s", f.Project)
+	default:
+		return "all apps"
+	}
+}
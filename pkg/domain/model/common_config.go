@@ -1,6 +1,7 @@
 package model
 
 import (
+	"context"
 	"fmt"
 	"github.com/gigurra/flycd/pkg/util/util_cfg_merge"
 	"github.com/gigurra/flycd/pkg/util/util_cvt"
@@ -16,17 +17,17 @@ type CommonAppConfig struct {
 }
 
 // Plus merges two CommonAppConfig's, used when traversing the project tree with projects-in-projects.
-func (c CommonAppConfig) Plus(other CommonAppConfig) CommonAppConfig {
+func (c CommonAppConfig) Plus(ctx context.Context, other CommonAppConfig) CommonAppConfig {
 	return CommonAppConfig{
-		AppDefaults:      util_cfg_merge.MergeMaps(c.AppDefaults, other.AppDefaults),
-		AppSubstitutions: util_cfg_merge.MergeMaps(c.AppSubstitutions, other.AppSubstitutions),
-		AppOverrides:     util_cfg_merge.MergeMaps(c.AppOverrides, other.AppOverrides),
+		AppDefaults:      util_cfg_merge.MergeMaps(ctx, c.AppDefaults, other.AppDefaults),
+		AppSubstitutions: util_cfg_merge.MergeMaps(ctx, c.AppSubstitutions, other.AppSubstitutions),
+		AppOverrides:     util_cfg_merge.MergeMaps(ctx, c.AppOverrides, other.AppOverrides),
 	}
 }
 
 // MakeAppConfig creates an AppConfig from a raw app.yaml file, applying all substitutions and overrides,
 // from the parent projects and their CommonAppConfig's.
-func (c CommonAppConfig) MakeAppConfig(appYaml []byte, validate ...bool) (AppConfig, map[string]any, error) {
+func (c CommonAppConfig) MakeAppConfig(ctx context.Context, appYaml []byte, validate ...bool) (AppConfig, map[string]any, error) {
 
 	// Copy the bytes to a new slice to avoid modifying the original
 	// when we start doing substitutions
@@ -51,9 +52,9 @@ func (c CommonAppConfig) MakeAppConfig(appYaml []byte, validate ...bool) (AppCon
 
 	// Combine all the configuration sources into one map
 	untyped := map[string]any{}
-	untyped = util_cfg_merge.MergeMaps(untyped, c.AppDefaults)
-	untyped = util_cfg_merge.MergeMaps(untyped, cfgInFile)
-	untyped = util_cfg_merge.MergeMaps(untyped, c.AppOverrides)
+	untyped = util_cfg_merge.MergeMaps(ctx, untyped, c.AppDefaults)
+	untyped = util_cfg_merge.MergeMaps(ctx, untyped, cfgInFile)
+	untyped = util_cfg_merge.MergeMaps(ctx, untyped, c.AppOverrides)
 
 	// Convert the map to a typed AppConfig
 	typed, err := util_cvt.MapYamlToStruct[AppConfig](untyped)
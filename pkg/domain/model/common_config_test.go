@@ -1,6 +1,7 @@
 package model
 
 import (
+	"context"
 	"github.com/google/go-cmp/cmp"
 	"testing"
 )
@@ -34,7 +35,7 @@ source:
 		},
 	}
 
-	appCfgTyped, appCfgUntyped, err := commonCfg.MakeAppConfig([]byte(yamlConf), false)
+	appCfgTyped, appCfgUntyped, err := commonCfg.MakeAppConfig(context.Background(), []byte(yamlConf), false)
 	if err != nil {
 		t.Fatalf("MakeAppConfig failed: %v", err)
 	}
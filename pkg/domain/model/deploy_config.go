@@ -4,11 +4,22 @@ import (
 	"time"
 )
 
+// AppLocker Blocks until the app may be deployed, and returns a func releasing it again
+type AppLocker func(app string, org string) (unlock func())
+
 type DeployConfig struct {
 	Force             bool
 	Retries           int
 	AttemptTimeout    time.Duration
 	AbortOnFirstError bool
+	ReconcileInfra    bool           // repair volumes, ips and scale also for apps that are otherwise up to date
+	Parallelism       int            // max apps deployed at once by DeployAll. 1 or less deploys them one by one
+	OrgLimits         map[string]int // max apps of an org deployed at once by DeployAll, "*" for orgs not listed
+	Trigger           string         // what caused the deploy, for the deploy history
+	Only              AppFilter      // limits DeployAll to some of the apps in the tree
+	Commit            string         // the pushed commit that caused the deploy, if any. For notifications
+	Author            string         // of that commit
+	LockApp           AppLocker      // held while DeployAll deploys each app, if set
 }
 
 func NewDefaultDeployConfig() DeployConfig {
@@ -17,6 +28,8 @@ func NewDefaultDeployConfig() DeployConfig {
 		Retries:           2,
 		AttemptTimeout:    5 * time.Minute,
 		AbortOnFirstError: true,
+		ReconcileInfra:    false,
+		Parallelism:       1,
 	}
 }
 
@@ -55,3 +68,44 @@ func (c DeployConfig) WithAttemptTimeout(timeout ...time.Duration) DeployConfig
 	}
 	return c
 }
+
+func (c DeployConfig) WithReconcileInfra(state ...bool) DeployConfig {
+	if len(state) > 0 {
+		c.ReconcileInfra = state[0]
+	} else {
+		c.ReconcileInfra = true
+	}
+	return c
+}
+
+func (c DeployConfig) WithParallelism(parallelism int) DeployConfig {
+	c.Parallelism = parallelism
+	return c
+}
+
+func (c DeployConfig) WithOrgLimits(orgLimits map[string]int) DeployConfig {
+	c.OrgLimits = orgLimits
+	return c
+}
+
+func (c DeployConfig) WithTrigger(trigger string) DeployConfig {
+	c.Trigger = trigger
+	return c
+}
+
+func (c DeployConfig) WithOnly(filter AppFilter) DeployConfig {
+	c.Only = filter
+	return c
+}
+
+func (c DeployConfig) WithLockApp(lockApp AppLocker) DeployConfig {
+	c.LockApp = lockApp
+	return c
+}
+
+// WithPush Tells notifications which commit, and whose, caused the deploy
+func (c DeployConfig) WithPush(event PushEvent) DeployConfig {
+	c.Commit = event.Commit
+	c.Author = event.Author
+	return c
+}
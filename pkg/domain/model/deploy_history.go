@@ -0,0 +1,42 @@
+package model
+
+import (
+	"time"
+)
+
+// What triggered a deploy, besides pushes which are described by PushEvent.Trigger
+const (
+	TriggerCli                = "cli"
+	TriggerStartupSync        = "startup sync"
+	TriggerScheduledSync      = "scheduled sync"
+	TriggerScheduledReconcile = "scheduled reconcile"
+	TriggerApi                = "api"
+)
+
+// DeployRecord One attempt to deploy an app, as kept in the deploy history
+type DeployRecord struct {
+	Time            time.Time                  `json:"time"`
+	App             string                     `json:"app"`
+	Org             string                     `json:"org,omitempty"`
+	Path            string                     `json:"path"`
+	Trigger         string                     `json:"trigger,omitempty"`
+	AppHash         string                     `json:"app_hash,omitempty"`
+	ConfigHash      string                     `json:"config_hash,omitempty"`
+	Commit          string                     `json:"commit,omitempty"` // only for apps from git
+	Result          SingleAppDeploySuccessType `json:"result,omitempty"` // empty if the attempt failed
+	DurationSeconds float64                    `json:"duration_seconds"`
+	Error           string                     `json:"error,omitempty"`
+}
+
+func (r DeployRecord) Success() bool {
+	return r.Error == ""
+}
+
+// DeployHistory An append-only store of deploy attempts
+type DeployHistory interface {
+	Record(record DeployRecord) error
+
+	// List Lists the attempts to deploy the app (or all apps if app is empty), newest first.
+	// At most limit records are returned, or all of them if limit is 0.
+	List(app string, limit int) ([]DeployRecord, error)
+}
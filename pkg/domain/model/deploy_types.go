@@ -6,6 +6,9 @@ const (
 	SingleAppDeployCreated  SingleAppDeploySuccessType = "created"
 	SingleAppDeployUpdated  SingleAppDeploySuccessType = "updated"
 	SingleAppDeployNoChange SingleAppDeploySuccessType = "no-change"
+
+	// SingleAppDeployReconciled The app itself was up to date, but its volumes, ips or scale had drifted and were repaired
+	SingleAppDeployReconciled SingleAppDeploySuccessType = "reconciled"
 )
 
 type AppDeployFailure struct {
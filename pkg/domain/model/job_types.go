@@ -0,0 +1,146 @@
+package model
+
+import (
+	"fmt"
+	"github.com/samber/lo"
+	"time"
+)
+
+type JobType string
+
+const (
+	// JobTypePush Deploys the apps and projects affected by a push (webhook or git poll)
+	JobTypePush JobType = "push"
+
+	// JobTypeSync Deploys everything in the tree, like 'flycd deploy'
+	JobTypeSync JobType = "sync"
+
+	// JobTypeReconcile Repairs drifted volumes, ips and scale of everything in the tree
+	JobTypeReconcile JobType = "reconcile"
+)
+
+// Job A serializable description of work for the job queue. Unlike closures, these
+// survive restarts, so pushes arriving mid-deploy are not lost.
+type Job struct {
+	Id         string     `json:"id"`
+	Type       JobType    `json:"type"`
+	Path       string     `json:"path"`
+	PushEvent  *PushEvent `json:"push_event,omitempty"` // only for JobTypePush
+	Attempts   int        `json:"attempts"`
+	LastError  string     `json:"last_error,omitempty"`
+	NotBefore  time.Time  `json:"not_before"` // when a failed job may be retried. Zero for jobs that haven't failed
+	EnqueuedAt time.Time  `json:"enqueued_at"`
+	Absorbed   int        `json:"absorbed,omitempty"` // number of newer triggers merged into this job while it was pending
+	Trigger    string     `json:"trigger,omitempty"`  // what caused the job, for the deploy history
+	Only       AppFilter  `json:"only,omitempty"`     // only for JobTypeSync, limits it to one app or project
+	// CorrelationId Tags all log lines of the job, and of the webhook request that queued it
+	CorrelationId string `json:"correlation_id,omitempty"`
+}
+
+func NewPushJob(event PushEvent, path string) Job {
+	return Job{Type: JobTypePush, Path: path, PushEvent: &event, Trigger: event.Trigger()}
+}
+
+func NewSyncJob(path string) Job {
+	return Job{Type: JobTypeSync, Path: path, Trigger: TriggerScheduledSync}
+}
+
+func NewReconcileJob(path string) Job {
+	return Job{Type: JobTypeReconcile, Path: path, Trigger: TriggerScheduledReconcile}
+}
+
+func (j Job) WithOnly(filter AppFilter) Job {
+	j.Only = filter
+	return j
+}
+
+func (j Job) WithTrigger(trigger string) Job {
+	j.Trigger = trigger
+	return j
+}
+
+func (j Job) WithCorrelationId(correlationId string) Job {
+	j.CorrelationId = correlationId
+	return j
+}
+
+// SameTarget tells if two jobs would do the same work, apart from which commit they deploy.
+// Pushes are the same if they are to the same ref of the same repo.
+func (j Job) SameTarget(other Job) bool {
+	if j.Type != other.Type || j.Path != other.Path {
+		return false
+	}
+	if j.Type != JobTypePush {
+		return j.Only == other.Only
+	}
+	if j.PushEvent == nil || other.PushEvent == nil {
+		return false
+	}
+	return j.PushEvent.Ref == other.PushEvent.Ref && j.PushEvent.SameRepo(*other.PushEvent)
+}
+
+// Due tells if the job may run now, i.e. it hasn't failed or its retry backoff has passed
+func (j Job) Due(now time.Time) bool {
+	return !now.Before(j.NotBefore)
+}
+
+// Absorb Merges a newer trigger of the same target into this pending job, so that a burst of
+// pushes deploys once (from the latest commit) instead of once per push. Since the newer trigger
+// may fix whatever made this job fail, its attempts start over.
+func (j Job) Absorb(newer Job) Job {
+	j.Attempts = 0
+	j.LastError = ""
+	j.NotBefore = time.Time{}
+	j.Absorbed += 1 + newer.Absorbed
+	j.Trigger = newer.Trigger
+	if j.PushEvent != nil && newer.PushEvent != nil {
+		merged := *newer.PushEvent
+		merged.RepoUrls = lo.Uniq(append(append([]string{}, j.PushEvent.RepoUrls...), newer.PushEvent.RepoUrls...))
+		if j.PushEvent.ChangedFiles == nil || newer.PushEvent.ChangedFiles == nil {
+			merged.ChangedFiles = nil // if we don't know what one of them changed, we don't know what they changed together
+		} else {
+			merged.ChangedFiles = CollectChangedFiles(j.PushEvent.ChangedFiles, newer.PushEvent.ChangedFiles)
+		}
+		j.PushEvent = &merged
+	}
+	return j
+}
+
+func (j Job) Description() string {
+	switch j.Type {
+	case JobTypePush:
+		if j.PushEvent != nil {
+			return j.PushEvent.Description()
+		}
+	case JobTypeSync:
+		if !j.Only.IsEmpty() {
+			return fmt.Sprintf("sync of %s in %s", j.Only.Description(), j.Path)
+		}
+		return fmt.Sprintf("sync of %s", j.Path)
+	case JobTypeReconcile:
+		return fmt.Sprintf("reconcile of %s", j.Path)
+	}
+	return fmt.Sprintf("%s job %s", j.Type, j.Id)
+}
+
+// JobQueue Where the webhook service keeps its jobs until they are done.
+// Jobs are processed in the order they were pushed.
+type JobQueue interface {
+	// Push Adds a job to the end of the queue, assigning it an id
+	Push(job Job) (Job, error)
+
+	// Pending Lists all jobs that are not done yet, oldest first
+	Pending() ([]Job, error)
+
+	// Update Stores a job's new attempt count and last error
+	Update(job Job) error
+
+	// Remove Removes a job that is done
+	Remove(id string) error
+
+	// DeadLetter Moves a job that failed too many times out of the queue, keeping it for inspection
+	DeadLetter(job Job) error
+
+	// DeadLetters Lists all dead jobs, oldest first
+	DeadLetters() ([]Job, error)
+}
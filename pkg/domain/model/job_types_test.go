@@ -0,0 +1,78 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestJob_SameTarget(t *testing.T) {
+	push := func(ref string, urls ...string) Job {
+		return NewPushJob(PushEvent{Provider: "github", RepoUrls: urls, Ref: ref}, "projects")
+	}
+
+	base := push("refs/heads/main", "https://github.com/org/repo", "git@github.com:org/repo.git", "")
+	for _, test := range []struct {
+		name     string
+		other    Job
+		expected bool
+	}{
+		{name: "same repo other url form", other: push("refs/heads/main", "https://github.com/Org/Repo.git"), expected: true},
+		{name: "other ref", other: push("refs/heads/dev", "https://github.com/org/repo"), expected: false},
+		{name: "other repo", other: push("refs/heads/main", "https://github.com/org/other", ""), expected: false},
+		{name: "other type", other: NewSyncJob("projects"), expected: false},
+	} {
+		if actual := base.SameTarget(test.other); actual != test.expected {
+			t.Fatalf("%s: expected %v, got %v", test.name, test.expected, actual)
+		}
+	}
+
+	if !NewSyncJob("projects").SameTarget(NewSyncJob("projects")) || NewSyncJob("projects").SameTarget(NewSyncJob("other")) {
+		t.Fatalf("expected syncs to be the same target only for the same path")
+	}
+	if NewSyncJob("projects").SameTarget(NewSyncJob("projects").WithOnly(AppFilter{App: "app1"})) {
+		t.Fatalf("expected a sync of one app not to be the same target as a sync of all apps")
+	}
+}
+
+func TestAppFilter_Matches(t *testing.T) {
+	app := AppConfig{App: "auth"}
+	parents := []ProjectConfig{{Project: "root"}, {Project: "backend"}}
+	for _, test := range []struct {
+		filter   AppFilter
+		expected bool
+	}{
+		{filter: AppFilter{}, expected: true},
+		{filter: AppFilter{App: "auth"}, expected: true},
+		{filter: AppFilter{App: "users"}, expected: false},
+		{filter: AppFilter{Project: "backend"}, expected: true},
+		{filter: AppFilter{Project: "frontend"}, expected: false},
+		{filter: AppFilter{App: "auth", Project: "frontend"}, expected: false},
+	} {
+		if actual := test.filter.Matches(app, parents); actual != test.expected {
+			t.Fatalf("%s: expected %v, got %v", test.filter.Description(), test.expected, actual)
+		}
+	}
+}
+
+func TestJob_Absorb(t *testing.T) {
+	older := NewPushJob(PushEvent{Provider: "github", RepoUrls: []string{"a"}, Ref: "refs/heads/main", Commit: "111", ChangedFiles: []string{"api/main.go"}}, "projects")
+	older.Attempts = 2
+	older.LastError = "build failed"
+	newer := NewPushJob(PushEvent{Provider: "github", RepoUrls: []string{"a"}, Ref: "refs/heads/main", Commit: "222", ChangedFiles: []string{"web/index.ts"}}, "projects")
+
+	merged := older.Absorb(newer)
+	if merged.Attempts != 0 || merged.LastError != "" || merged.Absorbed != 1 {
+		t.Fatalf("expected attempts to start over, got %+v", merged)
+	}
+	if merged.PushEvent.Commit != "222" {
+		t.Fatalf("expected the latest commit, got %s", merged.PushEvent.Commit)
+	}
+	if !reflect.DeepEqual(merged.PushEvent.ChangedFiles, []string{"api/main.go", "web/index.ts"}) {
+		t.Fatalf("expected the changed files of both pushes, got %v", merged.PushEvent.ChangedFiles)
+	}
+
+	newer.PushEvent.ChangedFiles = nil
+	if merged = merged.Absorb(newer); merged.PushEvent.ChangedFiles != nil || merged.Absorbed != 2 {
+		t.Fatalf("expected unknown changed files to stay unknown, got %+v", merged.PushEvent)
+	}
+}
@@ -0,0 +1,116 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"github.com/samber/lo"
+	"os"
+)
+
+type NotifierType string
+
+const (
+	NotifierSlack   NotifierType = "slack"   // slack incoming webhook
+	NotifierDiscord NotifierType = "discord" // discord channel webhook
+	NotifierWebhook NotifierType = "webhook" // the DeployNotification as json, for anything else
+)
+
+var AllNotifierTypes = []NotifierType{NotifierSlack, NotifierDiscord, NotifierWebhook}
+
+// Deploy events that notifiers can be told about
+const (
+	NotifyStarted   = "started"   // an app is about to be created, redeployed or have its infra repaired. Not sent for apps that are up to date
+	NotifySucceeded = "succeeded" // an app was created, redeployed or had its infra repaired
+	NotifyFailed    = "failed"
+)
+
+var AllNotifyEvents = []string{NotifyStarted, NotifySucceeded, NotifyFailed}
+
+// NotificationsConfig is configuration defined in project.yaml files telling who to notify about deploys
+// of the apps in the project. Like CommonAppConfig it applies to sub-projects too, unless they opt out.
+type NotificationsConfig struct {
+	Inherit   *bool            `yaml:"inherit,omitempty" toml:"inherit,omitempty"` // default true. false ignores the notifiers of parent projects
+	Notifiers []NotifierConfig `yaml:"notifiers,omitempty" toml:"notifiers,omitempty"`
+}
+
+// Plus merges two NotificationsConfig's, used when traversing the project tree with projects-in-projects.
+func (c NotificationsConfig) Plus(other NotificationsConfig) NotificationsConfig {
+	if other.Inherit != nil && !*other.Inherit {
+		return NotificationsConfig{Notifiers: other.Notifiers}
+	}
+	return NotificationsConfig{Notifiers: append(append([]NotifierConfig{}, c.Notifiers...), other.Notifiers...)}
+}
+
+// For The notifiers that want to hear about the event
+func (c NotificationsConfig) For(event string) []NotifierConfig {
+	return lo.Filter(c.Notifiers, func(notifier NotifierConfig, _ int) bool { return notifier.Wants(event) })
+}
+
+func (c NotificationsConfig) Validate() error {
+	for i, notifier := range c.Notifiers {
+		err := notifier.Validate()
+		if err != nil {
+			return fmt.Errorf("notifier %d is invalid: %w", i, err)
+		}
+	}
+	return nil
+}
+
+type NotifierConfig struct {
+	Type   NotifierType `yaml:"type" toml:"type"`
+	Url    string       `yaml:"url,omitempty" toml:"url,omitempty"`
+	UrlEnv string       `yaml:"url_env,omitempty" toml:"url_env,omitempty"` // env var to read the url from, to keep it out of git
+	Events []string     `yaml:"events,omitempty" toml:"events,omitempty"`   // default all events
+}
+
+func (n NotifierConfig) Validate() error {
+	if !lo.Contains(AllNotifierTypes, n.Type) {
+		return fmt.Errorf("type '%s' is invalid, expected one of %v", n.Type, AllNotifierTypes)
+	}
+	if (n.Url == "") == (n.UrlEnv == "") {
+		return fmt.Errorf("exactly one of url and url_env is required")
+	}
+	for _, event := range n.Events {
+		if !lo.Contains(AllNotifyEvents, event) {
+			return fmt.Errorf("event '%s' is invalid, expected one of %v", event, AllNotifyEvents)
+		}
+	}
+	return nil
+}
+
+func (n NotifierConfig) Wants(event string) bool {
+	return len(n.Events) == 0 || lo.Contains(n.Events, event)
+}
+
+// ResolveUrl The url to post to. Env vars are read when notifying, so a missing one doesn't stop deploys.
+func (n NotifierConfig) ResolveUrl() (string, error) {
+	if n.UrlEnv == "" {
+		return n.Url, nil
+	}
+	url := os.Getenv(n.UrlEnv)
+	if url == "" {
+		return "", fmt.Errorf("env var %s with the url of the %s notifier is not set", n.UrlEnv, n.Type)
+	}
+	return url, nil
+}
+
+// DeployNotification What notifiers are told about a deploy. Posted as is by NotifierWebhook.
+type DeployNotification struct {
+	Event           string                     `json:"event"`
+	App             string                     `json:"app"`
+	Org             string                     `json:"org,omitempty"`
+	Path            string                     `json:"path"`
+	Trigger         string                     `json:"trigger,omitempty"`
+	Commit          string                     `json:"commit,omitempty"`
+	Author          string                     `json:"author,omitempty"`
+	Result          SingleAppDeploySuccessType `json:"result,omitempty"`           // only when succeeded
+	DurationSeconds float64                    `json:"duration_seconds,omitempty"` // not when started
+	Error           string                     `json:"error,omitempty"`            // only when failed
+}
+
+// DeployNotifier Sends deploy notifications. Failing to notify must never fail the deploy,
+// so implementations log their errors instead of returning them. Nor should slow notifiers
+// slow down the deploy, since deploys may hold locks while notifying.
+type DeployNotifier interface {
+	Notify(ctx context.Context, notifiers []NotifierConfig, notification DeployNotification)
+}
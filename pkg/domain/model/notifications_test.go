@@ -0,0 +1,51 @@
+package model
+
+import (
+	"github.com/google/go-cmp/cmp"
+	"testing"
+)
+
+func TestNotificationsConfig_Plus(t *testing.T) {
+	slack := NotifierConfig{Type: NotifierSlack, Url: "https://hooks.slack.com/services/x"}
+	discord := NotifierConfig{Type: NotifierDiscord, UrlEnv: "DISCORD_URL", Events: []string{NotifyFailed}}
+	dontInherit := false
+
+	parent := NotificationsConfig{}.Plus(NotificationsConfig{Notifiers: []NotifierConfig{slack}})
+	inherited := parent.Plus(NotificationsConfig{Notifiers: []NotifierConfig{discord}})
+	if diff := cmp.Diff([]NotifierConfig{slack, discord}, inherited.Notifiers); diff != "" {
+		t.Fatalf("expected sub-projects to add to the notifiers of their parents: %v", diff)
+	}
+	if len(parent.Notifiers) != 1 {
+		t.Fatalf("expected the parent to be unchanged, got %+v", parent)
+	}
+
+	own := parent.Plus(NotificationsConfig{Inherit: &dontInherit, Notifiers: []NotifierConfig{discord}})
+	if diff := cmp.Diff([]NotifierConfig{discord}, own.Notifiers); diff != "" {
+		t.Fatalf("expected inherit: false to drop the notifiers of parents: %v", diff)
+	}
+
+	if diff := cmp.Diff([]NotifierConfig{slack}, inherited.For(NotifyStarted)); diff != "" {
+		t.Fatalf("expected only notifiers of started events: %v", diff)
+	}
+	if len(inherited.For(NotifyFailed)) != 2 {
+		t.Fatalf("expected both notifiers to want failures")
+	}
+}
+
+func TestNotifierConfig_Validate(t *testing.T) {
+	for _, test := range []struct {
+		notifier NotifierConfig
+		valid    bool
+	}{
+		{notifier: NotifierConfig{Type: NotifierWebhook, Url: "https://example.com"}, valid: true},
+		{notifier: NotifierConfig{Type: NotifierSlack, UrlEnv: "SLACK_URL", Events: []string{NotifyFailed, NotifySucceeded}}, valid: true},
+		{notifier: NotifierConfig{Type: "email", Url: "https://example.com"}},
+		{notifier: NotifierConfig{Type: NotifierDiscord}},
+		{notifier: NotifierConfig{Type: NotifierDiscord, Url: "https://example.com", UrlEnv: "DISCORD_URL"}},
+		{notifier: NotifierConfig{Type: NotifierDiscord, Url: "https://example.com", Events: []string{"deleted"}}},
+	} {
+		if err := test.notifier.Validate(); (err == nil) != test.valid {
+			t.Fatalf("expected %+v to be valid=%v, got %v", test.notifier, test.valid, err)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package model
+
+type PlannedActionType string
+
+const (
+	PlannedActionCreateApp    PlannedActionType = "create-app"
+	PlannedActionDeploy       PlannedActionType = "deploy"
+	PlannedActionCreateVolume PlannedActionType = "create-volume"
+	PlannedActionExtendVolume PlannedActionType = "extend-volume"
+	PlannedActionSetSecrets   PlannedActionType = "set-secrets"
+	PlannedActionAllocateIp   PlannedActionType = "allocate-ip"
+	PlannedActionReleaseIp    PlannedActionType = "release-ip"
+	PlannedActionScaleCount   PlannedActionType = "scale-count"
+	PlannedActionScaleRam     PlannedActionType = "scale-ram"
+	PlannedActionScaleVm      PlannedActionType = "scale-vm"
+)
+
+// PlannedAction is a single change that a deploy would make to fly.io
+type PlannedAction struct {
+	Type        PlannedActionType `json:"type"`
+	Description string            `json:"description"`
+	Params      map[string]any    `json:"params,omitempty"`
+}
+
+type AppPlan struct {
+	App     string                     `json:"app"`
+	Path    string                     `json:"path"`
+	Change  SingleAppDeploySuccessType `json:"change,omitempty"`
+	Actions []PlannedAction            `json:"actions"`
+	Error   string                     `json:"error,omitempty"`
+}
+
+func (p AppPlan) HasChanges() bool {
+	return len(p.Actions) > 0
+}
+
+type ProjectPlan struct {
+	Project string `json:"project"`
+	Path    string `json:"path"`
+	Error   string `json:"error,omitempty"`
+}
+
+// DeployPlan is everything a deploy would do, computed without changing anything
+type DeployPlan struct {
+	Apps     []AppPlan     `json:"apps"`
+	Projects []ProjectPlan `json:"projects"`
+}
+
+func (p DeployPlan) HasErrors() bool {
+	for _, app := range p.Apps {
+		if app.Error != "" {
+			return true
+		}
+	}
+	for _, project := range p.Projects {
+		if project.Error != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func NewEmptyDeployPlan() DeployPlan {
+	return DeployPlan{
+		Apps:     make([]AppPlan, 0),
+		Projects: make([]ProjectPlan, 0),
+	}
+}
@@ -6,9 +6,10 @@ import (
 )
 
 type ProjectConfig struct {
-	Project string          `yaml:"project" toml:"project"` // Name Required. Unique name of the project
-	Source  Source          `yaml:"source" toml:"source"`   // Source Required. Where the app configs of the project are located
-	Common  CommonAppConfig `yaml:"common" toml:"common"`   // Common Optional. Common config for all apps in the project
+	Project       string              `yaml:"project" toml:"project"`             // Name Required. Unique name of the project
+	Source        Source              `yaml:"source" toml:"source"`               // Source Required. Where the app configs of the project are located
+	Common        CommonAppConfig     `yaml:"common" toml:"common"`               // Common Optional. Common config for all apps in the project
+	Notifications NotificationsConfig `yaml:"notifications" toml:"notifications"` // Notifications Optional. Who to tell about deploys of the apps in the project
 }
 
 func (cfg *ProjectConfig) Validate() error {
@@ -29,6 +30,11 @@ func (cfg *ProjectConfig) Validate() error {
 		return fmt.Errorf("project source is invalid: %w", err)
 	}
 
+	err = cfg.Notifications.Validate()
+	if err != nil {
+		return fmt.Errorf("project notifications are invalid: %w", err)
+	}
+
 	switch cfg.Source.Type {
 	case SourceTypeLocal:
 		//ok
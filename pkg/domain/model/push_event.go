@@ -0,0 +1,132 @@
+package model
+
+import (
+	"fmt"
+	"github.com/gigurra/flycd/pkg/util/util_glob"
+	"github.com/samber/lo"
+	"strings"
+)
+
+// PushEvent is what all webhook providers (and the git poller) map their push notifications to
+type PushEvent struct {
+	Provider string   `json:"provider"`
+	Id       string   `json:"id,omitempty"`   // delivery/hook id, if the provider has one
+	Repo     string   `json:"repo,omitempty"` // human-readable repo name, for logging
+	RepoUrls []string `json:"repo_urls"`      // all urls the repo is known by, to match against our sources
+	Ref      string   `json:"ref,omitempty"`  // e.g. refs/heads/main or refs/tags/v1.0.0
+	Commit   string   `json:"commit,omitempty"`
+	Author   string   `json:"author,omitempty"` // of the head commit, if the provider tells us. For notifications
+
+	// DefaultBranch of the repo, if the provider tells us. Sources without any ref track it, so without it
+	// any pushed branch may be the one they track.
+	DefaultBranch string `json:"default_branch,omitempty"`
+
+	// ChangedFiles added, modified and removed by the pushed commits. Nil if the provider doesn't tell us
+	// (or only told us about some of the commits), in which case all files are assumed to have changed.
+	ChangedFiles []string `json:"changed_files,omitempty"`
+}
+
+// HeadRef is used as Ref when we know the default branch changed, but not its name (e.g. when git polling)
+const HeadRef = "HEAD"
+
+func (e PushEvent) Branch() string {
+	if branch, found := strings.CutPrefix(e.Ref, "refs/heads/"); found {
+		return branch
+	}
+	return ""
+}
+
+func (e PushEvent) Tag() string {
+	if tag, found := strings.CutPrefix(e.Ref, "refs/tags/"); found {
+		return tag
+	}
+	return ""
+}
+
+// IsDefaultBranch tells if the push was to the repo's default branch, as far as we know.
+// When the provider doesn't tell us which branch is the default, no branch is known to be it.
+func (e PushEvent) IsDefaultBranch() bool {
+	if e.Ref == HeadRef {
+		return true
+	}
+	branch := e.Branch()
+	return branch != "" && branch == e.DefaultBranch
+}
+
+// MatchesRef tells if a source tracking the given ref is affected by this push.
+// Sources pinned to a commit never are, and sources pinned to a tag only when that tag is pushed.
+// Sources without a ref are affected by pushes to the default branch, or to any branch if we don't know which it is.
+// Deploying them on a push to another branch is harmless, since they are deployed from the default branch anyway.
+func (e PushEvent) MatchesRef(ref GitRef) bool {
+	switch {
+	case e.Ref == "":
+		return true // we don't know what was pushed, so we can't rule anything out
+	case ref.Commit != "":
+		return false
+	case ref.Tag != "":
+		return e.Tag() == ref.Tag
+	case ref.Branch != "":
+		return e.Branch() == ref.Branch
+	case e.DefaultBranch == "" && e.Branch() != "":
+		return true // we don't know which branch is the default, so it may be this one
+	default:
+		return e.IsDefaultBranch()
+	}
+}
+
+// TouchesAny tells if the push changed any file matching the globs.
+// A glob matching a directory matches everything in it.
+func (e PushEvent) TouchesAny(globs []string) bool {
+	if e.ChangedFiles == nil {
+		return true
+	}
+	for _, file := range e.ChangedFiles {
+		for _, glob := range globs {
+			if util_glob.MatchSelfOrParent(glob, file) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CollectChangedFiles Merges the file lists of a push's commits. Pushes without any commits
+// (e.g. a new branch) give nil, since then we don't know what changed.
+func CollectChangedFiles(fileLists ...[]string) []string {
+	if len(fileLists) == 0 {
+		return nil
+	}
+	return lo.Uniq(lo.Flatten(fileLists))
+}
+
+// SameRepo tells if two pushes are to the same repo, i.e. if they share any repo url
+func (e PushEvent) SameRepo(other PushEvent) bool {
+	normalize := func(url string, _ int) string {
+		return strings.TrimSuffix(strings.TrimSuffix(strings.ToLower(url), "/"), ".git")
+	}
+	return len(lo.Intersect(lo.Compact(lo.Map(e.RepoUrls, normalize)), lo.Compact(lo.Map(other.RepoUrls, normalize)))) > 0
+}
+
+// Trigger What triggered the push, as recorded in the deploy history
+func (e PushEvent) Trigger() string {
+	if e.Id != "" {
+		return fmt.Sprintf("%s webhook %s", e.Provider, e.Id)
+	}
+	return fmt.Sprintf("%s push", e.Provider)
+}
+
+func (e PushEvent) Description() string {
+	result := fmt.Sprintf("%s push", e.Provider)
+	if e.Id != "" {
+		result += " " + e.Id
+	}
+	repo := e.Repo
+	if repo == "" && len(e.RepoUrls) > 0 {
+		repo = e.RepoUrls[0]
+	}
+	result += " for " + repo
+	if e.Ref != "" {
+		result += " @ " + e.Ref
+	}
+	return result
+}
@@ -0,0 +1,68 @@
+package model
+
+type AppSyncStatus string
+
+const (
+	AppStatusInSync    AppSyncStatus = "in-sync"
+	AppStatusOutOfDate AppSyncStatus = "out-of-date"
+	AppStatusMissing   AppSyncStatus = "missing"
+	AppStatusFailing   AppSyncStatus = "failing"
+)
+
+// AppStatus is how a single app in the config tree compares to what is deployed on fly.io
+type AppStatus struct {
+	App                   string          `json:"app"`
+	Path                  string          `json:"path"`
+	Status                AppSyncStatus   `json:"status"`
+	WantedAppVersion      string          `json:"wanted_app_version,omitempty"`
+	DeployedAppVersion    string          `json:"deployed_app_version,omitempty"`
+	WantedConfigVersion   string          `json:"wanted_config_version,omitempty"`
+	DeployedConfigVersion string          `json:"deployed_config_version,omitempty"`
+	Drift                 []PlannedAction `json:"drift"` // scale, volume and ip changes needed to match the config
+	Error                 string          `json:"error,omitempty"`
+}
+
+func (s AppStatus) VersionsMatch() bool {
+	return s.WantedAppVersion == s.DeployedAppVersion && s.WantedConfigVersion == s.DeployedConfigVersion
+}
+
+type ProjectStatus struct {
+	Project string `json:"project"`
+	Path    string `json:"path"`
+	Error   string `json:"error,omitempty"`
+}
+
+// StatusReport is the drift between the config tree and the live fly.io org
+type StatusReport struct {
+	Apps     []AppStatus     `json:"apps"`
+	Projects []ProjectStatus `json:"projects"`
+}
+
+func (r StatusReport) CountByStatus() map[AppSyncStatus]int {
+	result := map[AppSyncStatus]int{}
+	for _, app := range r.Apps {
+		result[app.Status]++
+	}
+	return result
+}
+
+func (r StatusReport) InSync() bool {
+	for _, app := range r.Apps {
+		if app.Status != AppStatusInSync {
+			return false
+		}
+	}
+	for _, project := range r.Projects {
+		if project.Error != "" {
+			return false
+		}
+	}
+	return true
+}
+
+func NewEmptyStatusReport() StatusReport {
+	return StatusReport{
+		Apps:     make([]AppStatus, 0),
+		Projects: make([]ProjectStatus, 0),
+	}
+}
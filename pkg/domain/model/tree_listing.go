@@ -0,0 +1,34 @@
+package model
+
+import (
+	"github.com/samber/lo"
+)
+
+// TreeListing The apps and projects found in a config tree, without looking at fly.io
+type TreeListing struct {
+	Apps     []ListedApp     `json:"apps"`
+	Projects []ListedProject `json:"projects"`
+}
+
+type ListedApp struct {
+	App      string   `json:"app"`
+	Org      string   `json:"org,omitempty"`
+	Path     string   `json:"path"`
+	Projects []string `json:"projects"` // the projects the app was found in, outermost first
+	Error    string   `json:"error,omitempty"`
+}
+
+type ListedProject struct {
+	Project  string   `json:"project"`
+	Path     string   `json:"path"`
+	Projects []string `json:"projects"` // the projects this project was found in, outermost first
+	Error    string   `json:"error,omitempty"`
+}
+
+func (l TreeListing) HasApp(app string) bool {
+	return lo.SomeBy(l.Apps, func(a ListedApp) bool { return a.App == app })
+}
+
+func (l TreeListing) HasProject(project string) bool {
+	return lo.SomeBy(l.Projects, func(p ListedProject) bool { return p.Project == project })
+}
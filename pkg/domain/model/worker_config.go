@@ -0,0 +1,69 @@
+package model
+
+import (
+	"time"
+)
+
+type WorkerConfig struct {
+	Queue       JobQueue       // nil for an in-memory queue
+	MaxAttempts int            // after which a failing job is moved to the dead letters
+	Workers     int            // how many jobs may run in parallel
+	OrgLimits   map[string]int // max parallel app deploys per org, "*" for orgs not listed. 0 or missing means unlimited
+	OnJobDone   func(job Job)  // called after every attempt to run a job, successful or not. May be nil
+
+	// RetryBackoff How long to wait before retrying a failed job. It doubles with every failed attempt, up to MaxRetryBackoff
+	RetryBackoff    time.Duration
+	MaxRetryBackoff time.Duration
+}
+
+func NewDefaultWorkerConfig() WorkerConfig {
+	return WorkerConfig{
+		Queue:       nil,
+		MaxAttempts: 3,
+		Workers:     1,
+		OrgLimits:   map[string]int{},
+
+		RetryBackoff:    10 * time.Second,
+		MaxRetryBackoff: 5 * time.Minute,
+	}
+}
+
+func (c WorkerConfig) WithRetryBackoff(backoff time.Duration, maxBackoff time.Duration) WorkerConfig {
+	c.RetryBackoff = backoff
+	c.MaxRetryBackoff = maxBackoff
+	return c
+}
+
+// RetryDelay How long to wait before retrying a job that has failed attempts times
+func (c WorkerConfig) RetryDelay(attempts int) time.Duration {
+	delay := c.RetryBackoff
+	for i := 1; i < attempts && delay < c.MaxRetryBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, c.MaxRetryBackoff)
+}
+
+func (c WorkerConfig) WithQueue(queue JobQueue) WorkerConfig {
+	c.Queue = queue
+	return c
+}
+
+func (c WorkerConfig) WithMaxAttempts(maxAttempts int) WorkerConfig {
+	c.MaxAttempts = maxAttempts
+	return c
+}
+
+func (c WorkerConfig) WithWorkers(workers int) WorkerConfig {
+	c.Workers = workers
+	return c
+}
+
+func (c WorkerConfig) WithOnJobDone(onJobDone func(job Job)) WorkerConfig {
+	c.OnJobDone = onJobDone
+	return c
+}
+
+func (c WorkerConfig) WithOrgLimits(orgLimits map[string]int) WorkerConfig {
+	c.OrgLimits = orgLimits
+	return c
+}
@@ -0,0 +1,22 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWorkerConfig_RetryDelay(t *testing.T) {
+	cfg := NewDefaultWorkerConfig().WithRetryBackoff(10*time.Second, time.Minute)
+
+	for attempts, expected := range map[int]time.Duration{
+		1:  10 * time.Second,
+		2:  20 * time.Second,
+		3:  40 * time.Second,
+		4:  time.Minute,
+		50: time.Minute,
+	} {
+		if delay := cfg.RetryDelay(attempts); delay != expected {
+			t.Fatalf("expected a delay of %v after %d attempts, got %v", expected, attempts, delay)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"github.com/gigurra/flycd/pkg/domain/model"
+	"github.com/gigurra/flycd/pkg/ext/fly_client"
+	"github.com/gigurra/flycd/pkg/util/util_work_dir"
+	"github.com/samber/lo"
+	"sync"
+)
+
+// planningFlyClient passes all reads through to the real fly client, but only records
+// the writes. Running the regular deploy code against it gives us the exact list of
+// actions a deploy would take, without changing anything.
+type planningFlyClient struct {
+	underlying fly_client.FlyClient
+	mutex      sync.Mutex
+	actions    []model.PlannedAction
+	newApps    map[string]bool
+}
+
+var _ fly_client.FlyClient = &planningFlyClient{}
+
+func newPlanningFlyClient(underlying fly_client.FlyClient) *planningFlyClient {
+	return &planningFlyClient{
+		underlying: underlying,
+		actions:    make([]model.PlannedAction, 0),
+		newApps:    map[string]bool{},
+	}
+}
+
+func (p *planningFlyClient) Actions() []model.PlannedAction {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return append([]model.PlannedAction{}, p.actions...)
+}
+
+func (p *planningFlyClient) record(actionType model.PlannedActionType, params map[string]any, format string, args ...any) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.actions = append(p.actions, model.PlannedAction{
+		Type:        actionType,
+		Description: fmt.Sprintf(format, args...),
+		Params:      params,
+	})
+}
+
+// isNewApp tells if the app would be created by the plan, in which case there is nothing to read from fly.io
+func (p *planningFlyClient) isNewApp(app string) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.newApps[app]
+}
+
+func (p *planningFlyClient) CreateOrgToken(_ context.Context, orgSlug string) (string, error) {
+	return "", fmt.Errorf("creating org tokens for org %s is not supported when planning", orgSlug)
+}
+
+func (p *planningFlyClient) ExistsSecret(ctx context.Context, cmd fly_client.ExistsSecretCmd) (bool, error) {
+	if p.isNewApp(cmd.AppName) {
+		return false, nil
+	}
+	return p.underlying.ExistsSecret(ctx, cmd)
+}
+
+func (p *planningFlyClient) StoreSecret(_ context.Context, cmd fly_client.StoreSecretCmd) error {
+	p.record(model.PlannedActionSetSecrets, map[string]any{"app": cmd.AppName, "secrets": []string{cmd.SecretName}},
+		"set secret %s", cmd.SecretName)
+	return nil
+}
+
+func (p *planningFlyClient) ExistsApp(ctx context.Context, name string) (bool, error) {
+	if p.isNewApp(name) {
+		return true, nil
+	}
+	return p.underlying.ExistsApp(ctx, name)
+}
+
+func (p *planningFlyClient) GetDeployedAppConfig(ctx context.Context, name string) (model.AppConfig, error) {
+	if p.isNewApp(name) {
+		return model.AppConfig{Env: map[string]string{}}, nil
+	}
+	return p.underlying.GetDeployedAppConfig(ctx, name)
+}
+
+func (p *planningFlyClient) GetAppVolumes(ctx context.Context, name string) ([]model.VolumeState, error) {
+	if p.isNewApp(name) {
+		return []model.VolumeState{}, nil
+	}
+	return p.underlying.GetAppVolumes(ctx, name)
+}
+
+func (p *planningFlyClient) CreateNewApp(_ context.Context, cfg model.AppConfig, _ util_work_dir.WorkDir, _ bool) error {
+	p.mutex.Lock()
+	p.newApps[cfg.App] = true
+	p.mutex.Unlock()
+	p.record(model.PlannedActionCreateApp, map[string]any{"app": cfg.App, "org": cfg.Org, "region": cfg.PrimaryRegion},
+		"create app %s in org %s", cfg.App, cfg.Org)
+	return nil
+}
+
+func (p *planningFlyClient) DeployExistingApp(_ context.Context, cfg model.AppConfig, _ util_work_dir.WorkDir, _ model.DeployConfig, region string) error {
+	p.record(model.PlannedActionDeploy, map[string]any{
+		"app":            cfg.App,
+		"region":         region,
+		"app_version":    cfg.Env["FLYCD_APP_VERSION"],
+		"config_version": cfg.Env["FLYCD_CONFIG_VERSION"],
+	}, "deploy app %s (app version %s)", cfg.App, ShortHash(cfg.Env["FLYCD_APP_VERSION"]))
+	return nil
+}
+
+func (p *planningFlyClient) CreateVolume(_ context.Context, app string, cfg model.VolumeConfig, region string) (model.VolumeState, error) {
+	p.record(model.PlannedActionCreateVolume, map[string]any{"app": app, "volume": cfg.Name, "size_gb": cfg.SizeGb, "region": region},
+		"create volume %s (%d GB) in %s", cfg.Name, cfg.SizeGb, region)
+	return model.VolumeState{Name: cfg.Name, SizeGb: cfg.SizeGb, Region: region}, nil
+}
+
+func (p *planningFlyClient) GetAppScale(ctx context.Context, app string) ([]model.ScaleState, error) {
+	if p.isNewApp(app) {
+		return []model.ScaleState{}, nil
+	}
+	return p.underlying.GetAppScale(ctx, app)
+}
+
+func (p *planningFlyClient) ExtendVolume(_ context.Context, app string, volumeId string, gb int) error {
+	p.record(model.PlannedActionExtendVolume, map[string]any{"app": app, "volume_id": volumeId, "size_gb": gb},
+		"extend volume %s to %d GB", volumeId, gb)
+	return nil
+}
+
+func (p *planningFlyClient) ScaleApp(_ context.Context, app string, region string, count int) error {
+	p.record(model.PlannedActionScaleCount, map[string]any{"app": app, "region": region, "count": count},
+		"scale to %d machines in %s", count, region)
+	return nil
+}
+
+func (p *planningFlyClient) ScaleAppRam(_ context.Context, app string, ramMb int) error {
+	p.record(model.PlannedActionScaleRam, map[string]any{"app": app, "ram_mb": ramMb},
+		"scale ram to %d MB", ramMb)
+	return nil
+}
+
+func (p *planningFlyClient) ScaleAppVm(_ context.Context, app string, vm string) error {
+	p.record(model.PlannedActionScaleVm, map[string]any{"app": app, "vm": vm},
+		"scale vm to %s", vm)
+	return nil
+}
+
+// SaveSecrets Only the names are recorded. Secret values must never end up in a plan.
+func (p *planningFlyClient) SaveSecrets(_ context.Context, app string, secrets []fly_client.Secret, _ bool) error {
+	names := lo.Map(secrets, func(s fly_client.Secret, _ int) string { return s.Name })
+	p.record(model.PlannedActionSetSecrets, map[string]any{"app": app, "secrets": names},
+		"set secrets %v", names)
+	return nil
+}
+
+func (p *planningFlyClient) ListApps(ctx context.Context) ([]fly_client.AppListItem, error) {
+	return p.underlying.ListApps(ctx)
+}
+
+func (p *planningFlyClient) ListIps(ctx context.Context, app string) ([]fly_client.IpListItem, error) {
+	if p.isNewApp(app) {
+		return []fly_client.IpListItem{}, nil
+	}
+	return p.underlying.ListIps(ctx, app)
+}
+
+func (p *planningFlyClient) DeleteIp(_ context.Context, app string, id string, address string) error {
+	p.record(model.PlannedActionReleaseIp, map[string]any{"app": app, "id": id, "address": address},
+		"release ip %s", address)
+	return nil
+}
+
+func (p *planningFlyClient) CreateIp(_ context.Context, app string, ip model.IpConfig) error {
+	params := map[string]any{"app": app, "v": ip.V, "private": ip.Private, "shared": ip.Shared}
+	if ip.Region != "" {
+		params["region"] = ip.Region
+	}
+	if ip.Network != "" {
+		params["network"] = ip.Network
+	}
+	p.record(model.PlannedActionAllocateIp, params, "allocate %s ip %s", describeIp(ip), ip.V)
+	return nil
+}
+
+func describeIp(ip model.IpConfig) string {
+	switch {
+	case ip.Private:
+		return "private"
+	case ip.Shared:
+		return "shared"
+	default:
+		return "dedicated"
+	}
+}
@@ -0,0 +1,233 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gigurra/flycd/pkg/domain/model"
+	"github.com/gigurra/flycd/pkg/ext/fly_client"
+	"github.com/gigurra/flycd/pkg/util/util_log"
+	"github.com/gigurra/flycd/pkg/util/util_metrics"
+	"github.com/gigurra/flycd/pkg/util/util_trace"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+var SkippedNotDeployed = fmt.Errorf("skipped: app is not deployed yet, nothing to reconcile")
+
+// reconcileAll repairs volumes, ips and scale of all deployed apps in the tree, without
+// fetching or redeploying the apps themselves. Apps that don't exist yet are left to DeployAll.
+// Apps that were repaired, or failed to be, are recorded in the history and notified about.
+// Like DeployAll, up to deployCfg.Parallelism apps are reconciled at once, within the org limits.
+func reconcileAll(
+	flyClient fly_client.FlyClient,
+	history model.DeployHistory,
+	notifier model.DeployNotifier,
+	ctx context.Context,
+	path string,
+	deployCfg model.DeployConfig,
+) (model.DeployResult, error) {
+
+	result := model.NewEmptyDeployResult()
+	mutex := sync.Mutex{} // guards result, since apps may be reconciled in parallel
+
+	failApp := func(appNode model.AppAtFsNode, cause error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		result.FailedApps = append(result.FailedApps, model.AppDeployFailure{
+			Spec:  appNode,
+			Cause: cause,
+		})
+	}
+
+	appContexts := map[string]context.Context{} // by app path, with the project of each app for its log lines
+
+	reconcile := func(appNode model.AppAtFsNode) error {
+		appCtx := appContexts[appNode.Path]
+		slog.InfoContext(appCtx, "Reconciling app", "path", appNode.Path)
+		res, err := reconcileAppRecorded(flyClient, history, notifier, appCtx, appNode, deployCfg)
+		if err != nil {
+			failApp(appNode, err)
+			return nil // infra of the apps depending on it can still be repaired
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		result.SucceededApps = append(result.SucceededApps, model.AppDeploySuccess{
+			Spec:        appNode,
+			SuccessType: res,
+		})
+		return nil
+	}
+
+	// Only the app configs are needed, so the projects cloned from git needn't be kept around
+	collectedApps := make([]model.AppAtFsNode, 0)
+	otherApps := make([]string, 0) // not selected by deployCfg.Only
+
+	err := TraverseDeepAppTree(path, model.TraverseAppTreeContext{
+		Context: ctx,
+		ValidAppCb: func(ctx model.TraverseAppTreeContext, appNode model.AppAtFsNode) error {
+			if !deployCfg.Only.Matches(appNode.AppConfig, ctx.Parents) {
+				otherApps = append(otherApps, appNode.AppConfig.App)
+				return nil
+			}
+			appContexts[appNode.Path] = util_log.With(ctx, util_log.KeyApp, appNode.AppConfig.App)
+			collectedApps = append(collectedApps, appNode)
+			return nil
+		},
+		InvalidAppCb: func(ctx model.TraverseAppTreeContext, appNode model.AppAtFsNode) error {
+			if !deployCfg.Only.Matches(appNode.AppConfig, ctx.Parents) {
+				return nil
+			}
+			result.FailedApps = append(result.FailedApps, model.AppDeployFailure{
+				Spec:  appNode,
+				Cause: SkippedNotValid(appNode.ErrCause()),
+			})
+			return nil
+		},
+		BeginProjectCb: func(ctx model.TraverseAppTreeContext, projNode model.ProjectAtFsNode) error {
+			if !projNode.IsValidProject() {
+				result.FailedProjects = append(result.FailedProjects, model.ProjectProcessingFailure{
+					Spec:  projNode,
+					Cause: SkippedNotValid(projNode.ErrCause()),
+				})
+			} else {
+				result.ProcessedProjects = append(result.ProcessedProjects, projNode)
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		return result, fmt.Errorf("error traversing app tree: %w", err)
+	}
+
+	deployInOrder(collectedApps, otherApps, deployCfg.Parallelism, deployCfg.OrgLimits, false, reconcile, failApp)
+	return result, nil
+}
+
+// reconcileAppRecorded Reconciles the app, recording and notifying about it only if something changed
+// (or failed to), so that the history isn't flooded by every scheduled reconciliation
+func reconcileAppRecorded(
+	flyClient fly_client.FlyClient,
+	history model.DeployHistory,
+	notifier model.DeployNotifier,
+	ctx context.Context,
+	appNode model.AppAtFsNode,
+	deployCfg model.DeployConfig,
+) (model.SingleAppDeploySuccessType, error) {
+
+	record := model.DeployRecord{
+		Time:    time.Now(),
+		App:     appNode.AppConfig.App,
+		Org:     appNode.AppConfig.Org,
+		Path:    appNode.Path,
+		Trigger: deployCfg.Trigger,
+	}
+	notify := func(event string) {
+		notifyDeploy(notifier, ctx, appNode.Notifications, deployCfg, record, event)
+	}
+
+	result, err := reconcileApp(flyClient, ctx, appNode.AppConfig, deployCfg, func() { notify(model.NotifyStarted) })
+	if errors.Is(err, SkippedNotDeployed) || (err == nil && result != model.SingleAppDeployReconciled) {
+		return result, err
+	}
+
+	record.Result = result
+	record.DurationSeconds = time.Since(record.Time).Seconds()
+	if err != nil {
+		record.Error = err.Error()
+	}
+	recordDeploy(history, record)
+	if err != nil {
+		notify(model.NotifyFailed)
+	} else {
+		notify(model.NotifySucceeded)
+	}
+	return result, err
+}
+
+func reconcileApp(
+	flyClient fly_client.FlyClient,
+	ctx context.Context,
+	cfg model.AppConfig,
+	deployCfg model.DeployConfig,
+	started func(),
+) (model.SingleAppDeploySuccessType, error) {
+
+	err := cfg.Validate()
+	if err != nil {
+		return "", fmt.Errorf("error validating app config: %w", err)
+	}
+
+	appExists, err := flyClient.ExistsApp(ctx, cfg.App)
+	if err != nil {
+		return "", fmt.Errorf("error checking if app %s exists: %w", cfg.App, err)
+	}
+	if !appExists {
+		return "", SkippedNotDeployed
+	}
+
+	// The infra steps only need the config, not the app fs or hashes
+	return reconcileInfra(deployInput{
+		ctx:       ctx,
+		flyClient: flyClient,
+		deployCfg: deployCfg,
+		cfgTyped:  cfg,
+		started:   started,
+	})
+}
+
+// reconcileInfra First checks for drift with a planningFlyClient, and only if something
+// has drifted, runs the infra steps for real. This way we don't touch anything that is fine.
+func reconcileInfra(input deployInput) (model.SingleAppDeploySuccessType, error) {
+
+	recorder := newPlanningFlyClient(input.flyClient)
+	checkInput := input
+	checkInput.flyClient = recorder
+	err := util_trace.Run(input.ctx, "check infra drift", func(ctx context.Context) error {
+		checkInput.ctx = ctx
+		return runInfraSteps(checkInput)
+	})
+	if err != nil {
+		return "", fmt.Errorf("error checking infra drift for app %s: %w", input.cfgTyped.App, err)
+	}
+
+	drift := recorder.Actions()
+	if len(drift) == 0 {
+		slog.InfoContext(input.ctx, "No infra drift found")
+		return model.SingleAppDeployNoChange, nil
+	}
+
+	for _, action := range drift {
+		slog.InfoContext(input.ctx, "Found infra drift, repairing it", "drift", action.Description)
+	}
+	input.notifyStarted()
+
+	err = runInfraSteps(input)
+	if err != nil {
+		return "", fmt.Errorf("error reconciling infra for app %s: %w", input.cfgTyped.App, err)
+	}
+
+	return model.SingleAppDeployReconciled, nil
+}
+
+// runInfraSteps All steps that bring volumes, ips and scale up to the config, i.e. everything
+// except secrets and the deploy itself. We can't tell if secrets differ, fly.io never returns them.
+func runInfraSteps(input deployInput) error {
+
+	err := runStep(input, util_metrics.StepVolumes, runIntermediateVolumeSteps)
+	if err != nil {
+		return inStep(util_metrics.StepVolumes, fmt.Errorf("error running intermediate volume steps: %w", err))
+	}
+
+	err = runStep(input, util_metrics.StepNetworking, runIntermediateNetworkingSteps)
+	if err != nil {
+		return inStep(util_metrics.StepNetworking, fmt.Errorf("error running intermediate networking steps: %w", err))
+	}
+
+	err = runStep(input, util_metrics.StepScale, runPostDeploySteps)
+	if err != nil {
+		return inStep(util_metrics.StepScale, err)
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"github.com/gigurra/flycd/pkg/domain/model"
+	"github.com/gigurra/flycd/pkg/ext/fly_client"
+	"github.com/gigurra/flycd/pkg/util/util_work_dir"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReconcileAll_simulatedBackend(t *testing.T) {
+	ctx := context.Background()
+	flyClient := fly_client.NewFlyClientSim()
+	deployService := NewDeployService(flyClient)
+	deployCfg := model.NewDefaultDeployConfig().WithRetries(0)
+	path := "../../test/test-projects/nginx-with-volumes"
+	appName := "nginx-with-volumes-test"
+
+	reconcile := func() model.DeployResult {
+		result, err := deployService.ReconcileAll(ctx, path, deployCfg)
+		if err != nil {
+			t.Fatalf("ReconcileAll failed: %v", err)
+		}
+		return result
+	}
+
+	// Reconciling doesn't create apps, that is what deploys are for
+	result := reconcile()
+	if len(result.FailedApps) != 1 || !errors.Is(result.FailedApps[0].Cause, SkippedNotDeployed) {
+		t.Fatalf("expected app to be skipped as not deployed, got %+v", result)
+	}
+
+	_, err := deployService.DeployAll(ctx, path, deployCfg)
+	if err != nil {
+		t.Fatalf("DeployAll failed: %v", err)
+	}
+
+	// The volumes fly.io creates on the first deploy are too small, and someone scales the app down by hand
+	err = flyClient.ScaleApp(ctx, appName, "arn", 1)
+	if err != nil {
+		t.Fatalf("ScaleApp failed: %v", err)
+	}
+
+	result = reconcile()
+	if !result.Success() || result.SucceededApps[0].SuccessType != model.SingleAppDeployReconciled {
+		t.Fatalf("expected app to be reconciled, got %+v", result)
+	}
+	app := flyClient.State().Apps[appName]
+	if app.Deploys != 1 || len(app.Machines) != 3 {
+		t.Fatalf("expected 3 machines without a redeploy, got %d deploys and %d machines", app.Deploys, len(app.Machines))
+	}
+	for _, volume := range app.Volumes {
+		if volume.SizeGb != 10 {
+			t.Fatalf("expected all volumes to be extended to 10 GB, got %+v", app.Volumes)
+		}
+	}
+
+	if result = reconcile(); result.SucceededApps[0].SuccessType != model.SingleAppDeployNoChange {
+		t.Fatalf("expected nothing left to reconcile, got %+v", result)
+	}
+}
+
+func TestReconcileAll_simulatedBackend_parallel(t *testing.T) {
+	ctx := context.Background()
+	flyClient := fly_client.NewFlyClientSim()
+	deployService := NewDeployService(flyClient)
+	deployCfg := model.
+		NewDefaultDeployConfig().
+		WithRetries(0).
+		WithParallelism(4).
+		WithOrgLimits(map[string]int{"personal": 1})
+	appNames := []string{"app1", "app2"}
+
+	root := util_work_dir.NewWorkDir(t.TempDir())
+	for _, appName := range appNames {
+		if err := os.MkdirAll(filepath.Join(root.Cwd(), appName), 0755); err != nil {
+			t.Fatalf("error creating app dir: %v", err)
+		}
+		err := root.WithChildCwd(appName).WriteFile("app.yaml", "app: "+appName+"\norg: personal\nprimary_region: arn\n"+
+			"services:\n  - internal_port: 80\n    min_machines_running: 2\nsource:\n  type: local\n")
+		if err != nil {
+			t.Fatalf("error writing app.yaml: %v", err)
+		}
+	}
+
+	_, err := deployService.DeployAll(ctx, root.Cwd(), deployCfg)
+	if err != nil {
+		t.Fatalf("DeployAll failed: %v", err)
+	}
+	for _, appName := range appNames {
+		err = flyClient.ScaleApp(ctx, appName, "arn", 1)
+		if err != nil {
+			t.Fatalf("ScaleApp failed: %v", err)
+		}
+	}
+
+	result, err := deployService.ReconcileAll(ctx, root.Cwd(), deployCfg)
+	if err != nil {
+		t.Fatalf("ReconcileAll failed: %v", err)
+	}
+	if !result.Success() || len(result.SucceededApps) != len(appNames) {
+		t.Fatalf("expected %d reconciled apps, got %+v", len(appNames), result)
+	}
+	for _, success := range result.SucceededApps {
+		if success.SuccessType != model.SingleAppDeployReconciled {
+			t.Fatalf("expected app %s to be reconciled, got %s", success.Spec.AppConfig.App, success.SuccessType)
+		}
+	}
+	for _, appName := range appNames {
+		if app := flyClient.State().Apps[appName]; app.Deploys != 1 || len(app.Machines) != 2 {
+			t.Fatalf("expected app %s to be scaled back up without a redeploy, got %+v", appName, app)
+		}
+	}
+}
+
+func TestDeployAll_reconcileInfraOfUpToDateApp(t *testing.T) {
+	ctx := context.Background()
+	flyClient := fly_client.NewFlyClientSim()
+	deployService := NewDeployService(flyClient)
+	deployCfg := model.NewDefaultDeployConfig().WithRetries(0)
+	path := "../../test/test-projects/nginx-with-volumes"
+
+	_, err := deployService.DeployAll(ctx, path, deployCfg)
+	if err != nil {
+		t.Fatalf("DeployAll failed: %v", err)
+	}
+
+	result, err := deployService.DeployAll(ctx, path, deployCfg)
+	if err != nil || result.SucceededApps[0].SuccessType != model.SingleAppDeployNoChange {
+		t.Fatalf("expected no change without reconciling, got %+v, err=%v", result, err)
+	}
+
+	result, err = deployService.DeployAll(ctx, path, deployCfg.WithReconcileInfra())
+	if err != nil || result.SucceededApps[0].SuccessType != model.SingleAppDeployReconciled {
+		t.Fatalf("expected drifted volumes to be reconciled, got %+v, err=%v", result, err)
+	}
+	if deploys := flyClient.State().Apps["nginx-with-volumes-test"].Deploys; deploys != 1 {
+		t.Fatalf("expected reconciling not to redeploy, got %d deploys", deploys)
+	}
+}
+
+func TestReconcileAll_recordsChanges(t *testing.T) {
+	ctx := context.Background()
+	flyClient := fly_client.NewFlyClientSim()
+	history := NewJsonlDeployHistory(filepath.Join(t.TempDir(), "history.jsonl"))
+	deployService := NewDeployServiceWithHistory(flyClient, history)
+	deployCfg := model.NewDefaultDeployConfig().WithRetries(0).WithTrigger(model.TriggerScheduledReconcile)
+	path := "../../test/test-projects/nginx-with-volumes"
+	appName := "nginx-with-volumes-test"
+
+	_, err := deployService.DeployAll(ctx, path, deployCfg)
+	if err != nil {
+		t.Fatalf("DeployAll failed: %v", err)
+	}
+	err = flyClient.ScaleApp(ctx, appName, "arn", 1)
+	if err != nil {
+		t.Fatalf("ScaleApp failed: %v", err)
+	}
+
+	result, err := deployService.ReconcileAll(ctx, path, deployCfg.WithOnly(model.AppFilter{App: "some-other-app"}))
+	if err != nil || len(result.SucceededApps) != 0 || len(result.FailedApps) != 0 {
+		t.Fatalf("expected apps not matching the filter to be left alone, got %+v, err=%v", result, err)
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err = deployService.ReconcileAll(ctx, path, deployCfg)
+		if err != nil {
+			t.Fatalf("ReconcileAll failed: %v", err)
+		}
+	}
+
+	records, err := history.List(appName, 0)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(records) != 2 ||
+		records[0].Result != model.SingleAppDeployReconciled ||
+		records[0].Trigger != model.TriggerScheduledReconcile ||
+		records[1].Result != model.SingleAppDeployCreated {
+		t.Fatalf("expected the deploy and the one reconciliation that changed something, got %+v", records)
+	}
+}
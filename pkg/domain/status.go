@@ -0,0 +1,254 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"github.com/gigurra/flycd/pkg/domain/model"
+	"github.com/gigurra/flycd/pkg/ext/fly_client"
+	"github.com/gigurra/flycd/pkg/util/util_git"
+	"github.com/gigurra/flycd/pkg/util/util_log"
+	"github.com/gigurra/flycd/pkg/util/util_metrics"
+	"github.com/gigurra/flycd/pkg/util/util_work_dir"
+	"github.com/samber/lo"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// statusAll compares every app in the tree with what is deployed on fly.io, without changing anything.
+// Versions of git apps are looked up in, and added to, gitAppVersions.
+func statusAll(
+	flyClient fly_client.FlyClient,
+	gitAppVersions *gitAppVersionCache,
+	ctx context.Context,
+	path string,
+) (model.StatusReport, error) {
+
+	report := model.NewEmptyStatusReport()
+	gitAppKeys := map[string]bool{}
+
+	err := TraverseDeepAppTree(path, model.TraverseAppTreeContext{
+		Context: ctx,
+		ValidAppCb: func(ctx model.TraverseAppTreeContext, appNode model.AppAtFsNode) error {
+			if err := ctx.Err(); err != nil {
+				return err // e.g. the api request timed out. Don't report every remaining app as failing
+			}
+			appCtx := util_log.With(ctx, util_log.KeyApp, appNode.AppConfig.App)
+			slog.InfoContext(appCtx, "Checking status of app", "path", appNode.Path)
+			report.Apps = append(report.Apps, appStatus(flyClient, gitAppVersions, appCtx, appNode))
+			if appNode.AppConfig.Source.Type == model.SourceTypeGit {
+				gitAppKeys[gitAppVersionKey(appNode.AppConfig)] = true
+			}
+			return nil
+		},
+		InvalidAppCb: func(ctx model.TraverseAppTreeContext, appNode model.AppAtFsNode) error {
+			report.Apps = append(report.Apps, model.AppStatus{
+				App:    appNode.AppConfig.App,
+				Path:   appNode.Path,
+				Status: model.AppStatusFailing,
+				Drift:  []model.PlannedAction{},
+				Error:  SkippedNotValid(appNode.ErrCause()).Error(),
+			})
+			return nil
+		},
+		BeginProjectCb: func(ctx model.TraverseAppTreeContext, projNode model.ProjectAtFsNode) error {
+			projStatus := model.ProjectStatus{
+				Project: projNode.ProjectConfig.Project,
+				Path:    projNode.Path,
+			}
+			if !projNode.IsValidProject() {
+				projStatus.Error = SkippedNotValid(projNode.ErrCause()).Error()
+			}
+			report.Projects = append(report.Projects, projStatus)
+			return nil
+		},
+	})
+	if err != nil {
+		return report, fmt.Errorf("error traversing app tree: %w", err)
+	}
+
+	// Only now that the whole tree has been seen is it known which apps are gone from it
+	gitAppVersions.retain(gitAppKeys)
+
+	counts := report.CountByStatus()
+	util_metrics.AppStatuses(
+		lo.MapKeys(counts, func(_ int, status model.AppSyncStatus) string { return string(status) }),
+		len(report.Apps)-counts[model.AppStatusInSync],
+	)
+
+	return report, nil
+}
+
+func appStatus(
+	flyClient fly_client.FlyClient,
+	gitAppVersions *gitAppVersionCache,
+	ctx context.Context,
+	appNode model.AppAtFsNode,
+) model.AppStatus {
+
+	result := model.AppStatus{
+		App:   appNode.AppConfig.App,
+		Path:  appNode.Path,
+		Drift: []model.PlannedAction{},
+	}
+
+	failing := func(err error) model.AppStatus {
+		result.Status = model.AppStatusFailing
+		result.Error = err.Error()
+		return result
+	}
+
+	cfgTyped := appNode.AppConfig
+	err := cfgTyped.Validate()
+	if err != nil {
+		return failing(fmt.Errorf("error validating app config: %w", err))
+	}
+
+	result.WantedConfigVersion, err = effectiveCfgHash(util_work_dir.NewWorkDir(appNode.Path), appNode.AppConfigUntyped)
+	if err != nil {
+		return failing(fmt.Errorf("error getting config hash for '%s': %w", appNode.Path, err))
+	}
+
+	appExists, err := flyClient.ExistsApp(ctx, cfgTyped.App)
+	if err != nil {
+		return failing(fmt.Errorf("error checking if app %s exists: %w", cfgTyped.App, err))
+	}
+
+	deployedEnv := map[string]string{}
+	if appExists {
+		deployedCfg, err := flyClient.GetDeployedAppConfig(ctx, cfgTyped.App)
+		if err != nil {
+			return failing(fmt.Errorf("error getting deployed app config: %w", err))
+		}
+		deployedEnv = deployedCfg.Env
+		result.DeployedAppVersion = deployedEnv["FLYCD_APP_VERSION"]
+		result.DeployedConfigVersion = deployedEnv["FLYCD_CONFIG_VERSION"]
+	}
+
+	result.WantedAppVersion, err = wantedAppVersion(ctx, gitAppVersions, cfgTyped, appNode.Path, deployedEnv)
+	if err != nil {
+		return failing(err)
+	}
+
+	if !appExists {
+		result.Status = model.AppStatusMissing
+		return result
+	}
+
+	// Infra drift is found by running the regular infra steps against a recorder
+	recorder := newPlanningFlyClient(flyClient)
+	err = runInfraSteps(deployInput{
+		ctx:       ctx,
+		flyClient: recorder,
+		deployCfg: model.NewDefaultDeployConfig(),
+		cfgTyped:  cfgTyped,
+	})
+	if err != nil {
+		return failing(err)
+	}
+	result.Drift = recorder.Actions()
+
+	if result.VersionsMatch() && len(result.Drift) == 0 {
+		result.Status = model.AppStatusInSync
+	} else {
+		result.Status = model.AppStatusOutOfDate
+	}
+
+	return result
+}
+
+// wantedAppVersion The app version a deploy would give the app, like FLYCD_APP_VERSION. Git apps are never cloned
+// for it: if the deployed commit is still the latest, so is the deployed version. Otherwise the version is worked
+// out from the hashes of the files at the latest commit, fetched without their contents.
+func wantedAppVersion(
+	ctx context.Context,
+	gitAppVersions *gitAppVersionCache,
+	cfgTyped model.AppConfig,
+	path string,
+	deployedEnv map[string]string,
+) (string, error) {
+
+	tempDir, err := util_work_dir.NewTempDir(cfgTyped.App, "")
+	if err != nil {
+		return "", fmt.Errorf("error creating temp dir: %w", err)
+	}
+	defer tempDir.RemoveAll()
+
+	if cfgTyped.Source.Type != model.SourceTypeGit {
+		appHash, _, err := fetchAppFs(ctx, cfgTyped, util_work_dir.NewWorkDir(path), &tempDir)
+		if err != nil {
+			return "", fmt.Errorf("error preparing fs to deploy: %w", err)
+		}
+		return appHash, nil
+	}
+
+	source := cfgTyped.Source.AsGitCloneSource()
+	commit, err := util_git.LsRemote(ctx, source)
+	if err != nil {
+		return "", err
+	}
+	if commit == deployedEnv["FLYCD_APP_COMMIT"] && deployedEnv["FLYCD_APP_VERSION"] != "" {
+		return deployedEnv["FLYCD_APP_VERSION"], nil
+	}
+
+	key := gitAppVersionKey(cfgTyped)
+	if version, found := gitAppVersions.get(key, commit); found {
+		return version, nil
+	}
+
+	commit, files, err := util_git.ListRemoteFiles(ctx, source, tempDir)
+	if err != nil {
+		return "", err
+	}
+	version := watchedFilesHash(files, cfgTyped.WatchedPaths())
+	gitAppVersions.put(key, commit, version)
+	return version, nil
+}
+
+// gitAppVersionKey What the version of a git app depends on, besides the commit
+func gitAppVersionKey(cfgTyped model.AppConfig) string {
+	return cfgTyped.Source.Repo + " " + strings.Join(cfgTyped.WatchedPaths(), ",")
+}
+
+type gitAppVersion struct {
+	commit  string
+	version string
+}
+
+// gitAppVersionCache Remembers the version of each repo and watched paths at their latest commit only,
+// so that periodic status checks only fetch repos that have moved on since the previous check
+type gitAppVersionCache struct {
+	mutex   sync.Mutex
+	entries map[string]gitAppVersion
+}
+
+func newGitAppVersionCache() *gitAppVersionCache {
+	return &gitAppVersionCache{entries: map[string]gitAppVersion{}}
+}
+
+func (c *gitAppVersionCache) get(key string, commit string) (string, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	entry, found := c.entries[key]
+	if !found || entry.commit != commit {
+		return "", false
+	}
+	return entry.version, true
+}
+
+func (c *gitAppVersionCache) put(key string, commit string, version string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.entries[key] = gitAppVersion{commit: commit, version: version}
+}
+
+// retain Forgets the versions of all apps but the given ones, e.g. of apps removed from the tree
+func (c *gitAppVersionCache) retain(keys map[string]bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for key := range c.entries {
+		if !keys[key] {
+			delete(c.entries, key)
+		}
+	}
+}
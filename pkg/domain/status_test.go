@@ -0,0 +1,186 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"github.com/gigurra/flycd/pkg/domain/model"
+	"github.com/gigurra/flycd/pkg/ext/fly_client"
+	"github.com/gigurra/flycd/pkg/util/util_metrics"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStatusAll_simulatedBackend(t *testing.T) {
+	ctx := context.Background()
+	flyClient := fly_client.NewFlyClientSim()
+	deployService := NewDeployService(flyClient)
+	path := "../../test/test-projects/nginx-with-volumes"
+
+	statusOf := func() model.AppStatus {
+		report, err := deployService.StatusAll(ctx, path)
+		if err != nil {
+			t.Fatalf("StatusAll failed: %v", err)
+		}
+		if len(report.Apps) != 1 {
+			t.Fatalf("expected status for exactly 1 app, got %+v", report)
+		}
+		return report.Apps[0]
+	}
+
+	if status := statusOf(); status.Status != model.AppStatusMissing {
+		t.Fatalf("expected app to be missing before deploy, got %+v", status)
+	}
+
+	deployCfg := model.NewDefaultDeployConfig().WithRetries(0)
+	_, err := deployService.DeployAll(ctx, path, deployCfg)
+	if err != nil {
+		t.Fatalf("DeployAll failed: %v", err)
+	}
+
+	// The volumes fly.io creates on the first deploy are too small, only a later deploy extends them
+	status := statusOf()
+	if status.Status != model.AppStatusOutOfDate || !status.VersionsMatch() || len(status.Drift) != 3 ||
+		status.Drift[0].Type != model.PlannedActionExtendVolume {
+		t.Fatalf("expected volume drift after first deploy, got %+v", status)
+	}
+
+	_, err = deployService.DeployAll(ctx, path, deployCfg.WithForce())
+	if err != nil {
+		t.Fatalf("DeployAll failed: %v", err)
+	}
+	if status := statusOf(); status.Status != model.AppStatusInSync {
+		t.Fatalf("expected app to be in sync after deploy, got %+v", status)
+	}
+
+	// Someone scales the app down by hand
+	err = flyClient.ScaleApp(ctx, "nginx-with-volumes-test", "arn", 1)
+	if err != nil {
+		t.Fatalf("ScaleApp failed: %v", err)
+	}
+	status = statusOf()
+	if status.Status != model.AppStatusOutOfDate || !status.VersionsMatch() || len(status.Drift) != 1 ||
+		status.Drift[0].Type != model.PlannedActionScaleCount {
+		t.Fatalf("expected scale drift, got %+v", status)
+	}
+	if len(flyClient.State().Apps["nginx-with-volumes-test"].Machines) != 1 {
+		t.Fatalf("expected status not to change anything")
+	}
+}
+
+func TestStatusAll_stopsWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := NewDeployService(fly_client.NewFlyClientSim()).StatusAll(ctx, "../../test/test-projects/nginx-with-volumes")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the status check to stop when cancelled, got %v", err)
+	}
+}
+
+// countAppClones The number of app repos cloned so far, according to the metrics
+func countAppClones(t *testing.T) uint64 {
+	families, err := util_metrics.Registry.Gather()
+	if err != nil {
+		t.Fatalf("error gathering metrics: %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() != "flycd_git_clone_duration_seconds" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "kind" && label.GetValue() == util_metrics.CloneApp {
+					return metric.GetHistogram().GetSampleCount()
+				}
+			}
+		}
+	}
+	return 0
+}
+
+func TestStatusAll_gitAppWithoutCloning(t *testing.T) {
+	ctx := context.Background()
+	repo, commit := newGitRepo(t, map[string]string{
+		"apps/a/Dockerfile": "FROM scratch",
+		"apps/b/Dockerfile": "FROM scratch",
+	})
+	path := t.TempDir()
+	appYaml := "app: status-git-app\norg: personal\nprimary_region: arn\n" +
+		"source:\n  type: git\n  repo: " + repo + "\n  path: apps/a\n"
+	err := os.WriteFile(filepath.Join(path, "app.yaml"), []byte(appYaml), 0644)
+	if err != nil {
+		t.Fatalf("failed to write app.yaml: %v", err)
+	}
+
+	deployService := NewDeployService(fly_client.NewFlyClientSim())
+	_, err = deployService.DeployAll(ctx, path, model.NewDefaultDeployConfig().WithRetries(0))
+	if err != nil {
+		t.Fatalf("DeployAll failed: %v", err)
+	}
+	statusOf := func() model.AppStatus {
+		report, err := deployService.StatusAll(ctx, path)
+		if err != nil || len(report.Apps) != 1 {
+			t.Fatalf("expected status for exactly 1 app, got %+v, err=%v", report, err)
+		}
+		return report.Apps[0]
+	}
+
+	clones := countAppClones(t)
+	if status := statusOf(); status.Status != model.AppStatusInSync {
+		t.Fatalf("expected the app to be in sync after deploy, got %+v", status)
+	}
+
+	commit(map[string]string{"apps/b/Dockerfile": "FROM alpine"})
+	if status := statusOf(); status.Status != model.AppStatusInSync {
+		t.Fatalf("expected a change to another app in the repo to leave the app in sync, got %+v", status)
+	}
+
+	commit(map[string]string{"apps/a/Dockerfile": "FROM alpine"})
+	if status := statusOf(); status.Status != model.AppStatusOutOfDate || status.VersionsMatch() {
+		t.Fatalf("expected a change to the app to make it out of date, got %+v", status)
+	}
+
+	if after := countAppClones(t); after != clones {
+		t.Fatalf("expected status not to clone the app repo, but it was cloned %d times", after-clones)
+	}
+}
+
+func TestStatusAll_gitAppVersionsPerService(t *testing.T) {
+	ctx := context.Background()
+	repo, commit := newGitRepo(t, map[string]string{"Dockerfile": "FROM scratch"})
+	path := t.TempDir()
+	appYaml := "app: status-git-app\norg: personal\nprimary_region: arn\n" +
+		"source:\n  type: git\n  repo: " + repo + "\n"
+	err := os.WriteFile(filepath.Join(path, "app.yaml"), []byte(appYaml), 0644)
+	if err != nil {
+		t.Fatalf("failed to write app.yaml: %v", err)
+	}
+
+	deployService := NewDeployService(fly_client.NewFlyClientSim()).(DeployServiceImpl)
+	_, err = deployService.DeployAll(ctx, path, model.NewDefaultDeployConfig().WithRetries(0))
+	if err != nil {
+		t.Fatalf("DeployAll failed: %v", err)
+	}
+	commit(map[string]string{"Dockerfile": "FROM alpine"})
+
+	if _, err = deployService.StatusAll(ctx, path); err != nil {
+		t.Fatalf("StatusAll failed: %v", err)
+	}
+	if len(deployService.gitAppVersions.entries) != 1 {
+		t.Fatalf("expected the version at the new commit to be remembered, got %+v", deployService.gitAppVersions.entries)
+	}
+	if otherService := NewDeployService(fly_client.NewFlyClientSim()).(DeployServiceImpl); len(otherService.gitAppVersions.entries) != 0 {
+		t.Fatalf("expected other deploy services not to share the versions, got %+v", otherService.gitAppVersions.entries)
+	}
+
+	// Apps removed from the tree are forgotten
+	if err = os.Remove(filepath.Join(path, "app.yaml")); err != nil {
+		t.Fatalf("failed to remove app.yaml: %v", err)
+	}
+	if _, err = deployService.StatusAll(ctx, path); err != nil {
+		t.Fatalf("StatusAll failed: %v", err)
+	}
+	if len(deployService.gitAppVersions.entries) != 0 {
+		t.Fatalf("expected the version of the removed app to be forgotten, got %+v", deployService.gitAppVersions.entries)
+	}
+}
@@ -0,0 +1,92 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"github.com/gigurra/flycd/pkg/domain/model"
+	"github.com/samber/lo"
+	"sync"
+)
+
+// TreeListingCache Remembers the listing of a tree, since listing it clones every git project in it.
+// Invalidate it whenever the tree may have changed, e.g. when a sync or push job is done.
+type TreeListingCache struct {
+	mutex   sync.Mutex
+	path    string
+	listing *model.TreeListing
+}
+
+func NewTreeListingCache(path string) *TreeListingCache {
+	return &TreeListingCache{path: path}
+}
+
+// Get Lists the tree, unless it was already listed since the last Invalidate. Errors are not cached.
+func (c *TreeListingCache) Get(ctx context.Context) (model.TreeListing, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.listing != nil {
+		return *c.listing, nil
+	}
+	listing, err := ListTree(ctx, c.path)
+	if err != nil {
+		return listing, err
+	}
+	c.listing = &listing
+	return listing, nil
+}
+
+func (c *TreeListingCache) Invalidate() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.listing = nil
+}
+
+// ListTree Lists the apps and projects in the tree, without looking at fly.io
+func ListTree(ctx context.Context, path string) (model.TreeListing, error) {
+
+	listing := model.TreeListing{
+		Apps:     []model.ListedApp{},
+		Projects: []model.ListedProject{},
+	}
+
+	listApp := func(ctx model.TraverseAppTreeContext, appNode model.AppAtFsNode, err error) {
+		app := model.ListedApp{
+			App:      appNode.AppConfig.App,
+			Org:      appNode.AppConfig.Org,
+			Path:     appNode.Path,
+			Projects: lo.Map(ctx.Parents, func(p model.ProjectConfig, _ int) string { return p.Project }),
+		}
+		if err != nil {
+			app.Error = err.Error()
+		}
+		listing.Apps = append(listing.Apps, app)
+	}
+
+	err := TraverseDeepAppTree(path, model.TraverseAppTreeContext{
+		Context: ctx,
+		ValidAppCb: func(ctx model.TraverseAppTreeContext, appNode model.AppAtFsNode) error {
+			listApp(ctx, appNode, nil)
+			return nil
+		},
+		InvalidAppCb: func(ctx model.TraverseAppTreeContext, appNode model.AppAtFsNode) error {
+			listApp(ctx, appNode, SkippedNotValid(appNode.ErrCause()))
+			return nil
+		},
+		BeginProjectCb: func(ctx model.TraverseAppTreeContext, projNode model.ProjectAtFsNode) error {
+			project := model.ListedProject{
+				Project:  projNode.ProjectConfig.Project,
+				Path:     projNode.Path,
+				Projects: lo.Map(ctx.Parents, func(p model.ProjectConfig, _ int) string { return p.Project }),
+			}
+			if !projNode.IsValidProject() {
+				project.Error = SkippedNotValid(projNode.ErrCause()).Error()
+			}
+			listing.Projects = append(listing.Projects, project)
+			return nil
+		},
+	})
+	if err != nil {
+		return listing, fmt.Errorf("error traversing app tree: %w", err)
+	}
+	return listing, nil
+}
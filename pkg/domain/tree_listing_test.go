@@ -0,0 +1,109 @@
+package domain
+
+import (
+	"context"
+	"github.com/gigurra/flycd/pkg/domain/model"
+	"github.com/gigurra/flycd/pkg/ext/fly_client"
+	"github.com/google/go-cmp/cmp"
+	"github.com/samber/lo"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListTree(t *testing.T) {
+	listing, err := ListTree(context.Background(), "../../test/test-projects/listing")
+	if err != nil {
+		t.Fatalf("ListTree failed: %v", err)
+	}
+
+	apps := lo.Map(listing.Apps, func(app model.ListedApp, _ int) []string {
+		return append([]string{app.App}, app.Projects...)
+	})
+	expected := [][]string{
+		{"listing-auth", "backend"},
+		{"listing-gateway", "backend"},
+		{"listing-frontend"},
+	}
+	if diff := cmp.Diff(expected, apps); diff != "" {
+		t.Fatalf("unexpected apps: %v", diff)
+	}
+	projects := lo.Map(listing.Projects, func(project model.ListedProject, _ int) []string {
+		return append([]string{project.Project}, project.Projects...)
+	})
+	if diff := cmp.Diff([][]string{{"backend"}}, projects); diff != "" {
+		t.Fatalf("unexpected projects: %v", diff)
+	}
+	if !listing.HasProject("backend") || listing.HasProject("frontend") || !listing.HasApp("listing-frontend") {
+		t.Fatalf("unexpected listing: %+v", listing)
+	}
+}
+
+func TestDeployAll_only(t *testing.T) {
+	for _, test := range []struct {
+		name         string
+		filter       model.AppFilter
+		expectedApps []string
+	}{
+		{name: "app", filter: model.AppFilter{App: "listing-frontend"}, expectedApps: []string{"listing-frontend"}},
+		{name: "project", filter: model.AppFilter{Project: "backend"}, expectedApps: []string{"listing-auth", "listing-gateway"}},
+		// the dependency of the gateway is in the tree but not selected, so it is taken as already deployed
+		{name: "dependent app", filter: model.AppFilter{App: "listing-gateway"}, expectedApps: []string{"listing-gateway"}},
+		{name: "all", filter: model.AppFilter{}, expectedApps: []string{"listing-auth", "listing-frontend", "listing-gateway"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			flyClient := fly_client.NewFlyClientSim()
+			deployService := NewDeployService(flyClient)
+			deployCfg := model.
+				NewDefaultDeployConfig().
+				WithRetries(0).
+				WithOnly(test.filter)
+
+			result, err := deployService.DeployAll(context.Background(), "../../test/test-projects/listing", deployCfg)
+			if err != nil {
+				t.Fatalf("DeployAll failed: %v", err)
+			}
+			if !result.Success() {
+				t.Fatalf("expected success, got %+v", result.FailedApps)
+			}
+
+			deployed := lo.Map(result.SucceededApps, func(s model.AppDeploySuccess, _ int) string { return s.Spec.AppConfig.App })
+			if diff := cmp.Diff(test.expectedApps, deployed); diff != "" {
+				t.Fatalf("unexpected deployed apps: %v", diff)
+			}
+			if len(flyClient.State().Apps) != len(test.expectedApps) {
+				t.Fatalf("expected only the selected apps to exist, got %+v", flyClient.State().Apps)
+			}
+		})
+	}
+}
+
+func TestTreeListingCache(t *testing.T) {
+	dir := t.TempDir()
+	writeApp := func(name string) {
+		err := os.MkdirAll(filepath.Join(dir, name), 0755)
+		if err == nil {
+			err = os.WriteFile(filepath.Join(dir, name, "app.yaml"), []byte("app: "+name+"\norg: personal\nprimary_region: arn\nsource:\n  type: local\n"), 0644)
+		}
+		if err != nil {
+			t.Fatalf("error writing app %s: %v", name, err)
+		}
+	}
+	writeApp("app1")
+
+	cache := NewTreeListingCache(dir)
+	listing, err := cache.Get(context.Background())
+	if err != nil || len(listing.Apps) != 1 {
+		t.Fatalf("expected 1 app, got %+v, err=%v", listing, err)
+	}
+
+	writeApp("app2")
+	if listing, _ = cache.Get(context.Background()); len(listing.Apps) != 1 {
+		t.Fatalf("expected the cached listing until invalidated, got %+v", listing)
+	}
+
+	cache.Invalidate()
+	if listing, _ = cache.Get(context.Background()); !listing.HasApp("app1") || !listing.HasApp("app2") {
+		t.Fatalf("expected a fresh listing after invalidating, got %+v", listing)
+	}
+}
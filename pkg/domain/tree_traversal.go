@@ -5,11 +5,17 @@ import (
 	"fmt"
 	"github.com/gigurra/flycd/pkg/domain/model"
 	"github.com/gigurra/flycd/pkg/util/util_git"
+	"github.com/gigurra/flycd/pkg/util/util_log"
+	"github.com/gigurra/flycd/pkg/util/util_metrics"
+	"github.com/gigurra/flycd/pkg/util/util_trace"
 	"github.com/gigurra/flycd/pkg/util/util_work_dir"
+	"go.opentelemetry.io/otel/attribute"
 	"gopkg.in/yaml.v3"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 func TraverseDeepAppTree(
@@ -29,7 +35,11 @@ func TraverseDeepAppTree(
 	if ctx.Seen.Projects == nil {
 		ctx.Seen.Projects = map[string]bool{}
 	}
-	return doTraverseDeepAppTree(path, ctx)
+	spanCtx, span := util_trace.Start(ctx.Context, "traverse app tree", util_trace.KeyPath.String(path))
+	ctx.Context = spanCtx
+	err = doTraverseDeepAppTree(path, ctx)
+	util_trace.End(span, err)
+	return err
 }
 
 func doTraverseDeepAppTree(
@@ -99,14 +109,14 @@ func doTraverseDeepAppTree(
 	return nil
 }
 
-func calcCommonAppCfg(projectConfigs []model.ProjectConfig) (model.CommonAppConfig, error) {
+func calcCommonAppCfg(ctx context.Context, projectConfigs []model.ProjectConfig) (model.CommonAppConfig, error) {
 	commonAppCfg := model.CommonAppConfig{}
 	for _, projectCfg := range projectConfigs {
 		err := projectCfg.Validate()
 		if err != nil {
 			return commonAppCfg, fmt.Errorf("error validating project config %s: %w", projectCfg.Project, err)
 		} else {
-			commonAppCfg = commonAppCfg.Plus(projectCfg.Common)
+			commonAppCfg = commonAppCfg.Plus(ctx, projectCfg.Common)
 		}
 	}
 	return commonAppCfg, nil
@@ -115,7 +125,7 @@ func calcCommonAppCfg(projectConfigs []model.ProjectConfig) (model.CommonAppConf
 func traverseProject(
 	ctx model.TraverseAppTreeContext,
 	project model.ProjectAtFsNode,
-) error {
+) (err error) {
 	if ctx.BeginProjectCb != nil {
 		err := ctx.BeginProjectCb(ctx, project)
 		if err != nil {
@@ -123,15 +133,28 @@ func traverseProject(
 		}
 	}
 
+	spanCtx, span := util_trace.Start(ctx.Context, "project",
+		util_trace.KeyProject.String(project.ProjectConfig.Project),
+		util_trace.KeyPath.String(project.Path),
+		attribute.String("flycd.source.type", string(project.ProjectConfig.Source.Type)),
+	)
+	defer func() { util_trace.End(span, err) }()
+
+	ctx.Context = util_log.With(spanCtx, util_log.KeyProject, project.ProjectConfig.Project)
 	ctx.Parents = append(ctx.Parents, project.ProjectConfig)
 	commonAppCfgBefore := ctx.CommonAppCfg
-	CommonAppCfgAfter, err := calcCommonAppCfg(ctx.Parents)
+	_, mergeSpan := util_trace.Start(ctx, "merge common app config", attribute.Int("flycd.projects", len(ctx.Parents)))
+	CommonAppCfgAfter, err := calcCommonAppCfg(ctx, ctx.Parents)
+	util_trace.End(mergeSpan, err)
 	if err != nil {
 		return fmt.Errorf("error calculating common app config for project %s @ %s: %w", project.ProjectConfig.Project, project.Path, err)
 	}
 	ctx.CommonAppCfg = CommonAppCfgAfter
+	notificationsBefore := ctx.Notifications
+	ctx.Notifications = ctx.Notifications.Plus(project.ProjectConfig.Notifications)
 	defer func() {
 		ctx.CommonAppCfg = commonAppCfgBefore
+		ctx.Notifications = notificationsBefore
 		ctx.Parents = ctx.Parents[:len(ctx.Parents)-1]
 	}()
 
@@ -139,7 +162,7 @@ func traverseProject(
 		if ctx.EndProjectCb != nil {
 			err := ctx.EndProjectCb(ctx, project)
 			if err != nil {
-				fmt.Printf("error calling function for valid project %s @ %s: %v", project.ProjectConfig.Project, project.Path, err)
+				slog.ErrorContext(ctx, "Error calling function for valid project", "path", project.Path, "error", err)
 			}
 		}
 	}()
@@ -168,13 +191,19 @@ func traverseProject(
 				if err != nil {
 					return fmt.Errorf("creating temp dir for project %s: %w", project.ProjectConfig.Project, err)
 				}
-				defer tempDir.RemoveAll() // this is ok. We can wait until the end of the function
+				if ctx.DeferCleanup != nil {
+					ctx.DeferCleanup(tempDir.RemoveAll)
+				} else {
+					defer tempDir.RemoveAll() // this is ok. We can wait until the end of the function
+				}
 
 				// Clone to temp dir
+				cloneStart := time.Now()
 				cloneResult, err := util_git.CloneShallow(ctx, project.ProjectConfig.Source.AsGitCloneSource(), tempDir)
 				if err != nil {
 					return fmt.Errorf("cloning project %s: %w", project.ProjectConfig.Project, err)
 				} else {
+					util_metrics.CloneFinished(util_metrics.CloneProject, time.Since(cloneStart))
 					err := doTraverseDeepAppTree(filepath.Join(cloneResult.Dir.Cwd(), project.ProjectConfig.Source.Path), ctx)
 					if err != nil {
 						return fmt.Errorf("error traversing cloned project %s @ %s: %w", project.ProjectConfig.Project, project.Path, err)
@@ -189,7 +218,7 @@ func traverseProject(
 			}
 
 		default:
-			fmt.Printf("BUG: illegal or unknown source type '%s' for project '%s' @ %s\n", project.ProjectConfig.Source.Type, project.ProjectConfig.Project, project.Path)
+			slog.ErrorContext(ctx, "BUG: illegal or unknown source type for project", "source_type", project.ProjectConfig.Source.Type, "path", project.Path)
 		}
 
 	}
@@ -225,8 +254,11 @@ func analyzeFsTree(
 			return result, fmt.Errorf("error reading app.yaml: %w", err)
 		}
 
+		_, mergeSpan := util_trace.Start(ctx, "merge app config", util_trace.KeyPath.String(path))
 		cfgTyped, cfgUntyped, errCfg :=
-			ctx.CommonAppCfg.MakeAppConfig([]byte(appYaml))
+			ctx.CommonAppCfg.MakeAppConfig(ctx, []byte(appYaml))
+		mergeSpan.SetAttributes(util_trace.KeyApp.String(cfgTyped.App))
+		util_trace.End(mergeSpan, errCfg)
 
 		result.App = &model.AppAtFsNode{
 			Path:             path,
@@ -234,6 +266,7 @@ func analyzeFsTree(
 			AppConfigUntyped: cfgUntyped,
 			AppConfig:        cfgTyped,
 			AppConfigErr:     errCfg,
+			Notifications:    ctx.Notifications,
 		}
 	}
 
@@ -2,151 +2,525 @@ package domain
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/gigurra/flycd/pkg/domain/model"
 	"github.com/gigurra/flycd/pkg/ext/github"
+	"github.com/gigurra/flycd/pkg/util/util_git"
+	"github.com/gigurra/flycd/pkg/util/util_log"
+	"github.com/gigurra/flycd/pkg/util/util_trace"
 	"github.com/samber/lo"
+	"go.opentelemetry.io/otel/attribute"
+	"log/slog"
 	"strings"
+	"sync"
+	"time"
 )
 
 type WebHookService interface {
 	HandleGithubWebhook(payload github.PushWebhookPayload, path string) <-chan error
-	Start(ctx context.Context) error
+	HandlePushEvent(event model.PushEvent, path string) <-chan error
+	Start(ctx context.Context, cfg model.WorkerConfig) error
 	CloseJobQueue()
-	EnqueueJob(job func())
+	EnqueueJob(job model.Job) <-chan error
+	SubmitJob(job model.Job) (model.Job, error)
+	PendingJobs() ([]model.Job, error)
+	RunningJobs() []model.Job
+	DeadJobs() ([]model.Job, error)
+	JobLog(jobId string) ([]string, bool)
+	// LogHandler Wraps the handler to also keep the log records of jobs in their job logs
+	LogHandler(inner slog.Handler) slog.Handler
 }
 
 type WebHookServiceImpl struct {
 	deployService DeployService
-	workQueue     chan func()
+	cfg           model.WorkerConfig
+	wakeUp        chan struct{}
+	closing       chan struct{}
+	closeOnce     sync.Once
+	workers       sync.WaitGroup
+	mutex         sync.Mutex
+	started       bool
+	running       map[string]model.Job
+	waiters       map[string][]chan error
+	exclusive     sync.RWMutex // held for writing by jobs touching all apps, and for reading by the rest
+	locks         *deployLocks
+	logs          *jobLogs
 }
 
-// Stop An alternative to cancelling the context itself
+// prove that WebHookServiceImpl implements WebHookService
+var _ WebHookService = &WebHookServiceImpl{}
+
+func NewWebHookService(deployService DeployService) WebHookService {
+	return &WebHookServiceImpl{
+		deployService: deployService,
+		cfg:           model.NewDefaultWorkerConfig().WithQueue(NewMemJobQueue()),
+		wakeUp:        make(chan struct{}, 1),
+		closing:       make(chan struct{}),
+		running:       map[string]model.Job{},
+		waiters:       map[string][]chan error{},
+		locks:         newDeployLocks(map[string]int{}),
+		logs:          newJobLogs(100, 1000),
+	}
+}
+
+// CloseJobQueue Stops the workers once they are done with their current jobs, and waits for them.
+// Jobs still in the queue are picked up again on the next start, if the queue is persistent.
 func (w *WebHookServiceImpl) CloseJobQueue() {
-	fmt.Printf("Closing webhook service's job queue\n")
-	close(w.workQueue)
-}
-
-// Start Starts the internal worker
-func (w *WebHookServiceImpl) Start(ctx context.Context) error {
-	fmt.Printf("Creating webhook service & worker\n")
-
-	// Prob add some way of preventing multiple workers from being started...
-
-	go func() {
-		for {
-			select {
-			case work, isOpen := <-w.workQueue:
-				if isOpen {
-					work()
-				} else {
-					fmt.Printf("Work queue closed: Stopping webhook worker\n")
-					return
-				}
-			case <-ctx.Done():
-				fmt.Printf("Context cancelled: Stopping webhook worker\n")
-				return
-			}
+	slog.Info("Closing webhook service's job queue")
+	w.closeOnce.Do(func() { close(w.closing) })
+	w.mutex.Lock()
+	started := w.started
+	w.mutex.Unlock()
+	if started {
+		w.workers.Wait()
+	}
+}
+
+// Start Starts the internal workers, resuming any jobs left in the queue from before a restart.
+// Must be called before any jobs are queued, since it replaces the queue with the configured one.
+func (w *WebHookServiceImpl) Start(ctx context.Context, cfg model.WorkerConfig) error {
+	slog.InfoContext(ctx, "Creating webhook service", "workers", cfg.Workers)
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.started {
+		return fmt.Errorf("webhook service already started")
+	}
+	if cfg.MaxAttempts < 1 {
+		return fmt.Errorf("max attempts must be at least 1, got %d", cfg.MaxAttempts)
+	}
+	if cfg.Workers < 1 {
+		return fmt.Errorf("workers must be at least 1, got %d", cfg.Workers)
+	}
+	for org, limit := range cfg.OrgLimits {
+		if limit < 0 {
+			return fmt.Errorf("limit for org '%s' must not be negative, got %d", org, limit)
 		}
-	}()
+	}
+	if cfg.Queue == nil {
+		cfg.Queue = NewMemJobQueue()
+	}
+
+	pending, err := cfg.Queue.Pending()
+	if err != nil {
+		return fmt.Errorf("error reading job queue: %w", err)
+	}
+	if len(pending) > 0 {
+		slog.InfoContext(ctx, "Resuming jobs left in the queue", "jobs", len(pending))
+	}
+
+	w.cfg = cfg
+	w.locks = newDeployLocks(cfg.OrgLimits)
+	w.started = true
+
+	w.workers.Add(cfg.Workers)
+	for i := 0; i < cfg.Workers; i++ {
+		go w.work(ctx)
+	}
 
 	return nil
 }
 
-func NewWebHookService(deployService DeployService) WebHookService {
-	return &WebHookServiceImpl{
-		deployService: deployService,
-		workQueue:     make(chan func(), 100),
+// EnqueueJob Stores the job in the queue and returns right away. The returned channel
+// gets the job's error, if any, and is closed when the job is done or dead.
+// If a job with the same target is already waiting in the queue, the new job is merged into it
+// instead. A running job is never merged into, so it gets at most one follow-up job.
+func (w *WebHookServiceImpl) EnqueueJob(job model.Job) <-chan error {
+	ch := make(chan error, 1)
+	_, err := w.submit(job, ch)
+	if err != nil {
+		ch <- err
+		close(ch)
 	}
+	return ch
 }
 
-func (w *WebHookServiceImpl) EnqueueJob(job func()) {
-	w.workQueue <- job
+// SubmitJob Like EnqueueJob, but without waiting for the result. Returns the queued job,
+// which is the already queued job if the new one was merged into it.
+func (w *WebHookServiceImpl) SubmitJob(job model.Job) (model.Job, error) {
+	return w.submit(job, nil)
 }
 
-func (w *WebHookServiceImpl) HandleGithubWebhook(payload github.PushWebhookPayload, path string) <-chan error {
+func (w *WebHookServiceImpl) submit(job model.Job, waiter chan error) (model.Job, error) {
+	if job.CorrelationId == "" {
+		job.CorrelationId = util_log.NewCorrelationId()
+	}
 
-	ch := make(chan error, 1)
+	w.mutex.Lock()
+	job, err := w.pushOrAbsorb(job)
+	if err == nil && waiter != nil {
+		w.waiters[job.Id] = append(w.waiters[job.Id], waiter)
+	}
+	w.mutex.Unlock()
+
+	if err != nil {
+		return job, fmt.Errorf("error queueing %s: %w", job.Description(), err)
+	}
 
-	// TODO: Implement some kind of persistence and/or caching here... So we don't have to clone everything every time...
+	w.notify()
 
-	task := func() {
+	return job, nil
+}
 
-		fmt.Printf("Start processing webhook %d for %s...\n", payload.HookId, payload.Repository.Url)
+// notify Wakes up an idle worker, if any
+func (w *WebHookServiceImpl) notify() {
+	select {
+	case w.wakeUp <- struct{}{}:
+	default: // a worker is already about to wake up
+	}
+}
 
-		defer close(ch)
+// pushOrAbsorb must be called with the mutex held, so that the worker can't start the job we merge into
+func (w *WebHookServiceImpl) pushOrAbsorb(job model.Job) (model.Job, error) {
+	pending, err := w.cfg.Queue.Pending()
+	if err != nil {
+		return job, err
+	}
+	existing, found := lo.Find(pending, func(j model.Job) bool {
+		_, isRunning := w.running[j.Id]
+		return !isRunning && j.SameTarget(job)
+	})
+	if !found {
+		return w.cfg.Queue.Push(job)
+	}
+	merged := existing.Absorb(job)
+	slog.InfoContext(jobContext(context.Background(), job), "Merged into already queued job",
+		"job", job.Description(),
+		"merged_job_id", merged.Id,
+		"merged_correlation_id", merged.CorrelationId,
+		"merged_triggers", merged.Absorbed,
+	)
+	return merged, w.cfg.Queue.Update(merged)
+}
 
-		matchedProjCount := 0
-		err := TraverseDeepAppTree(path, model.TraverseAppTreeContext{
-			Context: context.Background(),
-			ValidAppCb: func(ctx model.TraverseAppTreeContext, app model.AppAtFsNode) error {
+func (w *WebHookServiceImpl) PendingJobs() ([]model.Job, error) {
+	return w.queue().Pending()
+}
 
-				if matchedProjCount > 0 || matchesApp(app, payload) {
+func (w *WebHookServiceImpl) RunningJobs() []model.Job {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return lo.Values(w.running)
+}
 
-					if matchedProjCount > 0 {
-						fmt.Printf("App %s deploying because it is in a project that matches webhook %s...\n", app.AppConfig.App, payload.Repository.Url)
-					} else {
-						fmt.Printf("Found app %s matching webhook url %s. Deploying...\n", app.AppConfig.App, payload.Repository.Url)
-					}
+func (w *WebHookServiceImpl) DeadJobs() ([]model.Job, error) {
+	return w.queue().DeadLetters()
+}
 
-					deployCfg := model.
-						NewDefaultDeployConfig().
-						WithRetries(1).
-						WithForce(false)
-					_, err := w.deployService.DeployAppFromFolder(ctx, app.Path, deployCfg, app.ToPreCalculatedApoConf())
-					if err != nil {
-						fmt.Printf("Error deploying app %s: %v\n", app.AppConfig.App, err)
-					}
-				}
-				return nil
-			},
-			BeginProjectCb: func(ctx model.TraverseAppTreeContext, node model.ProjectAtFsNode) error {
-				// unfortunately we have to deploy everything here :S. This is because we don't know how far down
-				// the tree the change might affect our apps. So we have to deploy everything to be sure.
-				// It would be better to just use app repo webhooks instead, or at least group apps into small projects
-
-				if matchesProject(node, payload) {
-					fmt.Printf("Found project %s matching webhook url %s. Deploying all apps in the project...\n", node.ProjectConfig.Project, payload.Repository.Url)
-					matchedProjCount++
-				}
-				return nil
-			},
-			EndProjectCb: func(ctx model.TraverseAppTreeContext, node model.ProjectAtFsNode) error {
-				if matchesProject(node, payload) {
-					matchedProjCount--
-				}
-				return nil
-			},
-		})
+// JobLog The log lines of the job, for the 100 most recently run jobs. Only kept when logging through LogHandler
+func (w *WebHookServiceImpl) JobLog(jobId string) ([]string, bool) {
+	return w.logs.get(jobId)
+}
+
+// LogHandler Keeps everything logged while running a job in its job log, not only what the worker itself logs,
+// e.g. the output of git and the deploy steps. The job's lines are those logged with the job's context.
+func (w *WebHookServiceImpl) LogHandler(inner slog.Handler) slog.Handler {
+	return jobLogHandler{inner: inner, logs: w.logs}
+}
 
+// logf Logs the line with the job's context, so it also ends up in the job's log
+func (w *WebHookServiceImpl) logf(job model.Job, format string, args ...any) {
+	w.logAt(job, slog.LevelInfo, format, args...)
+}
+
+// errorf Like logf, but at error level
+func (w *WebHookServiceImpl) errorf(job model.Job, format string, args ...any) {
+	w.logAt(job, slog.LevelError, format, args...)
+}
+
+func (w *WebHookServiceImpl) logAt(job model.Job, level slog.Level, format string, args ...any) {
+	slog.Log(jobContext(context.Background(), job), level, fmt.Sprintf(format, args...))
+}
+
+// jobContext Tags all log lines of the job with its id and correlation id
+func jobContext(ctx context.Context, job model.Job) context.Context {
+	ctx = util_log.WithCorrelationId(ctx, job.CorrelationId)
+	if job.Id != "" {
+		ctx = util_log.With(ctx, util_log.KeyJobId, job.Id)
+	}
+	return ctx
+}
+
+func (w *WebHookServiceImpl) queue() model.JobQueue {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.cfg.Queue
+}
+
+func (w *WebHookServiceImpl) work(ctx context.Context) {
+	defer w.workers.Done()
+	for {
+		select {
+		case <-w.closing:
+			slog.Debug("Work queue closed: Stopping webhook worker")
+			return
+		case <-ctx.Done():
+			slog.Debug("Context cancelled: Stopping webhook worker")
+			return
+		default:
+		}
+
+		job, found, retryAt, err := w.nextJob()
 		if err != nil {
-			fmt.Printf("error traversing app tree: %v", err)
-			ch <- err
+			slog.Error("Error reading job queue", "error", err)
+		} else if found {
+			w.notify() // there may be more jobs for the other workers
+			w.process(ctx, job)
+			w.mutex.Lock()
+			delete(w.running, job.Id)
+			w.mutex.Unlock()
+			w.notify() // a job waiting for this one may be runnable now
+			continue
+		}
+
+		// Jobs waiting for their retry backoff don't wake anyone up, so set an alarm for the first of them
+		var retryDue <-chan time.Time
+		if !retryAt.IsZero() {
+			retryDue = time.After(time.Until(retryAt))
 		}
 
-		fmt.Printf("Done processing webhook %d for %s...\n", payload.HookId, payload.Repository.Url)
+		select {
+		case <-w.wakeUp:
+		case <-retryDue:
+		case <-w.closing:
+		case <-ctx.Done():
+		}
+	}
+}
 
+// nextJob Takes the oldest job that isn't already running, and marks it as running.
+// Jobs with the same target as a running job wait for it, so that follow-ups deploy after it.
+// Failed jobs wait for their retry backoff. If nothing is found, retryAt is when the first of
+// those may run, or zero if there are none.
+func (w *WebHookServiceImpl) nextJob() (job model.Job, found bool, retryAt time.Time, err error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	pending, err := w.cfg.Queue.Pending()
+	if err != nil {
+		return model.Job{}, false, time.Time{}, err
 	}
+	now := time.Now()
+	job, found = lo.Find(pending, func(j model.Job) bool {
+		if _, isRunning := w.running[j.Id]; isRunning {
+			return false
+		}
+		if !j.Due(now) {
+			if retryAt.IsZero() || j.NotBefore.Before(retryAt) {
+				retryAt = j.NotBefore
+			}
+			return false
+		}
+		return !lo.SomeBy(lo.Values(w.running), func(r model.Job) bool { return r.SameTarget(j) })
+	})
+	if found {
+		w.running[job.Id] = job
+	}
+	return job, found, retryAt, nil
+}
 
-	w.EnqueueJob(task)
+// process Runs a job, retrying it later if it fails, until it has failed MaxAttempts times.
+// The attempt is stored before running the job, so that jobs crashing flycd are not retried forever.
+func (w *WebHookServiceImpl) process(ctx context.Context, job model.Job) {
+	queue := w.queue()
 
-	return ch
+	if job.Attempts >= w.cfg.MaxAttempts {
+		if job.LastError == "" {
+			job.LastError = "interrupted, e.g. by a restart"
+		}
+		w.deadLetter(job)
+		return
+	}
+
+	job.Attempts++
+	err := queue.Update(job)
+	if err != nil {
+		w.errorf(job, "Error storing attempt %d of %s: %v", job.Attempts, job.Description(), err)
+	}
+
+	w.logf(job, "Start processing %s (attempt %d/%d)...", job.Description(), job.Attempts, w.cfg.MaxAttempts)
+	jobCtx, span := util_trace.Start(jobContext(ctx, job), "job."+string(job.Type),
+		util_trace.KeyJobId.String(job.Id),
+		util_trace.KeyJobType.String(string(job.Type)),
+		util_trace.KeyCorrelationId.String(job.CorrelationId),
+		util_trace.KeyPath.String(job.Path),
+		attribute.String("flycd.trigger", job.Trigger),
+		attribute.Int("flycd.job.attempt", job.Attempts),
+		attribute.Int("flycd.job.absorbed", job.Absorbed),
+	)
+	err = w.runJob(jobCtx, job)
+	util_trace.End(span, err)
+	w.logf(job, "Done processing %s...", job.Description())
+	if w.cfg.OnJobDone != nil {
+		w.cfg.OnJobDone(job)
+	}
+
+	if err == nil {
+		err = queue.Remove(job.Id)
+		if err != nil {
+			w.errorf(job, "Error removing finished %s from the queue: %v", job.Description(), err)
+		}
+		w.resolve(job.Id, nil)
+		return
+	}
+
+	job.LastError = err.Error()
+	if job.Attempts >= w.cfg.MaxAttempts {
+		w.deadLetter(job)
+		return
+	}
+
+	retryDelay := w.cfg.RetryDelay(job.Attempts)
+	job.NotBefore = time.Now().Add(retryDelay)
+	w.errorf(job, "Error processing %s, will retry in %v: %v", job.Description(), retryDelay, err)
+	err = queue.Update(job)
+	if err != nil {
+		w.errorf(job, "Error storing failure of %s: %v", job.Description(), err)
+	}
+}
+
+func (w *WebHookServiceImpl) deadLetter(job model.Job) {
+	w.errorf(job, "Giving up on %s after %d attempt(s): %s", job.Description(), job.Attempts, job.LastError)
+	err := w.queue().DeadLetter(job)
+	if err != nil {
+		w.errorf(job, "Error moving %s to the dead letters: %v", job.Description(), err)
+	}
+	w.resolve(job.Id, errors.New(job.LastError))
+}
+
+func (w *WebHookServiceImpl) resolve(jobId string, err error) {
+	w.mutex.Lock()
+	chs := w.waiters[jobId]
+	delete(w.waiters, jobId)
+	w.mutex.Unlock()
+	for _, ch := range chs {
+		if err != nil {
+			ch <- err
+		}
+		close(ch)
+	}
 }
 
-func matchesSpec(source model.Source, payload github.PushWebhookPayload) bool {
+// runJob Push jobs and syncs of some of the apps run in parallel, locking each app they deploy.
+// Other sync and reconcile jobs touch every app in the tree, so they wait for all other jobs and run alone.
+func (w *WebHookServiceImpl) runJob(ctx context.Context, job model.Job) error {
+	switch job.Type {
+	case model.JobTypePush:
+		if job.PushEvent == nil {
+			return fmt.Errorf("push job %s without push event", job.Id)
+		}
+		w.exclusive.RLock()
+		defer w.exclusive.RUnlock()
+		return w.deployMatching(ctx, job)
+	case model.JobTypeSync:
+		logf := func(format string, args ...any) { w.logf(job, format, args...) }
+		if !job.Only.IsEmpty() {
+			w.exclusive.RLock()
+			defer w.exclusive.RUnlock()
+			return runSyncJob(ctx, w.deployService, job, w.cfg, w.locks.lock, logf)
+		}
+		w.exclusive.Lock()
+		defer w.exclusive.Unlock()
+		return runSyncJob(ctx, w.deployService, job, w.cfg, nil, logf)
+	case model.JobTypeReconcile:
+		w.exclusive.Lock()
+		defer w.exclusive.Unlock()
+		return runReconcileJob(ctx, w.deployService, job, w.cfg, func(format string, args ...any) { w.logf(job, format, args...) })
+	default:
+		return fmt.Errorf("unknown job type '%s'", job.Type)
+	}
+}
+
+func (w *WebHookServiceImpl) HandleGithubWebhook(payload github.PushWebhookPayload, path string) <-chan error {
+	return w.HandlePushEvent(payload.ToPushEvent(), path)
+}
+
+// HandlePushEvent Deploys all apps and projects using the pushed repo, regardless of which provider sent it
+func (w *WebHookServiceImpl) HandlePushEvent(event model.PushEvent, path string) <-chan error {
+	return w.EnqueueJob(model.NewPushJob(event, path))
+}
+
+func (w *WebHookServiceImpl) deployMatching(ctx context.Context, job model.Job) error {
+
+	event := *job.PushEvent
+	description := event.Description()
+
+	// TODO: Implement some kind of caching here... So we don't have to clone everything every time...
+
+	deployErrs := make([]error, 0)
+	matchedProjCount := 0
+	err := TraverseDeepAppTree(job.Path, model.TraverseAppTreeContext{
+		Context: ctx,
+		ValidAppCb: func(ctx model.TraverseAppTreeContext, app model.AppAtFsNode) error {
+
+			switch {
+			case matchesApp(app, event):
+				w.logf(job, "Found app %s matching %s. Deploying...", app.AppConfig.App, description)
+			case matchedProjCount > 0 && touchesAppConfig(ctx, app, event):
+				w.logf(job, "App %s deploying because %s changed its config in the project...", app.AppConfig.App, description)
+			case matchedProjCount > 0:
+				w.logf(job, "Skipping app %s: %s didn't change its config or source", app.AppConfig.App, description)
+				return nil
+			case matchesSpec(app.AppConfig.Source, event):
+				w.logf(job, "Skipping app %s: %s didn't change any of %v", app.AppConfig.App, description, app.AppConfig.WatchedPaths())
+				return nil
+			default:
+				return nil
+			}
+
+			deployCfg := model.
+				NewDefaultDeployConfig().
+				WithRetries(1).
+				WithForce(false).
+				WithTrigger(job.Trigger).
+				WithPush(event)
+			unlock := w.locks.lock(app.AppConfig.App, app.AppConfig.Org)
+			result, err := w.deployService.DeployAppFromFolder(ctx, app.Path, deployCfg, app.ToPreCalculatedApoConf())
+			unlock()
+			if err != nil {
+				w.errorf(job, "Error deploying app %s: %v", app.AppConfig.App, err)
+				deployErrs = append(deployErrs, fmt.Errorf("error deploying app %s: %w", app.AppConfig.App, err))
+			} else {
+				w.logf(job, "Deployed app %s (%s)", app.AppConfig.App, result)
+			}
+			return nil
+		},
+		BeginProjectCb: func(ctx model.TraverseAppTreeContext, node model.ProjectAtFsNode) error {
+			// The apps of a pushed project repo are deployed if the push changed their config there
+			// (or their source, if it is local), since we can't otherwise tell which apps it affects
+
+			if matchesProject(node, event) {
+				w.logf(job, "Found project %s matching %s. Deploying the apps it changed...", node.ProjectConfig.Project, description)
+				matchedProjCount++
+			}
+			return nil
+		},
+		EndProjectCb: func(ctx model.TraverseAppTreeContext, node model.ProjectAtFsNode) error {
+			if matchesProject(node, event) {
+				matchedProjCount--
+			}
+			return nil
+		},
+	})
+
+	if err != nil {
+		w.errorf(job, "Error traversing app tree: %v", err)
+		return err
+	}
+
+	// Apps that were deployed successfully are skipped as up to date when retrying
+	return errors.Join(deployErrs...)
+}
+
+func matchesSpec(source model.Source, event model.PushEvent) bool {
 	if source.Repo == "" {
 		return false
 	}
 	localKey := strings.ToLower(source.Repo)
-	remoteKeys := allEntriesBothWithAndWithoutGitSuffix([]string{
-		strings.ToLower(payload.Repository.Url),
-		strings.ToLower(payload.Repository.CloneUrl),
-		strings.ToLower(payload.Repository.HtmlUrl),
-		strings.ToLower(payload.Repository.GitUrl),
-		strings.ToLower(payload.Repository.SvnUrl),
-		strings.ToLower(payload.Repository.SshUrl),
-	})
-	return lo.Contains(remoteKeys, localKey)
+	remoteKeys := allEntriesBothWithAndWithoutGitSuffix(lo.Map(event.RepoUrls, func(url string, _ int) string {
+		return strings.ToLower(url)
+	}))
+	return lo.Contains(remoteKeys, localKey) && event.MatchesRef(source.Ref)
 }
 
 func allEntriesBothWithAndWithoutGitSuffix(entries []string) []string {
@@ -162,10 +536,25 @@ func allEntriesBothWithAndWithoutGitSuffix(entries []string) []string {
 	return lo.Uniq(append(normalized, withSuffix...))
 }
 
-func matchesApp(app model.AppAtFsNode, payload github.PushWebhookPayload) bool {
-	return matchesSpec(app.AppConfig.Source, payload)
+// matchesApp Apps sharing a repo with other apps (e.g. in a monorepo) are only deployed when the push changed their files
+func matchesApp(app model.AppAtFsNode, event model.PushEvent) bool {
+	return matchesSpec(app.AppConfig.Source, event) && event.TouchesAny(app.AppConfig.WatchedPaths())
+}
+
+// touchesAppConfig Tells if the push to the repo of a project changed the config of one of its apps.
+// If we can't tell where the app is in the repo, it is assumed to have changed.
+func touchesAppConfig(ctx context.Context, app model.AppAtFsNode, event model.PushEvent) bool {
+	if event.ChangedFiles == nil {
+		return true
+	}
+	configDir, err := util_git.RepoRelativeDir(ctx, app.Path)
+	if err != nil {
+		slog.WarnContext(ctx, "Could not find the app in the pushed repo, deploying it to be sure", util_log.KeyApp, app.AppConfig.App, "error", err)
+		return true
+	}
+	return event.TouchesAny(app.AppConfig.WatchedConfigPaths(configDir))
 }
 
-func matchesProject(project model.ProjectAtFsNode, payload github.PushWebhookPayload) bool {
-	return matchesSpec(project.ProjectConfig.Source, payload)
+func matchesProject(project model.ProjectAtFsNode, event model.PushEvent) bool {
+	return matchesSpec(project.ProjectConfig.Source, event)
 }
@@ -5,9 +5,16 @@ import (
 	"fmt"
 	"github.com/gigurra/flycd/mocks/domain"
 	"github.com/gigurra/flycd/pkg/domain/model"
+	"github.com/gigurra/flycd/pkg/ext/fly_client"
 	"github.com/gigurra/flycd/pkg/ext/github"
+	"github.com/gigurra/flycd/pkg/ext/gitlab"
+	"github.com/gigurra/flycd/pkg/util/util_log"
+	"github.com/gigurra/flycd/pkg/util/util_work_dir"
 	"github.com/stretchr/testify/mock"
+	"log/slog"
+	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -37,7 +44,7 @@ func TestWebHookService(t *testing.T) {
 
 			fakeDeployService := domain.NewMockDeployService(t)
 			webhookService := NewWebHookService(fakeDeployService)
-			err := webhookService.Start(ctx)
+			err := webhookService.Start(ctx, model.NewDefaultWorkerConfig())
 			if err != nil {
 				t.Fatalf("Failed to start webhook service: %v", err)
 			}
@@ -74,6 +81,396 @@ func TestWebHookService(t *testing.T) {
 
 }
 
+func TestWebHookService_gitlab(t *testing.T) {
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	fakeDeployService := domain.NewMockDeployService(t)
+	webhookService := NewWebHookService(fakeDeployService)
+	err := webhookService.Start(ctx, model.NewDefaultWorkerConfig())
+	if err != nil {
+		t.Fatalf("Failed to start webhook service: %v", err)
+	}
+
+	expPath, err := filepath.Abs("../../test/test-projects/webhooks/regular/app1")
+	if err != nil {
+		t.Fatalf("Failed to get abs path: %v", err)
+	}
+
+	fakeDeployService.
+		EXPECT().
+		DeployAppFromFolder(mock.Anything, expPath, mock.Anything, mock.Anything).
+		Return(model.SingleAppDeployUpdated, nil)
+
+	event := gitlab.PushEvent{
+		ObjectKind: "push",
+		Ref:        "refs/heads/main",
+		Project: gitlab.Project{
+			DefaultBranch:     "main",
+			PathWithNamespace: "TestUser/TestRepo",
+			WebUrl:            "https://github.com/TestUser/TestRepo",
+			GitSshUrl:         "git@github.com:TestUser/TestRepo.git",
+			GitHttpUrl:        "https://github.com/TestUser/TestRepo.git",
+		},
+	}
+
+	ch := webhookService.HandlePushEvent(event.ToPushEvent(), "../../test/test-projects/webhooks/regular")
+
+	select {
+	case err := <-ch:
+		if err != nil {
+			t.Fatalf("Failed to handle webhook: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for webhook to be handled")
+	}
+}
+
+func TestWebHookService_refs(t *testing.T) {
+
+	path := "../../test/test-projects/webhooks/refs"
+
+	for _, test := range []struct {
+		ref           string
+		defaultBranch string
+		expectedApps  []string
+	}{
+		{ref: "refs/heads/main", defaultBranch: "main", expectedApps: []string{"default"}},
+		{ref: "refs/heads/release", defaultBranch: "main", expectedApps: []string{"branch"}},
+		{ref: "refs/tags/v1.0.0", defaultBranch: "main", expectedApps: []string{"tag"}},
+		{ref: "refs/heads/feature", defaultBranch: "main", expectedApps: []string{}},
+		{ref: "refs/tags/v2.0.0", defaultBranch: "main", expectedApps: []string{}},
+		// when the provider doesn't tell us the default branch, any branch may be it
+		{ref: "refs/heads/main", expectedApps: []string{"default"}},
+		{ref: "refs/heads/release", expectedApps: []string{"branch", "default"}},
+		{ref: "refs/tags/v1.0.0", expectedApps: []string{"tag"}},
+	} {
+		t.Run(test.ref+"@"+test.defaultBranch, func(t *testing.T) {
+			testPushEventDeploys(t, path, model.PushEvent{
+				Provider:      "test",
+				RepoUrls:      []string{"git@github.com:TestUser/TestRepo.git"},
+				Ref:           test.ref,
+				DefaultBranch: test.defaultBranch,
+			}, test.expectedApps)
+		})
+	}
+}
+
+func TestWebHookService_changedFiles(t *testing.T) {
+
+	path := "../../test/test-projects/webhooks/monorepo"
+
+	for _, test := range []struct {
+		name         string
+		changedFiles []string
+		expectedApps []string
+	}{
+		{name: "source path", changedFiles: []string{"services/api/main.go"}, expectedApps: []string{"api"}},
+		{name: "watched dir", changedFiles: []string{"libs/ui/button.tsx"}, expectedApps: []string{"web"}},
+		{name: "watched glob", changedFiles: []string{"services/api/main.go", "protos/api.proto"}, expectedApps: []string{"api", "web"}},
+		{name: "unrelated", changedFiles: []string{"README.md", "services/api2/main.go"}, expectedApps: []string{}},
+		{name: "unknown", changedFiles: nil, expectedApps: []string{"api", "web"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			testPushEventDeploys(t, path, model.PushEvent{
+				Provider:      "test",
+				RepoUrls:      []string{"git@github.com:TestUser/MonoRepo.git"},
+				Ref:           "refs/heads/main",
+				DefaultBranch: "main",
+				ChangedFiles:  test.changedFiles,
+			}, test.expectedApps)
+		})
+	}
+}
+
+// testPushEventDeploys Checks that exactly the expected apps (dirs under path) are deployed
+func testPushEventDeploys(t *testing.T, path string, event model.PushEvent, expectedApps []string) {
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	fakeDeployService := domain.NewMockDeployService(t)
+	webhookService := NewWebHookService(fakeDeployService)
+	err := webhookService.Start(ctx, model.NewDefaultWorkerConfig())
+	if err != nil {
+		t.Fatalf("Failed to start webhook service: %v", err)
+	}
+
+	for _, app := range expectedApps {
+		expPath, err := filepath.Abs(filepath.Join(path, app))
+		if err != nil {
+			t.Fatalf("Failed to get abs path: %v", err)
+		}
+		fakeDeployService.
+			EXPECT().
+			DeployAppFromFolder(mock.Anything, expPath, mock.Anything, mock.Anything).
+			Return(model.SingleAppDeployUpdated, nil)
+	}
+
+	select {
+	case err := <-webhookService.HandlePushEvent(event, path):
+		if err != nil {
+			t.Fatalf("Failed to handle webhook: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for webhook to be handled")
+	}
+}
+
+func TestWebHookService_retriesAndDeadLetters(t *testing.T) {
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	fakeDeployService := domain.NewMockDeployService(t)
+	webhookService := NewWebHookService(fakeDeployService)
+	err := webhookService.Start(ctx, model.NewDefaultWorkerConfig().WithMaxAttempts(2).WithRetryBackoff(time.Millisecond, time.Millisecond))
+	if err != nil {
+		t.Fatalf("Failed to start webhook service: %v", err)
+	}
+
+	fakeDeployService.
+		EXPECT().
+		DeployAppFromFolder(mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return("", fmt.Errorf("remote builder unavailable")).
+		Times(2)
+
+	select {
+	case err := <-webhookService.HandleGithubWebhook(generateTestPushWebhookPayload(), "../../test/test-projects/webhooks/regular"):
+		if err == nil {
+			t.Fatalf("expected the job to fail")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for webhook to be handled")
+	}
+
+	dead, err := webhookService.DeadJobs()
+	if err != nil || len(dead) != 1 || dead[0].Attempts != 2 {
+		t.Fatalf("expected the job to be dead after 2 attempts, got %+v, err=%v", dead, err)
+	}
+}
+
+func TestWebHookService_resumesAfterRestart(t *testing.T) {
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	path := "../../test/test-projects/webhooks/regular"
+	event := generateTestPushWebhookPayload().ToPushEvent()
+
+	// Left behind by a previous run: one job that never started, and one that was
+	// interrupted on its last attempt (e.g. crashed flycd), which must not run again
+	queue, err := NewFileJobQueue(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileJobQueue failed: %v", err)
+	}
+	interrupted := model.NewPushJob(event, path)
+	interrupted.Attempts = 3
+	_, _ = queue.Push(interrupted)
+	_, _ = queue.Push(model.NewPushJob(event, path))
+
+	expPath, err := filepath.Abs("../../test/test-projects/webhooks/regular/app1")
+	if err != nil {
+		t.Fatalf("Failed to get abs path: %v", err)
+	}
+	fakeDeployService := domain.NewMockDeployService(t)
+	deployed := make(chan struct{})
+	fakeDeployService.
+		EXPECT().
+		DeployAppFromFolder(mock.Anything, expPath, mock.Anything, mock.Anything).
+		Run(func(_ context.Context, _ string, _ model.DeployConfig, _ *model.PreCalculatedAppConfig) {
+			close(deployed)
+		}).
+		Return(model.SingleAppDeployUpdated, nil).
+		Once()
+
+	webhookService := NewWebHookService(fakeDeployService)
+	err = webhookService.Start(ctx, model.NewDefaultWorkerConfig().WithQueue(queue))
+	if err != nil {
+		t.Fatalf("Failed to start webhook service: %v", err)
+	}
+
+	select {
+	case <-deployed:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for the resumed job")
+	}
+	webhookService.CloseJobQueue()
+
+	pending, _ := queue.Pending()
+	dead, _ := queue.DeadLetters()
+	if len(pending) != 0 || len(dead) != 1 || dead[0].Attempts != 3 {
+		t.Fatalf("expected only the interrupted job to be dead, got pending=%+v, dead=%+v", pending, dead)
+	}
+}
+
+func TestWebHookService_mergesQueuedPushes(t *testing.T) {
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	path := "../../test/test-projects/webhooks/regular"
+	fakeDeployService := domain.NewMockDeployService(t)
+	webhookService := NewWebHookService(fakeDeployService)
+	err := webhookService.Start(ctx, model.NewDefaultWorkerConfig())
+	if err != nil {
+		t.Fatalf("Failed to start webhook service: %v", err)
+	}
+
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	fakeDeployService.
+		EXPECT().
+		DeployAppFromFolder(mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Run(func(_ context.Context, _ string, _ model.DeployConfig, _ *model.PreCalculatedAppConfig) {
+			started <- struct{}{}
+			<-release
+		}).
+		Return(model.SingleAppDeployUpdated, nil).
+		Times(2)
+
+	push := func(commit string) <-chan error {
+		payload := generateTestPushWebhookPayload()
+		payload.After = commit
+		return webhookService.HandleGithubWebhook(payload, path)
+	}
+
+	first := push("111")
+	<-started
+
+	// While the first push is deploying, a burst of pushes only gives one follow-up deploy, of the latest commit
+	followUps := []<-chan error{push("222"), push("333"), push("444")}
+
+	pending, err := webhookService.PendingJobs()
+	if err != nil || len(pending) != 2 {
+		t.Fatalf("expected the running job and one follow-up, got %+v, err=%v", pending, err)
+	}
+	if pending[1].Absorbed != 2 || pending[1].PushEvent.Commit != "444" {
+		t.Fatalf("expected the follow-up to have absorbed the later pushes, got %+v", pending[1])
+	}
+
+	close(release)
+	for _, ch := range append(followUps, first) {
+		select {
+		case err := <-ch:
+			if err != nil {
+				t.Fatalf("Failed to handle webhook: %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for webhook to be handled")
+		}
+	}
+}
+
+func TestWebHookService_parallelWorkers(t *testing.T) {
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	fakeDeployService := domain.NewMockDeployService(t)
+	webhookService := NewWebHookService(fakeDeployService)
+	err := webhookService.Start(ctx, model.NewDefaultWorkerConfig().WithWorkers(2))
+	if err != nil {
+		t.Fatalf("Failed to start webhook service: %v", err)
+	}
+
+	// Each deploy waits for the other to start, so this only finishes if they run in parallel
+	started := make(chan struct{}, 2)
+	fakeDeployService.
+		EXPECT().
+		DeployAppFromFolder(mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Run(func(_ context.Context, _ string, _ model.DeployConfig, _ *model.PreCalculatedAppConfig) {
+			started <- struct{}{}
+			for len(started) < 2 {
+				time.Sleep(10 * time.Millisecond)
+			}
+		}).
+		Return(model.SingleAppDeployUpdated, nil).
+		Times(2)
+
+	results := []<-chan error{
+		webhookService.HandlePushEvent(model.PushEvent{
+			Provider: "test",
+			RepoUrls: []string{"git@github.com:TestUser/TestRepo.git"},
+			Ref:      "refs/tags/v1.0.0",
+		}, "../../test/test-projects/webhooks/refs"),
+		webhookService.HandlePushEvent(model.PushEvent{
+			Provider:      "test",
+			RepoUrls:      []string{"git@github.com:TestUser/MonoRepo.git"},
+			Ref:           "refs/heads/main",
+			DefaultBranch: "main",
+			ChangedFiles:  []string{"services/api/main.go"},
+		}, "../../test/test-projects/webhooks/monorepo"),
+	}
+
+	for _, ch := range results {
+		select {
+		case err := <-ch:
+			if err != nil {
+				t.Fatalf("Failed to handle webhook: %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for webhooks to be handled in parallel")
+		}
+	}
+}
+
+// waitingFlyClient Lets no deploy finish until `parallel` deploys have started, and fails them if that takes too long
+type waitingFlyClient struct {
+	*fly_client.FlyClientSim
+	started  chan struct{}
+	parallel int
+}
+
+func (c *waitingFlyClient) DeployExistingApp(
+	ctx context.Context,
+	cfg model.AppConfig,
+	tempDir util_work_dir.WorkDir,
+	deployCfg model.DeployConfig,
+	region string,
+) error {
+	c.started <- struct{}{}
+	deadline := time.Now().Add(2 * time.Second)
+	for len(c.started) < c.parallel {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("app %s was not deployed in parallel with another app", cfg.App)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return c.FlyClientSim.DeployExistingApp(ctx, cfg, tempDir, deployCfg, region)
+}
+
+func TestWebHookService_parallelSync(t *testing.T) {
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	flyClient := &waitingFlyClient{FlyClientSim: fly_client.NewFlyClientSim(), started: make(chan struct{}, 2), parallel: 2}
+	webhookService := NewWebHookService(NewDeployService(flyClient))
+	err := webhookService.Start(ctx, model.NewDefaultWorkerConfig().WithWorkers(2).WithOrgLimits(map[string]int{"*": 1}))
+	if err != nil {
+		t.Fatalf("Failed to start webhook service: %v", err)
+	}
+
+	// The apps are in different orgs, so the org limit of 1 doesn't keep them from being deployed at once
+	select {
+	case err := <-webhookService.EnqueueJob(model.NewSyncJob("../../test/test-projects/webhooks/orgs")):
+		if err != nil {
+			t.Fatalf("Failed to sync: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for the sync")
+	}
+
+	state := flyClient.State()
+	for _, appName := range []string{"app-team-a", "app-team-b"} {
+		if app, ok := state.Apps[appName]; !ok || app.Deploys != 1 {
+			t.Fatalf("expected app %s to be deployed once, got %+v", appName, app)
+		}
+	}
+}
+
 func generateTestPushWebhookPayloadWithoutGitSuffix() github.PushWebhookPayload {
 	result := generateTestPushWebhookPayload()
 	result.Repository.GitUrl = "git://github.com/TestUser/TestRepo"
@@ -124,3 +521,282 @@ func generateTestPushWebhookPayload() github.PushWebhookPayload {
 
 	return testPayload
 }
+
+func TestWebHookService_submitJobAndLog(t *testing.T) {
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	fakeDeployService := domain.NewMockDeployService(t)
+	deployed := make(chan struct{})
+	fakeDeployService.
+		EXPECT().
+		DeployAll(mock.Anything, "projects", mock.Anything).
+		Run(func(ctx context.Context, _ string, deployCfg model.DeployConfig) {
+			if deployCfg.Only.App != "app1" || deployCfg.Trigger != model.TriggerApi {
+				t.Errorf("expected a sync of app1 triggered by the api, got %+v", deployCfg)
+			}
+			slog.WarnContext(util_log.With(ctx, util_log.KeyApp, "app1"), "Scaling up instance count in region", "region", "arn")
+			close(deployed)
+		}).
+		Return(model.DeployResult{}, nil).
+		Once()
+
+	doneJobs := make(chan model.Job, 1)
+	webhookService := NewWebHookService(fakeDeployService)
+	previous := slog.Default()
+	slog.SetDefault(slog.New(webhookService.LogHandler(slog.NewTextHandler(os.Stderr, nil))))
+	defer slog.SetDefault(previous)
+	err := webhookService.Start(ctx, model.NewDefaultWorkerConfig().WithOnJobDone(func(job model.Job) { doneJobs <- job }))
+	if err != nil {
+		t.Fatalf("Failed to start webhook service: %v", err)
+	}
+
+	job, err := webhookService.SubmitJob(model.NewSyncJob("projects").WithOnly(model.AppFilter{App: "app1"}).WithTrigger(model.TriggerApi))
+	if err != nil || job.Id == "" {
+		t.Fatalf("expected the job to be queued with an id, got %+v, err=%v", job, err)
+	}
+
+	select {
+	case <-deployed:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for the submitted job")
+	}
+	webhookService.CloseJobQueue()
+	if done := <-doneJobs; done.Id != job.Id {
+		t.Fatalf("expected to be told that the job is done, got %+v", done)
+	}
+
+	lines, found := webhookService.JobLog(job.Id)
+	if !found || !strings.Contains(strings.Join(lines, "\n"), "Syncing/Deploying app app1 in projects") {
+		t.Fatalf("expected the job log to describe the sync, got %v", lines)
+	}
+	// Also what the deploy logged, not only the worker
+	if !strings.Contains(strings.Join(lines, "\n"), "WARN Scaling up instance count in region app=app1 region=arn") {
+		t.Fatalf("expected the job log to include the deploy's own log lines, got %v", lines)
+	}
+	if _, found = webhookService.JobLog("unknown"); found {
+		t.Fatalf("expected no log for an unknown job")
+	}
+}
+
+func TestWebHookService_filteredSyncDoesNotWaitForPushes(t *testing.T) {
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	fakeDeployService := domain.NewMockDeployService(t)
+	webhookService := NewWebHookService(fakeDeployService)
+	err := webhookService.Start(ctx, model.NewDefaultWorkerConfig().WithWorkers(2))
+	if err != nil {
+		t.Fatalf("Failed to start webhook service: %v", err)
+	}
+
+	pushStarted := make(chan struct{})
+	releasePush := make(chan struct{})
+	fakeDeployService.
+		EXPECT().
+		DeployAppFromFolder(mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Run(func(_ context.Context, _ string, _ model.DeployConfig, _ *model.PreCalculatedAppConfig) {
+			close(pushStarted)
+			<-releasePush
+		}).
+		Return(model.SingleAppDeployUpdated, nil).
+		Once()
+	synced := make(chan struct{})
+	fakeDeployService.
+		EXPECT().
+		DeployAll(mock.Anything, "projects", mock.Anything).
+		Run(func(_ context.Context, _ string, deployCfg model.DeployConfig) {
+			if deployCfg.LockApp == nil {
+				t.Errorf("expected a sync of some of the apps to lock each app it deploys")
+			}
+			close(synced)
+		}).
+		Return(model.DeployResult{}, nil).
+		Once()
+
+	pushed := webhookService.HandleGithubWebhook(generateTestPushWebhookPayload(), "../../test/test-projects/webhooks/regular")
+	<-pushStarted
+
+	// Only a sync of the whole tree waits for the running push
+	webhookService.EnqueueJob(model.NewSyncJob("projects").WithOnly(model.AppFilter{App: "app1"}))
+	select {
+	case <-synced:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for the sync of app1 while the push was deploying")
+	}
+
+	close(releasePush)
+	select {
+	case err := <-pushed:
+		if err != nil {
+			t.Fatalf("Failed to handle webhook: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for webhook to be handled")
+	}
+}
+
+func TestWebHookService_correlationIds(t *testing.T) {
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	fakeDeployService := domain.NewMockDeployService(t)
+	deployCtxs := make(chan context.Context, 2)
+	fakeDeployService.
+		EXPECT().
+		DeployAll(mock.Anything, "projects", mock.Anything).
+		Run(func(ctx context.Context, _ string, _ model.DeployConfig) {
+			deployCtxs <- ctx
+		}).
+		Return(model.DeployResult{}, nil).
+		Twice()
+
+	webhookService := NewWebHookService(fakeDeployService)
+	err := webhookService.Start(ctx, model.NewDefaultWorkerConfig())
+	if err != nil {
+		t.Fatalf("Failed to start webhook service: %v", err)
+	}
+
+	given, err := webhookService.SubmitJob(model.NewSyncJob("projects").WithCorrelationId("abc123"))
+	if err != nil || given.CorrelationId != "abc123" {
+		t.Fatalf("expected the job to keep its correlation id, got %+v, err=%v", given, err)
+	}
+	select {
+	case deployCtx := <-deployCtxs:
+		if cid := util_log.CorrelationId(deployCtx); cid != "abc123" {
+			t.Fatalf("expected the deploy to be logged with correlation id abc123, got '%s'", cid)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for the first job")
+	}
+
+	generated, err := webhookService.SubmitJob(model.NewSyncJob("projects"))
+	if err != nil || generated.CorrelationId == "" || generated.CorrelationId == "abc123" {
+		t.Fatalf("expected the job to get a new correlation id, got %+v, err=%v", generated, err)
+	}
+	select {
+	case deployCtx := <-deployCtxs:
+		if cid := util_log.CorrelationId(deployCtx); cid != generated.CorrelationId {
+			t.Fatalf("expected the deploy to be logged with correlation id %s, got '%s'", generated.CorrelationId, cid)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for the second job")
+	}
+	webhookService.CloseJobQueue()
+}
+
+func TestWebHookService_changedFilesInProject(t *testing.T) {
+
+	repo, _ := newGitRepo(t, map[string]string{
+		"apps/a/app.yaml":     "app: a\norg: personal\nprimary_region: arn\nsource:\n  type: local\n  path: ../../src/a\n",
+		"apps/b/app.yaml":     "app: b\norg: personal\nprimary_region: arn\nsource:\n  type: git\n  repo: git@github.com:TestUser/Other.git\n",
+		"apps/b/fly.toml":     "",
+		"src/a/Dockerfile":    "FROM scratch",
+		"README.md":           "",
+		"apps/c/project.yaml": "project: nested\nsource:\n  type: local\n",
+	})
+	path := t.TempDir()
+	projectYaml := "project: mono\nsource:\n  type: git\n  repo: " + repo + "\n  path: apps\n"
+	err := os.WriteFile(filepath.Join(path, "project.yaml"), []byte(projectYaml), 0644)
+	if err != nil {
+		t.Fatalf("failed to write project.yaml: %v", err)
+	}
+
+	for _, test := range []struct {
+		name         string
+		changedFiles []string
+		expectedApps []string
+	}{
+		{name: "local source", changedFiles: []string{"src/a/Dockerfile"}, expectedApps: []string{"a"}},
+		{name: "app config", changedFiles: []string{"apps/b/fly.toml"}, expectedApps: []string{"b"}},
+		{name: "project config", changedFiles: []string{"apps/c/project.yaml"}, expectedApps: []string{"a", "b"}},
+		{name: "unrelated", changedFiles: []string{"README.md"}, expectedApps: []string{}},
+		{name: "unknown", changedFiles: nil, expectedApps: []string{"a", "b"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancelFunc := context.WithCancel(context.Background())
+			defer cancelFunc()
+
+			fakeDeployService := domain.NewMockDeployService(t)
+			webhookService := NewWebHookService(fakeDeployService)
+			err := webhookService.Start(ctx, model.NewDefaultWorkerConfig())
+			if err != nil {
+				t.Fatalf("Failed to start webhook service: %v", err)
+			}
+
+			for _, app := range test.expectedApps {
+				fakeDeployService.
+					EXPECT().
+					DeployAppFromFolder(mock.Anything, mock.MatchedBy(func(path string) bool {
+						return strings.HasSuffix(path, filepath.Join("apps", app))
+					}), mock.Anything, mock.Anything).
+					Return(model.SingleAppDeployUpdated, nil)
+			}
+
+			event := model.PushEvent{
+				Provider:      "test",
+				RepoUrls:      []string{repo},
+				Ref:           "refs/heads/main",
+				DefaultBranch: "main",
+				ChangedFiles:  test.changedFiles,
+			}
+			select {
+			case err := <-webhookService.HandlePushEvent(event, path):
+				if err != nil {
+					t.Fatalf("Failed to handle webhook: %v", err)
+				}
+			case <-time.After(10 * time.Second):
+				t.Fatalf("Timed out waiting for webhook to be handled")
+			}
+		})
+	}
+}
+
+func TestWebHookService_waitsBeforeRetrying(t *testing.T) {
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	fakeDeployService := domain.NewMockDeployService(t)
+	webhookService := NewWebHookService(fakeDeployService)
+	backoff := 300 * time.Millisecond
+	err := webhookService.Start(ctx, model.NewDefaultWorkerConfig().WithRetryBackoff(backoff, time.Minute))
+	if err != nil {
+		t.Fatalf("Failed to start webhook service: %v", err)
+	}
+
+	attempts := make(chan time.Time, 2)
+	fakeDeployService.
+		EXPECT().
+		DeployAppFromFolder(mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Run(func(_ context.Context, _ string, _ model.DeployConfig, _ *model.PreCalculatedAppConfig) {
+			attempts <- time.Now()
+		}).
+		Return("", fmt.Errorf("remote builder unavailable")).
+		Once()
+	fakeDeployService.
+		EXPECT().
+		DeployAppFromFolder(mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Run(func(_ context.Context, _ string, _ model.DeployConfig, _ *model.PreCalculatedAppConfig) {
+			attempts <- time.Now()
+		}).
+		Return(model.SingleAppDeployUpdated, nil).
+		Once()
+
+	select {
+	case err := <-webhookService.HandleGithubWebhook(generateTestPushWebhookPayload(), "../../test/test-projects/webhooks/regular"):
+		if err != nil {
+			t.Fatalf("expected the retry to succeed, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for webhook to be handled")
+	}
+
+	first, second := <-attempts, <-attempts
+	if second.Sub(first) < backoff {
+		t.Fatalf("expected the retry to wait at least %v, it waited %v", backoff, second.Sub(first))
+	}
+}
@@ -0,0 +1,109 @@
+package bitbucket
+
+import (
+	"net/url"
+	"strings"
+)
+
+const (
+	EventHeader     = "X-Event-Key"
+	RequestHeader   = "X-Request-UUID"
+	SignatureHeader = "X-Hub-Signature"
+
+	EventPush = "repo:push"
+)
+
+type Link struct {
+	Href string `json:"href"`
+}
+
+type RepositoryLinks struct {
+	Html Link `json:"html"`
+}
+
+type Branch struct {
+	Name string `json:"name"`
+}
+
+type Repository struct {
+	Uuid       string          `json:"uuid"`
+	Name       string          `json:"name"`
+	FullName   string          `json:"full_name"`
+	Links      RepositoryLinks `json:"links"`
+	MainBranch *Branch         `json:"mainbranch"` // not always included in webhook payloads
+}
+
+// CommitAuthor Raw is the git author, e.g. "Jane Doe <jane@example.com>". User is only set if it is a bitbucket user.
+type CommitAuthor struct {
+	Raw  string `json:"raw"`
+	User *Actor `json:"user"`
+}
+
+type Target struct {
+	Hash    string       `json:"hash"`
+	Message string       `json:"message"`
+	Author  CommitAuthor `json:"author"`
+}
+
+type Ref struct {
+	Type   string `json:"type"` // "branch" or "tag"
+	Name   string `json:"name"`
+	Target Target `json:"target"`
+}
+
+type Change struct {
+	New     *Ref `json:"new"` // nil when the branch or tag was deleted
+	Old     *Ref `json:"old"`
+	Created bool `json:"created"`
+	Closed  bool `json:"closed"`
+}
+
+type Push struct {
+	Changes []Change `json:"changes"`
+}
+
+type Actor struct {
+	DisplayName string `json:"display_name"`
+}
+
+// PushEvent is the payload of Bitbucket Cloud's repo:push webhook. One push can update several refs.
+type PushEvent struct {
+	Push       Push       `json:"push"`
+	Repository Repository `json:"repository"`
+	Actor      Actor      `json:"actor"`
+}
+
+// DefaultBranch The repo's main branch, or "" if the payload doesn't tell us
+func (e PushEvent) DefaultBranch() string {
+	if e.Repository.MainBranch == nil {
+		return ""
+	}
+	return e.Repository.MainBranch.Name
+}
+
+// Author Of the head commit of the change. Falls back to whoever pushed
+func (e PushEvent) Author(change Change) string {
+	author := change.New.Target.Author
+	if author.User != nil && author.User.DisplayName != "" {
+		return author.User.DisplayName
+	}
+	if name, _, _ := strings.Cut(author.Raw, " <"); strings.TrimSpace(name) != "" {
+		return strings.TrimSpace(name)
+	}
+	return e.Actor.DisplayName
+}
+
+// RepoUrls Bitbucket only sends the web url, so we derive the clone urls from it
+func (e PushEvent) RepoUrls() []string {
+	htmlUrl := strings.TrimSuffix(e.Repository.Links.Html.Href, "/")
+	result := []string{htmlUrl}
+	if parsed, err := url.Parse(htmlUrl); err == nil && parsed.Host != "" {
+		path := strings.TrimPrefix(parsed.Path, "/")
+		result = append(result,
+			"https://"+parsed.Host+"/"+path+".git",
+			"git@"+parsed.Host+":"+path+".git",
+			"ssh://git@"+parsed.Host+"/"+path+".git",
+		)
+	}
+	return result
+}
@@ -0,0 +1,81 @@
+package bitbucket
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gigurra/flycd/pkg/domain/model"
+	"github.com/gigurra/flycd/pkg/util/util_hmac"
+	"net/http"
+	"strings"
+)
+
+var ErrMissingSignature = errors.New("missing " + SignatureHeader + " header")
+var ErrInvalidSignature = errors.New("webhook signature does not match any configured secret")
+
+// Provider Bitbucket Cloud repo:push webhooks
+type Provider struct{}
+
+func (p Provider) Name() string {
+	return "bitbucket"
+}
+
+func (p Provider) Detect(header http.Header) bool {
+	return header.Get(EventHeader) != ""
+}
+
+// Verify Bitbucket Cloud signs webhooks with a secret like GitHub does, as sha256=<hex hmac of body>
+func (p Provider) Verify(header http.Header, body []byte, secrets []string) error {
+	signature := header.Get(SignatureHeader)
+	if signature == "" {
+		return ErrMissingSignature
+	}
+	hexSignature, found := strings.CutPrefix(signature, "sha256=")
+	if !found || !util_hmac.VerifySha256(body, hexSignature, secrets) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func (p Provider) Parse(header http.Header, body []byte) ([]model.PushEvent, error) {
+	if header.Get(EventHeader) != EventPush {
+		return []model.PushEvent{}, nil
+	}
+
+	var payload PushEvent
+	err := json.Unmarshal(body, &payload)
+	if err != nil {
+		return nil, fmt.Errorf("error deserializing bitbucket webhook payload: %w", err)
+	}
+
+	result := make([]model.PushEvent, 0, len(payload.Push.Changes))
+	for _, change := range payload.Push.Changes {
+		if change.New == nil {
+			continue // deleted branch or tag
+		}
+		result = append(result, model.PushEvent{
+			Provider: p.Name(),
+			Id:       header.Get(RequestHeader),
+			Repo:     payload.Repository.FullName,
+			RepoUrls: payload.RepoUrls(),
+			Ref:      refName(*change.New),
+			Commit:   change.New.Target.Hash,
+			Author:   payload.Author(change),
+			// Without it, no branch counts as the default one, so only sources tracking a branch by name are deployed
+			DefaultBranch: payload.DefaultBranch(),
+			// Bitbucket doesn't tell us which files the pushed commits changed, so they are all assumed to have
+			ChangedFiles: nil,
+		})
+	}
+
+	return result, nil
+}
+
+func refName(ref Ref) string {
+	switch ref.Type {
+	case "tag":
+		return "refs/tags/" + ref.Name
+	default:
+		return "refs/heads/" + ref.Name
+	}
+}
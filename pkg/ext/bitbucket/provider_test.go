@@ -0,0 +1,117 @@
+package bitbucket
+
+import (
+	"errors"
+	"github.com/gigurra/flycd/pkg/domain/model"
+	"github.com/gigurra/flycd/pkg/util/util_hmac"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Trimmed down from https://support.atlassian.com/bitbucket-cloud/docs/event-payloads/#Push
+const pushEventBlob = `{
+  "actor": {"display_name": "Emma"},
+  "repository": {
+    "name": "repo_name",
+    "full_name": "team_name/repo_name",
+    "uuid": "{1234}",
+    "links": {"html": {"href": "https://bitbucket.org/team_name/repo_name"}},
+    "mainbranch": {"name": "main"}
+  },
+  "push": {
+    "changes": [
+      {
+        "new": {"type": "branch", "name": "main", "target": {
+          "hash": "709d658dc5b6d6afcd46049c2f332ee3f515a67d",
+          "author": {"raw": "Jane Doe <jane@example.com>"}
+        }},
+        "old": {"type": "branch", "name": "main", "target": {"hash": "1e65c05c1d5171631d92438a13901ca7dae9618c"}},
+        "created": false,
+        "closed": false
+      },
+      {
+        "new": {"type": "tag", "name": "v1.0.0", "target": {"hash": "709d658dc5b6d6afcd46049c2f332ee3f515a67d"}},
+        "old": null,
+        "created": true,
+        "closed": false
+      },
+      {
+        "new": null,
+        "old": {"type": "branch", "name": "old-feature", "target": {"hash": "1e65c05c1d5171631d92438a13901ca7dae9618c"}},
+        "created": false,
+        "closed": true
+      }
+    ]
+  }
+}`
+
+func TestProvider_Parse(t *testing.T) {
+	header := http.Header{}
+	header.Set(EventHeader, EventPush)
+
+	events, err := Provider{}.Parse(header, []byte(pushEventBlob))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("expected the deleted branch to be skipped, got %+v", events)
+	}
+	if events[0].Branch() != "main" || events[1].Tag() != "v1.0.0" {
+		t.Fatalf("unexpected refs: %s, %s", events[0].Ref, events[1].Ref)
+	}
+	if events[0].Commit != "709d658dc5b6d6afcd46049c2f332ee3f515a67d" {
+		t.Fatalf("unexpected commit: %s", events[0].Commit)
+	}
+	if events[0].Author != "Jane Doe" || events[1].Author != "Emma" {
+		t.Fatalf("unexpected authors: %s, %s", events[0].Author, events[1].Author)
+	}
+	if events[0].DefaultBranch != "main" || !events[0].IsDefaultBranch() {
+		t.Fatalf("expected main to be the default branch, got %q", events[0].DefaultBranch)
+	}
+	if events[0].ChangedFiles != nil {
+		t.Fatalf("expected all files to be assumed changed, got %v", events[0].ChangedFiles)
+	}
+	expectedUrls := []string{
+		"https://bitbucket.org/team_name/repo_name",
+		"https://bitbucket.org/team_name/repo_name.git",
+		"git@bitbucket.org:team_name/repo_name.git",
+		"ssh://git@bitbucket.org/team_name/repo_name.git",
+	}
+	if !reflect.DeepEqual(events[0].RepoUrls, expectedUrls) {
+		t.Fatalf("expected %v, got %v", expectedUrls, events[0].RepoUrls)
+	}
+
+	withoutMainBranch := strings.Replace(pushEventBlob, `"mainbranch": {"name": "main"}`, `"mainbranch": null`, 1)
+	events, err = Provider{}.Parse(header, []byte(withoutMainBranch))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if events[0].DefaultBranch != "" || !events[0].MatchesRef(model.GitRef{}) {
+		t.Fatalf("expected sources tracking the default branch to match when bitbucket doesn't tell us which it is")
+	}
+
+	header.Set(EventHeader, "repo:fork")
+	events, err = Provider{}.Parse(header, []byte(pushEventBlob))
+	if err != nil || len(events) != 0 {
+		t.Fatalf("expected other events to be ignored, got %+v, err=%v", events, err)
+	}
+}
+
+func TestProvider_Verify(t *testing.T) {
+	body := []byte(pushEventBlob)
+	header := http.Header{}
+
+	if err := (Provider{}).Verify(header, body, []string{"secret"}); !errors.Is(err, ErrMissingSignature) {
+		t.Fatalf("expected ErrMissingSignature, got %v", err)
+	}
+
+	header.Set(SignatureHeader, "sha256="+util_hmac.SignSha256(body, "secret"))
+	if err := (Provider{}).Verify(header, body, []string{"other", "secret"}); err != nil {
+		t.Fatalf("expected a valid signature, got %v", err)
+	}
+	if err := (Provider{}).Verify(header, body, []string{"other"}); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("expected ErrInvalidSignature, got %v", err)
+	}
+}
@@ -0,0 +1,52 @@
+package fly_client
+
+import (
+	"fmt"
+)
+
+type Backend string
+
+const (
+	BackendCli Backend = "cli" // shells out to the fly cli (default)
+	BackendApi Backend = "api" // talks to the fly.io Machines/GraphQL APIs directly
+	BackendSim Backend = "sim" // simulated in-memory fly.io, for dry runs and tests
+)
+
+var AllBackends = []Backend{BackendCli, BackendApi, BackendSim}
+
+// RequiresFlyCli tells if the fly cli must be installed for the backend to work
+func (b Backend) RequiresFlyCli() bool {
+	return b == BackendCli
+}
+
+func NewFlyClientForBackend(backend Backend) (FlyClient, error) {
+	switch backend {
+	case BackendCli, "":
+		return NewFlyClient(), nil
+	case BackendApi:
+		return NewFlyApiClient(NewDefaultFlyApiConfig()), nil
+	case BackendSim:
+		return NewFlyClientSim(), nil
+	default:
+		return nil, fmt.Errorf("unknown fly backend '%s', must be one of %v", backend, AllBackends)
+	}
+}
+
+// SelectableFlyClient lets us hand out a FlyClient to all components at startup,
+// and decide which backend it actually uses later, once cli flags have been parsed.
+type SelectableFlyClient struct {
+	FlyClient
+}
+
+func NewSelectableFlyClient() *SelectableFlyClient {
+	return &SelectableFlyClient{FlyClient: NewFlyClient()}
+}
+
+func (s *SelectableFlyClient) Select(backend Backend) error {
+	client, err := NewFlyClientForBackend(backend)
+	if err != nil {
+		return err
+	}
+	s.FlyClient = client
+	return nil
+}
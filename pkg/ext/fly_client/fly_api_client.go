@@ -0,0 +1,839 @@
+package fly_client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gigurra/flycd/pkg/domain/model"
+	"github.com/gigurra/flycd/pkg/util/util_work_dir"
+	"github.com/samber/lo"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FlyApiClientImpl talks to the fly.io Machines REST API and GraphQL API directly,
+// instead of shelling out to the fly cli. The only thing it can't do on its own is
+// building images from source, for which it falls back to the fly cli (if installed).
+type FlyApiClientImpl struct {
+	cfg FlyApiConfig
+	cli FlyClient
+}
+
+func NewFlyApiClient(cfg FlyApiConfig) FlyClient {
+	return FlyApiClientImpl{
+		cfg: cfg,
+		cli: FlyClientImpl{},
+	}
+}
+
+var _ FlyClient = FlyApiClientImpl{}
+
+const defaultProcessGroup = "app"
+
+type apiAppListItem struct {
+	Name         string `json:"name"`
+	Organization struct {
+		Slug string `json:"slug"`
+	} `json:"organization"`
+}
+
+type apiMachine struct {
+	Id     string          `json:"id"`
+	Name   string          `json:"name"`
+	State  string          `json:"state"`
+	Region string          `json:"region"`
+	Config json.RawMessage `json:"config"`
+}
+
+type apiGuest struct {
+	CpuKind  string `json:"cpu_kind"`
+	Cpus     int    `json:"cpus"`
+	MemoryMb int    `json:"memory_mb"`
+}
+
+type apiMount struct {
+	Volume string `json:"volume"`
+	Path   string `json:"path"`
+	Name   string `json:"name,omitempty"`
+}
+
+type apiMachineConfig struct {
+	Image    string            `json:"image"`
+	Env      map[string]string `json:"env"`
+	Guest    apiGuest          `json:"guest"`
+	Metadata map[string]string `json:"metadata"`
+	Mounts   []apiMount        `json:"mounts"`
+}
+
+func (m apiMachine) typedConfig() apiMachineConfig {
+	var cfg apiMachineConfig
+	_ = json.Unmarshal(m.Config, &cfg) // best effort, missing fields are just left empty
+	return cfg
+}
+
+func (m apiMachine) rawConfig() (map[string]any, error) {
+	cfg := map[string]any{}
+	if len(m.Config) == 0 {
+		return cfg, nil
+	}
+	err := json.Unmarshal(m.Config, &cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing config of machine %s: %w", m.Id, err)
+	}
+	return cfg, nil
+}
+
+func (m apiMachine) processGroup() string {
+	group := m.typedConfig().Metadata["fly_process_group"]
+	if group == "" {
+		return defaultProcessGroup
+	}
+	return group
+}
+
+type apiVolume struct {
+	model.VolumeState
+	AttachedMachineId *string `json:"attached_machine_id"`
+}
+
+// orgId Looks up the id of the org, which is what mutations want, rather than the slug used everywhere else
+func (c FlyApiClientImpl) orgId(ctx context.Context, orgSlug string) (string, error) {
+	var org struct {
+		Organization struct {
+			Id string `json:"id"`
+		} `json:"organization"`
+	}
+	err := c.graphQl(ctx, `query($slug: String!) { organization(slug: $slug) { id } }`, map[string]any{
+		"slug": orgSlug,
+	}, &org)
+	if err != nil {
+		return "", fmt.Errorf("error looking up org %s: %w", orgSlug, err)
+	}
+	return org.Organization.Id, nil
+}
+
+func (c FlyApiClientImpl) CreateOrgToken(ctx context.Context, orgSlug string) (string, error) {
+
+	orgId, err := c.orgId(ctx, orgSlug)
+	if err != nil {
+		return "", err
+	}
+
+	var res struct {
+		CreateLimitedAccessToken struct {
+			LimitedAccessToken struct {
+				TokenHeader string `json:"tokenHeader"`
+			} `json:"limitedAccessToken"`
+		} `json:"createLimitedAccessToken"`
+	}
+	err = c.graphQl(ctx, `mutation($input: CreateLimitedAccessTokenInput!) {
+		createLimitedAccessToken(input: $input) { limitedAccessToken { tokenHeader } }
+	}`, map[string]any{
+		"input": map[string]any{
+			"name":           "flycd",
+			"organizationId": orgId,
+			"profile":        "deploy_organization",
+			// no expiry, to get fly.io's default. An empty one is not a valid duration
+		},
+	}, &res)
+	if err != nil {
+		return "", fmt.Errorf("error creating token for org %s: %w", orgSlug, err)
+	}
+
+	token := strings.TrimSpace(res.CreateLimitedAccessToken.LimitedAccessToken.TokenHeader)
+	if token == "" {
+		return "", fmt.Errorf("fly.io api returned an empty token for org %s", orgSlug)
+	}
+
+	return token, nil
+}
+
+type apiSecretListItem struct {
+	Name      string    `json:"name"`
+	Digest    string    `json:"digest"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func (c FlyApiClientImpl) listSecrets(ctx context.Context, app string) ([]apiSecretListItem, error) {
+	var res struct {
+		App struct {
+			Secrets []apiSecretListItem `json:"secrets"`
+		} `json:"app"`
+	}
+	err := c.graphQl(ctx, `query($app: String!) { app(name: $app) { secrets { name digest createdAt } } }`, map[string]any{
+		"app": app,
+	}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return res.App.Secrets, nil
+}
+
+func (c FlyApiClientImpl) ExistsSecret(ctx context.Context, cmd ExistsSecretCmd) (bool, error) {
+
+	if cmd.SecretName == "" {
+		return false, fmt.Errorf("secret name cannot be empty")
+	}
+
+	secrets, err := c.listSecrets(ctx, cmd.AppName)
+	if err != nil {
+		return false, fmt.Errorf("error listing secrets for '%s': %w", cmd.AppName, err)
+	}
+
+	return lo.ContainsBy(secrets, func(item apiSecretListItem) bool {
+		return item.Name == cmd.SecretName
+	}), nil
+}
+
+func (c FlyApiClientImpl) StoreSecret(ctx context.Context, cmd StoreSecretCmd) error {
+
+	if cmd.SecretName == "" {
+		return fmt.Errorf("secret name cannot be empty")
+	}
+
+	if cmd.SecretValue == "" {
+		return fmt.Errorf("secret value cannot be empty")
+	}
+
+	return c.SaveSecrets(ctx, cmd.AppName, []Secret{{Name: cmd.SecretName, Value: cmd.SecretValue}}, false)
+}
+
+// SaveSecrets The graphql api never restarts any machines, so secrets are
+// always effectively staged until the next deploy, regardless of 'stage'
+func (c FlyApiClientImpl) SaveSecrets(
+	ctx context.Context,
+	app string,
+	secrets []Secret,
+	_ bool,
+) error {
+
+	input := lo.Map(secrets, func(s Secret, _ int) map[string]string {
+		return map[string]string{"key": s.Name, "value": s.Value}
+	})
+
+	err := c.graphQl(ctx, `mutation($input: SetSecretsInput!) { setSecrets(input: $input) { app { name } } }`, map[string]any{
+		"input": map[string]any{
+			"appId":      app,
+			"secrets":    input,
+			"replaceAll": false,
+		},
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("error setting secrets for app %s: %w", app, err)
+	}
+
+	return nil
+}
+
+func (c FlyApiClientImpl) ExistsApp(ctx context.Context, name string) (bool, error) {
+	err := c.machinesRequest(ctx, http.MethodGet, "/apps/"+url.PathEscape(name), nil, nil)
+	if err != nil {
+		if IsNotFound(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("error checking if app %s exists: %w", name, err)
+	}
+	return true, nil
+}
+
+func (c FlyApiClientImpl) listMachines(ctx context.Context, app string) ([]apiMachine, error) {
+	machines := make([]apiMachine, 0)
+	err := c.machinesRequest(ctx, http.MethodGet, "/apps/"+url.PathEscape(app)+"/machines", nil, &machines)
+	if err != nil {
+		return nil, fmt.Errorf("error listing machines for app %s: %w", app, err)
+	}
+	return machines, nil
+}
+
+func (c FlyApiClientImpl) listAppMachines(ctx context.Context, app string) ([]apiMachine, error) {
+	machines, err := c.listMachines(ctx, app)
+	if err != nil {
+		return nil, err
+	}
+	return lo.Filter(machines, func(m apiMachine, _ int) bool {
+		return m.processGroup() == defaultProcessGroup
+	}), nil
+}
+
+func (c FlyApiClientImpl) GetDeployedAppConfig(ctx context.Context, name string) (model.AppConfig, error) {
+
+	machines, err := c.listAppMachines(ctx, name)
+	if err != nil {
+		return model.AppConfig{}, err
+	}
+
+	// Same as the fly cli: no machines means nothing deployed yet
+	if len(machines) == 0 {
+		return model.AppConfig{Env: map[string]string{}}, nil
+	}
+
+	machineCfg := machines[0].typedConfig()
+	if machineCfg.Env == nil {
+		machineCfg.Env = map[string]string{}
+	}
+
+	return model.AppConfig{
+		App:           name,
+		PrimaryRegion: machines[0].Region,
+		Env:           machineCfg.Env,
+	}, nil
+}
+
+func (c FlyApiClientImpl) listVolumes(ctx context.Context, name string) ([]apiVolume, error) {
+	volumes := make([]apiVolume, 0)
+	err := c.machinesRequest(ctx, http.MethodGet, "/apps/"+url.PathEscape(name)+"/volumes", nil, &volumes)
+	if err != nil {
+		return nil, fmt.Errorf("error listing volumes for app %s: %w", name, err)
+	}
+	return volumes, nil
+}
+
+func (c FlyApiClientImpl) GetAppVolumes(ctx context.Context, name string) ([]model.VolumeState, error) {
+	volumes, err := c.listVolumes(ctx, name)
+	if err != nil {
+		return []model.VolumeState{}, err
+	}
+	return lo.Map(volumes, func(v apiVolume, _ int) model.VolumeState {
+		return v.VolumeState
+	}), nil
+}
+
+func (c FlyApiClientImpl) CreateNewApp(
+	ctx context.Context,
+	cfg model.AppConfig,
+	tempDir util_work_dir.WorkDir,
+	twoStep bool,
+) error {
+
+	orgSlug := cfg.Org
+	if orgSlug == "" {
+		orgSlug = "personal"
+	}
+
+	err := c.machinesRequest(ctx, http.MethodPost, "/apps", map[string]any{
+		"app_name": cfg.App,
+		"org_slug": orgSlug,
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("error creating app %s: %w", cfg.App, err)
+	}
+
+	if twoStep {
+		return nil
+	}
+
+	return c.DeployExistingApp(ctx, cfg, tempDir, model.NewDefaultDeployConfig(), cfg.PrimaryRegion)
+}
+
+// DeployExistingApp Apps with a pre-built image (build.image) are deployed by updating/creating
+// machines directly. Everything else needs an image built by fly.io's remote builders,
+// which we can only reach through the fly cli.
+func (c FlyApiClientImpl) DeployExistingApp(
+	ctx context.Context,
+	cfg model.AppConfig,
+	tempDir util_work_dir.WorkDir,
+	deployCfg model.DeployConfig,
+	region string,
+) error {
+
+	image, _ := cfg.Build["image"].(string)
+	if image == "" {
+		if _, err := exec.LookPath("fly"); err != nil {
+			return fmt.Errorf("error deploying app %s without build.image: %w", cfg.App, ErrCliRequired)
+		}
+		return c.cli.DeployExistingApp(ctx, cfg, tempDir, deployCfg, region)
+	}
+
+	machines, err := c.listAppMachines(ctx, cfg.App)
+	if err != nil {
+		return fmt.Errorf("error deploying app %s: %w", cfg.App, err)
+	}
+
+	if len(machines) == 0 {
+		machineCfg, err := c.newMachineConfig(ctx, cfg, image, region)
+		if err != nil {
+			return fmt.Errorf("error deploying app %s: %w", cfg.App, err)
+		}
+		return c.createMachine(ctx, cfg.App, region, machineCfg)
+	}
+
+	for _, machine := range machines {
+		machineCfg, err := machine.rawConfig()
+		if err != nil {
+			return fmt.Errorf("error deploying app %s: %w", cfg.App, err)
+		}
+		machineCfg["image"] = image
+		machineCfg["env"] = cfg.Env
+		if services := machineServices(cfg); len(services) > 0 {
+			machineCfg["services"] = services
+		}
+		err = c.updateMachine(ctx, cfg.App, machine, machineCfg)
+		if err != nil {
+			return fmt.Errorf("error deploying app %s: %w", cfg.App, err)
+		}
+	}
+
+	return nil
+}
+
+func (c FlyApiClientImpl) newMachineConfig(
+	ctx context.Context,
+	cfg model.AppConfig,
+	image string,
+	region string,
+) (map[string]any, error) {
+
+	guest := apiGuest{CpuKind: "shared", Cpus: 1, MemoryMb: 256}
+	if cfg.Machines.CpuType != "" {
+		guest.CpuKind = cfg.Machines.CpuType
+	}
+	if cfg.Machines.CpuCores > 0 {
+		guest.Cpus = cfg.Machines.CpuCores
+	}
+	if cfg.Machines.RamMB > 0 {
+		guest.MemoryMb = cfg.Machines.RamMB
+	}
+
+	mounts, err := c.pickVolumesForMounts(ctx, cfg.App, cfg.Mounts, region)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]any{
+		"image":    image,
+		"env":      cfg.Env,
+		"guest":    guest,
+		"services": machineServices(cfg),
+		"mounts":   mounts,
+		"metadata": map[string]string{"fly_process_group": defaultProcessGroup},
+	}, nil
+}
+
+// pickVolumesForMounts finds an unattached volume in the region for each mount
+func (c FlyApiClientImpl) pickVolumesForMounts(
+	ctx context.Context,
+	app string,
+	mounts []model.Mount,
+	region string,
+) ([]apiMount, error) {
+
+	if len(mounts) == 0 {
+		return []apiMount{}, nil
+	}
+
+	volumes, err := c.listVolumes(ctx, app)
+	if err != nil {
+		return nil, err
+	}
+
+	taken := map[string]bool{}
+	result := make([]apiMount, 0, len(mounts))
+	for _, mount := range mounts {
+		volume, found := lo.Find(volumes, func(v apiVolume) bool {
+			return v.Name == mount.Source &&
+				v.Region == region &&
+				(v.AttachedMachineId == nil || *v.AttachedMachineId == "") &&
+				!taken[v.ID]
+		})
+		if !found {
+			return nil, fmt.Errorf("no unattached volume named %s in region %s for app %s", mount.Source, region, app)
+		}
+		taken[volume.ID] = true
+		result = append(result, apiMount{Volume: volume.ID, Path: mount.Destination, Name: mount.Source})
+	}
+
+	return result, nil
+}
+
+func machineServices(cfg model.AppConfig) []map[string]any {
+
+	result := make([]map[string]any, 0)
+
+	for _, svc := range cfg.Services {
+		ports := lo.Map(svc.Ports, func(p model.Port, _ int) map[string]any {
+			return map[string]any{"port": p.Port, "handlers": p.Handlers, "force_https": p.ForceHttps}
+		})
+		machineSvc := map[string]any{
+			"protocol":             svc.Protocol,
+			"internal_port":        svc.InternalPort,
+			"autostop":             svc.AutoStopMachines,
+			"autostart":            svc.AutoStartMachines,
+			"min_machines_running": svc.MinMachinesRunning,
+			"ports":                ports,
+		}
+		if svc.Concurrency.Type != "" {
+			machineSvc["concurrency"] = map[string]any{
+				"type":       svc.Concurrency.Type,
+				"soft_limit": svc.Concurrency.SoftLimit,
+				"hard_limit": svc.Concurrency.HardLimit,
+			}
+		}
+		result = append(result, machineSvc)
+	}
+
+	if cfg.HttpService != nil && !cfg.HttpService.IsEmpty() {
+		httpSvc := map[string]any{
+			"protocol":             "tcp",
+			"internal_port":        cfg.HttpService.InternalPort,
+			"autostop":             cfg.HttpService.AutoStopMachines,
+			"autostart":            cfg.HttpService.AutoStartMachines,
+			"min_machines_running": cfg.HttpService.MinMachinesRunning,
+			"ports": []map[string]any{
+				{"port": 80, "handlers": []string{"http"}, "force_https": cfg.HttpService.ForceHttps},
+				{"port": 443, "handlers": []string{"tls", "http"}},
+			},
+		}
+		if cfg.HttpService.Concurrency.Type != "" {
+			httpSvc["concurrency"] = map[string]any{
+				"type":       cfg.HttpService.Concurrency.Type,
+				"soft_limit": cfg.HttpService.Concurrency.SoftLimit,
+				"hard_limit": cfg.HttpService.Concurrency.HardLimit,
+			}
+		}
+		result = append(result, httpSvc)
+	}
+
+	return result
+}
+
+func (c FlyApiClientImpl) createMachine(ctx context.Context, app string, region string, machineCfg map[string]any) error {
+	err := c.machinesRequest(ctx, http.MethodPost, "/apps/"+url.PathEscape(app)+"/machines", map[string]any{
+		"region": region,
+		"config": machineCfg,
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("error creating machine for app %s in region %s: %w", app, region, err)
+	}
+	return nil
+}
+
+func (c FlyApiClientImpl) updateMachine(ctx context.Context, app string, machine apiMachine, machineCfg map[string]any) error {
+	err := c.machinesRequest(ctx, http.MethodPost, "/apps/"+url.PathEscape(app)+"/machines/"+url.PathEscape(machine.Id), map[string]any{
+		"region": machine.Region,
+		"config": machineCfg,
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("error updating machine %s for app %s: %w", machine.Id, app, err)
+	}
+	return nil
+}
+
+func (c FlyApiClientImpl) destroyMachine(ctx context.Context, app string, machine apiMachine) error {
+	err := c.machinesRequest(ctx, http.MethodDelete, "/apps/"+url.PathEscape(app)+"/machines/"+url.PathEscape(machine.Id)+"?force=true", nil, nil)
+	if err != nil {
+		return fmt.Errorf("error destroying machine %s for app %s: %w", machine.Id, app, err)
+	}
+	return nil
+}
+
+func (c FlyApiClientImpl) CreateVolume(
+	ctx context.Context,
+	app string,
+	cfg model.VolumeConfig,
+	region string,
+) (model.VolumeState, error) {
+
+	var volume model.VolumeState
+	err := c.machinesRequest(ctx, http.MethodPost, "/apps/"+url.PathEscape(app)+"/volumes", map[string]any{
+		"name":    cfg.Name,
+		"region":  region,
+		"size_gb": cfg.SizeGb,
+	}, &volume)
+	if err != nil {
+		return model.VolumeState{}, fmt.Errorf("error creating volume %s for app %s: %w", cfg.Name, app, err)
+	}
+
+	return volume, nil
+}
+
+func (c FlyApiClientImpl) ExtendVolume(
+	ctx context.Context,
+	appName string,
+	volumeId string,
+	gb int,
+) error {
+
+	err := c.machinesRequest(ctx, http.MethodPut, "/apps/"+url.PathEscape(appName)+"/volumes/"+url.PathEscape(volumeId)+"/extend", map[string]any{
+		"size_gb": gb,
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("error extending volume %s for app %s: %w", volumeId, appName, err)
+	}
+
+	return nil
+}
+
+func (c FlyApiClientImpl) GetAppScale(ctx context.Context, app string) ([]model.ScaleState, error) {
+
+	machines, err := c.listMachines(ctx, app)
+	if err != nil {
+		return nil, err
+	}
+
+	byGroup := lo.GroupBy(machines, func(m apiMachine) string { return m.processGroup() })
+
+	result := make([]model.ScaleState, 0, len(byGroup))
+	for _, group := range lo.Keys(byGroup) {
+		groupMachines := byGroup[group]
+		guest := groupMachines[0].typedConfig().Guest
+		result = append(result, model.ScaleState{
+			Process:  group,
+			Count:    len(groupMachines),
+			CPUKind:  guest.CpuKind,
+			CPUs:     guest.Cpus,
+			MemoryMB: guest.MemoryMb,
+			Regions:  lo.CountValuesBy(groupMachines, func(m apiMachine) string { return m.Region }),
+		})
+	}
+
+	return result, nil
+}
+
+// ScaleApp Clones an existing machine of the app to scale up, like 'fly scale count' does
+func (c FlyApiClientImpl) ScaleApp(
+	ctx context.Context,
+	app string,
+	region string,
+	count int,
+) error {
+
+	machines, err := c.listAppMachines(ctx, app)
+	if err != nil {
+		return err
+	}
+
+	if len(machines) == 0 {
+		return fmt.Errorf("error scaling app %s: no machines to clone, deploy the app first", app)
+	}
+
+	inRegion := lo.Filter(machines, func(m apiMachine, _ int) bool { return m.Region == region })
+
+	for i := len(inRegion); i > count; i-- {
+		err := c.destroyMachine(ctx, app, inRegion[i-1])
+		if err != nil {
+			return err
+		}
+	}
+
+	template := machines[0]
+	if len(inRegion) > 0 {
+		template = inRegion[0]
+	}
+
+	for i := len(inRegion); i < count; i++ {
+		machineCfg, err := template.rawConfig()
+		if err != nil {
+			return err
+		}
+		mounts, err := c.pickVolumesForMounts(ctx, app, lo.Map(template.typedConfig().Mounts, func(m apiMount, _ int) model.Mount {
+			return model.Mount{Source: m.Name, Destination: m.Path}
+		}), region)
+		if err != nil {
+			return fmt.Errorf("error scaling app %s in region %s: %w", app, region, err)
+		}
+		machineCfg["mounts"] = mounts
+		err = c.createMachine(ctx, app, region, machineCfg)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c FlyApiClientImpl) updateAppMachineGuests(ctx context.Context, app string, update func(guest map[string]any)) error {
+
+	machines, err := c.listAppMachines(ctx, app)
+	if err != nil {
+		return err
+	}
+
+	for _, machine := range machines {
+		machineCfg, err := machine.rawConfig()
+		if err != nil {
+			return err
+		}
+		guest, ok := machineCfg["guest"].(map[string]any)
+		if !ok {
+			guest = map[string]any{}
+		}
+		update(guest)
+		machineCfg["guest"] = guest
+		err = c.updateMachine(ctx, app, machine, machineCfg)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c FlyApiClientImpl) ScaleAppRam(ctx context.Context, app string, ramMb int) error {
+	return c.updateAppMachineGuests(ctx, app, func(guest map[string]any) {
+		guest["memory_mb"] = ramMb
+	})
+}
+
+type vmSize struct {
+	CPUKind  string
+	CPUs     int
+	MemoryMB int // 0 leaves the memory as it is
+}
+
+// vmSizePresets The vm sizes of fly.io, as given to 'fly scale vm'
+var vmSizePresets = map[string]vmSize{
+	"shared-cpu-1x":   {CPUKind: "shared", CPUs: 1, MemoryMB: 256},
+	"shared-cpu-2x":   {CPUKind: "shared", CPUs: 2, MemoryMB: 512},
+	"shared-cpu-4x":   {CPUKind: "shared", CPUs: 4, MemoryMB: 1024},
+	"shared-cpu-8x":   {CPUKind: "shared", CPUs: 8, MemoryMB: 2048},
+	"performance-1x":  {CPUKind: "performance", CPUs: 1, MemoryMB: 2048},
+	"performance-2x":  {CPUKind: "performance", CPUs: 2, MemoryMB: 4096},
+	"performance-4x":  {CPUKind: "performance", CPUs: 4, MemoryMB: 8192},
+	"performance-8x":  {CPUKind: "performance", CPUs: 8, MemoryMB: 16384},
+	"performance-16x": {CPUKind: "performance", CPUs: 16, MemoryMB: 32768},
+}
+
+// vmSizeRegex Sizes like the ones flycd builds from cpu_type and cpu_cores, which only set the cpus
+var vmSizeRegex = regexp.MustCompile(`^([a-z]+)-cpu-(\d+)x$`)
+
+func parseVmSize(vm string) (vmSize, error) {
+	if preset, found := vmSizePresets[vm]; found {
+		return preset, nil
+	}
+	match := vmSizeRegex.FindStringSubmatch(vm)
+	if match == nil {
+		return vmSize{}, fmt.Errorf("unsupported vm size '%s'", vm)
+	}
+	cpus, err := strconv.Atoi(match[2])
+	if err != nil {
+		return vmSize{}, fmt.Errorf("unsupported vm size '%s': %w", vm, err)
+	}
+	return vmSize{CPUKind: match[1], CPUs: cpus}, nil
+}
+
+func (c FlyApiClientImpl) ScaleAppVm(ctx context.Context, app string, vm string) error {
+
+	size, err := parseVmSize(vm)
+	if err != nil {
+		return fmt.Errorf("error scaling app %s: %w", app, err)
+	}
+
+	return c.updateAppMachineGuests(ctx, app, func(guest map[string]any) {
+		guest["cpu_kind"] = size.CPUKind
+		guest["cpus"] = size.CPUs
+		if size.MemoryMB > 0 {
+			guest["memory_mb"] = size.MemoryMB
+		}
+	})
+}
+
+func (c FlyApiClientImpl) ListApps(ctx context.Context) ([]AppListItem, error) {
+
+	var res struct {
+		Apps struct {
+			Nodes []apiAppListItem `json:"nodes"`
+		} `json:"apps"`
+	}
+	err := c.graphQl(ctx, `query { apps { nodes { name organization { slug } } } }`, nil, &res)
+	if err != nil {
+		return nil, fmt.Errorf("error getting apps list. Do you have a token loaded?: %w", err)
+	}
+
+	return lo.Map(res.Apps.Nodes, func(item apiAppListItem, _ int) AppListItem {
+		return AppListItem{Name: item.Name, Org: item.Organization.Slug}
+	}), nil
+}
+
+type apiIpListItem struct {
+	Id        string    `json:"id"`
+	Address   string    `json:"address"`
+	Type      string    `json:"type"`
+	Region    string    `json:"region"`
+	CreatedAt time.Time `json:"createdAt"`
+	Network   *struct {
+		Name string `json:"name"`
+	} `json:"network"`
+}
+
+func (c FlyApiClientImpl) ListIps(ctx context.Context, app string) ([]IpListItem, error) {
+
+	var res struct {
+		App struct {
+			IpAddresses struct {
+				Nodes []apiIpListItem `json:"nodes"`
+			} `json:"ipAddresses"`
+		} `json:"app"`
+	}
+	err := c.graphQl(ctx, `query($app: String!) {
+		app(name: $app) { ipAddresses { nodes { id address type region createdAt network { name } } } }
+	}`, map[string]any{"app": app}, &res)
+	if err != nil {
+		return nil, fmt.Errorf("error getting ips list for app %s: %w", app, err)
+	}
+
+	return lo.Map(res.App.IpAddresses.Nodes, func(item apiIpListItem, _ int) IpListItem {
+		network := ""
+		if item.Network != nil {
+			network = item.Network.Name
+		}
+		return IpListItem{
+			Id:        item.Id,
+			Address:   item.Address,
+			Type:      item.Type,
+			Region:    item.Region,
+			Network:   network,
+			CreatedAt: item.CreatedAt,
+		}
+	}), nil
+}
+
+func (c FlyApiClientImpl) DeleteIp(ctx context.Context, app string, _ string, address string) error {
+	err := c.graphQl(ctx, `mutation($input: ReleaseIPAddressInput!) { releaseIpAddress(input: $input) { app { name } } }`, map[string]any{
+		"input": map[string]any{"appId": app, "ip": address},
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("error releasing ip %s for app %s: %w", address, app, err)
+	}
+	return nil
+}
+
+func (c FlyApiClientImpl) CreateIp(ctx context.Context, app string, ip model.IpConfig) error {
+
+	ipType := "v6"
+	switch {
+	case ip.V == model.IpV4 && ip.Shared:
+		ipType = "shared_v4"
+	case ip.V == model.IpV4:
+		ipType = "v4"
+	case ip.Private:
+		ipType = "private_v6"
+	}
+
+	input := map[string]any{"appId": app, "type": ipType}
+	if ip.Region != "" {
+		input["region"] = ip.Region
+	}
+	if ip.Network != "" {
+		input["network"] = ip.Network
+	}
+	if ip.Org != "" {
+		orgId, err := c.orgId(ctx, ip.Org)
+		if err != nil {
+			return err
+		}
+		input["organizationId"] = orgId
+	}
+
+	err := c.graphQl(ctx, `mutation($input: AllocateIPAddressInput!) { allocateIpAddress(input: $input) { app { name } } }`, map[string]any{
+		"input": input,
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("error allocating ip %+v for app %s: %w", ip, app, err)
+	}
+	return nil
+}
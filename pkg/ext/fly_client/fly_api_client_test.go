@@ -0,0 +1,323 @@
+package fly_client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/gigurra/flycd/pkg/domain/model"
+	"github.com/gigurra/flycd/pkg/util/util_work_dir"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type fakeFlyApi struct {
+	t        *testing.T
+	machines map[string][]map[string]any
+	requests []string
+	bodies   []map[string]any
+}
+
+func newFakeFlyApi(t *testing.T) (*fakeFlyApi, FlyClient) {
+	fake := &fakeFlyApi{
+		t:        t,
+		machines: map[string][]map[string]any{},
+	}
+	server := httptest.NewServer(http.HandlerFunc(fake.handle))
+	t.Cleanup(server.Close)
+
+	client := NewFlyApiClient(NewDefaultFlyApiConfig().
+		WithMachinesUrl(server.URL + "/v1").
+		WithGraphQlUrl(server.URL + "/graphql"))
+
+	return fake, client
+}
+
+func (f *fakeFlyApi) handle(w http.ResponseWriter, r *http.Request) {
+
+	if r.Header.Get("Authorization") != "Bearer test-token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+
+	body := map[string]any{}
+	bodyBytes, _ := io.ReadAll(r.Body)
+	if len(bodyBytes) > 0 {
+		if err := json.Unmarshal(bodyBytes, &body); err != nil {
+			f.t.Fatalf("invalid request body: %v", err)
+		}
+	}
+	f.bodies = append(f.bodies, body)
+
+	writeJson := func(v any) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(v)
+	}
+
+	switch {
+	case r.URL.Path == "/graphql":
+		query, _ := body["query"].(string)
+		switch {
+		case strings.Contains(query, "apps {"):
+			writeJson(map[string]any{"data": map[string]any{"apps": map[string]any{"nodes": []any{
+				map[string]any{"name": "app1", "organization": map[string]any{"slug": "personal"}},
+				map[string]any{"name": "app2", "organization": map[string]any{"slug": "other-org"}},
+			}}}})
+		case strings.Contains(query, "organization(slug"):
+			variables, _ := body["variables"].(map[string]any)
+			writeJson(map[string]any{"data": map[string]any{"organization": map[string]any{"id": "org-id-of-" + variables["slug"].(string)}}})
+		case strings.Contains(query, "createLimitedAccessToken"):
+			writeJson(map[string]any{"data": map[string]any{"createLimitedAccessToken": map[string]any{
+				"limitedAccessToken": map[string]any{"tokenHeader": "FlyV1 fm2_token"},
+			}}})
+		case strings.Contains(query, "secrets {"):
+			writeJson(map[string]any{"errors": []any{map[string]any{"message": "Could not find App"}}})
+		default:
+			writeJson(map[string]any{"data": map[string]any{}})
+		}
+	case r.URL.Path == "/v1/apps/existing-app":
+		writeJson(map[string]any{"name": "existing-app"})
+	case r.URL.Path == "/v1/apps/missing-app":
+		w.WriteHeader(http.StatusNotFound)
+		writeJson(map[string]any{"error": "app not found"})
+	case r.URL.Path == "/v1/apps/broken-app":
+		w.WriteHeader(http.StatusInternalServerError)
+		writeJson(map[string]any{"error": "boom"})
+	case strings.HasSuffix(r.URL.Path, "/machines") && r.Method == http.MethodGet:
+		app := strings.Split(r.URL.Path, "/")[3]
+		writeJson(f.machines[app])
+	case strings.HasSuffix(r.URL.Path, "/volumes") && r.Method == http.MethodPost:
+		writeJson(map[string]any{"id": "vol_123", "name": body["name"], "region": body["region"], "size_gb": body["size_gb"]})
+	default:
+		writeJson(map[string]any{})
+	}
+}
+
+func testCtx() context.Context {
+	return context.WithValue(context.Background(), "FLY_ACCESS_TOKEN", "test-token")
+}
+
+func TestFlyApiClient_ExistsApp(t *testing.T) {
+	_, client := newFakeFlyApi(t)
+
+	exists, err := client.ExistsApp(testCtx(), "existing-app")
+	if err != nil || !exists {
+		t.Fatalf("expected existing-app to exist, got exists=%v, err=%v", exists, err)
+	}
+
+	exists, err = client.ExistsApp(testCtx(), "missing-app")
+	if err != nil || exists {
+		t.Fatalf("expected missing-app to not exist, got exists=%v, err=%v", exists, err)
+	}
+
+	_, err = client.ExistsApp(testCtx(), "broken-app")
+	var apiErr *ApiError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("expected an ApiError with status 500, got %v", err)
+	}
+}
+
+func TestFlyApiClient_ListApps(t *testing.T) {
+	_, client := newFakeFlyApi(t)
+
+	apps, err := client.ListApps(testCtx())
+	if err != nil {
+		t.Fatalf("ListApps failed: %v", err)
+	}
+
+	expected := []AppListItem{{Name: "app1", Org: "personal"}, {Name: "app2", Org: "other-org"}}
+	if len(apps) != len(expected) || apps[0] != expected[0] || apps[1] != expected[1] {
+		t.Fatalf("expected %+v, got %+v", expected, apps)
+	}
+}
+
+func TestFlyApiClient_graphQlErrors(t *testing.T) {
+	_, client := newFakeFlyApi(t)
+
+	_, err := client.ExistsSecret(testCtx(), ExistsSecretCmd{AppName: "missing-app", SecretName: "FOO"})
+	var gqlErr *GraphQlError
+	if !errors.As(err, &gqlErr) || !IsNotFound(err) {
+		t.Fatalf("expected a not-found GraphQlError, got %v", err)
+	}
+}
+
+func TestFlyApiClient_missingToken(t *testing.T) {
+	_, client := newFakeFlyApi(t)
+	t.Setenv("FLY_API_TOKEN", "")
+	t.Setenv("FLY_ACCESS_TOKEN", "")
+	t.Setenv("HOME", t.TempDir())
+
+	_, err := client.ExistsApp(context.Background(), "existing-app")
+	if !errors.Is(err, ErrNoAccessToken) {
+		t.Fatalf("expected ErrNoAccessToken, got %v", err)
+	}
+}
+
+func TestFlyApiClient_GetAppScale(t *testing.T) {
+	fake, client := newFakeFlyApi(t)
+	fake.machines["my-app"] = []map[string]any{
+		{"id": "m1", "region": "arn", "config": map[string]any{"guest": map[string]any{"cpu_kind": "shared", "cpus": 1, "memory_mb": 512}}},
+		{"id": "m2", "region": "arn", "config": map[string]any{"guest": map[string]any{"cpu_kind": "shared", "cpus": 1, "memory_mb": 512}}},
+		{"id": "m3", "region": "ams", "config": map[string]any{"guest": map[string]any{"cpu_kind": "shared", "cpus": 1, "memory_mb": 512}}},
+		{"id": "m4", "region": "ams", "config": map[string]any{"metadata": map[string]any{"fly_process_group": "worker"}}},
+	}
+
+	scales, err := client.GetAppScale(testCtx(), "my-app")
+	if err != nil {
+		t.Fatalf("GetAppScale failed: %v", err)
+	}
+
+	perRegion := model.CountDeployedAppsPerRegion(scales)
+	if perRegion["arn"] != 2 || perRegion["ams"] != 1 {
+		t.Fatalf("unexpected app machines per region: %+v", perRegion)
+	}
+
+	for _, scale := range scales {
+		if scale.Process == "app" && (scale.MemoryMB != 512 || scale.CPUKind != "shared" || scale.Count != 3) {
+			t.Fatalf("unexpected app scale: %+v", scale)
+		}
+	}
+}
+
+func TestFlyApiClient_GetDeployedAppConfig(t *testing.T) {
+	fake, client := newFakeFlyApi(t)
+	fake.machines["my-app"] = []map[string]any{
+		{"id": "m1", "region": "arn", "config": map[string]any{"env": map[string]any{"FLYCD_APP_VERSION": "abc"}}},
+	}
+
+	cfg, err := client.GetDeployedAppConfig(testCtx(), "my-app")
+	if err != nil {
+		t.Fatalf("GetDeployedAppConfig failed: %v", err)
+	}
+	if cfg.Env["FLYCD_APP_VERSION"] != "abc" {
+		t.Fatalf("expected FLYCD_APP_VERSION=abc, got %+v", cfg.Env)
+	}
+
+	cfg, err = client.GetDeployedAppConfig(testCtx(), "no-machines-app")
+	if err != nil || cfg.Env == nil || len(cfg.Env) != 0 {
+		t.Fatalf("expected empty env for app without machines, got %+v, err=%v", cfg, err)
+	}
+}
+
+func TestFlyApiClient_ScaleAppVm(t *testing.T) {
+	fake, client := newFakeFlyApi(t)
+	fake.machines["my-app"] = []map[string]any{
+		{"id": "m1", "region": "arn", "config": map[string]any{"image": "nginx", "guest": map[string]any{"cpu_kind": "shared", "cpus": 1}}},
+	}
+
+	err := client.ScaleAppVm(testCtx(), "my-app", "performance-2x")
+	if err != nil {
+		t.Fatalf("ScaleAppVm failed: %v", err)
+	}
+
+	lastReq := fake.requests[len(fake.requests)-1]
+	if lastReq != "POST /v1/apps/my-app/machines/m1" {
+		t.Fatalf("expected machine update, got %s", lastReq)
+	}
+
+	cfg := fake.bodies[len(fake.bodies)-1]["config"].(map[string]any)
+	guest := cfg["guest"].(map[string]any)
+	if guest["cpu_kind"] != "performance" || guest["cpus"] != float64(2) || guest["memory_mb"] != float64(4096) || cfg["image"] != "nginx" {
+		t.Fatalf("unexpected updated machine config: %+v", cfg)
+	}
+
+	fake.machines["my-app"][0]["config"] = map[string]any{"image": "nginx", "guest": map[string]any{"cpu_kind": "shared", "cpus": 1, "memory_mb": 256}}
+	err = client.ScaleAppVm(testCtx(), "my-app", "shared-cpu-4x")
+	if err != nil {
+		t.Fatalf("ScaleAppVm failed: %v", err)
+	}
+	guest = fake.bodies[len(fake.bodies)-1]["config"].(map[string]any)["guest"].(map[string]any)
+	if guest["cpu_kind"] != "shared" || guest["cpus"] != float64(4) || guest["memory_mb"] != float64(1024) {
+		t.Fatalf("unexpected updated guest: %+v", guest)
+	}
+
+	// Sizes built from cpu_type and cpu_cores only change the cpus
+	err = client.ScaleAppVm(testCtx(), "my-app", "performance-cpu-3x")
+	if err != nil {
+		t.Fatalf("ScaleAppVm failed: %v", err)
+	}
+	guest = fake.bodies[len(fake.bodies)-1]["config"].(map[string]any)["guest"].(map[string]any)
+	if guest["cpu_kind"] != "performance" || guest["cpus"] != float64(3) || guest["memory_mb"] != float64(256) {
+		t.Fatalf("unexpected updated guest: %+v", guest)
+	}
+
+	err = client.ScaleAppVm(testCtx(), "my-app", "huge")
+	if err == nil {
+		t.Fatalf("expected error for invalid vm size")
+	}
+}
+
+func TestFlyApiClient_DeployImage(t *testing.T) {
+	fake, client := newFakeFlyApi(t)
+
+	cfg := model.AppConfig{
+		App:           "my-app",
+		PrimaryRegion: "arn",
+		Build:         map[string]any{"image": "nginx:latest"},
+		Env:           map[string]string{"FOO": "bar"},
+		Services:      []model.Service{model.NewDefaultServiceConfig()},
+	}
+
+	err := client.DeployExistingApp(testCtx(), cfg, util_work_dir.NewWorkDir(t.TempDir()), model.NewDefaultDeployConfig(), "arn")
+	if err != nil {
+		t.Fatalf("DeployExistingApp failed: %v", err)
+	}
+
+	lastReq := fake.requests[len(fake.requests)-1]
+	if lastReq != "POST /v1/apps/my-app/machines" {
+		t.Fatalf("expected machine creation, got %s", lastReq)
+	}
+
+	body := fake.bodies[len(fake.bodies)-1]
+	machineCfg := body["config"].(map[string]any)
+	if body["region"] != "arn" || machineCfg["image"] != "nginx:latest" || len(machineCfg["services"].([]any)) != 1 {
+		t.Fatalf("unexpected machine creation request: %+v", body)
+	}
+}
+
+func TestFlyApiClient_CreateVolume(t *testing.T) {
+	_, client := newFakeFlyApi(t)
+
+	volume, err := client.CreateVolume(testCtx(), "my-app", model.VolumeConfig{Name: "data", SizeGb: 10}, "arn")
+	if err != nil {
+		t.Fatalf("CreateVolume failed: %v", err)
+	}
+
+	if volume.ID != "vol_123" || volume.SizeGb != 10 || volume.Region != "arn" {
+		t.Fatalf("unexpected volume: %+v", volume)
+	}
+}
+
+func TestFlyApiClient_CreateIp(t *testing.T) {
+	fake, client := newFakeFlyApi(t)
+
+	err := client.CreateIp(testCtx(), "app1", model.IpConfig{V: model.IpV6, Private: true, Network: "net1", Org: "my-org"})
+	if err != nil {
+		t.Fatalf("CreateIp failed: %v", err)
+	}
+
+	variables, _ := fake.bodies[len(fake.bodies)-1]["variables"].(map[string]any)
+	input, _ := variables["input"].(map[string]any)
+	if input["type"] != "private_v6" || input["network"] != "net1" || input["organizationId"] != "org-id-of-my-org" {
+		t.Fatalf("expected a private ip in the org looked up by its slug, got %+v", input)
+	}
+}
+
+func TestFlyApiClient_CreateOrgToken(t *testing.T) {
+	fake, client := newFakeFlyApi(t)
+
+	token, err := client.CreateOrgToken(testCtx(), "my-org")
+	if err != nil || token != "FlyV1 fm2_token" {
+		t.Fatalf("expected the created token, got %s, err=%v", token, err)
+	}
+
+	variables, _ := fake.bodies[len(fake.bodies)-1]["variables"].(map[string]any)
+	input, _ := variables["input"].(map[string]any)
+	if _, hasExpiry := input["expiry"]; hasExpiry || input["organizationId"] != "org-id-of-my-org" {
+		t.Fatalf("expected a token for the org without an expiry, got %+v", input)
+	}
+}
@@ -0,0 +1,267 @@
+package fly_client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrNoAccessToken is returned by the api backend when no fly.io token could be found
+var ErrNoAccessToken = errors.New("no fly.io access token found (set FLY_ACCESS_TOKEN/FLY_API_TOKEN or log in with the fly cli)")
+
+// ErrCliRequired is returned by the api backend for operations it can only do through the fly cli
+// (e.g. building images from source with fly.io's remote builders), when the fly cli is not installed.
+var ErrCliRequired = errors.New("operation requires the fly cli, which is not installed")
+
+// ApiError is returned when the Machines or GraphQL API responds with a non-2xx status code
+type ApiError struct {
+	StatusCode int
+	Method     string
+	Url        string
+	Body       string
+}
+
+func (e *ApiError) Error() string {
+	return fmt.Sprintf("fly.io api %s %s returned %d: %s", e.Method, e.Url, e.StatusCode, strings.TrimSpace(e.Body))
+}
+
+func (e *ApiError) IsNotFound() bool {
+	return e.StatusCode == http.StatusNotFound
+}
+
+// GraphQlError is returned when the GraphQL API responds with errors in its response body
+type GraphQlError struct {
+	Messages []string
+}
+
+func (e *GraphQlError) Error() string {
+	return fmt.Sprintf("fly.io graphql api returned errors: %s", strings.Join(e.Messages, "; "))
+}
+
+func (e *GraphQlError) IsNotFound() bool {
+	for _, msg := range e.Messages {
+		if strings.Contains(strings.ToLower(msg), "could not find") ||
+			strings.Contains(strings.ToLower(msg), "not found") {
+			return true
+		}
+	}
+	return false
+}
+
+// IsNotFound checks if an error returned by the api backend means that the resource does not exist
+func IsNotFound(err error) bool {
+	var apiErr *ApiError
+	if errors.As(err, &apiErr) {
+		return apiErr.IsNotFound()
+	}
+	var gqlErr *GraphQlError
+	if errors.As(err, &gqlErr) {
+		return gqlErr.IsNotFound()
+	}
+	return false
+}
+
+type FlyApiConfig struct {
+	MachinesUrl string
+	GraphQlUrl  string
+	Timeout     time.Duration
+	HttpClient  *http.Client
+}
+
+func NewDefaultFlyApiConfig() FlyApiConfig {
+	return FlyApiConfig{
+		MachinesUrl: "https://api.machines.dev/v1",
+		GraphQlUrl:  "https://api.fly.io/graphql",
+		Timeout:     2 * time.Minute,
+		HttpClient:  http.DefaultClient,
+	}
+}
+
+func (c FlyApiConfig) WithMachinesUrl(url string) FlyApiConfig {
+	c.MachinesUrl = strings.TrimSuffix(url, "/")
+	return c
+}
+
+func (c FlyApiConfig) WithGraphQlUrl(url string) FlyApiConfig {
+	c.GraphQlUrl = url
+	return c
+}
+
+func (c FlyApiConfig) WithTimeout(timeout time.Duration) FlyApiConfig {
+	c.Timeout = timeout
+	return c
+}
+
+func (c FlyApiConfig) WithHttpClient(client *http.Client) FlyApiConfig {
+	c.HttpClient = client
+	return c
+}
+
+func (c FlyApiClientImpl) machinesRequest(
+	ctx context.Context,
+	method string,
+	path string,
+	body any,
+	result any,
+) error {
+	return c.doRequest(ctx, method, c.cfg.MachinesUrl+path, body, result)
+}
+
+type graphQlRequest struct {
+	Query     string         `json:"query"`
+	Variables map[string]any `json:"variables"`
+}
+
+type graphQlResponse struct {
+	Data   json.RawMessage `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+func (c FlyApiClientImpl) graphQl(
+	ctx context.Context,
+	query string,
+	variables map[string]any,
+	result any,
+) error {
+
+	var resp graphQlResponse
+	err := c.doRequest(ctx, http.MethodPost, c.cfg.GraphQlUrl, graphQlRequest{Query: query, Variables: variables}, &resp)
+	if err != nil {
+		return err
+	}
+
+	if len(resp.Errors) > 0 {
+		gqlErr := &GraphQlError{}
+		for _, e := range resp.Errors {
+			gqlErr.Messages = append(gqlErr.Messages, e.Message)
+		}
+		return gqlErr
+	}
+
+	if result != nil {
+		err = json.Unmarshal(resp.Data, result)
+		if err != nil {
+			return fmt.Errorf("error parsing fly.io graphql response: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (c FlyApiClientImpl) doRequest(
+	ctx context.Context,
+	method string,
+	url string,
+	body any,
+	result any,
+) error {
+
+	token, err := c.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+
+	var reqBody io.Reader
+	if body != nil {
+		bodyBytes, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("error marshalling request body for %s %s: %w", method, url, err)
+		}
+		reqBody = bytes.NewReader(bodyBytes)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
+	if err != nil {
+		return fmt.Errorf("error creating request %s %s: %w", method, url, err)
+	}
+	req.Header.Set("Authorization", authorizationHeader(token))
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.cfg.HttpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error calling fly.io api %s %s: %w", method, url, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	respBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error reading response of %s %s: %w", method, url, err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &ApiError{
+			StatusCode: resp.StatusCode,
+			Method:     method,
+			Url:        url,
+			Body:       string(respBytes),
+		}
+	}
+
+	if result != nil && len(bytes.TrimSpace(respBytes)) > 0 {
+		err = json.Unmarshal(respBytes, result)
+		if err != nil {
+			return fmt.Errorf("error parsing response of %s %s: %w", method, url, err)
+		}
+	}
+
+	return nil
+}
+
+func authorizationHeader(token string) string {
+	// Tokens created with 'fly tokens create' are macaroons that carry their own scheme
+	if strings.HasPrefix(token, "FlyV1 ") {
+		return token
+	}
+	return "Bearer " + token
+}
+
+func (c FlyApiClientImpl) accessToken(ctx context.Context) (string, error) {
+
+	if token := getAccessToken(ctx); token != "" {
+		return token, nil
+	}
+
+	for _, envVar := range []string{"FLY_API_TOKEN", "FLY_ACCESS_TOKEN"} {
+		if token := os.Getenv(envVar); token != "" {
+			return token, nil
+		}
+	}
+
+	// Fall back to whatever the fly cli has stored when logging in locally
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", ErrNoAccessToken
+	}
+
+	cfgBytes, err := os.ReadFile(filepath.Join(homeDir, ".fly", "config.yml"))
+	if err != nil {
+		return "", ErrNoAccessToken
+	}
+
+	var flyCfg struct {
+		AccessToken string `yaml:"access_token"`
+	}
+	err = yaml.Unmarshal(cfgBytes, &flyCfg)
+	if err != nil || flyCfg.AccessToken == "" {
+		return "", ErrNoAccessToken
+	}
+
+	return flyCfg.AccessToken, nil
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"github.com/GiGurra/cmder"
 	"github.com/gigurra/flycd/pkg/domain/model"
+	"github.com/gigurra/flycd/pkg/util/util_log"
 	"github.com/gigurra/flycd/pkg/util/util_tab_table"
 	"github.com/gigurra/flycd/pkg/util/util_work_dir"
 	"github.com/samber/lo"
@@ -572,13 +573,12 @@ func (c FlyClientImpl) StoreSecret(ctx context.Context, cmd StoreSecretCmd) erro
 		args = append(args, "-a", cmd.AppName)
 	}
 
-	res := cmder.
+	spec := cmder.
 		NewA("fly", args...).
 		WithExtraArgs(accessTokenArgs(ctx)...).
 		WithAttemptTimeout(240 * time.Second).
-		WithRetries(5).
-		WithStdOutErrForwarded().
-		Run(ctx)
+		WithRetries(5)
+	res := util_log.RunLogged(ctx, spec)
 	if res.Err != nil {
 		return fmt.Errorf("error running fly secrets set for '%s': %w", cmd.SecretName, res.Err)
 	}
@@ -667,13 +667,12 @@ func (c FlyClientImpl) CreateNewApp(
 	if !lo.Contains(allParams, "--region") && !lo.Contains(allParams, "-r") {
 		allParams = append(allParams, "--region", cfg.PrimaryRegion)
 	}
-	res := tempDir.
+	spec := tempDir.
 		NewCommand("fly", allParams...).
 		WithExtraArgs(accessTokenArgs(ctx)...).
 		WithAttemptTimeout(20 * time.Second).
-		WithRetries(5).
-		WithStdOutErrForwarded().
-		Run(ctx)
+		WithRetries(5)
+	res := util_log.RunLogged(ctx, spec)
 	if res.Err != nil {
 		return fmt.Errorf("error creating app %s: %w", cfg.App, res.Err)
 	}
@@ -693,13 +692,12 @@ func (c FlyClientImpl) DeployExistingApp(
 		allParams = append(allParams, "--region", region)
 	}
 
-	res := tempDir.
+	spec := tempDir.
 		NewCommand("fly", allParams...).
 		WithExtraArgs(accessTokenArgs(ctx)...).
 		WithAttemptTimeout(deployCfg.AttemptTimeout).
-		WithRetries(deployCfg.Retries).
-		WithStdOutErrForwarded().
-		Run(ctx)
+		WithRetries(deployCfg.Retries)
+	res := util_log.RunLogged(ctx, spec)
 	if res.Err != nil {
 		return fmt.Errorf("error deploying app %s: %w", cfg.App, res.Err)
 	}
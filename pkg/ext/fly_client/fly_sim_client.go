@@ -0,0 +1,552 @@
+package fly_client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gigurra/flycd/pkg/domain/model"
+	"github.com/gigurra/flycd/pkg/util/util_work_dir"
+	"github.com/samber/lo"
+	"net/netip"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrSimAppNotFound is returned by the simulated backend for operations on apps that don't exist
+var ErrSimAppNotFound = errors.New("could not find app")
+
+type SimMachine struct {
+	ID       string `json:"id"`
+	Region   string `json:"region"`
+	Process  string `json:"process"`
+	CPUKind  string `json:"cpu_kind"`
+	CPUs     int    `json:"cpus"`
+	MemoryMB int    `json:"memory_mb"`
+}
+
+type SimApp struct {
+	Name     string              `json:"name"`
+	Org      string              `json:"org"`
+	Config   *model.AppConfig    `json:"config,omitempty"` // nil until first deployed
+	Deploys  int                 `json:"deploys"`
+	Secrets  map[string]string   `json:"secrets"`
+	Volumes  []model.VolumeState `json:"volumes"`
+	Ips      []IpListItem        `json:"ips"`
+	Machines []SimMachine        `json:"machines"`
+}
+
+// SimState is the complete simulated state of all orgs
+type SimState struct {
+	Apps   map[string]*SimApp `json:"apps"`
+	NextId int                `json:"next_id"`
+}
+
+func NewEmptySimState() SimState {
+	return SimState{Apps: map[string]*SimApp{}}
+}
+
+// FlyClientSim is a stateful in-memory fly.io, for dry runs and end-to-end tests.
+// It mimics what the fly cli would do, e.g. creating missing volumes for mounts on deploy/scale.
+type FlyClientSim struct {
+	mutex       sync.Mutex
+	state       SimState
+	persistPath string
+}
+
+var _ FlyClient = &FlyClientSim{}
+
+func NewFlyClientSim() *FlyClientSim {
+	return NewFlyClientSimFromState(NewEmptySimState())
+}
+
+func NewFlyClientSimFromState(state SimState) *FlyClientSim {
+	if state.Apps == nil {
+		state.Apps = map[string]*SimApp{}
+	}
+	return &FlyClientSim{state: state}
+}
+
+// WithPersistence loads the simulated state from the file (if it exists),
+// and writes it back after every modification
+func (c *FlyClientSim) WithPersistence(path string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.persistPath = path
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("error reading sim state %s: %w", path, err)
+	}
+
+	state := NewEmptySimState()
+	err = json.Unmarshal(data, &state)
+	if err != nil {
+		return fmt.Errorf("error parsing sim state %s: %w", path, err)
+	}
+	if state.Apps == nil {
+		state.Apps = map[string]*SimApp{}
+	}
+	c.state = state
+
+	return nil
+}
+
+// State returns a deep copy of the current simulated state
+func (c *FlyClientSim) State() SimState {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	data, err := json.Marshal(c.state)
+	if err != nil {
+		panic(fmt.Errorf("BUG: could not serialize sim state: %w", err))
+	}
+
+	result := NewEmptySimState()
+	err = json.Unmarshal(data, &result)
+	if err != nil {
+		panic(fmt.Errorf("BUG: could not deserialize sim state: %w", err))
+	}
+
+	return result
+}
+
+// mutate runs fn under lock, and persists the state afterwards if fn succeeded
+func (c *FlyClientSim) mutate(fn func() error) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	err := fn()
+	if err != nil {
+		return err
+	}
+
+	if c.persistPath != "" {
+		data, err := json.MarshalIndent(c.state, "", "  ")
+		if err != nil {
+			return fmt.Errorf("error serializing sim state: %w", err)
+		}
+		err = os.WriteFile(c.persistPath, data, 0644)
+		if err != nil {
+			return fmt.Errorf("error writing sim state %s: %w", c.persistPath, err)
+		}
+	}
+
+	return nil
+}
+
+func (c *FlyClientSim) read(fn func() error) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return fn()
+}
+
+func (c *FlyClientSim) app(name string) (*SimApp, error) {
+	app, ok := c.state.Apps[name]
+	if !ok {
+		return nil, fmt.Errorf("app %s: %w", name, ErrSimAppNotFound)
+	}
+	return app, nil
+}
+
+func (c *FlyClientSim) nextId(prefix string) string {
+	c.state.NextId++
+	return fmt.Sprintf("%s_sim%d", prefix, c.state.NextId)
+}
+
+func (c *FlyClientSim) CreateOrgToken(_ context.Context, orgSlug string) (string, error) {
+	return "FlyV1 sim-token-" + orgSlug, nil
+}
+
+func (c *FlyClientSim) ExistsSecret(_ context.Context, cmd ExistsSecretCmd) (bool, error) {
+	if cmd.SecretName == "" {
+		return false, fmt.Errorf("secret name cannot be empty")
+	}
+	exists := false
+	err := c.read(func() error {
+		app, err := c.app(cmd.AppName)
+		if err != nil {
+			return err
+		}
+		_, exists = app.Secrets[cmd.SecretName]
+		return nil
+	})
+	return exists, err
+}
+
+func (c *FlyClientSim) StoreSecret(ctx context.Context, cmd StoreSecretCmd) error {
+	if cmd.SecretName == "" {
+		return fmt.Errorf("secret name cannot be empty")
+	}
+	if cmd.SecretValue == "" {
+		return fmt.Errorf("secret value cannot be empty")
+	}
+	return c.SaveSecrets(ctx, cmd.AppName, []Secret{{Name: cmd.SecretName, Value: cmd.SecretValue}}, false)
+}
+
+func (c *FlyClientSim) SaveSecrets(_ context.Context, appName string, secrets []Secret, _ bool) error {
+	return c.mutate(func() error {
+		app, err := c.app(appName)
+		if err != nil {
+			return err
+		}
+		for _, secret := range secrets {
+			app.Secrets[secret.Name] = secret.Value
+		}
+		return nil
+	})
+}
+
+func (c *FlyClientSim) ExistsApp(_ context.Context, name string) (bool, error) {
+	exists := false
+	err := c.read(func() error {
+		_, exists = c.state.Apps[name]
+		return nil
+	})
+	return exists, err
+}
+
+func (c *FlyClientSim) GetDeployedAppConfig(_ context.Context, name string) (model.AppConfig, error) {
+	result := model.AppConfig{Env: map[string]string{}}
+	err := c.read(func() error {
+		app, err := c.app(name)
+		if err != nil {
+			return err
+		}
+		if app.Config == nil || len(app.Machines) == 0 {
+			return nil // same as the fly cli: no machines configured for this app
+		}
+		result = *app.Config
+		result.Env = lo.Assign(app.Config.Env)
+		return nil
+	})
+	return result, err
+}
+
+func (c *FlyClientSim) GetAppVolumes(_ context.Context, name string) ([]model.VolumeState, error) {
+	result := []model.VolumeState{}
+	err := c.read(func() error {
+		app, err := c.app(name)
+		if err != nil {
+			return err
+		}
+		result = append(result, app.Volumes...)
+		return nil
+	})
+	return result, err
+}
+
+func (c *FlyClientSim) CreateNewApp(
+	ctx context.Context,
+	cfg model.AppConfig,
+	tempDir util_work_dir.WorkDir,
+	twoStep bool,
+) error {
+	err := c.mutate(func() error {
+		if _, exists := c.state.Apps[cfg.App]; exists {
+			return fmt.Errorf("error creating app %s: name has already been taken", cfg.App)
+		}
+		org := cfg.Org
+		if org == "" {
+			org = "personal"
+		}
+		c.state.Apps[cfg.App] = &SimApp{
+			Name:     cfg.App,
+			Org:      org,
+			Secrets:  map[string]string{},
+			Volumes:  []model.VolumeState{},
+			Ips:      []IpListItem{},
+			Machines: []SimMachine{},
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if twoStep {
+		return nil
+	}
+
+	return c.DeployExistingApp(ctx, cfg, tempDir, model.NewDefaultDeployConfig(), cfg.PrimaryRegion)
+}
+
+func (c *FlyClientSim) DeployExistingApp(
+	_ context.Context,
+	cfg model.AppConfig,
+	_ util_work_dir.WorkDir,
+	_ model.DeployConfig,
+	region string,
+) error {
+	return c.mutate(func() error {
+		app, err := c.app(cfg.App)
+		if err != nil {
+			return fmt.Errorf("error deploying app %s: %w", cfg.App, err)
+		}
+
+		deployedCfg := cfg
+		deployedCfg.Env = lo.Assign(cfg.Env)
+		app.Config = &deployedCfg
+		app.Deploys++
+
+		// Like 'fly deploy', the first deploy creates a machine
+		if len(app.Machines) == 0 {
+			c.addMachine(app, region)
+		}
+
+		return nil
+	})
+}
+
+// addMachine creates a machine, and like the fly cli, any volumes it needs for its mounts
+func (c *FlyClientSim) addMachine(app *SimApp, region string) {
+
+	template := SimMachine{Process: defaultProcessGroup, CPUKind: "shared", CPUs: 1, MemoryMB: 256}
+	if len(app.Machines) > 0 {
+		template = app.Machines[0]
+	}
+	template.ID = c.nextId("machine")
+	template.Region = region
+	app.Machines = append(app.Machines, template)
+
+	if app.Config == nil {
+		return
+	}
+
+	machinesInRegion := lo.CountBy(app.Machines, func(m SimMachine) bool { return m.Region == region })
+	for _, mount := range app.Config.Mounts {
+		volumesInRegion := lo.CountBy(app.Volumes, func(v model.VolumeState) bool {
+			return v.Name == mount.Source && v.Region == region
+		})
+		if volumesInRegion < machinesInRegion {
+			app.Volumes = append(app.Volumes, c.newVolume(mount.Source, 1, region))
+		}
+	}
+}
+
+func (c *FlyClientSim) newVolume(name string, sizeGb int, region string) model.VolumeState {
+	return model.VolumeState{
+		ID:        c.nextId("vol"),
+		Name:      name,
+		SizeGb:    sizeGb,
+		State:     "created",
+		Region:    region,
+		Encrypted: true,
+		CreatedAt: time.Now(),
+	}
+}
+
+func (c *FlyClientSim) CreateVolume(_ context.Context, appName string, cfg model.VolumeConfig, region string) (model.VolumeState, error) {
+	var result model.VolumeState
+	err := c.mutate(func() error {
+		app, err := c.app(appName)
+		if err != nil {
+			return err
+		}
+		result = c.newVolume(cfg.Name, cfg.SizeGb, region)
+		app.Volumes = append(app.Volumes, result)
+		return nil
+	})
+	return result, err
+}
+
+func (c *FlyClientSim) GetAppScale(_ context.Context, appName string) ([]model.ScaleState, error) {
+	result := []model.ScaleState{}
+	err := c.read(func() error {
+		app, err := c.app(appName)
+		if err != nil {
+			return err
+		}
+		byProcess := lo.GroupBy(app.Machines, func(m SimMachine) string { return m.Process })
+		processes := lo.Keys(byProcess)
+		sort.Strings(processes)
+		for _, process := range processes {
+			machines := byProcess[process]
+			result = append(result, model.ScaleState{
+				Process:  process,
+				Count:    len(machines),
+				CPUKind:  machines[0].CPUKind,
+				CPUs:     machines[0].CPUs,
+				MemoryMB: machines[0].MemoryMB,
+				Regions:  lo.CountValuesBy(machines, func(m SimMachine) string { return m.Region }),
+			})
+		}
+		return nil
+	})
+	return result, err
+}
+
+func (c *FlyClientSim) ExtendVolume(_ context.Context, appName string, volumeId string, gb int) error {
+	return c.mutate(func() error {
+		app, err := c.app(appName)
+		if err != nil {
+			return err
+		}
+		for i := range app.Volumes {
+			if app.Volumes[i].ID == volumeId {
+				if gb < app.Volumes[i].SizeGb {
+					return fmt.Errorf("error extending volume %s: volumes can not be shrunk", volumeId)
+				}
+				app.Volumes[i].SizeGb = gb
+				return nil
+			}
+		}
+		return fmt.Errorf("error extending volume %s for app %s: volume not found", volumeId, appName)
+	})
+}
+
+func (c *FlyClientSim) ScaleApp(_ context.Context, appName string, region string, count int) error {
+	return c.mutate(func() error {
+		app, err := c.app(appName)
+		if err != nil {
+			return err
+		}
+		if len(app.Machines) == 0 {
+			return fmt.Errorf("error scaling app %s: no machines to clone, deploy the app first", appName)
+		}
+		inRegion := func() []SimMachine {
+			return lo.Filter(app.Machines, func(m SimMachine, _ int) bool {
+				return m.Region == region && m.Process == defaultProcessGroup
+			})
+		}
+		for len(inRegion()) < count {
+			c.addMachine(app, region)
+		}
+		for current := inRegion(); len(current) > count; current = inRegion() {
+			last := current[len(current)-1]
+			app.Machines = lo.Filter(app.Machines, func(m SimMachine, _ int) bool { return m.ID != last.ID })
+		}
+		return nil
+	})
+}
+
+func (c *FlyClientSim) updateAppMachines(appName string, update func(m *SimMachine)) error {
+	return c.mutate(func() error {
+		app, err := c.app(appName)
+		if err != nil {
+			return err
+		}
+		for i := range app.Machines {
+			if app.Machines[i].Process == defaultProcessGroup {
+				update(&app.Machines[i])
+			}
+		}
+		return nil
+	})
+}
+
+func (c *FlyClientSim) ScaleAppRam(_ context.Context, appName string, ramMb int) error {
+	return c.updateAppMachines(appName, func(m *SimMachine) {
+		m.MemoryMB = ramMb
+	})
+}
+
+func (c *FlyClientSim) ScaleAppVm(_ context.Context, appName string, vm string) error {
+	size, err := parseVmSize(vm)
+	if err != nil {
+		return fmt.Errorf("error scaling app %s: %w", appName, err)
+	}
+	return c.updateAppMachines(appName, func(m *SimMachine) {
+		m.CPUKind = size.CPUKind
+		m.CPUs = size.CPUs
+		if size.MemoryMB > 0 {
+			m.MemoryMB = size.MemoryMB
+		}
+	})
+}
+
+func (c *FlyClientSim) ListApps(_ context.Context) ([]AppListItem, error) {
+	result := []AppListItem{}
+	err := c.read(func() error {
+		for _, app := range c.state.Apps {
+			result = append(result, AppListItem{Name: app.Name, Org: app.Org})
+		}
+		sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+		return nil
+	})
+	return result, err
+}
+
+func (c *FlyClientSim) ListIps(_ context.Context, appName string) ([]IpListItem, error) {
+	result := []IpListItem{}
+	err := c.read(func() error {
+		app, err := c.app(appName)
+		if err != nil {
+			return err
+		}
+		result = append(result, app.Ips...)
+		return nil
+	})
+	return result, err
+}
+
+func (c *FlyClientSim) DeleteIp(_ context.Context, appName string, id string, address string) error {
+	return c.mutate(func() error {
+		app, err := c.app(appName)
+		if err != nil {
+			return err
+		}
+		// Addresses aren't unique, e.g. all shared v4 ips have the same one, so the address is only used without an id
+		before := len(app.Ips)
+		app.Ips = lo.Filter(app.Ips, func(ip IpListItem, _ int) bool {
+			if id != "" {
+				return ip.Id != id
+			}
+			return ip.Address != address
+		})
+		if len(app.Ips) == before {
+			return fmt.Errorf("error releasing ip %s for app %s: ip not found", address, appName)
+		}
+		return nil
+	})
+}
+
+// simV4Address A dedicated v4 address unique to the id, counting up from 137.66.0.0
+func simV4Address(id int) string {
+	n := uint32(137)<<24 | uint32(66)<<16 + uint32(id)
+	return netip.AddrFrom4([4]byte{byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)}).String()
+}
+
+func (c *FlyClientSim) CreateIp(_ context.Context, appName string, ip model.IpConfig) error {
+	return c.mutate(func() error {
+		app, err := c.app(appName)
+		if err != nil {
+			return err
+		}
+
+		id := c.nextId("ip")
+		item := IpListItem{
+			Id:        id,
+			Region:    ip.Region,
+			Network:   ip.Network,
+			CreatedAt: time.Now(),
+		}
+		if item.Region == "" {
+			item.Region = "global"
+		}
+
+		switch {
+		case ip.V == model.IpV4 && ip.Shared:
+			item.Type = "shared_v4"
+			item.Address = "66.241.124.1"
+		case ip.V == model.IpV4:
+			item.Type = "v4"
+			item.Address = simV4Address(c.state.NextId)
+		case ip.Private:
+			item.Type = "private_v6"
+			item.Address = fmt.Sprintf("fdaa:0:1::%x", c.state.NextId)
+		default:
+			item.Type = "v6"
+			item.Address = fmt.Sprintf("2a09:8280:1::%x", c.state.NextId)
+		}
+
+		app.Ips = append(app.Ips, item)
+		return nil
+	})
+}
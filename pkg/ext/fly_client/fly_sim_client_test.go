@@ -0,0 +1,167 @@
+package fly_client
+
+import (
+	"context"
+	"errors"
+	"github.com/gigurra/flycd/pkg/domain/model"
+	"github.com/gigurra/flycd/pkg/util/util_work_dir"
+	"github.com/samber/lo"
+	"path/filepath"
+	"testing"
+)
+
+func TestFlyClientSim_appLifecycle(t *testing.T) {
+	ctx := context.Background()
+	sim := NewFlyClientSim()
+	cfg := model.AppConfig{App: "my-app", Org: "my-org", PrimaryRegion: "arn", Env: map[string]string{"FOO": "bar"}}
+	workDir := util_work_dir.NewWorkDir(t.TempDir())
+
+	_, err := sim.GetDeployedAppConfig(ctx, "my-app")
+	if !errors.Is(err, ErrSimAppNotFound) {
+		t.Fatalf("expected ErrSimAppNotFound, got %v", err)
+	}
+
+	if err := sim.CreateNewApp(ctx, cfg, workDir, true); err != nil {
+		t.Fatalf("CreateNewApp failed: %v", err)
+	}
+	if err := sim.CreateNewApp(ctx, cfg, workDir, true); err == nil {
+		t.Fatalf("expected creating the same app twice to fail")
+	}
+
+	deployed, err := sim.GetDeployedAppConfig(ctx, "my-app")
+	if err != nil || len(deployed.Env) != 0 {
+		t.Fatalf("expected empty env before first deploy, got %+v, err=%v", deployed, err)
+	}
+
+	if err := sim.DeployExistingApp(ctx, cfg, workDir, model.NewDefaultDeployConfig(), "arn"); err != nil {
+		t.Fatalf("DeployExistingApp failed: %v", err)
+	}
+	if err := sim.ScaleApp(ctx, "my-app", "ams", 2); err != nil {
+		t.Fatalf("ScaleApp failed: %v", err)
+	}
+	if err := sim.ScaleAppVm(ctx, "my-app", "performance-2x"); err != nil {
+		t.Fatalf("ScaleAppVm failed: %v", err)
+	}
+
+	scales, err := sim.GetAppScale(ctx, "my-app")
+	if err != nil {
+		t.Fatalf("GetAppScale failed: %v", err)
+	}
+	if len(scales) != 1 || scales[0].Count != 3 || scales[0].Regions["ams"] != 2 || scales[0].CPUKind != "performance" || scales[0].CPUs != 2 || scales[0].MemoryMB != 4096 {
+		t.Fatalf("unexpected scale: %+v", scales)
+	}
+
+	if err := sim.ScaleApp(ctx, "my-app", "ams", 0); err != nil {
+		t.Fatalf("ScaleApp failed: %v", err)
+	}
+	if machines := sim.State().Apps["my-app"].Machines; len(machines) != 1 || machines[0].Region != "arn" {
+		t.Fatalf("expected scaling down to leave 1 machine in arn, got %+v", machines)
+	}
+
+	deployed, err = sim.GetDeployedAppConfig(ctx, "my-app")
+	if err != nil || deployed.Env["FOO"] != "bar" {
+		t.Fatalf("expected deployed env, got %+v, err=%v", deployed, err)
+	}
+}
+
+func TestFlyClientSim_ips(t *testing.T) {
+	ctx := context.Background()
+	sim := NewFlyClientSim()
+	err := sim.CreateNewApp(ctx, model.AppConfig{App: "my-app"}, util_work_dir.NewWorkDir(t.TempDir()), true)
+	if err != nil {
+		t.Fatalf("CreateNewApp failed: %v", err)
+	}
+
+	for _, ip := range []model.IpConfig{{V: model.IpV6, Private: true}, {V: model.IpV4, Shared: true}} {
+		if err := sim.CreateIp(ctx, "my-app", ip); err != nil {
+			t.Fatalf("CreateIp failed: %v", err)
+		}
+	}
+
+	ips, err := sim.ListIps(ctx, "my-app")
+	if err != nil || len(ips) != 2 {
+		t.Fatalf("expected 2 ips, got %+v, err=%v", ips, err)
+	}
+	if !ips[0].IsPrivate() || ips[0].Ipv() != model.IpV6 || ips[1].Ipv() != model.IpV4 || ips[0].Region != "global" {
+		t.Fatalf("unexpected ips: %+v", ips)
+	}
+
+	if err := sim.DeleteIp(ctx, "my-app", ips[0].Id, ips[0].Address); err != nil {
+		t.Fatalf("DeleteIp failed: %v", err)
+	}
+	if err := sim.DeleteIp(ctx, "my-app", ips[0].Id, ips[0].Address); err == nil {
+		t.Fatalf("expected deleting an ip twice to fail")
+	}
+}
+
+func TestFlyClientSim_ipsWithSameAddress(t *testing.T) {
+	ctx := context.Background()
+	sim := NewFlyClientSim()
+	err := sim.CreateNewApp(ctx, model.AppConfig{App: "my-app"}, util_work_dir.NewWorkDir(t.TempDir()), true)
+	if err != nil {
+		t.Fatalf("CreateNewApp failed: %v", err)
+	}
+
+	// Enough dedicated ips for their ids to wrap around the last octet
+	for i := 0; i < 300; i++ {
+		if err := sim.CreateIp(ctx, "my-app", model.IpConfig{V: model.IpV4}); err != nil {
+			t.Fatalf("CreateIp failed: %v", err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if err := sim.CreateIp(ctx, "my-app", model.IpConfig{V: model.IpV4, Shared: true}); err != nil {
+			t.Fatalf("CreateIp failed: %v", err)
+		}
+	}
+
+	ips, err := sim.ListIps(ctx, "my-app")
+	if err != nil || len(ips) != 302 {
+		t.Fatalf("expected 302 ips, got %d, err=%v", len(ips), err)
+	}
+	dedicated := lo.Filter(ips, func(ip IpListItem, _ int) bool { return ip.Type == "v4" })
+	if addresses := lo.Uniq(lo.Map(dedicated, func(ip IpListItem, _ int) string { return ip.Address })); len(addresses) != 300 {
+		t.Fatalf("expected every dedicated ip to have its own address, got %d addresses", len(addresses))
+	}
+
+	// Both shared ips have the same address, but only the one with the id is released
+	shared := ips[300]
+	if err := sim.DeleteIp(ctx, "my-app", shared.Id, shared.Address); err != nil {
+		t.Fatalf("DeleteIp failed: %v", err)
+	}
+	ips, err = sim.ListIps(ctx, "my-app")
+	if err != nil || len(ips) != 301 || ips[300].Id == shared.Id || ips[300].Address != shared.Address {
+		t.Fatalf("expected only the released shared ip to be gone, got %d ips, err=%v", len(ips), err)
+	}
+}
+
+func TestFlyClientSim_persistence(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "sim-state.json")
+
+	sim := NewFlyClientSim()
+	if err := sim.WithPersistence(path); err != nil {
+		t.Fatalf("WithPersistence failed: %v", err)
+	}
+	err := sim.CreateNewApp(ctx, model.AppConfig{App: "my-app", Org: "my-org"}, util_work_dir.NewWorkDir(t.TempDir()), true)
+	if err != nil {
+		t.Fatalf("CreateNewApp failed: %v", err)
+	}
+	if err := sim.SaveSecrets(ctx, "my-app", []Secret{{Name: "FOO", Value: "bar"}}, true); err != nil {
+		t.Fatalf("SaveSecrets failed: %v", err)
+	}
+
+	reloaded := NewFlyClientSim()
+	if err := reloaded.WithPersistence(path); err != nil {
+		t.Fatalf("WithPersistence failed: %v", err)
+	}
+
+	apps, err := reloaded.ListApps(ctx)
+	if err != nil || len(apps) != 1 || apps[0] != (AppListItem{Name: "my-app", Org: "my-org"}) {
+		t.Fatalf("expected reloaded state to contain my-app, got %+v, err=%v", apps, err)
+	}
+
+	exists, err := reloaded.ExistsSecret(ctx, ExistsSecretCmd{AppName: "my-app", SecretName: "FOO"})
+	if err != nil || !exists {
+		t.Fatalf("expected secret FOO to exist after reload, got %v, err=%v", exists, err)
+	}
+}
@@ -0,0 +1,146 @@
+package fly_client
+
+import (
+	"context"
+	"github.com/gigurra/flycd/pkg/domain/model"
+	"github.com/gigurra/flycd/pkg/util/util_trace"
+	"github.com/gigurra/flycd/pkg/util/util_work_dir"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// TracedFlyClient Wraps every call to the underlying FlyClient in a span, whichever backend it uses
+type TracedFlyClient struct {
+	underlying FlyClient
+}
+
+// prove that TracedFlyClient implements FlyClient
+var _ FlyClient = TracedFlyClient{}
+
+func NewTracedFlyClient(underlying FlyClient) FlyClient {
+	return TracedFlyClient{underlying: underlying}
+}
+
+func traced[T any](ctx context.Context, name string, fn func(ctx context.Context) (T, error), attrs ...attribute.KeyValue) (T, error) {
+	ctx, span := util_trace.Start(ctx, "fly."+name, attrs...)
+	result, err := fn(ctx)
+	util_trace.End(span, err)
+	return result, err
+}
+
+func tracedErr(ctx context.Context, name string, fn func(ctx context.Context) error, attrs ...attribute.KeyValue) error {
+	return util_trace.Run(ctx, "fly."+name, fn, attrs...)
+}
+
+func (c TracedFlyClient) CreateOrgToken(ctx context.Context, orgSlug string) (string, error) {
+	return traced(ctx, "CreateOrgToken", func(ctx context.Context) (string, error) {
+		return c.underlying.CreateOrgToken(ctx, orgSlug)
+	}, util_trace.KeyOrg.String(orgSlug))
+}
+
+func (c TracedFlyClient) ExistsSecret(ctx context.Context, cmd ExistsSecretCmd) (bool, error) {
+	return traced(ctx, "ExistsSecret", func(ctx context.Context) (bool, error) {
+		return c.underlying.ExistsSecret(ctx, cmd)
+	}, util_trace.KeyApp.String(cmd.AppName), attribute.String("flycd.secret", cmd.SecretName))
+}
+
+func (c TracedFlyClient) StoreSecret(ctx context.Context, cmd StoreSecretCmd) error {
+	return tracedErr(ctx, "StoreSecret", func(ctx context.Context) error {
+		return c.underlying.StoreSecret(ctx, cmd)
+	}, util_trace.KeyApp.String(cmd.AppName), attribute.String("flycd.secret", cmd.SecretName))
+}
+
+func (c TracedFlyClient) ExistsApp(ctx context.Context, name string) (bool, error) {
+	return traced(ctx, "ExistsApp", func(ctx context.Context) (bool, error) {
+		return c.underlying.ExistsApp(ctx, name)
+	}, util_trace.KeyApp.String(name))
+}
+
+func (c TracedFlyClient) GetDeployedAppConfig(ctx context.Context, name string) (model.AppConfig, error) {
+	return traced(ctx, "GetDeployedAppConfig", func(ctx context.Context) (model.AppConfig, error) {
+		return c.underlying.GetDeployedAppConfig(ctx, name)
+	}, util_trace.KeyApp.String(name))
+}
+
+func (c TracedFlyClient) GetAppVolumes(ctx context.Context, name string) ([]model.VolumeState, error) {
+	return traced(ctx, "GetAppVolumes", func(ctx context.Context) ([]model.VolumeState, error) {
+		return c.underlying.GetAppVolumes(ctx, name)
+	}, util_trace.KeyApp.String(name))
+}
+
+func (c TracedFlyClient) CreateNewApp(ctx context.Context, cfg model.AppConfig, tempDir util_work_dir.WorkDir, twoStep bool) error {
+	return tracedErr(ctx, "CreateNewApp", func(ctx context.Context) error {
+		return c.underlying.CreateNewApp(ctx, cfg, tempDir, twoStep)
+	}, util_trace.KeyApp.String(cfg.App), util_trace.KeyOrg.String(cfg.Org))
+}
+
+func (c TracedFlyClient) DeployExistingApp(ctx context.Context, cfg model.AppConfig, tempDir util_work_dir.WorkDir, deployCfg model.DeployConfig, region string) error {
+	return tracedErr(ctx, "DeployExistingApp", func(ctx context.Context) error {
+		return c.underlying.DeployExistingApp(ctx, cfg, tempDir, deployCfg, region)
+	}, util_trace.KeyApp.String(cfg.App), util_trace.KeyOrg.String(cfg.Org), util_trace.KeyRegion.String(region))
+}
+
+func (c TracedFlyClient) CreateVolume(ctx context.Context, app string, cfg model.VolumeConfig, region string) (model.VolumeState, error) {
+	return traced(ctx, "CreateVolume", func(ctx context.Context) (model.VolumeState, error) {
+		return c.underlying.CreateVolume(ctx, app, cfg, region)
+	}, util_trace.KeyApp.String(app), attribute.String("flycd.volume", cfg.Name), util_trace.KeyRegion.String(region))
+}
+
+func (c TracedFlyClient) GetAppScale(ctx context.Context, app string) ([]model.ScaleState, error) {
+	return traced(ctx, "GetAppScale", func(ctx context.Context) ([]model.ScaleState, error) {
+		return c.underlying.GetAppScale(ctx, app)
+	}, util_trace.KeyApp.String(app))
+}
+
+func (c TracedFlyClient) ExtendVolume(ctx context.Context, appName string, volumeId string, gb int) error {
+	return tracedErr(ctx, "ExtendVolume", func(ctx context.Context) error {
+		return c.underlying.ExtendVolume(ctx, appName, volumeId, gb)
+	}, util_trace.KeyApp.String(appName), attribute.String("flycd.volume_id", volumeId), attribute.Int("flycd.size_gb", gb))
+}
+
+func (c TracedFlyClient) ScaleApp(ctx context.Context, app string, region string, count int) error {
+	return tracedErr(ctx, "ScaleApp", func(ctx context.Context) error {
+		return c.underlying.ScaleApp(ctx, app, region, count)
+	}, util_trace.KeyApp.String(app), util_trace.KeyRegion.String(region), attribute.Int("flycd.count", count))
+}
+
+func (c TracedFlyClient) ScaleAppRam(ctx context.Context, app string, ramMb int) error {
+	return tracedErr(ctx, "ScaleAppRam", func(ctx context.Context) error {
+		return c.underlying.ScaleAppRam(ctx, app, ramMb)
+	}, util_trace.KeyApp.String(app), attribute.Int("flycd.ram_mb", ramMb))
+}
+
+func (c TracedFlyClient) ScaleAppVm(ctx context.Context, app string, vm string) error {
+	return tracedErr(ctx, "ScaleAppVm", func(ctx context.Context) error {
+		return c.underlying.ScaleAppVm(ctx, app, vm)
+	}, util_trace.KeyApp.String(app), attribute.String("flycd.vm", vm))
+}
+
+func (c TracedFlyClient) SaveSecrets(ctx context.Context, app string, secrets []Secret, stage bool) error {
+	return tracedErr(ctx, "SaveSecrets", func(ctx context.Context) error {
+		return c.underlying.SaveSecrets(ctx, app, secrets, stage)
+	}, util_trace.KeyApp.String(app), attribute.Int("flycd.secrets", len(secrets)), attribute.Bool("flycd.stage", stage))
+}
+
+func (c TracedFlyClient) ListApps(ctx context.Context) ([]AppListItem, error) {
+	return traced(ctx, "ListApps", func(ctx context.Context) ([]AppListItem, error) {
+		return c.underlying.ListApps(ctx)
+	})
+}
+
+func (c TracedFlyClient) ListIps(ctx context.Context, app string) ([]IpListItem, error) {
+	return traced(ctx, "ListIps", func(ctx context.Context) ([]IpListItem, error) {
+		return c.underlying.ListIps(ctx, app)
+	}, util_trace.KeyApp.String(app))
+}
+
+func (c TracedFlyClient) DeleteIp(ctx context.Context, app string, id string, address string) error {
+	return tracedErr(ctx, "DeleteIp", func(ctx context.Context) error {
+		return c.underlying.DeleteIp(ctx, app, id, address)
+	}, util_trace.KeyApp.String(app), attribute.String("flycd.ip", address))
+}
+
+func (c TracedFlyClient) CreateIp(ctx context.Context, app string, ip model.IpConfig) error {
+	return tracedErr(ctx, "CreateIp", func(ctx context.Context) error {
+		return c.underlying.CreateIp(ctx, app, ip)
+	}, util_trace.KeyApp.String(app), attribute.String("flycd.ip_version", string(ip.V)), attribute.Bool("flycd.private", ip.Private))
+}
@@ -0,0 +1,98 @@
+package gitea
+
+import (
+	"github.com/gigurra/flycd/pkg/domain/model"
+	"github.com/samber/lo"
+)
+
+const (
+	// Forgejo sends both its own headers and Gitea's
+	EventHeader            = "X-Gitea-Event"
+	ForgejoEventHeader     = "X-Forgejo-Event"
+	DeliveryHeader         = "X-Gitea-Delivery"
+	SignatureHeader        = "X-Gitea-Signature"
+	ForgejoSignatureHeader = "X-Forgejo-Signature"
+
+	EventPush = "push"
+)
+
+// zeroSha is what Gitea sends as 'after' when a branch or tag is deleted
+const zeroSha = "0000000000000000000000000000000000000000"
+
+type Repository struct {
+	ID            int64  `json:"id"`
+	Name          string `json:"name"`
+	FullName      string `json:"full_name"`
+	HtmlUrl       string `json:"html_url"`
+	SshUrl        string `json:"ssh_url"`
+	CloneUrl      string `json:"clone_url"`
+	DefaultBranch string `json:"default_branch"`
+}
+
+type User struct {
+	Login    string `json:"login"`
+	FullName string `json:"full_name"`
+	Email    string `json:"email"`
+}
+
+type CommitUser struct {
+	Name     string `json:"name"`
+	Email    string `json:"email"`
+	Username string `json:"username"`
+}
+
+type Commit struct {
+	ID       string     `json:"id"`
+	Message  string     `json:"message"`
+	Url      string     `json:"url"`
+	Author   CommitUser `json:"author"`
+	Added    []string   `json:"added"`
+	Removed  []string   `json:"removed"`
+	Modified []string   `json:"modified"`
+}
+
+// PushEvent is the payload of Gitea's and Forgejo's push webhooks, for both branches and tags
+type PushEvent struct {
+	Ref          string     `json:"ref"`
+	Before       string     `json:"before"`
+	After        string     `json:"after"`
+	CompareUrl   string     `json:"compare_url"`
+	HeadCommit   *Commit    `json:"head_commit"`
+	Commits      []Commit   `json:"commits"`
+	TotalCommits int        `json:"total_commits"`
+	Repository   Repository `json:"repository"`
+	Pusher       User       `json:"pusher"`
+}
+
+func (e PushEvent) IsDelete() bool {
+	return e.After == zeroSha
+}
+
+// Author Of the head commit. Falls back to whoever pushed, when the head commit isn't in the payload
+func (e PushEvent) Author() string {
+	if e.HeadCommit != nil && e.HeadCommit.Author.Name != "" {
+		return e.HeadCommit.Author.Name
+	}
+	headCommit, found := lo.Find(e.Commits, func(commit Commit) bool { return commit.ID == e.After })
+	if found && headCommit.Author.Name != "" {
+		return headCommit.Author.Name
+	}
+	if e.Pusher.FullName != "" {
+		return e.Pusher.FullName
+	}
+	return e.Pusher.Login
+}
+
+func (e PushEvent) RepoUrls() []string {
+	return []string{e.Repository.HtmlUrl, e.Repository.SshUrl, e.Repository.CloneUrl}
+}
+
+// ChangedFiles Gitea only includes the first few commits of a push, so for larger pushes we don't know what changed
+func (e PushEvent) ChangedFiles() []string {
+	if e.TotalCommits > len(e.Commits) {
+		return nil
+	}
+	return model.CollectChangedFiles(lo.FlatMap(e.Commits, func(commit Commit, _ int) [][]string {
+		return [][]string{commit.Added, commit.Removed, commit.Modified}
+	})...)
+}
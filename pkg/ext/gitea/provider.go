@@ -0,0 +1,74 @@
+package gitea
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gigurra/flycd/pkg/domain/model"
+	"github.com/gigurra/flycd/pkg/util/util_hmac"
+	"net/http"
+)
+
+var ErrMissingSignature = errors.New("missing " + SignatureHeader + " header")
+var ErrInvalidSignature = errors.New("webhook signature does not match any configured secret")
+
+// Provider Gitea and Forgejo push webhooks
+type Provider struct{}
+
+func (p Provider) Name() string {
+	return "gitea"
+}
+
+func (p Provider) Detect(header http.Header) bool {
+	return event(header) != ""
+}
+
+// Verify Gitea signs webhooks with the plain hex hmac of the body, without any sha256= prefix
+func (p Provider) Verify(header http.Header, body []byte, secrets []string) error {
+	signature := header.Get(SignatureHeader)
+	if signature == "" {
+		signature = header.Get(ForgejoSignatureHeader)
+	}
+	if signature == "" {
+		return ErrMissingSignature
+	}
+	if !util_hmac.VerifySha256(body, signature, secrets) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func (p Provider) Parse(header http.Header, body []byte) ([]model.PushEvent, error) {
+	if event(header) != EventPush {
+		return []model.PushEvent{}, nil
+	}
+
+	var payload PushEvent
+	err := json.Unmarshal(body, &payload)
+	if err != nil {
+		return nil, fmt.Errorf("error deserializing gitea webhook payload: %w", err)
+	}
+
+	if payload.IsDelete() {
+		return []model.PushEvent{}, nil
+	}
+
+	return []model.PushEvent{{
+		Provider:      p.Name(),
+		Id:            header.Get(DeliveryHeader),
+		Repo:          payload.Repository.FullName,
+		RepoUrls:      payload.RepoUrls(),
+		Ref:           payload.Ref,
+		Commit:        payload.After,
+		Author:        payload.Author(),
+		DefaultBranch: payload.Repository.DefaultBranch,
+		ChangedFiles:  payload.ChangedFiles(),
+	}}, nil
+}
+
+func event(header http.Header) string {
+	if event := header.Get(EventHeader); event != "" {
+		return event
+	}
+	return header.Get(ForgejoEventHeader)
+}
@@ -0,0 +1,84 @@
+package gitea
+
+import (
+	"errors"
+	"github.com/gigurra/flycd/pkg/util/util_hmac"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// Trimmed down from https://docs.gitea.com/usage/webhooks#example
+const pushEventBlob = `{
+  "ref": "refs/heads/develop",
+  "before": "28e1879d029cb852e4844d9c718537df08844e03",
+  "after": "bffeb74224043ba2feb48d137756c8a9331c449a",
+  "compare_url": "http://localhost:3000/gitea/webhooks/compare/28e1879d029cb852e4844d9c718537df08844e03...bffeb74224043ba2feb48d137756c8a9331c449a",
+  "commits": [
+    {
+      "id": "bffeb74224043ba2feb48d137756c8a9331c449a",
+      "message": "Webhooks Yay!",
+      "author": {"name": "Gitea Author", "email": "author@gitea.io", "username": "author"},
+      "url": "http://localhost:3000/gitea/webhooks/commit/bffeb74224043ba2feb48d137756c8a9331c449a"
+    }
+  ],
+  "repository": {
+    "id": 140,
+    "name": "webhooks",
+    "full_name": "gitea/webhooks",
+    "html_url": "http://localhost:3000/gitea/webhooks",
+    "ssh_url": "ssh://gitea@localhost:2222/gitea/webhooks.git",
+    "clone_url": "http://localhost:3000/gitea/webhooks.git"
+  },
+  "pusher": {"login": "gitea", "full_name": "Gitea", "email": "someone@gitea.io"}
+}`
+
+func TestProvider_Parse(t *testing.T) {
+	header := http.Header{}
+	header.Set(ForgejoEventHeader, EventPush)
+	header.Set(DeliveryHeader, "f6266f16-1bf3-46a5-9ea4-602e06ead473")
+
+	if !(Provider{}).Detect(header) {
+		t.Fatalf("expected Forgejo headers to be detected")
+	}
+
+	events, err := Provider{}.Parse(header, []byte(pushEventBlob))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("expected one event, got %+v", events)
+	}
+	event := events[0]
+	if event.Branch() != "develop" || event.Commit != "bffeb74224043ba2feb48d137756c8a9331c449a" || event.Repo != "gitea/webhooks" {
+		t.Fatalf("unexpected event: %+v", event)
+	}
+	if event.Author != "Gitea Author" {
+		t.Fatalf("expected the head commit's author, got %q", event.Author)
+	}
+	expectedUrls := []string{
+		"http://localhost:3000/gitea/webhooks",
+		"ssh://gitea@localhost:2222/gitea/webhooks.git",
+		"http://localhost:3000/gitea/webhooks.git",
+	}
+	if !reflect.DeepEqual(event.RepoUrls, expectedUrls) {
+		t.Fatalf("expected %v, got %v", expectedUrls, event.RepoUrls)
+	}
+}
+
+func TestProvider_Verify(t *testing.T) {
+	body := []byte(pushEventBlob)
+	header := http.Header{}
+
+	if err := (Provider{}).Verify(header, body, []string{"secret"}); !errors.Is(err, ErrMissingSignature) {
+		t.Fatalf("expected ErrMissingSignature, got %v", err)
+	}
+
+	header.Set(SignatureHeader, util_hmac.SignSha256(body, "secret"))
+	if err := (Provider{}).Verify(header, body, []string{"secret"}); err != nil {
+		t.Fatalf("expected a valid signature, got %v", err)
+	}
+	if err := (Provider{}).Verify(header, body, []string{"other"}); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("expected ErrInvalidSignature, got %v", err)
+	}
+}
@@ -65,18 +65,24 @@ type User struct {
 }
 
 type Commit struct {
-	ID        string `json:"id"`
-	TreeID    string `json:"tree_id"`
-	Message   string `json:"message"`
-	Timestamp GhTime `json:"timestamp"`
-	URL       string `json:"url"`
-	Author    User   `json:"author"`
+	ID        string   `json:"id"`
+	TreeID    string   `json:"tree_id"`
+	Message   string   `json:"message"`
+	Timestamp GhTime   `json:"timestamp"`
+	URL       string   `json:"url"`
+	Author    User     `json:"author"`
+	Added     []string `json:"added"`
+	Removed   []string `json:"removed"`
+	Modified  []string `json:"modified"`
 }
 
 type PushWebhookPayload struct {
 	Ref        string     `json:"ref"`
+	After      string     `json:"after"`
+	Deleted    bool       `json:"deleted"`
 	HookId     int64      `json:"hook_id"`
 	Repository Repository `json:"repository"`
 	Pusher     User       `json:"pusher"`
 	HeadCommit Commit     `json:"head_commit"`
+	Commits    []Commit   `json:"commits"` // at most 20, see https://docs.github.com/en/webhooks/webhook-events-and-payloads#push
 }
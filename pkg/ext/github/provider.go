@@ -0,0 +1,86 @@
+package github
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gigurra/flycd/pkg/domain/model"
+	"github.com/samber/lo"
+	"net/http"
+	"strconv"
+)
+
+const (
+	EventHeader    = "X-GitHub-Event"
+	DeliveryHeader = "X-GitHub-Delivery"
+
+	maxPayloadCommits = 20
+)
+
+// Provider GitHub push webhooks. Requests without any provider headers are also treated
+// as GitHub webhooks, since that is all flycd used to support.
+type Provider struct{}
+
+func (p Provider) Name() string {
+	return "github"
+}
+
+func (p Provider) Detect(header http.Header) bool {
+	return header.Get(EventHeader) != ""
+}
+
+func (p Provider) Verify(header http.Header, body []byte, secrets []string) error {
+	return VerifySignature(body, header.Get(SignatureHeader), secrets)
+}
+
+func (p Provider) Parse(header http.Header, body []byte) ([]model.PushEvent, error) {
+	if event := header.Get(EventHeader); event != "" && event != "push" {
+		return []model.PushEvent{}, nil
+	}
+
+	var payload PushWebhookPayload
+	err := json.Unmarshal(body, &payload)
+	if err != nil {
+		return nil, fmt.Errorf("error deserializing github webhook payload: %w", err)
+	}
+
+	if payload.Deleted {
+		return []model.PushEvent{}, nil
+	}
+
+	event := payload.ToPushEvent()
+	if delivery := header.Get(DeliveryHeader); delivery != "" {
+		event.Id = delivery
+	}
+	return []model.PushEvent{event}, nil
+}
+
+func (p PushWebhookPayload) ToPushEvent() model.PushEvent {
+	return model.PushEvent{
+		Provider: Provider{}.Name(),
+		Id:       strconv.FormatInt(p.HookId, 10),
+		Repo:     p.Repository.FullName,
+		RepoUrls: []string{
+			p.Repository.Url,
+			p.Repository.CloneUrl,
+			p.Repository.HtmlUrl,
+			p.Repository.GitUrl,
+			p.Repository.SvnUrl,
+			p.Repository.SshUrl,
+		},
+		Ref:           p.Ref,
+		Commit:        p.After,
+		Author:        p.HeadCommit.Author.Name,
+		DefaultBranch: p.Repository.DefaultBranch,
+		ChangedFiles:  p.ChangedFiles(),
+	}
+}
+
+// ChangedFiles GitHub only includes the first 20 commits of a push, so for larger pushes we don't know what changed
+func (p PushWebhookPayload) ChangedFiles() []string {
+	if len(p.Commits) >= maxPayloadCommits {
+		return nil
+	}
+	return model.CollectChangedFiles(lo.FlatMap(p.Commits, func(commit Commit, _ int) [][]string {
+		return [][]string{commit.Added, commit.Removed, commit.Modified}
+	})...)
+}
@@ -0,0 +1,37 @@
+package github
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gigurra/flycd/pkg/util/util_hmac"
+	"strings"
+)
+
+const SignatureHeader = "X-Hub-Signature-256"
+
+var ErrMissingSignature = errors.New("missing " + SignatureHeader + " header")
+var ErrInvalidSignature = errors.New("webhook signature does not match any configured secret")
+
+// VerifySignature Checks the X-Hub-Signature-256 header (sha256=<hex hmac of body>) against
+// each of the secrets. Several secrets are allowed so they can be rotated without downtime.
+func VerifySignature(body []byte, signature string, secrets []string) error {
+	if signature == "" {
+		return ErrMissingSignature
+	}
+
+	hexSignature, found := strings.CutPrefix(signature, "sha256=")
+	if !found {
+		return fmt.Errorf("unsupported signature format, expected 'sha256=<hex>': %w", ErrInvalidSignature)
+	}
+
+	if !util_hmac.VerifySha256(body, hexSignature, secrets) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+// Sign Creates the X-Hub-Signature-256 header value GitHub would send for the body
+func Sign(body []byte, secret string) string {
+	return "sha256=" + util_hmac.SignSha256(body, secret)
+}
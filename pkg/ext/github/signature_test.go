@@ -0,0 +1,42 @@
+package github
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestVerifySignature(t *testing.T) {
+	body := []byte(`{"ref":"refs/heads/main"}`)
+
+	// Example from https://docs.github.com/en/webhooks/using-webhooks/validating-webhook-deliveries
+	err := VerifySignature(
+		[]byte("Hello, World!"),
+		"sha256=757107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e17",
+		[]string{"It's a Secret to Everybody"},
+	)
+	if err != nil {
+		t.Fatalf("expected GitHub's documented example to verify, got %v", err)
+	}
+
+	for _, test := range []struct {
+		name      string
+		signature string
+		secrets   []string
+		expected  error
+	}{
+		{name: "valid", signature: Sign(body, "secret"), secrets: []string{"secret"}, expected: nil},
+		{name: "rotated secret", signature: Sign(body, "new"), secrets: []string{"old", "new"}, expected: nil},
+		{name: "missing", signature: "", secrets: []string{"secret"}, expected: ErrMissingSignature},
+		{name: "wrong secret", signature: Sign(body, "other"), secrets: []string{"secret"}, expected: ErrInvalidSignature},
+		{name: "sha1 signature", signature: "sha1=abc", secrets: []string{"secret"}, expected: ErrInvalidSignature},
+		{name: "not hex", signature: "sha256=xyz", secrets: []string{"secret"}, expected: ErrInvalidSignature},
+		{name: "empty secret", signature: Sign(body, ""), secrets: []string{""}, expected: ErrInvalidSignature},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := VerifySignature(body, test.signature, test.secrets)
+			if !errors.Is(err, test.expected) {
+				t.Fatalf("expected %v, got %v", test.expected, err)
+			}
+		})
+	}
+}
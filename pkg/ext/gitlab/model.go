@@ -0,0 +1,88 @@
+package gitlab
+
+const (
+	EventHeader = "X-Gitlab-Event"
+	TokenHeader = "X-Gitlab-Token"
+
+	EventPush    = "Push Hook"
+	EventTagPush = "Tag Push Hook"
+)
+
+// zeroSha is what GitLab sends as 'after' when a branch or tag is deleted
+const zeroSha = "0000000000000000000000000000000000000000"
+
+type Project struct {
+	ID                int64  `json:"id"`
+	Name              string `json:"name"`
+	WebUrl            string `json:"web_url"`
+	GitSshUrl         string `json:"git_ssh_url"`
+	GitHttpUrl        string `json:"git_http_url"`
+	Namespace         string `json:"namespace"`
+	PathWithNamespace string `json:"path_with_namespace"`
+	DefaultBranch     string `json:"default_branch"`
+	Homepage          string `json:"homepage"`
+	Url               string `json:"url"`
+	SshUrl            string `json:"ssh_url"`
+	HttpUrl           string `json:"http_url"`
+}
+
+type Repository struct {
+	Name       string `json:"name"`
+	Url        string `json:"url"`
+	Homepage   string `json:"homepage"`
+	GitHttpUrl string `json:"git_http_url"`
+	GitSshUrl  string `json:"git_ssh_url"`
+}
+
+type Author struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+type Commit struct {
+	ID       string   `json:"id"`
+	Message  string   `json:"message"`
+	Url      string   `json:"url"`
+	Author   Author   `json:"author"`
+	Added    []string `json:"added"`
+	Modified []string `json:"modified"`
+	Removed  []string `json:"removed"`
+}
+
+// PushEvent is the payload of both GitLab's push and tag push webhooks
+type PushEvent struct {
+	ObjectKind   string     `json:"object_kind"` // "push" or "tag_push"
+	EventName    string     `json:"event_name"`
+	Before       string     `json:"before"`
+	After        string     `json:"after"`
+	Ref          string     `json:"ref"`
+	CheckoutSha  string     `json:"checkout_sha"`
+	UserName     string     `json:"user_name"`
+	ProjectId    int64      `json:"project_id"`
+	Project      Project    `json:"project"`
+	Repository   Repository `json:"repository"`
+	Commits      []Commit   `json:"commits"`
+	TotalCommits int        `json:"total_commits_count"`
+}
+
+// IsDelete tells if the event is for a deleted branch or tag, in which case there is nothing to deploy
+func (e PushEvent) IsDelete() bool {
+	return e.After == zeroSha
+}
+
+// RepoUrls All the urls GitLab knows the repo by, to match against our sources
+func (e PushEvent) RepoUrls() []string {
+	return []string{
+		e.Project.WebUrl,
+		e.Project.GitSshUrl,
+		e.Project.GitHttpUrl,
+		e.Project.Homepage,
+		e.Project.Url,
+		e.Project.SshUrl,
+		e.Project.HttpUrl,
+		e.Repository.Url,
+		e.Repository.Homepage,
+		e.Repository.GitHttpUrl,
+		e.Repository.GitSshUrl,
+	}
+}
@@ -0,0 +1,105 @@
+package gitlab
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// Trimmed down from https://docs.gitlab.com/ee/user/project/integrations/webhook_events.html#push-events
+const pushEventBlob = `{
+  "object_kind": "push",
+  "event_name": "push",
+  "before": "95790bf891e76fee5e1747ab589903a6a1f80f22",
+  "after": "da1560886d4f094c3e6c9ef40349f7d38b5d27d7",
+  "ref": "refs/heads/master",
+  "ref_protected": true,
+  "checkout_sha": "da1560886d4f094c3e6c9ef40349f7d38b5d27d7",
+  "user_id": 4,
+  "user_name": "John Smith",
+  "project_id": 15,
+  "project": {
+    "id": 15,
+    "name": "Diaspora",
+    "web_url": "http://example.com/mike/diaspora",
+    "git_ssh_url": "git@example.com:mike/diaspora.git",
+    "git_http_url": "http://example.com/mike/diaspora.git",
+    "namespace": "Mike",
+    "visibility_level": 0,
+    "path_with_namespace": "mike/diaspora",
+    "default_branch": "master",
+    "homepage": "http://example.com/mike/diaspora",
+    "url": "git@example.com:mike/diaspora.git",
+    "ssh_url": "git@example.com:mike/diaspora.git",
+    "http_url": "http://example.com/mike/diaspora.git"
+  },
+  "repository": {
+    "name": "Diaspora",
+    "url": "git@example.com:mike/diaspora.git",
+    "homepage": "http://example.com/mike/diaspora",
+    "git_http_url": "http://example.com/mike/diaspora.git",
+    "git_ssh_url": "git@example.com:mike/diaspora.git",
+    "visibility_level": 0
+  },
+  "commits": [
+    {
+      "id": "da1560886d4f094c3e6c9ef40349f7d38b5d27d7",
+      "message": "fixed readme",
+      "timestamp": "2012-01-03T23:36:29+02:00",
+      "url": "http://example.com/mike/diaspora/commit/da1560886d4f094c3e6c9ef40349f7d38b5d27d7",
+      "author": {
+        "name": "GitLab dev user",
+        "email": "gitlabdev@dv6700.(none)"
+      },
+      "added": ["CHANGELOG"],
+      "modified": ["app/controller/application.rb"],
+      "removed": []
+    }
+  ],
+  "total_commits_count": 1
+}`
+
+func TestModel_deserialize_push_event(t *testing.T) {
+	var event PushEvent
+	err := json.Unmarshal([]byte(pushEventBlob), &event)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if event.ObjectKind != "push" || event.Ref != "refs/heads/master" || event.IsDelete() {
+		t.Fatalf("unexpected event: %+v", event)
+	}
+	if event.Project.GitSshUrl != "git@example.com:mike/diaspora.git" || len(event.Commits) != 1 {
+		t.Fatalf("unexpected project or commits: %+v", event)
+	}
+
+	if author := event.ToPushEvent().Author; author != "GitLab dev user" {
+		t.Fatalf("expected the author of the head commit, got '%s'", author)
+	}
+
+	if files := event.ChangedFiles(); !reflect.DeepEqual(files, []string{"CHANGELOG", "app/controller/application.rb"}) {
+		t.Fatalf("unexpected changed files: %v", files)
+	}
+	event.TotalCommits = 21
+	if files := event.ChangedFiles(); files != nil {
+		t.Fatalf("expected changed files to be unknown when commits are missing from the payload, got %v", files)
+	}
+
+	event.After = zeroSha
+	if !event.IsDelete() {
+		t.Fatalf("expected an all zero 'after' to be a delete")
+	}
+}
+
+func TestVerifyToken(t *testing.T) {
+	if err := VerifyToken("new", []string{"old", "new"}); err != nil {
+		t.Fatalf("expected rotated token to verify, got %v", err)
+	}
+	if err := VerifyToken("", []string{"secret"}); !errors.Is(err, ErrMissingToken) {
+		t.Fatalf("expected ErrMissingToken, got %v", err)
+	}
+	if err := VerifyToken("wrong", []string{"secret"}); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("expected ErrInvalidToken, got %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+package gitlab
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gigurra/flycd/pkg/domain/model"
+	"github.com/samber/lo"
+	"net/http"
+)
+
+// Provider GitLab push and tag push webhooks
+type Provider struct{}
+
+func (p Provider) Name() string {
+	return "gitlab"
+}
+
+func (p Provider) Detect(header http.Header) bool {
+	return header.Get(EventHeader) != ""
+}
+
+func (p Provider) Verify(header http.Header, _ []byte, secrets []string) error {
+	return VerifyToken(header.Get(TokenHeader), secrets)
+}
+
+func (p Provider) Parse(header http.Header, body []byte) ([]model.PushEvent, error) {
+	if event := header.Get(EventHeader); event != EventPush && event != EventTagPush {
+		return []model.PushEvent{}, nil
+	}
+
+	var event PushEvent
+	err := json.Unmarshal(body, &event)
+	if err != nil {
+		return nil, fmt.Errorf("error deserializing gitlab webhook payload: %w", err)
+	}
+
+	if event.IsDelete() {
+		return []model.PushEvent{}, nil
+	}
+
+	return []model.PushEvent{event.ToPushEvent()}, nil
+}
+
+func (e PushEvent) ToPushEvent() model.PushEvent {
+	return model.PushEvent{
+		Provider:      Provider{}.Name(),
+		Repo:          e.Project.PathWithNamespace,
+		RepoUrls:      e.RepoUrls(),
+		Ref:           e.Ref,
+		Commit:        e.After,
+		Author:        e.Author(),
+		DefaultBranch: e.Project.DefaultBranch,
+		ChangedFiles:  e.ChangedFiles(),
+	}
+}
+
+// Author Of the head commit. Falls back to whoever pushed, when the head commit isn't in the payload
+func (e PushEvent) Author() string {
+	headCommit, found := lo.Find(e.Commits, func(commit Commit) bool { return commit.ID == e.After })
+	if found && headCommit.Author.Name != "" {
+		return headCommit.Author.Name
+	}
+	return e.UserName
+}
+
+// ChangedFiles GitLab only includes the first 20 commits of a push, so for larger pushes we don't know what changed
+func (e PushEvent) ChangedFiles() []string {
+	if e.TotalCommits > len(e.Commits) {
+		return nil
+	}
+	return model.CollectChangedFiles(lo.FlatMap(e.Commits, func(commit Commit, _ int) [][]string {
+		return [][]string{commit.Added, commit.Modified, commit.Removed}
+	})...)
+}
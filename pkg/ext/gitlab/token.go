@@ -0,0 +1,23 @@
+package gitlab
+
+import (
+	"crypto/subtle"
+	"errors"
+)
+
+var ErrMissingToken = errors.New("missing " + TokenHeader + " header")
+var ErrInvalidToken = errors.New("webhook token does not match any configured secret")
+
+// VerifyToken GitLab doesn't sign its webhooks, it sends the configured secret token as is
+// in the X-Gitlab-Token header. Several secrets are allowed so they can be rotated.
+func VerifyToken(token string, secrets []string) error {
+	if token == "" {
+		return ErrMissingToken
+	}
+	for _, secret := range secrets {
+		if secret != "" && subtle.ConstantTimeCompare([]byte(token), []byte(secret)) == 1 {
+			return nil
+		}
+	}
+	return ErrInvalidToken
+}
@@ -0,0 +1,238 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gigurra/flycd/pkg/domain/model"
+	"github.com/gigurra/flycd/pkg/util/util_log"
+	"github.com/gigurra/flycd/pkg/util/util_trace"
+	"go.opentelemetry.io/otel/attribute"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxErrorLength Discord rejects messages longer than 2000 characters, and nobody reads that much in slack either
+const maxErrorLength = 1500
+
+const (
+	sendTimeout = 5 * time.Second // per notification, so that a slow notifier doesn't hold up the others for long
+	maxWaiting  = 100             // notifications waiting to be sent. Any more are dropped
+)
+
+// HttpNotifier Posts deploy notifications to slack, discord and generic webhooks.
+// It can be turned off after construction with SetEnabled, e.g. once cli flags are parsed.
+// Notifications are sent in the background, in order, so that deploys never wait for them.
+// Call Flush before exiting, to not lose the ones still waiting.
+type HttpNotifier struct {
+	client   *http.Client
+	disabled atomic.Bool
+	waiting  chan pendingNotification
+	unsent   sync.WaitGroup
+}
+
+type pendingNotification struct {
+	ctx          context.Context
+	notifier     model.NotifierConfig
+	notification model.DeployNotification
+}
+
+// prove that HttpNotifier implements DeployNotifier
+var _ model.DeployNotifier = &HttpNotifier{}
+
+func NewHttpNotifier() *HttpNotifier {
+	n := &HttpNotifier{
+		client:  &http.Client{Timeout: sendTimeout},
+		waiting: make(chan pendingNotification, maxWaiting),
+	}
+	go n.sendWaiting()
+	return n
+}
+
+func (n *HttpNotifier) SetEnabled(enabled bool) {
+	n.disabled.Store(!enabled)
+}
+
+func (n *HttpNotifier) Notify(ctx context.Context, notifiers []model.NotifierConfig, notification model.DeployNotification) {
+	if n.disabled.Load() {
+		return
+	}
+	for _, notifier := range notifiers {
+		if !notifier.Wants(notification.Event) {
+			continue
+		}
+		n.unsent.Add(1)
+		select {
+		// the deploy may be done (and its context cancelled) long before the notification is sent
+		case n.waiting <- pendingNotification{ctx: context.WithoutCancel(ctx), notifier: notifier, notification: notification}:
+		default:
+			n.unsent.Done()
+			slog.WarnContext(ctx, "Dropping deploy notification, too many are waiting to be sent",
+				util_log.KeyApp, notification.App,
+				"notifier", notifier.Type,
+				"event", notification.Event,
+			)
+		}
+	}
+}
+
+// Flush Waits until the notifications so far have been sent (or failed to), at most timeout.
+// Returns false if some were still waiting.
+func (n *HttpNotifier) Flush(timeout time.Duration) bool {
+	sent := make(chan struct{})
+	go func() {
+		n.unsent.Wait()
+		close(sent)
+	}()
+	select {
+	case <-sent:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func (n *HttpNotifier) sendWaiting() {
+	for pending := range n.waiting {
+		ctx, cancel := context.WithTimeout(pending.ctx, sendTimeout)
+		err := util_trace.Run(ctx, "notify", func(ctx context.Context) error {
+			return n.send(ctx, pending.notifier, pending.notification)
+		},
+			util_trace.KeyApp.String(pending.notification.App),
+			attribute.String("flycd.notifier", string(pending.notifier.Type)),
+			attribute.String("flycd.event", pending.notification.Event),
+		)
+		cancel()
+		if err != nil {
+			slog.WarnContext(ctx, "Error sending deploy notification",
+				util_log.KeyApp, pending.notification.App,
+				"notifier", pending.notifier.Type,
+				"event", pending.notification.Event,
+				"error", err,
+			)
+		}
+		n.unsent.Done()
+	}
+}
+
+func (n *HttpNotifier) send(ctx context.Context, notifier model.NotifierConfig, notification model.DeployNotification) error {
+	url, err := notifier.ResolveUrl()
+	if err != nil {
+		return err
+	}
+
+	body, err := Body(notifier.Type, notification)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating %s notification request: %w", notifier.Type, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error posting %s notification: %w", notifier.Type, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s notification got status %d: %s", notifier.Type, resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+	return nil
+}
+
+// WebhookPayload What generic webhooks are posted. The notification, plus the text chat notifiers would post.
+type WebhookPayload struct {
+	model.DeployNotification
+	Message string `json:"message"`
+}
+
+// Body The json to post to a notifier of the given type
+func Body(notifierType model.NotifierType, notification model.DeployNotification) ([]byte, error) {
+	var payload any
+	switch notifierType {
+	case model.NotifierSlack:
+		payload = map[string]string{"text": Message(notification)}
+	case model.NotifierDiscord:
+		payload = map[string]string{"content": Message(notification)}
+	case model.NotifierWebhook:
+		payload = WebhookPayload{DeployNotification: notification, Message: Message(notification)}
+	default:
+		return nil, fmt.Errorf("unknown notifier type '%s'", notifierType)
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling %s notification: %w", notifierType, err)
+	}
+	return body, nil
+}
+
+// Message The notification as one line of text, e.g.
+// "Deployed app my-app (personal) @ 1a2b3c4 by Jane Doe in 42s: updated. Triggered by github webhook 123"
+func Message(notification model.DeployNotification) string {
+	var message strings.Builder
+
+	switch notification.Event {
+	case model.NotifyStarted:
+		message.WriteString("Deploying app ")
+	case model.NotifySucceeded:
+		message.WriteString("Deployed app ")
+	case model.NotifyFailed:
+		message.WriteString("Failed to deploy app ")
+	default:
+		message.WriteString(notification.Event + ": app ")
+	}
+	message.WriteString(notification.App)
+	if notification.Org != "" {
+		message.WriteString(" (" + notification.Org + ")")
+	}
+	if notification.Commit != "" {
+		message.WriteString(" @ " + shortCommit(notification.Commit))
+	}
+	if notification.Author != "" {
+		message.WriteString(" by " + notification.Author)
+	}
+	if notification.Event != model.NotifyStarted {
+		duration := time.Duration(notification.DurationSeconds * float64(time.Second)).Round(time.Second)
+		if notification.Event == model.NotifyFailed {
+			message.WriteString(" after " + duration.String())
+		} else {
+			message.WriteString(" in " + duration.String())
+		}
+	}
+	if notification.Result != "" {
+		message.WriteString(": " + string(notification.Result))
+	}
+	if notification.Error != "" {
+		message.WriteString(": " + truncate(notification.Error, maxErrorLength))
+	}
+	if notification.Trigger != "" {
+		message.WriteString(". Triggered by " + notification.Trigger)
+	}
+	return message.String()
+}
+
+func shortCommit(commit string) string {
+	if len(commit) > 7 {
+		return commit[:7]
+	}
+	return commit
+}
+
+func truncate(s string, maxLength int) string {
+	runes := []rune(s)
+	if len(runes) <= maxLength {
+		return s
+	}
+	return string(runes[:maxLength]) + "..."
+}
@@ -0,0 +1,145 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gigurra/flycd/pkg/domain/model"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type received struct {
+	path string
+	body map[string]any
+}
+
+func newReceiver(t *testing.T) (*httptest.Server, func() []received) {
+	mutex := sync.Mutex{}
+	result := make([]received, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bytes, _ := io.ReadAll(r.Body)
+		body := map[string]any{}
+		err := json.Unmarshal(bytes, &body)
+		if err != nil {
+			t.Errorf("notification is not json: %s", string(bytes))
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		result = append(result, received{path: r.URL.Path, body: body})
+		if r.URL.Path == "/broken" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, func() []received {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]received{}, result...)
+	}
+}
+
+func TestHttpNotifier_Notify(t *testing.T) {
+	server, receivedNotifications := newReceiver(t)
+	t.Setenv("FLYCD_TEST_DISCORD_URL", server.URL+"/discord")
+
+	notifiers := []model.NotifierConfig{
+		{Type: model.NotifierWebhook, Url: server.URL + "/broken"}, // must not stop the others
+		{Type: model.NotifierSlack, Url: server.URL + "/slack", Events: []string{model.NotifyFailed}},
+		{Type: model.NotifierDiscord, UrlEnv: "FLYCD_TEST_DISCORD_URL"},
+		{Type: model.NotifierWebhook, UrlEnv: "FLYCD_TEST_UNSET_URL"},
+		{Type: model.NotifierWebhook, Url: server.URL + "/webhook"},
+	}
+	notification := model.DeployNotification{
+		Event:           model.NotifySucceeded,
+		App:             "app1",
+		Org:             "personal",
+		Commit:          "1a2b3c4d5e6f",
+		Author:          "Jane Doe",
+		Result:          model.SingleAppDeployUpdated,
+		DurationSeconds: 42.3,
+		Trigger:         "github webhook 123",
+	}
+
+	notifier := NewHttpNotifier()
+	notifier.Notify(context.Background(), notifiers, notification)
+	if !notifier.Flush(5 * time.Second) {
+		t.Fatalf("expected the notifications to be sent in time")
+	}
+
+	notifications := receivedNotifications()
+	if len(notifications) != 3 {
+		t.Fatalf("expected the broken webhook, discord and the webhook to be notified, got %+v", notifications)
+	}
+	expectedMessage := "Deployed app app1 (personal) @ 1a2b3c4 by Jane Doe in 42s: updated. Triggered by github webhook 123"
+	if notifications[1].path != "/discord" || notifications[1].body["content"] != expectedMessage {
+		t.Fatalf("unexpected discord notification: %+v", notifications[1])
+	}
+	webhook := notifications[2]
+	if webhook.path != "/webhook" ||
+		webhook.body["event"] != model.NotifySucceeded ||
+		webhook.body["app"] != "app1" ||
+		webhook.body["author"] != "Jane Doe" ||
+		webhook.body["result"] != string(model.SingleAppDeployUpdated) ||
+		webhook.body["message"] != expectedMessage {
+		t.Fatalf("unexpected webhook notification: %+v", webhook)
+	}
+
+	notification.Event = model.NotifyFailed
+	notification.Result = ""
+	notification.Error = "error running intermediate volume steps: " + strings.Repeat("x", 2000)
+	notifier.SetEnabled(false)
+	notifier.Notify(context.Background(), notifiers, notification)
+	notifier.Flush(5 * time.Second)
+	if len(receivedNotifications()) != 3 {
+		t.Fatalf("expected nothing to be sent when disabled")
+	}
+
+	notifier.SetEnabled(true)
+	notifier.Notify(context.Background(), notifiers, notification)
+	notifier.Flush(5 * time.Second)
+	notifications = receivedNotifications()[3:]
+	if len(notifications) != 4 || notifications[1].path != "/slack" {
+		t.Fatalf("expected slack to be told about the failure too, got %+v", notifications)
+	}
+	text := notifications[1].body["text"].(string)
+	if !strings.HasPrefix(text, "Failed to deploy app app1 (personal) @ 1a2b3c4 by Jane Doe after 42s: error running intermediate volume steps: xxx") ||
+		len(text) > 2000 {
+		t.Fatalf("unexpected slack notification: %s", text)
+	}
+}
+
+func TestHttpNotifier_doesNotWaitForSlowNotifiers(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	t.Cleanup(server.Close)
+	t.Cleanup(func() { close(release) })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	notifier := NewHttpNotifier()
+	start := time.Now()
+	notifier.Notify(ctx, []model.NotifierConfig{{Type: model.NotifierWebhook, Url: server.URL}}, model.DeployNotification{
+		Event: model.NotifySucceeded,
+		App:   "app1",
+	})
+	cancel() // e.g. the deploy is done
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected Notify to return without waiting for the notifier, took %v", elapsed)
+	}
+	if notifier.Flush(100 * time.Millisecond) {
+		t.Fatalf("expected the notification to still be on its way")
+	}
+}
+
+func TestMessage_started(t *testing.T) {
+	message := Message(model.DeployNotification{Event: model.NotifyStarted, App: "app1", Trigger: model.TriggerCli})
+	if message != "Deploying app app1. Triggered by cli" {
+		t.Fatalf("unexpected message: %s", message)
+	}
+}
@@ -0,0 +1,44 @@
+package webhooks
+
+import (
+	"github.com/gigurra/flycd/pkg/domain/model"
+	"github.com/gigurra/flycd/pkg/ext/bitbucket"
+	"github.com/gigurra/flycd/pkg/ext/gitea"
+	"github.com/gigurra/flycd/pkg/ext/github"
+	"github.com/gigurra/flycd/pkg/ext/gitlab"
+	"net/http"
+)
+
+// Provider A git host that can send us push webhooks
+type Provider interface {
+	Name() string
+
+	// Detect tells if a request came from this provider, based on its headers
+	Detect(header http.Header) bool
+
+	// Verify checks the request's signature or token against the configured secrets
+	Verify(header http.Header, body []byte, secrets []string) error
+
+	// Parse maps the request to common push events. Other kinds of events and deleted
+	// branches/tags give no push events, since there is nothing to deploy for them.
+	Parse(header http.Header, body []byte) ([]model.PushEvent, error)
+}
+
+// Providers in the order they are detected. Gitea also sends GitHub's headers, so it must come before GitHub.
+var Providers = []Provider{
+	gitlab.Provider{},
+	gitea.Provider{},
+	bitbucket.Provider{},
+	github.Provider{},
+}
+
+// Detect Finds the provider that sent a request. Requests without any known provider headers
+// are assumed to come from GitHub, since that is all flycd used to support.
+func Detect(header http.Header) Provider {
+	for _, provider := range Providers {
+		if provider.Detect(header) {
+			return provider
+		}
+	}
+	return github.Provider{}
+}
@@ -0,0 +1,29 @@
+package webhooks
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestDetect(t *testing.T) {
+	for _, test := range []struct {
+		headers  map[string]string
+		expected string
+	}{
+		{headers: map[string]string{"X-GitHub-Event": "push"}, expected: "github"},
+		{headers: map[string]string{"X-Gitlab-Event": "Push Hook"}, expected: "gitlab"},
+		{headers: map[string]string{"X-Event-Key": "repo:push"}, expected: "bitbucket"},
+		// Gitea also sends GitHub's headers
+		{headers: map[string]string{"X-Gitea-Event": "push", "X-GitHub-Event": "push"}, expected: "gitea"},
+		{headers: map[string]string{"X-Forgejo-Event": "push"}, expected: "gitea"},
+		{headers: map[string]string{}, expected: "github"},
+	} {
+		header := http.Header{}
+		for k, v := range test.headers {
+			header.Set(k, v)
+		}
+		if provider := Detect(header); provider.Name() != test.expected {
+			t.Fatalf("expected %s for %v, got %s", test.expected, test.headers, provider.Name())
+		}
+	}
+}
@@ -1,8 +1,9 @@
 package util_cfg_merge
 
 import (
-	"fmt"
+	"context"
 	"github.com/samber/lo"
+	"log/slog"
 	"reflect"
 )
 
@@ -20,8 +21,10 @@ const (
 	SliceStrategyMergeOverlapReplaceRest SliceStrategy = "merge-overlap-replace-rest" // replace the original slice with the new slice
 )
 
-// MergeMaps This only works with basic builtin types - NOT with structs or pointers inside the maps
+// MergeMaps This only works with basic builtin types - NOT with structs or pointers inside the maps.
+// Type mismatches are logged with the context.
 func MergeMaps(
+	ctx context.Context,
 	base map[string]any,
 	overlay map[string]any,
 	configs ...MergeConfig,
@@ -42,7 +45,7 @@ func MergeMaps(
 		config = configs[0]
 	}
 
-	merged := doMerge(overlay, base, config)
+	merged := doMerge(ctx, overlay, base, config)
 
 	result, ok := merged.(map[string]any)
 	if !ok {
@@ -57,6 +60,7 @@ func MergeMaps(
 // + one nil fix + no longer returning errors
 
 func doMerge(
+	ctx context.Context,
 	overlay any,
 	base any,
 	config MergeConfig,
@@ -73,11 +77,8 @@ func doMerge(
 	overlayType := reflect.TypeOf(overlay)
 	baseType := reflect.TypeOf(base)
 	if overlayType.Kind() != baseType.Kind() {
-		fmt.Printf(
-			"Returning overlay data without merge. "+
-				"Type mismatch in merge. overlay type: %v, base type: %v."+
-				"overlay data: %v, base data: %v", overlayType.Kind(), baseType.Kind(), overlay, base,
-		)
+		slog.WarnContext(ctx, "Type mismatch in config merge, using the overlay without merging",
+			"overlay_type", overlayType.Kind(), "base_type", baseType.Kind(), "overlay", overlay, "base", base)
 		return overlay
 	}
 
@@ -96,7 +97,7 @@ func doMerge(
 			if !existsInBase {
 				resultMap[k] = overlayVal
 			} else {
-				mergedVal := doMerge(overlayVal, baseVal, config)
+				mergedVal := doMerge(ctx, overlayVal, baseVal, config)
 				resultMap[k] = mergedVal
 			}
 		}
@@ -187,7 +188,7 @@ func doMerge(
 
 				// Merge the values
 				valuesToAppend := lo.Map(matched, func(baseItem map[string]any, index int) any {
-					result := doMerge(overlayItem, baseItem, config)
+					result := doMerge(ctx, overlayItem, baseItem, config)
 					return result
 				})
 
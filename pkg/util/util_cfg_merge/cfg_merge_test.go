@@ -1,6 +1,7 @@
 package util_cfg_merge
 
 import (
+	"context"
 	"fmt"
 	"github.com/google/go-cmp/cmp"
 	"testing"
@@ -26,7 +27,7 @@ func TestMerge_TopLevel(t *testing.T) {
 		"bo":  nil,
 	}
 
-	actual := MergeMaps(base, overlay)
+	actual := MergeMaps(context.Background(), base, overlay)
 	if diff := cmp.Diff(actual, expected); diff != "" {
 		t.Fatalf("Expected %v, diff: %s", expected, diff)
 	}
@@ -54,7 +55,7 @@ func TestMerge_Deep(t *testing.T) {
 		},
 	}
 
-	actual := MergeMaps(base, overlay)
+	actual := MergeMaps(context.Background(), base, overlay)
 	if diff := cmp.Diff(actual, expected); diff != "" {
 		t.Fatalf("Expected %v, diff: %s", expected, diff)
 	}
@@ -65,7 +66,7 @@ func TestMerge_NilNil(t *testing.T) {
 	var overlay map[string]any = nil
 	var expected map[string]any = nil
 
-	actual := MergeMaps(base, overlay)
+	actual := MergeMaps(context.Background(), base, overlay)
 	if diff := cmp.Diff(actual, expected); diff != "" {
 		t.Fatalf("Expected %v, diff: %s", expected, diff)
 	}
@@ -105,7 +106,7 @@ func TestMerge_mapInMap(t *testing.T) {
 		},
 	}
 
-	actual := MergeMaps(base, overlay)
+	actual := MergeMaps(context.Background(), base, overlay)
 
 	fmt.Printf("  actual: %v\n", actual)
 	fmt.Printf("expected: %v\n", expected)
@@ -177,7 +178,7 @@ func TestMerge_Arrays(t *testing.T) {
 		"foo": expectedArray,
 	}
 
-	actual := MergeMaps(base, overlay, MergeConfig{SliceStrategy: SliceStrategyMergeOverlapReplaceRest, SliceMergeKeys: []string{"common1", "common2"}})
+	actual := MergeMaps(context.Background(), base, overlay, MergeConfig{SliceStrategy: SliceStrategyMergeOverlapReplaceRest, SliceMergeKeys: []string{"common1", "common2"}})
 	if diff := cmp.Diff(expected, actual); diff != "" {
 		t.Fatalf("Expected %v, diff: %s", expected, diff)
 	}
@@ -1,6 +1,14 @@
 package util_cobra
 
-import "github.com/spf13/cobra"
+import (
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+)
+
+// AnnotationStdoutIsOutput Marks commands that print their results to stdout, e.g. as json to pipe into other tools.
+// They log to stderr instead, so that the logs don't end up in the middle of the results.
+const AnnotationStdoutIsOutput = "flycd.stdout-is-output"
 
 type FlagStructIfc interface {
 	Init(cmd *cobra.Command)
@@ -11,3 +19,11 @@ func CreateCmd(flags FlagStructIfc, fnSetupCommand func() *cobra.Command) *cobra
 	flags.Init(cmd)
 	return cmd
 }
+
+// LogOutput Where the command should log to. See AnnotationStdoutIsOutput.
+func LogOutput(cmd *cobra.Command) io.Writer {
+	if cmd.Annotations[AnnotationStdoutIsOutput] == "true" {
+		return os.Stderr
+	}
+	return os.Stdout
+}
@@ -3,8 +3,13 @@ package util_git
 import (
 	"context"
 	"fmt"
+	"github.com/GiGurra/cmder"
+	"github.com/gigurra/flycd/pkg/util/util_log"
+	"github.com/gigurra/flycd/pkg/util/util_trace"
 	"github.com/gigurra/flycd/pkg/util/util_work_dir"
+	"go.opentelemetry.io/otel/attribute"
 	"strings"
+	"time"
 )
 
 type GitCloneResult struct {
@@ -19,74 +24,75 @@ type CloneSource struct {
 	Commit string
 }
 
+func (s CloneSource) traceAttrs() []attribute.KeyValue {
+	return []attribute.KeyValue{
+		util_trace.KeyRepo.String(s.Repo),
+		attribute.String("flycd.git.branch", s.Branch),
+		attribute.String("flycd.git.tag", s.Tag),
+		attribute.String("flycd.git.commit", s.Commit),
+	}
+}
+
 func CloneShallow(
 	ctx context.Context,
 	source CloneSource,
 	workDir util_work_dir.WorkDir,
 ) (GitCloneResult, error) {
+	ctx, span := util_trace.Start(ctx, "git.clone", source.traceAttrs()...)
+	result, err := cloneShallow(ctx, source, workDir)
+	if err == nil {
+		span.SetAttributes(attribute.String("flycd.git.hash", result.Hash))
+	}
+	util_trace.End(span, err)
+	return result, err
+}
 
-	var err error
+func cloneShallow(
+	ctx context.Context,
+	source CloneSource,
+	workDir util_work_dir.WorkDir,
+) (GitCloneResult, error) {
 
 	if source.Commit != "" {
 		// Shallow clone of specific commit
 		// https://stackoverflow.com/questions/31278902/how-to-shallow-clone-a-specific-commit-with-depth-1
-		res := workDir.
-			NewCommand("git", "init").
-			WithStdOutErrForwarded().
-			Run(ctx)
+		res := util_log.RunLogged(ctx, workDir.NewCommand("git", "init"))
 		if res.Err != nil {
-			return GitCloneResult{}, fmt.Errorf("error initializing git repo: %w", err)
+			return GitCloneResult{}, fmt.Errorf("error initializing git repo: %w", res.Err)
 		}
 
-		res = workDir.
-			NewCommand("git", "remote", "add", "origin", source.Repo).
-			WithStdOutErrForwarded().
-			Run(ctx)
-		if err != nil {
-			return GitCloneResult{}, fmt.Errorf("error adding git remote: %w", err)
+		res = util_log.RunLogged(ctx, workDir.NewCommand("git", "remote", "add", "origin", source.Repo))
+		if res.Err != nil {
+			return GitCloneResult{}, fmt.Errorf("error adding git remote: %w", res.Err)
 		}
 
-		res = workDir.
-			NewCommand("git", "fetch", "--depth", "1", "origin", source.Commit).
-			WithStdOutErrForwarded().
-			Run(ctx)
+		res = util_log.RunLogged(ctx, workDir.NewCommand("git", "fetch", "--depth", "1", "origin", source.Commit))
 		if res.Err != nil {
-			return GitCloneResult{}, fmt.Errorf("error fetching git commit: %w", err)
+			return GitCloneResult{}, fmt.Errorf("error fetching git commit: %w", res.Err)
 		}
 
-		res = workDir.
-			NewCommand("git", "checkout", "FETCH_HEAD").
-			WithStdOutErrForwarded().
-			Run(ctx)
-		if err != nil {
-			return GitCloneResult{}, fmt.Errorf("error checking out git commit: %w", err)
+		res = util_log.RunLogged(ctx, workDir.NewCommand("git", "checkout", "FETCH_HEAD"))
+		if res.Err != nil {
+			return GitCloneResult{}, fmt.Errorf("error checking out git commit: %w", res.Err)
 		}
 
 	} else if source.Tag != "" {
-		res := workDir.
-			NewCommand("git", "clone", source.Repo, "repo", "--depth", "1", "--branch", source.Tag).
-			WithStdOutErrForwarded().
-			Run(ctx)
+		res := util_log.RunLogged(ctx, workDir.NewCommand("git", "clone", source.Repo, "repo", "--depth", "1", "--branch", source.Tag))
 		if res.Err != nil {
-			return GitCloneResult{}, fmt.Errorf("error cloning git repo %s: %w", source.Repo, err)
+			return GitCloneResult{}, fmt.Errorf("error cloning git repo %s: %w", source.Repo, res.Err)
 		}
 		workDir = workDir.WithChildCwd("repo")
 
 	} else if source.Branch != "" {
-		res := workDir.
-			NewCommand("git", "clone", source.Repo, "repo", "--depth", "1", "--branch", source.Branch).
-			WithStdOutErrForwarded().
-			Run(ctx)
+		res := util_log.RunLogged(ctx, workDir.NewCommand("git", "clone", source.Repo, "repo", "--depth", "1", "--branch", source.Branch))
 		if res.Err != nil {
-			return GitCloneResult{}, fmt.Errorf("error cloning git repo %s: %w", source.Repo, err)
+			return GitCloneResult{}, fmt.Errorf("error cloning git repo %s: %w", source.Repo, res.Err)
 		}
 		workDir = workDir.WithChildCwd("repo")
 	} else {
-		res := workDir.NewCommand("git", "clone", source.Repo, "repo", "--depth", "1").
-			WithStdOutErrForwarded().
-			Run(ctx)
+		res := util_log.RunLogged(ctx, workDir.NewCommand("git", "clone", source.Repo, "repo", "--depth", "1"))
 		if res.Err != nil {
-			return GitCloneResult{}, fmt.Errorf("error cloning git repo %s: %w", source.Repo, err)
+			return GitCloneResult{}, fmt.Errorf("error cloning git repo %s: %w", source.Repo, res.Err)
 		}
 		workDir = workDir.WithChildCwd("repo")
 	}
@@ -94,8 +100,8 @@ func CloneShallow(
 	res := workDir.
 		NewCommand("git", "rev-parse", "HEAD").
 		Run(ctx)
-	if err != nil {
-		return GitCloneResult{}, fmt.Errorf("error getting git commit hash: %w", err)
+	if res.Err != nil {
+		return GitCloneResult{}, fmt.Errorf("error getting git commit hash: %w", res.Err)
 	}
 
 	return GitCloneResult{
@@ -103,3 +109,154 @@ func CloneShallow(
 		Hash: strings.TrimSpace(res.StdOut),
 	}, nil
 }
+
+// ListFiles Lists the files committed at HEAD of the repo in dir, with the hashes of their contents (blob ids)
+func ListFiles(ctx context.Context, dir util_work_dir.WorkDir) (map[string]string, error) {
+	return listFiles(ctx, dir, "HEAD")
+}
+
+func listFiles(ctx context.Context, dir util_work_dir.WorkDir, rev string) (map[string]string, error) {
+	res := dir.NewCommand("git", "ls-tree", "-r", "-z", "--full-tree", rev).Run(ctx)
+	if res.Err != nil {
+		return nil, fmt.Errorf("error listing files of git repo %s: %w", dir.Cwd(), res.Err)
+	}
+	return parseLsTree(res.StdOut)
+}
+
+// ListRemoteFiles Lists the files at the source's ref like ListFiles, and the commit the ref points to, without
+// fetching the contents of the files. Much cheaper than CloneShallow, when only the file hashes are needed.
+func ListRemoteFiles(
+	ctx context.Context,
+	source CloneSource,
+	workDir util_work_dir.WorkDir,
+) (string, map[string]string, error) {
+	ctx, span := util_trace.Start(ctx, "git.list-remote-files", source.traceAttrs()...)
+	commit, files, err := listRemoteFiles(ctx, source, workDir)
+	util_trace.End(span, err)
+	return commit, files, err
+}
+
+func listRemoteFiles(
+	ctx context.Context,
+	source CloneSource,
+	workDir util_work_dir.WorkDir,
+) (string, map[string]string, error) {
+
+	ref := source.Commit
+	if ref == "" {
+		refs := lsRemoteRefs(source)
+		ref = refs[len(refs)-1] // the tag itself rather than the peeled one, which can't be fetched
+	}
+
+	res := util_log.RunLogged(ctx, workDir.NewCommand("git", "init"))
+	if res.Err != nil {
+		return "", nil, fmt.Errorf("error initializing git repo: %w", res.Err)
+	}
+
+	// blob:none still fetches the trees, which is all that ls-tree needs
+	res = util_log.RunLogged(ctx, workDir.NewCommand("git", "fetch", "--depth", "1", "--filter=blob:none", source.Repo, ref))
+	if res.Err != nil {
+		return "", nil, fmt.Errorf("error fetching %s of git repo %s: %w", ref, source.Repo, res.Err)
+	}
+
+	res = workDir.NewCommand("git", "rev-parse", "FETCH_HEAD^{commit}").Run(ctx)
+	if res.Err != nil {
+		return "", nil, fmt.Errorf("error getting git commit hash: %w", res.Err)
+	}
+
+	files, err := listFiles(ctx, workDir, "FETCH_HEAD")
+	if err != nil {
+		return "", nil, err
+	}
+	return strings.TrimSpace(res.StdOut), files, nil
+}
+
+// parseLsTree Parses 'git ls-tree -r -z' output: '<mode> <type> <hash>\t<path>' entries separated by NUL
+func parseLsTree(output string) (map[string]string, error) {
+	result := map[string]string{}
+	for _, entry := range strings.Split(output, "\x00") {
+		if entry == "" {
+			continue
+		}
+		info, path, found := strings.Cut(entry, "\t")
+		fields := strings.Fields(info)
+		if !found || len(fields) != 3 {
+			return nil, fmt.Errorf("unexpected git ls-tree entry '%s'", entry)
+		}
+		if fields[1] == "blob" { // skip submodules
+			result[path] = fields[2]
+		}
+	}
+	return result, nil
+}
+
+// RepoRelativeDir Where dir is in the git repo it is part of, relative to the repo root ("" for the root itself)
+func RepoRelativeDir(ctx context.Context, dir string) (string, error) {
+	res := util_work_dir.NewWorkDir(dir).NewCommand("git", "rev-parse", "--show-prefix").Run(ctx)
+	if res.Err != nil {
+		return "", fmt.Errorf("error finding %s in its git repo: %w", dir, res.Err)
+	}
+	return strings.TrimSuffix(strings.TrimSpace(res.StdOut), "/"), nil
+}
+
+// LsRemote Gets the commit hash that the source's ref currently points to in the remote
+// repo, without cloning it. Sources pinned to a commit are returned as is.
+func LsRemote(
+	ctx context.Context,
+	source CloneSource,
+) (string, error) {
+
+	if source.Commit != "" {
+		return source.Commit, nil
+	}
+
+	ctx, span := util_trace.Start(ctx, "git.ls-remote", source.traceAttrs()...)
+	hash, err := lsRemote(ctx, source)
+	util_trace.End(span, err)
+	return hash, err
+}
+
+func lsRemote(
+	ctx context.Context,
+	source CloneSource,
+) (string, error) {
+
+	refs := lsRemoteRefs(source)
+	res := cmder.
+		New(append([]string{"git", "ls-remote", source.Repo}, refs...)...).
+		WithAttemptTimeout(1 * time.Minute).
+		Run(ctx)
+	if res.Err != nil {
+		return "", fmt.Errorf("error running git ls-remote for %s: %w", source.Repo, res.Err)
+	}
+
+	return parseLsRemote(res.StdOut, refs)
+}
+
+func lsRemoteRefs(source CloneSource) []string {
+	if source.Tag != "" {
+		// prefer the peeled ref, since annotated tags have their own hash
+		return []string{"refs/tags/" + source.Tag + "^{}", "refs/tags/" + source.Tag}
+	} else if source.Branch != "" {
+		return []string{"refs/heads/" + source.Branch}
+	} else {
+		return []string{"HEAD"}
+	}
+}
+
+// parseLsRemote Picks the hash of the first of the wanted refs found in the git ls-remote output
+func parseLsRemote(output string, wantedRefs []string) (string, error) {
+	hashes := map[string]string{}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 {
+			hashes[fields[1]] = fields[0]
+		}
+	}
+	for _, ref := range wantedRefs {
+		if hash, ok := hashes[ref]; ok {
+			return hash, nil
+		}
+	}
+	return "", fmt.Errorf("ref %s not found in remote", wantedRefs[len(wantedRefs)-1])
+}
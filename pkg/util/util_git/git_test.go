@@ -0,0 +1,147 @@
+package util_git
+
+import (
+	"context"
+	"github.com/gigurra/flycd/pkg/util/util_work_dir"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseLsRemote(t *testing.T) {
+	output := "" +
+		"1111111111111111111111111111111111111111\tHEAD\n" +
+		"2222222222222222222222222222222222222222\trefs/heads/main\n" +
+		"3333333333333333333333333333333333333333\trefs/tags/v1\n" +
+		"4444444444444444444444444444444444444444\trefs/tags/v1^{}\n"
+
+	for _, test := range []struct {
+		source   CloneSource
+		expected string
+	}{
+		{source: CloneSource{}, expected: "1111111111111111111111111111111111111111"},
+		{source: CloneSource{Branch: "main"}, expected: "2222222222222222222222222222222222222222"},
+		{source: CloneSource{Tag: "v1"}, expected: "4444444444444444444444444444444444444444"},
+	} {
+		hash, err := parseLsRemote(output, lsRemoteRefs(test.source))
+		if err != nil {
+			t.Fatalf("parseLsRemote failed for %+v: %v", test.source, err)
+		}
+		if hash != test.expected {
+			t.Fatalf("expected %s for %+v, got %s", test.expected, test.source, hash)
+		}
+	}
+
+	_, err := parseLsRemote(output, lsRemoteRefs(CloneSource{Branch: "missing"}))
+	if err == nil {
+		t.Fatalf("expected an error for a missing branch")
+	}
+}
+
+func TestLsRemote_localRepo(t *testing.T) {
+	repo := t.TempDir()
+	git := func(args ...string) string {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = repo
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v failed: %v, %s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	git("init", "-b", "main")
+	git("commit", "--allow-empty", "-m", "first")
+
+	hash, err := LsRemote(context.Background(), CloneSource{Repo: repo, Branch: "main"})
+	if err != nil {
+		t.Fatalf("LsRemote failed: %v", err)
+	}
+	if expected := git("rev-parse", "HEAD"); hash != expected {
+		t.Fatalf("expected %s, got %s", expected, hash)
+	}
+
+	pinned, err := LsRemote(context.Background(), CloneSource{Repo: repo, Commit: "abc123"})
+	if err != nil || pinned != "abc123" {
+		t.Fatalf("expected pinned commit to be returned as is, got %s, err=%v", pinned, err)
+	}
+}
+
+func TestParseLsTree(t *testing.T) {
+	output := "" +
+		"100644 blob 1111111111111111111111111111111111111111\tREADME.md\x00" +
+		"100644 blob 2222222222222222222222222222222222222222\tapps/app 1/main.go\x00" +
+		"160000 commit 3333333333333333333333333333333333333333\tvendor/lib\x00"
+
+	files, err := parseLsTree(output)
+	if err != nil {
+		t.Fatalf("parseLsTree failed: %v", err)
+	}
+	if len(files) != 2 ||
+		files["README.md"] != "1111111111111111111111111111111111111111" ||
+		files["apps/app 1/main.go"] != "2222222222222222222222222222222222222222" {
+		t.Fatalf("expected the 2 files but not the submodule, got %v", files)
+	}
+
+	_, err = parseLsTree("garbage\x00")
+	if err == nil {
+		t.Fatalf("expected an error for unexpected output")
+	}
+}
+
+func TestListRemoteFiles_localRepo(t *testing.T) {
+	repo := t.TempDir()
+	git := func(args ...string) string {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = repo
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v failed: %v, %s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	git("init", "-b", "main")
+	err := os.WriteFile(filepath.Join(repo, "Dockerfile"), []byte("FROM scratch"), 0644)
+	if err != nil {
+		t.Fatalf("failed to write Dockerfile: %v", err)
+	}
+	git("add", "-A")
+	git("commit", "-m", "first")
+	git("tag", "-a", "v1", "-m", "v1")
+	git("commit", "--allow-empty", "-m", "second")
+
+	for _, test := range []struct {
+		source   CloneSource
+		expected string
+	}{
+		{source: CloneSource{Repo: repo, Branch: "main"}, expected: git("rev-parse", "HEAD")},
+		{source: CloneSource{Repo: repo, Tag: "v1"}, expected: git("rev-parse", "v1^{commit}")},
+	} {
+		commit, files, err := ListRemoteFiles(context.Background(), test.source, util_work_dir.NewWorkDir(t.TempDir()))
+		if err != nil {
+			t.Fatalf("ListRemoteFiles failed for %+v: %v", test.source, err)
+		}
+		if commit != test.expected || files["Dockerfile"] != git("rev-parse", "HEAD:Dockerfile") || len(files) != 1 {
+			t.Fatalf("expected commit %s with the Dockerfile for %+v, got %s with %v", test.expected, test.source, commit, files)
+		}
+	}
+}
+
+func TestCloneShallow_reportsGitErrors(t *testing.T) {
+	missingRepo := filepath.Join(t.TempDir(), "missing")
+	for _, source := range []CloneSource{
+		{Repo: missingRepo},
+		{Repo: missingRepo, Branch: "main"},
+		{Repo: missingRepo, Tag: "v1"},
+		{Repo: missingRepo, Commit: "709d658dc5b6d6afcd46049c2f332ee3f515a67d"},
+	} {
+		_, err := CloneShallow(context.Background(), source, util_work_dir.NewWorkDir(t.TempDir()))
+		if err == nil {
+			t.Fatalf("expected cloning %+v to fail", source)
+		}
+		if strings.Contains(err.Error(), "%!w") {
+			t.Fatalf("expected the error of git to be wrapped for %+v, got %v", source, err)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package util_glob
+
+import (
+	"path"
+	"strings"
+)
+
+// Match Matches a slash separated path against a glob, where '**' matches any number of
+// path segments, and '*', '?' and character classes match within a segment, like in .gitignore.
+func Match(pattern string, name string) bool {
+	return matchSegments(segments(pattern), segments(name))
+}
+
+// MatchSelfOrParent Like Match, but also matches everything inside directories matching the glob
+func MatchSelfOrParent(pattern string, name string) bool {
+	patternSegments := segments(pattern)
+	nameSegments := segments(name)
+	for i := len(nameSegments); i > 0; i-- {
+		if matchSegments(patternSegments, nameSegments[:i]) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchSegments(pattern []string, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		matched, err := path.Match(pattern[0], name[0])
+		if err != nil || !matched {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// segments Splits a path into its segments, ignoring leading './' and '/'
+func segments(p string) []string {
+	cleaned := strings.Trim(path.Clean("/"+p), "/")
+	if cleaned == "" {
+		return []string{}
+	}
+	return strings.Split(cleaned, "/")
+}
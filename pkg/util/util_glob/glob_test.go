@@ -0,0 +1,46 @@
+package util_glob
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	for _, test := range []struct {
+		pattern  string
+		name     string
+		expected bool
+	}{
+		{pattern: "services/api/main.go", name: "services/api/main.go", expected: true},
+		{pattern: "./services/api/main.go", name: "services/api/main.go", expected: true},
+		{pattern: "services/*/main.go", name: "services/api/main.go", expected: true},
+		{pattern: "services/*", name: "services/api/main.go", expected: false},
+		{pattern: "services/**", name: "services/api/main.go", expected: true},
+		{pattern: "**/*.proto", name: "libs/proto/api.proto", expected: true},
+		{pattern: "**/*.proto", name: "api.proto", expected: true},
+		{pattern: "**", name: "anything/at/all", expected: true},
+		{pattern: "libs/**/go.mod", name: "libs/go.mod", expected: true},
+		{pattern: "libs/**/go.mod", name: "libs/a/b/go.mod", expected: true},
+		{pattern: "libs/**/go.mod", name: "libs/a/b/go.sum", expected: false},
+		{pattern: "services/api", name: "services/api2", expected: false},
+	} {
+		if actual := Match(test.pattern, test.name); actual != test.expected {
+			t.Fatalf("expected Match(%s, %s) to be %v", test.pattern, test.name, test.expected)
+		}
+	}
+}
+
+func TestMatchSelfOrParent(t *testing.T) {
+	for _, test := range []struct {
+		pattern  string
+		name     string
+		expected bool
+	}{
+		{pattern: "services/api", name: "services/api/main.go", expected: true},
+		{pattern: "services/api", name: "services/api", expected: true},
+		{pattern: "services/api", name: "services/api2/main.go", expected: false},
+		{pattern: "libs/*", name: "libs/common/util.go", expected: true},
+		{pattern: "*.md", name: "docs/README.md", expected: false},
+	} {
+		if actual := MatchSelfOrParent(test.pattern, test.name); actual != test.expected {
+			t.Fatalf("expected MatchSelfOrParent(%s, %s) to be %v", test.pattern, test.name, test.expected)
+		}
+	}
+}
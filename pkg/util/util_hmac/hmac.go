@@ -0,0 +1,33 @@
+package util_hmac
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// VerifySha256 Checks a hex encoded HMAC-SHA256 of the body against each of the secrets
+func VerifySha256(body []byte, hexSignature string, secrets []string) bool {
+	received, err := hex.DecodeString(hexSignature)
+	if err != nil {
+		return false
+	}
+	for _, secret := range secrets {
+		if secret == "" {
+			continue
+		}
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		if hmac.Equal(received, mac.Sum(nil)) {
+			return true
+		}
+	}
+	return false
+}
+
+// SignSha256 Creates the hex encoded HMAC-SHA256 of the body
+func SignSha256(body []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
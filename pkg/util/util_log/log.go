@@ -0,0 +1,213 @@
+package util_log
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/GiGurra/cmder"
+	"github.com/samber/lo"
+	"go.opentelemetry.io/otel/trace"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+const (
+	FormatText = "text"
+	FormatJson = "json"
+)
+
+// Common attribute keys
+const (
+	KeyCorrelationId = "correlation_id"
+	KeyJobId         = "job_id"
+	KeyApp           = "app"
+	KeyProject       = "project"
+	KeyStep          = "step"
+	KeyTraceId       = "trace_id"
+	KeySpanId        = "span_id"
+)
+
+type ctxAttrsKey struct{}
+
+// Setup Makes slog's default logger write to out in the given format (text or json), at the given
+// level and above, including the attributes added to the context with With
+func Setup(out io.Writer, format string, level string) error {
+	var slogLevel slog.Level
+	err := slogLevel.UnmarshalText([]byte(level))
+	if err != nil {
+		return fmt.Errorf("invalid log level '%s': %w", level, err)
+	}
+
+	opts := &slog.HandlerOptions{Level: slogLevel}
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case FormatText:
+		handler = slog.NewTextHandler(out, opts)
+	case FormatJson:
+		handler = slog.NewJSONHandler(out, opts)
+	default:
+		return fmt.Errorf("invalid log format '%s', expected %s or %s", format, FormatText, FormatJson)
+	}
+
+	slog.SetDefault(slog.New(NewContextHandler(handler)))
+	return nil
+}
+
+// With Returns a context whose log lines also get the given attributes, as key-value pairs like slog's.
+// Attributes already in the context with the same keys are replaced, e.g. the project of nested projects.
+func With(ctx context.Context, args ...any) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	existing, _ := ctx.Value(ctxAttrsKey{}).([]slog.Attr)
+	record := slog.Record{}
+	record.Add(args...)
+	added := make([]slog.Attr, 0, record.NumAttrs())
+	record.Attrs(func(attr slog.Attr) bool {
+		added = append(added, attr)
+		return true
+	})
+	attrs := make([]slog.Attr, 0, len(existing)+len(added))
+	for _, attr := range existing {
+		if !lo.ContainsBy(added, func(a slog.Attr) bool { return a.Key == attr.Key }) {
+			attrs = append(attrs, attr)
+		}
+	}
+	attrs = append(attrs, added...)
+	return context.WithValue(ctx, ctxAttrsKey{}, attrs)
+}
+
+// WithCorrelationId Returns a context whose log lines are tagged with the correlation id
+func WithCorrelationId(ctx context.Context, correlationId string) context.Context {
+	return With(ctx, KeyCorrelationId, correlationId)
+}
+
+// CorrelationId The correlation id of the context, or empty if it has none
+func CorrelationId(ctx context.Context) string {
+	attrs := Attrs(ctx)
+	for i := len(attrs) - 1; i >= 0; i-- {
+		if attrs[i].Key == KeyCorrelationId {
+			return attrs[i].Value.String()
+		}
+	}
+	return ""
+}
+
+// Attrs The attributes added to the context with With
+func Attrs(ctx context.Context) []slog.Attr {
+	if ctx == nil {
+		return nil
+	}
+	attrs, _ := ctx.Value(ctxAttrsKey{}).([]slog.Attr)
+	return attrs
+}
+
+func NewCorrelationId() string {
+	id := make([]byte, 8)
+	_, err := rand.Read(id)
+	if err != nil {
+		panic(fmt.Errorf("error generating correlation id: %w", err))
+	}
+	return hex.EncodeToString(id)
+}
+
+// ContextHandler Adds the attributes stored in the context with With to each record
+type ContextHandler struct {
+	inner slog.Handler
+}
+
+// prove that ContextHandler implements slog.Handler
+var _ slog.Handler = ContextHandler{}
+
+func NewContextHandler(inner slog.Handler) ContextHandler {
+	return ContextHandler{inner: inner}
+}
+
+func (h ContextHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.inner.Enabled(ctx, level)
+}
+
+func (h ContextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if ctx != nil {
+		if attrs, ok := ctx.Value(ctxAttrsKey{}).([]slog.Attr); ok {
+			record = record.Clone()
+			record.AddAttrs(attrs...)
+		}
+		// so that log lines can be found from traces, and the other way around
+		if spanCtx := trace.SpanContextFromContext(ctx); spanCtx.IsValid() {
+			record = record.Clone()
+			record.AddAttrs(
+				slog.String(KeyTraceId, spanCtx.TraceID().String()),
+				slog.String(KeySpanId, spanCtx.SpanID().String()),
+			)
+		}
+	}
+	return h.inner.Handle(ctx, record)
+}
+
+func (h ContextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return ContextHandler{inner: h.inner.WithAttrs(attrs)}
+}
+
+func (h ContextHandler) WithGroup(name string) slog.Handler {
+	return ContextHandler{inner: h.inner.WithGroup(name)}
+}
+
+// LineWriter Logs everything written to it line by line, e.g. the output of a command, with the
+// given attributes. Call Close when done to also log an unterminated last line.
+func LineWriter(ctx context.Context, level slog.Level, args ...any) io.WriteCloser {
+	return &lineWriter{ctx: ctx, level: level, args: args}
+}
+
+type lineWriter struct {
+	ctx   context.Context
+	level slog.Level
+	args  []any
+	mutex sync.Mutex
+	buf   []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		w.log(string(w.buf[:i]))
+		w.buf = w.buf[i+1:]
+	}
+}
+
+func (w *lineWriter) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if len(w.buf) > 0 {
+		w.log(string(w.buf))
+		w.buf = nil
+	}
+	return nil
+}
+
+func (w *lineWriter) log(line string) {
+	line = strings.TrimRight(line, "\r")
+	if strings.TrimSpace(line) != "" {
+		slog.Log(w.ctx, w.level, line, w.args...)
+	}
+}
+
+// RunLogged Runs the command with its stdout and stderr logged line by line, tagged with the command's name
+func RunLogged(ctx context.Context, spec cmder.Spec) cmder.Result {
+	output := LineWriter(ctx, slog.LevelInfo, "cmd", spec.App)
+	defer func() { _ = output.Close() }()
+	return spec.
+		WithStdOut(output).
+		WithStdErr(output).
+		Run(ctx)
+}
@@ -0,0 +1,105 @@
+package util_log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func captureJson(t *testing.T) (*bytes.Buffer, func()) {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	previous := slog.Default()
+	slog.SetDefault(slog.New(NewContextHandler(slog.NewJSONHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug}))))
+	return buf, func() { slog.SetDefault(previous) }
+}
+
+func decodeLines(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	result := make([]map[string]any, 0)
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		entry := map[string]any{}
+		err := json.Unmarshal([]byte(line), &entry)
+		if err != nil {
+			t.Fatalf("error decoding log line '%s': %v", line, err)
+		}
+		result = append(result, entry)
+	}
+	return result
+}
+
+func TestContextHandler(t *testing.T) {
+	buf, restore := captureJson(t)
+	defer restore()
+
+	ctx := WithCorrelationId(context.Background(), "abc123")
+	ctx = With(ctx, KeyProject, "outer")
+	ctx = With(ctx, KeyProject, "inner", KeyApp, "app1")
+	slog.InfoContext(ctx, "Deploying app", KeyStep, "deploy")
+
+	if cid := CorrelationId(ctx); cid != "abc123" {
+		t.Fatalf("expected correlation id abc123, got '%s'", cid)
+	}
+
+	lines := decodeLines(t, buf)
+	if len(lines) != 1 {
+		t.Fatalf("expected 1 log line, got %d", len(lines))
+	}
+	expected := map[string]any{
+		KeyCorrelationId: "abc123",
+		KeyProject:       "inner",
+		KeyApp:           "app1",
+		KeyStep:          "deploy",
+		"msg":            "Deploying app",
+	}
+	for key, value := range expected {
+		if lines[0][key] != value {
+			t.Fatalf("expected %s=%v, got %v", key, value, lines[0][key])
+		}
+	}
+	if strings.Count(buf.String(), `"project"`) != 1 {
+		t.Fatalf("expected the inner project to replace the outer one, got %s", buf.String())
+	}
+}
+
+func TestLineWriter(t *testing.T) {
+	buf, restore := captureJson(t)
+	defer restore()
+
+	writer := LineWriter(WithCorrelationId(context.Background(), "abc123"), slog.LevelInfo, "cmd", "git")
+	_, _ = writer.Write([]byte("first line\nsecond "))
+	_, _ = writer.Write([]byte("line\r\n\n"))
+	_, _ = writer.Write([]byte("unterminated"))
+	_ = writer.Close()
+
+	lines := decodeLines(t, buf)
+	msgs := make([]string, 0, len(lines))
+	for _, line := range lines {
+		if line["cmd"] != "git" || line[KeyCorrelationId] != "abc123" {
+			t.Fatalf("expected all lines to be tagged, got %v", line)
+		}
+		msgs = append(msgs, line["msg"].(string))
+	}
+	if strings.Join(msgs, "|") != "first line|second line|unterminated" {
+		t.Fatalf("unexpected lines: %v", msgs)
+	}
+}
+
+func TestSetup(t *testing.T) {
+	previous := slog.Default()
+	defer slog.SetDefault(previous)
+
+	if err := Setup(io.Discard, FormatJson, "debug"); err != nil {
+		t.Fatalf("expected json at debug to be valid, got %v", err)
+	}
+	if err := Setup(io.Discard, "xml", "info"); err == nil {
+		t.Fatalf("expected an error for an unknown format")
+	}
+	if err := Setup(io.Discard, FormatText, "loud"); err == nil {
+		t.Fatalf("expected an error for an unknown level")
+	}
+}
@@ -0,0 +1,151 @@
+package util_metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"time"
+)
+
+// Steps of a deploy that failures are counted by
+const (
+	StepPrepare    = "prepare" // fetching the app and its config
+	StepVolumes    = "volumes"
+	StepSecrets    = "secrets"
+	StepNetworking = "networking"
+	StepDeploy     = "deploy" // creating the app and the fly deploy itself
+	StepScale      = "scale"
+)
+
+// Kinds of git clones
+const (
+	CloneApp     = "app"
+	CloneProject = "project"
+)
+
+// Registry holds all flycd metrics, plus the standard go runtime and process metrics
+var Registry = prometheus.NewRegistry()
+
+var (
+	webhooksReceived = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "flycd_webhooks_received_total",
+		Help: "Webhook requests received, by provider",
+	}, []string{"provider"})
+
+	webhooksAccepted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "flycd_webhooks_accepted_total",
+		Help: "Webhook requests whose pushes were queued for deploy, by provider",
+	}, []string{"provider"})
+
+	webhooksRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "flycd_webhooks_rejected_total",
+		Help: "Webhook requests rejected, by provider and reason",
+	}, []string{"provider", "reason"})
+
+	deploys = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "flycd_deploys_total",
+		Help: "Successful app deploys, by app and result (created, updated, no-change, reconciled)",
+	}, []string{"app", "result"})
+
+	deployFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "flycd_deploy_failures_total",
+		Help: "Failed app deploys, by app and the step that failed",
+	}, []string{"app", "step"})
+
+	deployDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "flycd_deploy_duration_seconds",
+		Help:    "Duration of app deploys, by result (failed for failures)",
+		Buckets: []float64{1, 5, 15, 30, 60, 120, 300, 600, 1200},
+	}, []string{"result"})
+
+	cloneDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "flycd_git_clone_duration_seconds",
+		Help:    "Duration of git clones, by what was cloned (app or project)",
+		Buckets: []float64{0.5, 1, 2, 5, 10, 30, 60, 120},
+	}, []string{"kind"})
+
+	// a vec without labels, so that it isn't exported as 0 before any status check has run
+	appsOutOfSync = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "flycd_apps_out_of_sync",
+		Help: "Apps that were not in sync with fly.io at the last status check",
+	}, []string{})
+
+	appsByStatus = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "flycd_apps",
+		Help: "Apps by sync status (in-sync, out-of-date, missing, failing) at the last status check",
+	}, []string{"status"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		webhooksReceived,
+		webhooksAccepted,
+		webhooksRejected,
+		deploys,
+		deployFailures,
+		deployDuration,
+		cloneDuration,
+		appsOutOfSync,
+		appsByStatus,
+	)
+}
+
+// Handler Serves the metrics in the prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+func WebhookReceived(provider string) {
+	webhooksReceived.WithLabelValues(provider).Inc()
+}
+
+func WebhookAccepted(provider string) {
+	webhooksAccepted.WithLabelValues(provider).Inc()
+}
+
+func WebhookRejected(provider string, reason string) {
+	webhooksRejected.WithLabelValues(provider, reason).Inc()
+}
+
+// DeploySucceeded Counts a deploy of the app with the given result, e.g. created or no-change
+func DeploySucceeded(app string, result string, duration time.Duration) {
+	deploys.WithLabelValues(app, result).Inc()
+	deployDuration.WithLabelValues(result).Observe(duration.Seconds())
+}
+
+// DeployFailed Counts a failed deploy of the app, by the step it failed in
+func DeployFailed(app string, step string, duration time.Duration) {
+	deployFailures.WithLabelValues(app, step).Inc()
+	deployDuration.WithLabelValues("failed").Observe(duration.Seconds())
+}
+
+func CloneFinished(kind string, duration time.Duration) {
+	cloneDuration.WithLabelValues(kind).Observe(duration.Seconds())
+}
+
+// AppStatuses Sets the sync status gauges from the result of a status check
+func AppStatuses(countByStatus map[string]int, outOfSync int) {
+	appsByStatus.Reset()
+	for status, count := range countByStatus {
+		appsByStatus.WithLabelValues(status).Set(float64(count))
+	}
+	appsOutOfSync.WithLabelValues().Set(float64(outOfSync))
+}
+
+// ObserveJobQueue Registers gauges for the job queue, read from the given funcs on every scrape.
+// Must only be called once.
+func ObserveJobQueue(pending func() int, running func() int, dead func() int) {
+	gauge := func(name string, help string, value func() int) prometheus.Collector {
+		return prometheus.NewGaugeFunc(prometheus.GaugeOpts{Name: name, Help: help}, func() float64 {
+			return float64(value())
+		})
+	}
+	Registry.MustRegister(
+		gauge("flycd_job_queue_depth", "Jobs waiting in the job queue, not including running jobs", pending),
+		gauge("flycd_jobs_running", "Jobs currently running", running),
+		gauge("flycd_jobs_dead", "Jobs that failed too many times and were moved to the dead letters", dead),
+	)
+}
@@ -0,0 +1,61 @@
+package util_metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeployMetrics(t *testing.T) {
+	DeploySucceeded("test-app", "created", 20*time.Second)
+	DeploySucceeded("test-app", "no-change", 2*time.Second)
+	DeployFailed("test-app", StepSecrets, 3*time.Second)
+
+	if count := testutil.ToFloat64(deploys.WithLabelValues("test-app", "created")); count != 1 {
+		t.Fatalf("expected 1 created deploy, got %v", count)
+	}
+	if count := testutil.ToFloat64(deployFailures.WithLabelValues("test-app", StepSecrets)); count != 1 {
+		t.Fatalf("expected 1 failure in the secrets step, got %v", count)
+	}
+	if count := testutil.CollectAndCount(deployDuration); count != 3 {
+		t.Fatalf("expected durations for 3 results, got %d", count)
+	}
+}
+
+func TestAppStatuses(t *testing.T) {
+	if count, err := testutil.GatherAndCount(Registry, "flycd_apps_out_of_sync"); err != nil || count != 0 {
+		t.Fatalf("expected no out of sync metric before any status check, got %d, err=%v", count, err)
+	}
+
+	AppStatuses(map[string]int{"in-sync": 3, "missing": 1}, 1)
+	AppStatuses(map[string]int{"in-sync": 2, "out-of-date": 2}, 2)
+
+	expected := `
+# HELP flycd_apps Apps by sync status (in-sync, out-of-date, missing, failing) at the last status check
+# TYPE flycd_apps gauge
+flycd_apps{status="in-sync"} 2
+flycd_apps{status="out-of-date"} 2
+# HELP flycd_apps_out_of_sync Apps that were not in sync with fly.io at the last status check
+# TYPE flycd_apps_out_of_sync gauge
+flycd_apps_out_of_sync 2
+`
+	err := testutil.GatherAndCompare(Registry, strings.NewReader(expected), "flycd_apps", "flycd_apps_out_of_sync")
+	if err != nil {
+		t.Fatalf("unexpected app status metrics: %v", err)
+	}
+}
+
+func TestObserveJobQueue(t *testing.T) {
+	ObserveJobQueue(func() int { return 4 }, func() int { return 1 }, func() int { return 0 })
+
+	expected := `
+# HELP flycd_job_queue_depth Jobs waiting in the job queue, not including running jobs
+# TYPE flycd_job_queue_depth gauge
+flycd_job_queue_depth 4
+`
+	err := testutil.GatherAndCompare(Registry, strings.NewReader(expected), "flycd_job_queue_depth")
+	if err != nil {
+		t.Fatalf("unexpected job queue metrics: %v", err)
+	}
+}
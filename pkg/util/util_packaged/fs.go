@@ -1,11 +1,13 @@
 package util_packaged
 
 import (
+	"context"
 	"embed"
 	"fmt"
 	"github.com/gigurra/flycd/pkg/util/util_work_dir"
 	"io"
 	"io/fs"
+	"log/slog"
 	"os"
 	"path/filepath"
 )
@@ -20,14 +22,14 @@ type PackagedFileSystem struct {
 	Directories []embed.FS
 }
 
-func (embedded PackagedFileSystem) WriteOut(path string) error {
+func (embedded PackagedFileSystem) WriteOut(ctx context.Context, path string) error {
 	if path == "" {
 		return fmt.Errorf("PackagedFileSystem.WriteOut: path cannot be empty")
 	}
 
 	workDir := util_work_dir.NewWorkDir(path)
 
-	fmt.Printf("Writing embedded fs to: %s\n", workDir.Cwd())
+	slog.InfoContext(ctx, "Writing embedded fs", "path", workDir.Cwd())
 
 	// Write out all files to tempDir
 	for _, file := range embedded.Files {
@@ -40,7 +42,7 @@ func (embedded PackagedFileSystem) WriteOut(path string) error {
 
 	// Write out all directories to tempDir
 	for _, dir := range embedded.Directories {
-		err := writeFS(workDir.Cwd(), dir)
+		err := writeFS(ctx, workDir.Cwd(), dir)
 		if err != nil {
 			return fmt.Errorf("failed to create directory %v: %w", dir, err)
 		}
@@ -49,7 +51,7 @@ func (embedded PackagedFileSystem) WriteOut(path string) error {
 	return nil
 }
 
-func writeFS(outDir string, fsys fs.FS) error {
+func writeFS(ctx context.Context, outDir string, fsys fs.FS) error {
 	return fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
@@ -68,7 +70,7 @@ func writeFS(outDir string, fsys fs.FS) error {
 			defer func(outFile *os.File) {
 				err := outFile.Close()
 				if err != nil {
-					fmt.Printf("Error closing file: %v\n", err)
+					slog.ErrorContext(ctx, "Error closing file", "path", outPath, "error", err)
 				}
 			}(outFile)
 
@@ -79,7 +81,7 @@ func writeFS(outDir string, fsys fs.FS) error {
 			defer func(inFile fs.File) {
 				err := inFile.Close()
 				if err != nil {
-					fmt.Printf("Error closing file: %v\n", err)
+					slog.ErrorContext(ctx, "Error closing file", "path", path, "error", err)
 				}
 			}(inFile)
 
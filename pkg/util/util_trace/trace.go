@@ -0,0 +1,130 @@
+package util_trace
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"io"
+	"strings"
+	"sync"
+)
+
+const (
+	ExporterNone   = "none"   // no tracing (default)
+	ExporterOtlp   = "otlp"   // otlp over http, configured with the standard OTEL_EXPORTER_OTLP_* env vars
+	ExporterStdout = "stdout" // spans as json, next to the logs, for tests and debugging
+)
+
+var AllExporters = []string{ExporterNone, ExporterOtlp, ExporterStdout}
+
+const tracerName = "github.com/gigurra/flycd"
+
+// Common span attribute keys
+const (
+	KeyApp           = attribute.Key("flycd.app")
+	KeyOrg           = attribute.Key("flycd.org")
+	KeyProject       = attribute.Key("flycd.project")
+	KeyPath          = attribute.Key("flycd.path")
+	KeyStep          = attribute.Key("flycd.step")
+	KeyResult        = attribute.Key("flycd.result")
+	KeyJobId         = attribute.Key("flycd.job.id")
+	KeyJobType       = attribute.Key("flycd.job.type")
+	KeyCorrelationId = attribute.Key("flycd.correlation_id")
+	KeyRepo          = attribute.Key("flycd.git.repo")
+	KeyRegion        = attribute.Key("flycd.region")
+)
+
+var (
+	mutex    sync.Mutex
+	provider *sdktrace.TracerProvider
+)
+
+// Setup Makes otel's global tracer provider export spans with the given exporter. The stdout exporter
+// writes them to out. Call Shutdown before exiting, to flush spans that are not exported yet.
+func Setup(ctx context.Context, out io.Writer, exporter string, version string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	var spanProcessor sdktrace.SpanProcessor
+	switch strings.ToLower(exporter) {
+	case ExporterNone, "":
+		return nil
+	case ExporterOtlp:
+		otlpExporter, err := otlptracehttp.New(ctx)
+		if err != nil {
+			return fmt.Errorf("error creating otlp trace exporter: %w", err)
+		}
+		spanProcessor = sdktrace.NewBatchSpanProcessor(otlpExporter)
+	case ExporterStdout:
+		stdoutExporter, err := stdouttrace.New(stdouttrace.WithWriter(out))
+		if err != nil {
+			return fmt.Errorf("error creating stdout trace exporter: %w", err)
+		}
+		// export each span as it ends, so they are printed in order with everything else
+		spanProcessor = sdktrace.NewSimpleSpanProcessor(stdoutExporter)
+	default:
+		return fmt.Errorf("invalid trace exporter '%s', expected one of %v", exporter, AllExporters)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		attribute.String("service.name", "flycd"),
+		attribute.String("service.version", version),
+	))
+	if err != nil {
+		return fmt.Errorf("error creating trace resource: %w", err)
+	}
+
+	provider = sdktrace.NewTracerProvider(
+		sdktrace.WithSpanProcessor(spanProcessor),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	return nil
+}
+
+// Shutdown Exports all remaining spans and stops tracing. Does nothing if tracing was never set up.
+func Shutdown(ctx context.Context) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if provider == nil {
+		return nil
+	}
+	err := provider.Shutdown(ctx)
+	provider = nil
+	if err != nil {
+		return fmt.Errorf("error shutting down tracing: %w", err)
+	}
+	return nil
+}
+
+// Start Starts a span as a child of the span in the context, if any
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End Ends the span, marking it as failed if err is not nil
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Run Runs fn in a span, which is marked as failed if fn returns an error
+func Run(ctx context.Context, name string, fn func(ctx context.Context) error, attrs ...attribute.KeyValue) error {
+	ctx, span := Start(ctx, name, attrs...)
+	err := fn(ctx)
+	End(span, err)
+	return err
+}
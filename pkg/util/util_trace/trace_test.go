@@ -0,0 +1,63 @@
+package util_trace
+
+import (
+	"context"
+	"errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"io"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(previous)
+
+	err := Run(context.Background(), "outer", func(ctx context.Context) error {
+		_ = Run(ctx, "inner", func(ctx context.Context) error { return nil }, KeyApp.String("app1"))
+		return errors.New("boom")
+	})
+	if err == nil || err.Error() != "boom" {
+		t.Fatalf("expected the error of fn, got %v", err)
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 2 || spans[0].Name() != "inner" || spans[1].Name() != "outer" {
+		t.Fatalf("expected an inner and an outer span, got %v", spans)
+	}
+	inner, outer := spans[0], spans[1]
+	if inner.Parent().SpanID() != outer.SpanContext().SpanID() {
+		t.Fatalf("expected the inner span to be a child of the outer span")
+	}
+	if inner.Status().Code != codes.Unset || outer.Status().Code != codes.Error {
+		t.Fatalf("expected only the outer span to fail, got %v and %v", inner.Status(), outer.Status())
+	}
+	if len(inner.Attributes()) != 1 || inner.Attributes()[0] != KeyApp.String("app1") {
+		t.Fatalf("expected the inner span to have the app attribute, got %v", inner.Attributes())
+	}
+}
+
+func TestSetup(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(previous)
+
+	if err := Setup(context.Background(), io.Discard, "zipkin", "test"); err == nil {
+		t.Fatalf("expected an error for an unknown exporter")
+	}
+	if err := Setup(context.Background(), io.Discard, ExporterNone, "test"); err != nil {
+		t.Fatalf("expected no tracing to be valid, got %v", err)
+	}
+	if err := Setup(context.Background(), io.Discard, ExporterStdout, "test"); err != nil {
+		t.Fatalf("expected the stdout exporter to be valid, got %v", err)
+	}
+	if err := Shutdown(context.Background()); err != nil {
+		t.Fatalf("expected shutdown to flush without errors, got %v", err)
+	}
+	if err := Shutdown(context.Background()); err != nil {
+		t.Fatalf("expected a second shutdown to do nothing, got %v", err)
+	}
+}
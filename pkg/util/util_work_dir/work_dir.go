@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/GiGurra/cmder"
 	cp "github.com/otiai10/copy"
+	"log/slog"
 	"os"
 	"path/filepath"
 )
@@ -16,7 +17,7 @@ type WorkDir struct {
 func (t WorkDir) RemoveAll() {
 	err := os.RemoveAll(t.Root())
 	if err != nil {
-		fmt.Printf("error removing dir %s: %s", t.Root(), err)
+		slog.Warn("Error removing dir", "dir", t.Root(), "error", err)
 	}
 }
 